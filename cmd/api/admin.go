@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"notification-system/pkg/audit"
+	"notification-system/pkg/config"
+	"notification-system/pkg/openapi"
+)
+
+const adminNotificationsPath = "/admin/notifications"
+
+// weightsSuffix follows the event type in the path of target weight changes.
+const weightsSuffix = "/weights"
+
+// registerAdminHandlers exposes CRUD endpoints for notification configs:
+//
+//	GET    /admin/notifications                      list all notifications
+//	POST   /admin/notifications                      create a notification
+//	GET    /admin/notifications/{event_type}         get one notification
+//	PUT    /admin/notifications/{event_type}         replace a notification
+//	DELETE /admin/notifications/{event_type}         delete a notification
+//	PUT    /admin/notifications/{event_type}/weights change the weights of its targets
+//
+// The weights are a JSON object by target name, e.g. {"blue": 0, "green": 100}; targets
+// not named keep their weight.
+//
+// With tenants configured, ?tenant=<id> scopes the request to one tenant's notifications;
+// without it the default tenant is used (listing returns every tenant's notifications).
+// Changes are persisted by the store and picked up by workers watching the same config.
+//
+// Responses mask secrets as the config audit trail does. A replaced notification keeps
+// the secrets it is sent with masked, so one can be read, edited and written back.
+func registerAdminHandlers(mux *http.ServeMux, store *config.Store) {
+	mux.HandleFunc(adminNotificationsPath, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			notifications := store.Notifications()
+			if r.URL.Query().Has("tenant") {
+				tenant := r.URL.Query().Get("tenant")
+				scoped := notifications[:0]
+				for _, n := range notifications {
+					if n.Tenant == tenant {
+						scoped = append(scoped, n)
+					}
+				}
+				notifications = scoped
+			}
+			writeMasked(w, http.StatusOK, notifications)
+		case http.MethodPost:
+			var n config.NotificationConfig
+			if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+			if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+				n.Tenant = tenant
+			}
+			if err := store.CreateNotification(r.Context(), n); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeMasked(w, http.StatusCreated, n)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc(adminNotificationsPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		eventType := strings.TrimPrefix(r.URL.Path, adminNotificationsPath+"/")
+		if eventType == "" {
+			http.NotFound(w, r)
+			return
+		}
+		tenant := r.URL.Query().Get("tenant")
+		if eventType, ok := strings.CutSuffix(eventType, weightsSuffix); ok {
+			if r.Method != http.MethodPut {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var weights map[string]int
+			if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+			n, err := store.SetTargetWeights(r.Context(), tenant, eventType, weights)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeMasked(w, http.StatusOK, n)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			n, err := store.GetNotification(tenant, eventType)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeMasked(w, http.StatusOK, n)
+		case http.MethodPut:
+			var n config.NotificationConfig
+			if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+			if n.EventType == "" {
+				n.EventType = eventType
+			}
+			if old, err := store.GetNotification(tenant, eventType); err == nil {
+				if err := unmaskSecrets(&n, old); err != nil {
+					writeDecodeError(w, err)
+					return
+				}
+			}
+			if err := store.UpdateNotification(r.Context(), tenant, eventType, n); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeMasked(w, http.StatusOK, n)
+		case http.MethodDelete:
+			if err := store.DeleteNotification(r.Context(), tenant, eventType); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// describeAdmin describes the endpoints of registerAdminHandlers.
+func describeAdmin(doc *openapi.Document) {
+	notification := doc.Schema(config.NotificationConfig{})
+	tenant := openapi.Query("tenant", "string", "the tenant whose notifications are meant (default the default tenant)")
+	eventType := openapi.PathParam("event_type", "the event type of the notification")
+
+	doc.Add(http.MethodGet, adminNotificationsPath, &openapi.Operation{
+		OperationID: "listNotifications",
+		Summary:     "List notifications",
+		Description: "Without ?tenant, the notifications of every tenant are listed.",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{tenant},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK: {Content: openapi.JSON(&openapi.Schema{Type: "array", Items: notification})},
+		}),
+	})
+	doc.Add(http.MethodPost, adminNotificationsPath, &openapi.Operation{
+		OperationID: "createNotification",
+		Summary:     "Create a notification",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{tenant},
+		RequestBody: openapi.Body("The notification.", notification),
+		Responses:   storeResponses(http.StatusCreated, notification),
+	})
+	doc.Add(http.MethodGet, adminNotificationsPath+"/{event_type}", &openapi.Operation{
+		OperationID: "getNotification",
+		Summary:     "Get a notification",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{eventType, tenant},
+		Responses:   storeResponses(http.StatusOK, notification),
+	})
+	doc.Add(http.MethodPut, adminNotificationsPath+"/{event_type}", &openapi.Operation{
+		OperationID: "replaceNotification",
+		Summary:     "Replace a notification",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{eventType, tenant},
+		RequestBody: openapi.Body("The notification; event_type defaults to the one in the path.", notification),
+		Responses:   storeResponses(http.StatusOK, notification),
+	})
+	doc.Add(http.MethodDelete, adminNotificationsPath+"/{event_type}", &openapi.Operation{
+		OperationID: "deleteNotification",
+		Summary:     "Delete a notification",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{eventType, tenant},
+		Responses:   storeResponses(http.StatusNoContent, nil),
+	})
+	doc.Add(http.MethodPut, adminNotificationsPath+"/{event_type}"+weightsSuffix, &openapi.Operation{
+		OperationID: "setTargetWeights",
+		Summary:     "Change the weights of targets",
+		Description: "Shifts deliveries between the targets of a notification, e.g. from blue to green or back. Targets not named keep their weight.",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{eventType, tenant},
+		RequestBody: openapi.Body("The new weights by target name.", &openapi.Schema{Type: "object", AdditionalProperties: &openapi.Schema{Type: "integer"}}),
+		Responses:   storeResponses(http.StatusOK, notification),
+	})
+}
+
+// storeResponses describes a successful response with the given status and schema plus
+// the errors of writeStoreError.
+func storeResponses(status int, schema *openapi.Schema) map[string]*openapi.Response {
+	ok := &openapi.Response{}
+	if schema != nil {
+		ok.Content = openapi.JSON(schema)
+	}
+	return openapi.Responses(map[int]*openapi.Response{
+		status:                         ok,
+		http.StatusBadRequest:          openapi.Error("The request or the resulting config is invalid."),
+		http.StatusNotFound:            openapi.Error("No such notification or pause."),
+		http.StatusConflict:            openapi.Error("The notification or pause already exists, or the config kept changing concurrently."),
+		http.StatusInternalServerError: openapi.Error("The config change could not be persisted."),
+	})
+}
+
+// writeStoreError maps config.Store errors to HTTP status codes.
+// Anything that is not a lookup conflict is a validation or persistence failure.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, config.ErrNotificationNotFound), errors.Is(err, config.ErrPauseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, config.ErrNotificationExists), errors.Is(err, config.ErrPauseExists), errors.Is(err, config.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, config.ErrPersist):
+		log.Printf("Admin config change failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writeMasked writes v as JSON with its secrets masked, see audit.Masked.
+func writeMasked(w http.ResponseWriter, status int, v interface{}) {
+	masked, err := audit.Masked(v)
+	if err != nil {
+		log.Printf("Failed to mask response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, masked)
+}
+
+// unmaskSecrets replaces the masked secrets of n by those at the same place in old.
+func unmaskSecrets(n *config.NotificationConfig, old config.NotificationConfig) error {
+	var sent, current interface{}
+	if err := decodeAs(n, &sent); err != nil {
+		return err
+	}
+	if err := decodeAs(old, &current); err != nil {
+		return err
+	}
+	if !unmask(sent, current) {
+		return nil
+	}
+	return decodeAs(sent, n)
+}
+
+// decodeAs decodes the JSON encoding of v into out.
+func decodeAs(v, out interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// unmask replaces the masked strings nested in the decoded JSON value v by the strings at
+// the same place in old, and reports whether it replaced any.
+func unmask(v, old interface{}) bool {
+	replaced := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		o, _ := old.(map[string]interface{})
+		for k, nested := range v {
+			if s, ok := nested.(string); ok && s == audit.MaskedValue {
+				if secret, ok := o[k].(string); ok {
+					v[k], replaced = secret, true
+				}
+				continue
+			}
+			replaced = unmask(nested, o[k]) || replaced
+		}
+	case []interface{}:
+		o, _ := old.([]interface{})
+		for i, nested := range v {
+			if i < len(o) {
+				replaced = unmask(nested, o[i]) || replaced
+			}
+		}
+	}
+	return replaced
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/openapi"
+)
+
+// adminTokenHeader carries the admin token. "Authorization: Bearer <token>" is accepted too.
+const adminTokenHeader = "X-Admin-Token"
+
+// noAdminAuth answers admin requests while api.admin is unset.
+const noAdminAuth = "Admin endpoints are disabled: set api.admin"
+
+// securityAdminToken is the security scheme of the admin endpoints, matching adminUser.
+const securityAdminToken = "adminToken"
+
+// adminUserKey is the context key of the user an admin request was authenticated as.
+type adminUserKey struct{}
+
+// withAdminAuth lets requests to /admin/ through only from the users of api.admin, and
+// adds the user to their context. Without api.admin every admin request is refused. The
+// config is read per request.
+func withAdminAuth(next http.Handler, store *config.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		admin := store.Config().API.Admin
+		if admin == nil {
+			http.Error(w, noAdminAuth, http.StatusForbidden)
+			return
+		}
+		user := adminUser(admin, r)
+		if user == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Missing or invalid admin credentials", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminUserKey{}, user)))
+	})
+}
+
+// adminUser returns the user of admin presenting the token or the verified client
+// certificate of r, or "" if there is none.
+func adminUser(admin *config.AdminConfig, r *http.Request) string {
+	token := r.Header.Get(adminTokenHeader)
+	if token == "" {
+		token = bearerToken(r.Header.Get("Authorization"))
+	}
+	if token != "" {
+		return admin.AdminUser(token)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if cn := r.TLS.VerifiedChains[0][0].Subject.CommonName; cn != "" && slices.Contains(admin.ClientSubjects, cn) {
+			return cn
+		}
+	}
+	return ""
+}
+
+// describeAdminAuth requires the admin credentials for every admin operation of doc.
+func describeAdminAuth(doc *openapi.Document) {
+	doc.SecurityScheme(securityAdminToken, &openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer",
+		Description: "A token of api.admin.tokens, also accepted in the " + adminTokenHeader +
+			" header. A client certificate with a common name in api.admin.client_subjects is accepted instead.",
+	})
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/admin/") {
+			continue
+		}
+		for _, op := range item {
+			op.Security = []map[string][]string{{securityAdminToken: {}}}
+			for code, resp := range openapi.Responses(map[int]*openapi.Response{
+				http.StatusUnauthorized: openapi.Error("Missing or invalid admin credentials."),
+				http.StatusForbidden:    openapi.Error(noAdminAuth),
+			}) {
+				op.Responses[code] = resp
+			}
+		}
+	}
+}
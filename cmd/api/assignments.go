@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/openapi"
+	"notification-system/pkg/worker"
+)
+
+const noAssignment = "Worker assignment is not configured: set worker.assignment"
+
+// assignmentsResponse is the body of GET /admin/assignments.
+type assignmentsResponse struct {
+	Pins      map[string]config.TopicPin `json:"pins"`
+	Dedicated bool                       `json:"dedicated,omitempty"`
+	// Replicas are the assignments the running replicas recorded in worker.assignment.redis.
+	// Without it only the worker of this process, in -mode all, is listed.
+	Replicas []worker.ReplicaAssignment `json:"replicas"`
+}
+
+// registerAssignmentHandler exposes the worker replicas consuming each topic:
+//
+//	GET /admin/assignments  the pins of worker.assignment and the topics and leases of every replica
+//
+// w is the worker of this process in -mode all, or nil.
+func registerAssignmentHandler(mux *http.ServeMux, store *config.Store, w *worker.Worker) {
+	mux.HandleFunc("/admin/assignments", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a := store.Config().Worker.Assignment
+		if a == nil {
+			http.Error(rw, noAssignment, http.StatusNotImplemented)
+			return
+		}
+
+		resp := assignmentsResponse{Pins: a.Pins, Dedicated: a.Dedicated, Replicas: []worker.ReplicaAssignment{}}
+		switch {
+		case a.Redis != nil:
+			replicas, err := worker.ListAssignments(r.Context(), a)
+			if err != nil {
+				log.Printf("Failed to list worker assignments: %v", err)
+				http.Error(rw, "Worker assignments are not available", http.StatusServiceUnavailable)
+				return
+			}
+			resp.Replicas = replicas
+		case w != nil:
+			resp.Replicas = append(resp.Replicas, w.Assignment())
+		}
+		writeJSON(rw, http.StatusOK, resp)
+	})
+}
+
+// describeAssignments describes the endpoint of registerAssignmentHandler.
+func describeAssignments(doc *openapi.Document) {
+	doc.Add(http.MethodGet, "/admin/assignments", &openapi.Operation{
+		OperationID: "listAssignments",
+		Summary:     "List the topics each worker replica consumes",
+		Description: "The pins of worker.assignment and, for every running replica, the topics it consumes and the leases it holds.",
+		Tags:        []string{"admin"},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                 {Content: openapi.JSON(doc.Schema(assignmentsResponse{}))},
+			http.StatusNotImplemented:     openapi.Error(noAssignment),
+			http.StatusServiceUnavailable: openapi.Error("The Redis of worker.assignment is unavailable."),
+		}),
+	})
+}
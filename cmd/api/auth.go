@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// apiKeyHeader carries the ingestion API key. "Authorization: Bearer <key>" is accepted too.
+const apiKeyHeader = "X-API-Key"
+
+// errUnauthenticated is returned when tenants are configured and the caller presents no known API key.
+var errUnauthenticated = errors.New("missing or invalid API key")
+
+// authenticate maps an API key to its tenant. Without configured tenants ingestion is
+// open and every event belongs to the default tenant "".
+func (in *ingester) authenticate(key string) (string, error) {
+	cfg := in.store.Config()
+	if len(cfg.Tenants) == 0 {
+		return "", nil
+	}
+	if tenant := cfg.TenantForAPIKey(key); tenant != "" {
+		return tenant, nil
+	}
+	return "", errUnauthenticated
+}
+
+// requestAPIKey returns the API key of an HTTP request.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	return bearerToken(r.Header.Get("Authorization"))
+}
+
+// metadataAPIKey returns the API key of a gRPC call from the x-api-key or authorization metadata.
+func metadataAPIKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(strings.ToLower(apiKeyHeader)); len(keys) > 0 && keys[0] != "" {
+		return keys[0]
+	}
+	if auth := md.Get("authorization"); len(auth) > 0 {
+		return bearerToken(auth[0])
+	}
+	return ""
+}
+
+func bearerToken(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
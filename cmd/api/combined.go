@@ -0,0 +1,8 @@
+package main
+
+// Values of the -mode flag.
+const (
+	modeAPI = "api"
+	// modeAll also runs the worker in this process, sharing the config store.
+	modeAll = "all"
+)
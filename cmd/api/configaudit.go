@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-system/pkg/audit"
+	"notification-system/pkg/config"
+	"notification-system/pkg/openapi"
+)
+
+// noConfigLog answers requests for the config audit trail when the API keeps none.
+const noConfigLog = "Config audit trail is not kept: set audit.config_log"
+
+// actorKey is the context key of the requestActor of admin requests.
+type actorKey struct{}
+
+// requestActor identifies who made an admin request, for the config audit trail.
+type requestActor struct {
+	name       string
+	remoteAddr string
+}
+
+// withActor adds the requestActor of admin requests to their context: the user named by
+// audit.actor_header when the request comes from one of audit.trusted_proxies, or else the
+// api.admin user it was authenticated as by withAdminAuth.
+func withActor(next http.Handler, store *config.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		a := requestActor{remoteAddr: r.RemoteAddr}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			a.remoteAddr = host
+		}
+		a.name, _ = r.Context().Value(adminUserKey{}).(string)
+		if cfg := store.Config().Audit; cfg.ActorHeader != "" && cfg.TrustsProxy(a.remoteAddr) {
+			if name := r.Header.Get(cfg.ActorHeader); name != "" {
+				a.name = name
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, a)))
+	})
+}
+
+// registerAuditHandler records the config changes made through the admin endpoints in
+// audit.config_log and exposes them:
+//
+//	GET /admin/audit  the latest config changes, newest first
+//
+// ?since= and ?until= (RFC 3339) bound the time of the changes; ?actor=, ?action=,
+// ?tenant= and ?event_type= select them and ?limit= (default 100) caps their number.
+// audit.config_log is read at startup.
+func registerAuditHandler(mux *http.ServeMux, store *config.Store) {
+	var changes *audit.ConfigLog
+	if path := store.Config().Audit.ConfigLog; path != "" {
+		changes = audit.NewConfigLog(path)
+		store.OnUpdate(func(ctx context.Context, c config.Change) {
+			a, _ := ctx.Value(actorKey{}).(requestActor)
+			e, err := audit.NewConfigEntry(c, a.name, a.remoteAddr, time.Now())
+			if err == nil {
+				err = changes.Append(e)
+			}
+			if err != nil {
+				log.Printf("Failed to record config change %s of %s in the audit trail: %v", c.Action, c.EventType, err)
+			}
+		})
+	}
+
+	mux.HandleFunc("/admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if changes == nil {
+			http.Error(w, noConfigLog, http.StatusNotImplemented)
+			return
+		}
+
+		q := r.URL.Query()
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit: "+v, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		var since, until time.Time
+		for name, t := range map[string]*time.Time{"since": &since, "until": &until} {
+			if v := q.Get(name); v != "" {
+				parsed, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					http.Error(w, "Invalid "+name+": "+v, http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+		}
+
+		entries, err := changes.Entries(func(e audit.ConfigEntry) bool {
+			switch {
+			case !since.IsZero() && e.Time.Before(since), !until.IsZero() && !e.Time.Before(until):
+				return false
+			case q.Has("actor") && e.Actor != q.Get("actor"), q.Has("tenant") && e.Tenant != q.Get("tenant"):
+				return false
+			case q.Get("action") != "" && e.Action != q.Get("action"):
+				return false
+			case q.Get("event_type") != "" && e.EventType != q.Get("event_type"):
+				return false
+			}
+			return true
+		}, limit)
+		if err != nil {
+			log.Printf("Failed to read the config audit trail: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
+
+// describeAudit describes the endpoint of registerAuditHandler.
+func describeAudit(doc *openapi.Document) {
+	doc.Add(http.MethodGet, "/admin/audit", &openapi.Operation{
+		OperationID: "listConfigChanges",
+		Summary:     "List the latest config changes",
+		Description: "Changes made through the admin endpoints, newest first, with who made them and what changed. Secrets are masked.",
+		Tags:        []string{"admin"},
+		Parameters: []*openapi.Parameter{
+			openapi.Query("since", "string", "only changes at or after this RFC 3339 time"),
+			openapi.Query("until", "string", "only changes before this RFC 3339 time"),
+			openapi.Query("actor", "string", "only the changes of this user"),
+			openapi.Query("action", "string", "only changes of this action, e.g. update_notification"),
+			openapi.Query("tenant", "string", "only the changes of this tenant"),
+			openapi.Query("event_type", "string", "only the changes of this event type"),
+			openapi.Query("limit", "integer", "the maximum number of changes (default 100)"),
+		},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                  {Content: openapi.JSON(&openapi.Schema{Type: "array", Items: doc.Schema(audit.ConfigEntry{})})},
+			http.StatusBadRequest:          openapi.Error("A parameter is invalid."),
+			http.StatusNotImplemented:      openapi.Error(noConfigLog),
+			http.StatusInternalServerError: openapi.Error("The audit trail could not be read."),
+		}),
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"notification-system/pkg/config"
+)
+
+// withCORS answers preflight requests and adds the CORS headers of api.cors to responses
+// for allowed origins on its paths. Requests without an Origin header, and every request
+// while api.cors is unset, pass through unchanged. The config is read per request.
+func withCORS(next http.Handler, store *config.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cors := store.Config().API.CORS
+		origin := r.Header.Get("Origin")
+		if cors == nil || origin == "" || !corsPath(cors, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := corsOrigin(cors, origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+			if !allowed || !slices.Contains(cors.AllowedMethods, method) || !corsHeaders(cors, r.Header.Get("Access-Control-Request-Headers")) {
+				http.Error(w, "CORS request not allowed", http.StatusForbidden)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+			h.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Std().Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			h.Set("Access-Control-Allow-Origin", origin)
+			// Lets browsers read how long to back off after 429 and 503
+			h.Set("Access-Control-Expose-Headers", "Retry-After")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsPath reports whether path is open to other origins.
+func corsPath(cors *config.CORSConfig, path string) bool {
+	for _, p := range cors.Paths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOrigin reports whether origin matches one of the allowed origins.
+func corsOrigin(cors *config.CORSConfig, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range cors.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "/"))
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// "https://*.example.com" matches "https://app.example.com" but not "https://example.com"
+		if prefix, suffix, ok := strings.Cut(allowed, "://*."); ok &&
+			strings.HasPrefix(origin, prefix+"://") && strings.HasSuffix(origin, "."+suffix) &&
+			len(origin) > len(prefix)+len("://.")+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHeaders reports whether every header of an Access-Control-Request-Headers list is
+// allowed.
+func corsHeaders(cors *config.CORSConfig, requested string) bool {
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.ContainsFunc(cors.AllowedHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return false
+		}
+	}
+	return true
+}
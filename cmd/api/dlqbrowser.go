@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/openapi"
+	"notification-system/pkg/worker"
+)
+
+const (
+	dlqMessagesPath = "/admin/dlq/messages"
+	// dlqBrowserGroupSuffix is appended to mq.group_name for the consumer group of the DLQ
+	// browser, which keeps its own offsets on the DLQ topics.
+	dlqBrowserGroupSuffix = "_dlq_browser"
+	// maxDLQPage bounds the messages of one page of GET /admin/dlq/messages.
+	maxDLQPage = 500
+)
+
+const noDLQBrowser = "DLQ browsing is not configured: set api.dlq_browser"
+
+// dlqBrowser consumes the DLQ topics of the notifications into a redisDLQStore.
+type dlqBrowser struct {
+	store  *redisDLQStore
+	broker mq.Broker
+
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+// startDLQBrowser consumes the DLQ topics of cfg, and of notifications added later to
+// store, from their first message. The API replicas share one consumer group and the
+// Redis store of api.dlq_browser.
+func startDLQBrowser(cfg *config.Config, store *config.Store) (*dlqBrowser, error) {
+	mqCfg := cfg.MQ
+	mqCfg.GroupName += dlqBrowserGroupSuffix
+	mqCfg.ConsumeFrom = config.ConsumeFromFirst
+	b, err := mq.NewBroker(mqCfg)
+	if err != nil {
+		return nil, err
+	}
+	d := &dlqBrowser{store: newRedisDLQStore(cfg.API.DLQBrowser), broker: b, topics: make(map[string]bool)}
+	if err = d.subscribe(cfg); err == nil {
+		err = b.Start()
+	}
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+	store.OnChange(func(cfg *config.Config) {
+		if err := d.subscribe(cfg); err != nil {
+			log.Printf("Failed to browse the DLQ of a new topic: %v", err)
+		}
+	})
+	return d, nil
+}
+
+// subscribe consumes the DLQ topics of the notifications of cfg not consumed yet.
+func (d *dlqBrowser) subscribe(cfg *config.Config) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, n := range cfg.Notifications {
+		topic := "DLQ_" + n.QueueName
+		if d.topics[topic] {
+			continue
+		}
+		if err := d.broker.Subscribe(topic, d.keep); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+		}
+		d.topics[topic] = true
+	}
+	return nil
+}
+
+// keep stores a dead-lettered message unless it was dropped before.
+func (d *dlqBrowser) keep(ctx context.Context, del *mq.Delivery) error {
+	if del.Err != nil {
+		return del.Err
+	}
+	m := &dlqMessage{
+		ID:         del.ID,
+		Topic:      del.Topic,
+		Source:     strings.TrimPrefix(del.Topic, "DLQ_"),
+		Reason:     del.Properties[worker.DLQReasonProperty],
+		Error:      del.Properties[worker.DLQErrorProperty],
+		DeadAt:     del.BornTime,
+		Properties: del.Properties,
+		Body:       del.Body,
+	}
+	if ms, err := strconv.ParseInt(del.Properties[worker.DLQTimeProperty], 10, 64); err == nil {
+		m.DeadAt = time.UnixMilli(ms)
+	}
+	m.Retries, _ = strconv.Atoi(del.Properties[mq.RetryTimesProperty])
+	m.Redrives, _ = strconv.Atoi(del.Properties[worker.RedrivesProperty])
+	if err := d.store.add(ctx, m); err != nil {
+		log.Printf("Failed to keep DLQ message %s of %s: %v", del.ID, del.Topic, err)
+		return err
+	}
+	return nil
+}
+
+func (d *dlqBrowser) close() {
+	if err := d.broker.Close(); err != nil {
+		log.Printf("Failed to close the DLQ browser consumer: %v", err)
+	}
+	if err := d.store.Close(); err != nil {
+		log.Printf("Failed to close the DLQ browser store: %v", err)
+	}
+}
+
+// dlqPage is the body of GET /admin/dlq/messages.
+type dlqPage struct {
+	Messages []dlqMessage `json:"messages"`
+	// NextCursor is the ?cursor= of the next page; it is left out on the last page.
+	NextCursor int64 `json:"next_cursor,omitempty"`
+}
+
+// dlqPreview is the body of GET /admin/dlq/messages/{id}.
+type dlqPreview struct {
+	dlqMessage
+	// Event is the decoded event. The body is only returned when it can't be decoded,
+	// with DecodeError saying why.
+	Event       *event.Event `json:"event,omitempty"`
+	DecodeError string       `json:"decode_error,omitempty"`
+}
+
+// dlqSelection is the body of POST /admin/dlq/redrive and POST /admin/dlq/purge.
+type dlqSelection struct {
+	// IDs are the messages selected.
+	IDs []string `json:"ids,omitempty"`
+	// Topic selects every message of a DLQ topic; purge only.
+	Topic string `json:"topic,omitempty"`
+}
+
+// dlqRedriveResult reports what a redrive did.
+type dlqRedriveResult struct {
+	Redriven []string `json:"redriven"`
+	NotFound []string `json:"not_found,omitempty"`
+	// Tombstoned are the messages worker.redrive redrove before; they are dropped.
+	Tombstoned []string `json:"tombstoned,omitempty"`
+	// Errors holds the messages that could not be published, by ID; they stay in the DLQ.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// dlqPurgeResult reports how many messages a purge dropped.
+type dlqPurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// registerDLQHandlers exposes the messages kept by the DLQ browser d:
+//
+//	GET    /admin/dlq/messages?topic=DLQ_x  the messages of a DLQ topic, newest first
+//	GET    /admin/dlq/messages/{id}         a message with its decoded event
+//	DELETE /admin/dlq/messages/{id}         drop a message
+//	POST   /admin/dlq/redrive               publish messages back to their topics and drop them
+//	POST   /admin/dlq/purge                 drop messages, or every message of a topic
+//
+// Lists take ?limit= (default 50, at most 500) and the ?cursor= of the previous page. The
+// selections are {"ids": ["..."]}, or {"topic": "DLQ_x"} to purge a topic. The broker
+// keeps dropped messages for its retention, but they are tombstoned so worker.redrive
+// doesn't redrive them (again). d is nil without api.dlq_browser.
+func registerDLQHandlers(mux *http.ServeMux, store *config.Store, in *ingester, d *dlqBrowser) {
+	mux.HandleFunc(dlqMessagesPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if d == nil {
+			http.Error(w, noDLQBrowser, http.StatusNotImplemented)
+			return
+		}
+
+		q := r.URL.Query()
+		topic := q.Get("topic")
+		if topic == "" {
+			http.Error(w, "topic is required", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxDLQPage {
+				http.Error(w, "Invalid limit: "+v, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		var cursor int64
+		if v := q.Get("cursor"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid cursor: "+v, http.StatusBadRequest)
+				return
+			}
+			cursor = n
+		}
+
+		msgs, next, err := d.store.list(r.Context(), topic, cursor, limit)
+		if err != nil {
+			log.Printf("Failed to list the messages of %s: %v", topic, err)
+			http.Error(w, "DLQ messages are not available", http.StatusServiceUnavailable)
+			return
+		}
+		for i := range msgs {
+			msgs[i].Body = nil
+		}
+		writeJSON(w, http.StatusOK, dlqPage{Messages: msgs, NextCursor: next})
+	})
+
+	mux.HandleFunc(dlqMessagesPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, dlqMessagesPath+"/")
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if d == nil {
+			http.Error(w, noDLQBrowser, http.StatusNotImplemented)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			n, err := d.store.remove(r.Context(), []string{id})
+			if err != nil {
+				log.Printf("Failed to drop DLQ message %s: %v", id, err)
+				http.Error(w, "DLQ messages are not available", http.StatusServiceUnavailable)
+				return
+			}
+			if n == 0 {
+				http.Error(w, "Message not found: "+id, http.StatusNotFound)
+				return
+			}
+			log.Printf("Dropped DLQ message %s", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		m, err := d.store.get(r.Context(), id)
+		if err != nil {
+			log.Printf("Failed to get DLQ message %s: %v", id, err)
+			http.Error(w, "DLQ messages are not available", http.StatusServiceUnavailable)
+			return
+		}
+		if m == nil {
+			http.Error(w, "Message not found: "+id, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, in.preview(r.Context(), store.Config(), m))
+	})
+
+	mux.HandleFunc("/admin/dlq/redrive", func(w http.ResponseWriter, r *http.Request) {
+		sel, ok := dlqSelect(w, r, d)
+		if !ok {
+			return
+		}
+		if len(sel.IDs) == 0 {
+			http.Error(w, "ids is required", http.StatusBadRequest)
+			return
+		}
+
+		cfg := store.Config()
+		result := dlqRedriveResult{Redriven: []string{}}
+		for _, id := range sel.IDs {
+			m, err := d.store.get(r.Context(), id)
+			if err == nil && m == nil {
+				result.NotFound = append(result.NotFound, id)
+				continue
+			}
+			claimed := false
+			if err == nil {
+				claimed, err = d.store.tombstones.Claim(r.Context(), id)
+			}
+			if err == nil && !claimed {
+				// worker.redrive redrove it meanwhile
+				if _, err = d.store.remove(r.Context(), []string{id}); err == nil {
+					result.Tombstoned = append(result.Tombstoned, id)
+					continue
+				}
+			}
+			if err == nil {
+				err = in.broker.Publish(r.Context(), m.Source, m.Body,
+					mq.WithProperties(worker.RedriveProperties(m.Properties)), mq.Compression(cfg.MQ))
+				if err != nil {
+					if rErr := d.store.tombstones.Release(r.Context(), id); rErr != nil {
+						log.Printf("Failed to release the tombstone of DLQ message %s: %v", id, rErr)
+					}
+				}
+			}
+			if err == nil {
+				_, err = d.store.remove(r.Context(), []string{id})
+			}
+			if err != nil {
+				if result.Errors == nil {
+					result.Errors = make(map[string]string)
+				}
+				result.Errors[id] = err.Error()
+				continue
+			}
+			result.Redriven = append(result.Redriven, id)
+		}
+		log.Printf("Redrove %d DLQ messages (%d not found, %d redriven before, %d failed)", len(result.Redriven), len(result.NotFound), len(result.Tombstoned), len(result.Errors))
+		writeJSON(w, http.StatusOK, result)
+	})
+
+	mux.HandleFunc("/admin/dlq/purge", func(w http.ResponseWriter, r *http.Request) {
+		sel, ok := dlqSelect(w, r, d)
+		if !ok {
+			return
+		}
+		if (len(sel.IDs) == 0) == (sel.Topic == "") {
+			http.Error(w, "Either ids or topic is required", http.StatusBadRequest)
+			return
+		}
+
+		var result dlqPurgeResult
+		var err error
+		if sel.Topic != "" {
+			result.Purged, err = d.store.purge(r.Context(), sel.Topic)
+		} else {
+			result.Purged, err = d.store.remove(r.Context(), sel.IDs)
+		}
+		if err != nil {
+			log.Printf("DLQ purge failed after %d messages: %v", result.Purged, err)
+			http.Error(w, "DLQ messages are not available", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Purged %d DLQ messages", result.Purged)
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// dlqSelect decodes the selection of a POST to d, answering the request if that fails.
+func dlqSelect(w http.ResponseWriter, r *http.Request, d *dlqBrowser) (dlqSelection, bool) {
+	var sel dlqSelection
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return sel, false
+	}
+	if d == nil {
+		http.Error(w, noDLQBrowser, http.StatusNotImplemented)
+		return sel, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&sel); err != nil {
+		writeDecodeError(w, err)
+		return sel, false
+	}
+	return sel, true
+}
+
+// preview decodes the event of m, decrypting it with the keys of mq.encryption.
+func (in *ingester) preview(ctx context.Context, cfg *config.Config, m *dlqMessage) dlqPreview {
+	p := dlqPreview{dlqMessage: *m}
+	body, err := mq.Decrypt(ctx, in.keyring, cfg.MQ.Encryption, m.Body, m.Properties)
+	if err == nil {
+		var evt event.Event
+		if evt, err = mq.DecodeEvent(body, m.Properties[mq.ContentTypeProperty]); err == nil {
+			p.Event = &evt
+			p.Body = nil
+			return p
+		}
+	}
+	p.DecodeError = err.Error()
+	return p
+}
+
+// describeDLQ describes the endpoints of registerDLQHandlers.
+func describeDLQ(doc *openapi.Document) {
+	notConfigured := openapi.Error(noDLQBrowser)
+	unavailable := openapi.Error("The store of api.dlq_browser is unavailable.")
+	id := openapi.PathParam("id", "the ID of the message")
+	selection := openapi.Body("The messages selected.", doc.Schema(dlqSelection{}))
+
+	doc.Add(http.MethodGet, dlqMessagesPath, &openapi.Operation{
+		OperationID: "listDLQMessages",
+		Summary:     "List the messages of a DLQ topic",
+		Description: "The dead-lettered messages kept by the DLQ browser, newest first, without their bodies.",
+		Tags:        []string{"admin"},
+		Parameters: []*openapi.Parameter{
+			openapi.Query("topic", "string", "the DLQ topic, e.g. DLQ_order_queue"),
+			openapi.Query("limit", "integer", "the maximum number of messages (default 50, at most 500)"),
+			openapi.Query("cursor", "integer", "the next_cursor of the previous page"),
+		},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                 {Content: openapi.JSON(doc.Schema(dlqPage{}))},
+			http.StatusBadRequest:         openapi.Error("The topic is missing, or the limit or cursor is invalid."),
+			http.StatusNotImplemented:     notConfigured,
+			http.StatusServiceUnavailable: unavailable,
+		}),
+	})
+	doc.Add(http.MethodGet, dlqMessagesPath+"/{id}", &openapi.Operation{
+		OperationID: "getDLQMessage",
+		Summary:     "Preview a DLQ message",
+		Description: "The message with its failure metadata and decoded event, or its body if it can't be decoded.",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{id},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                 {Content: openapi.JSON(doc.Schema(dlqPreview{}))},
+			http.StatusNotFound:           openapi.Error("The message is not kept."),
+			http.StatusNotImplemented:     notConfigured,
+			http.StatusServiceUnavailable: unavailable,
+		}),
+	})
+	doc.Add(http.MethodDelete, dlqMessagesPath+"/{id}", &openapi.Operation{
+		OperationID: "deleteDLQMessage",
+		Summary:     "Drop a DLQ message",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{id},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusNoContent:          {Description: "The message was dropped."},
+			http.StatusNotFound:           openapi.Error("The message is not kept."),
+			http.StatusNotImplemented:     notConfigured,
+			http.StatusServiceUnavailable: unavailable,
+		}),
+	})
+	doc.Add(http.MethodPost, "/admin/dlq/redrive", &openapi.Operation{
+		OperationID: "redriveDLQMessages",
+		Summary:     "Redrive DLQ messages",
+		Description: "Publishes the messages back to the topics they were dead-lettered from, starting over with their retries, and drops them. Messages worker.redrive redrove already are dropped without being published again.",
+		Tags:        []string{"admin"},
+		RequestBody: selection,
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:             {Content: openapi.JSON(doc.Schema(dlqRedriveResult{}))},
+			http.StatusBadRequest:     openapi.Error("The ids are missing."),
+			http.StatusNotImplemented: notConfigured,
+		}),
+	})
+	doc.Add(http.MethodPost, "/admin/dlq/purge", &openapi.Operation{
+		OperationID: "purgeDLQMessages",
+		Summary:     "Purge DLQ messages",
+		Description: "Drops the messages selected by ID, or every message of a DLQ topic. worker.redrive doesn't redrive dropped messages.",
+		Tags:        []string{"admin"},
+		RequestBody: selection,
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                 {Content: openapi.JSON(doc.Schema(dlqPurgeResult{}))},
+			http.StatusBadRequest:         openapi.Error("Neither or both of ids and topic are set."),
+			http.StatusNotImplemented:     notConfigured,
+			http.StatusServiceUnavailable: unavailable,
+		}),
+	})
+}
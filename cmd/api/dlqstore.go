@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/worker"
+)
+
+// dlqRedisTimeout bounds one Redis request of the DLQ browser.
+const dlqRedisTimeout = 2 * time.Second
+
+// dlqMessage is a dead-lettered message kept by the DLQ browser.
+type dlqMessage struct {
+	ID string `json:"id"`
+	// Topic is the DLQ topic of the message, Source the topic it was dead-lettered from.
+	Topic  string `json:"topic"`
+	Source string `json:"source"`
+	// Reason and Error are the NOTIFY_DLQ_REASON and NOTIFY_DLQ_ERROR of the message.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// DeadAt is when the message was dead-lettered.
+	DeadAt time.Time `json:"dead_at"`
+	// Retries are the MQ retries before it was dead-lettered, Redrives how often it was
+	// redriven before.
+	Retries    int               `json:"retries"`
+	Redrives   int               `json:"redrives,omitempty"`
+	Properties map[string]string `json:"properties"`
+	// Body is the body as consumed: encrypted bodies stay encrypted. Lists leave it out.
+	Body []byte `json:"body,omitempty"`
+
+	// seq orders the messages of a topic as they were kept.
+	seq int64
+}
+
+// dlqAddScript keeps the message ARGV[2] in KEYS[1] unless it is kept already, indexes it
+// in the sorted set of its topic KEYS[2] by the sequence KEYS[3], and drops the oldest
+// messages of the topic beyond ARGV[3]; ARGV[4] prefixes their IDs to their keys.
+// Returns 1 when the message was added.
+var dlqAddScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return 0
+end
+local seq = redis.call('INCR', KEYS[3])
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], seq, ARGV[2])
+local excess = redis.call('ZCARD', KEYS[2]) - tonumber(ARGV[3])
+if excess > 0 then
+  for _, id in ipairs(redis.call('ZRANGE', KEYS[2], 0, excess - 1)) do
+    redis.call('DEL', ARGV[4] .. id)
+  end
+  redis.call('ZREMRANGEBYRANK', KEYS[2], 0, excess - 1)
+end
+return 1
+`)
+
+// redisDLQStore keeps the messages of the DLQ browser in Redis, by ID: every message as
+// JSON under msg:<id>, and the IDs of each topic in the sorted set topic:<topic>, scored
+// by the sequence seq.
+// Messages dropped from the store are tombstoned.
+type redisDLQStore struct {
+	client     *redis.Client
+	prefix     string
+	capacity   int
+	tombstones *worker.DLQTombstones
+}
+
+func newRedisDLQStore(cfg *config.DLQBrowserConfig) *redisDLQStore {
+	client, prefix := cfg.Redis.Client(dlqRedisTimeout, worker.DLQKeyPrefix)
+	return &redisDLQStore{client: client, prefix: prefix, capacity: cfg.Capacity, tombstones: worker.NewDLQTombstones(client, prefix)}
+}
+
+func (s *redisDLQStore) messageKey(id string) string { return s.prefix + "msg:" + id }
+
+func (s *redisDLQStore) topicKey(topic string) string { return s.prefix + "topic:" + topic }
+
+// add keeps m unless a message with its ID is kept already or was dropped, dropping the
+// oldest messages of its topic beyond the capacity.
+func (s *redisDLQStore) add(ctx context.Context, m *dlqMessage) error {
+	if dropped, err := s.tombstones.Marked(ctx, m.ID); err != nil || dropped {
+		return err
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	keys := []string{s.messageKey(m.ID), s.topicKey(m.Topic), s.prefix + "seq"}
+	return dlqAddScript.Run(ctx, s.client, keys, body, m.ID, s.capacity, s.prefix+"msg:").Err()
+}
+
+// list returns up to limit messages of topic kept before cursor (0 for the newest), newest
+// first, and the cursor of the next page (0 on the last page).
+func (s *redisDLQStore) list(ctx context.Context, topic string, cursor int64, limit int) ([]dlqMessage, int64, error) {
+	max := "+inf"
+	if cursor > 0 {
+		max = "(" + strconv.FormatInt(cursor, 10)
+	}
+	// One more than asked tells whether there is a next page
+	ids, err := s.client.ZRevRangeByScoreWithScores(ctx, s.topicKey(topic), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: int64(limit) + 1,
+	}).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	var next int64
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = int64(ids[limit-1].Score)
+	}
+	out := []dlqMessage{}
+	if len(ids) == 0 {
+		return out, next, nil
+	}
+	keys := make([]string, len(ids))
+	for i, z := range ids {
+		keys[i] = s.messageKey(z.Member.(string))
+	}
+	bodies, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, body := range bodies {
+		str, ok := body.(string)
+		if !ok {
+			// Removed since the index was read
+			continue
+		}
+		var m dlqMessage
+		if err := json.Unmarshal([]byte(str), &m); err != nil {
+			return nil, 0, fmt.Errorf("invalid message %s: %w", keys[i], err)
+		}
+		m.seq = int64(ids[i].Score)
+		out = append(out, m)
+	}
+	return out, next, nil
+}
+
+// get returns the message with the ID, or nil.
+func (s *redisDLQStore) get(ctx context.Context, id string) (*dlqMessage, error) {
+	body, err := s.client.Get(ctx, s.messageKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m dlqMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("invalid message %s: %w", id, err)
+	}
+	return &m, nil
+}
+
+// remove drops the messages with the IDs and returns how many were kept.
+func (s *redisDLQStore) remove(ctx context.Context, ids []string) (int, error) {
+	if err := s.tombstones.Mark(ctx, ids...); err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		m, err := s.get(ctx, id)
+		if err != nil {
+			return removed, err
+		}
+		if m == nil {
+			continue
+		}
+		pipe := s.client.TxPipeline()
+		pipe.ZRem(ctx, s.topicKey(m.Topic), id)
+		del := pipe.Del(ctx, s.messageKey(id))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return removed, err
+		}
+		removed += int(del.Val())
+	}
+	return removed, nil
+}
+
+// purge drops every message of topic and returns how many were kept.
+func (s *redisDLQStore) purge(ctx context.Context, topic string) (int, error) {
+	purged := 0
+	for {
+		ids, err := s.client.ZRange(ctx, s.topicKey(topic), 0, 499).Result()
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+		if err := s.tombstones.Mark(ctx, ids...); err != nil {
+			return purged, err
+		}
+		keys := make([]string, len(ids))
+		members := make([]interface{}, len(ids))
+		for i, id := range ids {
+			keys[i] = s.messageKey(id)
+			members[i] = id
+		}
+		pipe := s.client.TxPipeline()
+		del := pipe.Del(ctx, keys...)
+		pipe.ZRem(ctx, s.topicKey(topic), members...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return purged, err
+		}
+		purged += int(del.Val())
+	}
+}
+
+func (s *redisDLQStore) Close() error { return s.client.Close() }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"notification-system/pkg/event"
+	"notification-system/pkg/eventpb"
+	"notification-system/pkg/mq"
+)
+
+// ingestionServer implements eventpb.EventIngestionServer on top of the shared ingester.
+type ingestionServer struct {
+	eventpb.UnimplementedEventIngestionServer
+
+	*ingester
+}
+
+// PublishEvent validates and publishes a single event.
+func (s *ingestionServer) PublishEvent(ctx context.Context, req *eventpb.PublishEventRequest) (*eventpb.PublishEventResponse, error) {
+	if req.GetEvent() == nil {
+		return nil, status.Error(codes.InvalidArgument, "event is required")
+	}
+	release, err := s.admit()
+	if err != nil {
+		return nil, overloadedStatus(ctx, err)
+	}
+	defer release()
+
+	key := metadataAPIKey(ctx)
+	tenant, err := s.authenticate(key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := s.checkQuota(tenant, key); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	async, err := s.sendMode("")
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	evt := eventpb.ToEvent(req.GetEvent())
+	correlationID, traceparent := grpcRequestMetadata(ctx)
+	inheritMetadata(&evt, correlationID, traceparent)
+	if err := s.publish(ctx, tenant, &evt, async); err != nil {
+		return nil, toStatus(err)
+	}
+	return &eventpb.PublishEventResponse{Id: evt.ID}, nil
+}
+
+// PublishEventBatch publishes each event independently and reports a result per event
+// (see ingester.publishBatch).
+func (s *ingestionServer) PublishEventBatch(ctx context.Context, req *eventpb.PublishEventBatchRequest) (*eventpb.PublishEventBatchResponse, error) {
+	if len(req.GetEvents()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one event is required")
+	}
+	release, err := s.admit()
+	if err != nil {
+		return nil, overloadedStatus(ctx, err)
+	}
+	defer release()
+	key := metadataAPIKey(ctx)
+	tenant, err := s.authenticate(key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	async, err := s.sendMode("")
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	correlationID, traceparent := grpcRequestMetadata(ctx)
+	events := make([]event.Event, len(req.GetEvents()))
+	for i, pe := range req.GetEvents() {
+		events[i] = eventpb.ToEvent(pe)
+		inheritMetadata(&events[i], correlationID, traceparent)
+	}
+	resp := &eventpb.PublishEventBatchResponse{
+		Results: make([]*eventpb.PublishResult, 0, len(events)),
+	}
+	for _, r := range s.publishBatch(ctx, tenant, key, events, async) {
+		resp.Results = append(resp.Results, &eventpb.PublishResult{Index: int32(r.Index), Id: r.ID, Error: r.Error})
+	}
+	return resp, nil
+}
+
+// toStatus maps ingester errors to gRPC status codes.
+func toStatus(err error) error {
+	var vErr *validationError
+	if errors.As(err, &vErr) {
+		return status.Error(codes.InvalidArgument, vErr.Error())
+	}
+	if errors.Is(err, mq.ErrBufferFull) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	log.Printf("Failed to send message: %v", err)
+	return status.Error(codes.Internal, "internal server error")
+}
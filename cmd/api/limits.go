@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// withBodyLimit answers 413 to requests announcing a body over api.max_body_bytes and
+// stops reading bodies at that size. The limit is read per request, so config changes
+// apply at once.
+func withBodyLimit(next http.Handler, store *config.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := store.Config().API.MaxBodyBytes
+		if r.ContentLength > limit {
+			writeTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeDecodeError answers a request whose body could not be decoded: 413 when it was
+// cut off by a body limit, 400 otherwise.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeTooLarge(w, tooLarge.Limit)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+}
+
+// disableWriteTimeout lifts the server's write timeout for a response that legitimately
+// takes long, such as a stream.
+func disableWriteTimeout(w http.ResponseWriter) {
+	// Fails only for writers that don't support deadlines, which then have none
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
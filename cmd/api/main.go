@@ -3,101 +3,300 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
-	"github.com/apache/rocketmq-client-go/v2"
+	"google.golang.org/grpc"
 
+	"notification-system/pkg/archive"
 	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/diag"
 	"notification-system/pkg/event"
+	"notification-system/pkg/eventpb"
+	"notification-system/pkg/health"
 	"notification-system/pkg/mq"
+	"notification-system/pkg/openapi"
+	"notification-system/pkg/ratelimit"
+	"notification-system/pkg/schema"
+	"notification-system/pkg/secrets"
+	"notification-system/pkg/worker"
 )
 
 func main() {
+	configSource := flag.String("config", "config.json", "config file path or etcd://, consul:// source")
+	debugAddr := flag.String("debug-addr", "", "enable pprof and /debug/status on this address (e.g. localhost:6060)")
+	mode := flag.String("mode", modeAPI, "api, or all to also run the worker in this process")
+	addr := flag.String("addr", "", "HTTP listen address (default api.addr)")
+	grpcAddr := flag.String("grpc-addr", ":9090", "gRPC listen address")
+	printSpec := flag.Bool("openapi", false, "print the OpenAPI spec of the HTTP API and exit")
+	flag.Parse()
+	if *printSpec {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(apiSpec()); err != nil {
+			log.Fatalf("Failed to write OpenAPI spec: %v", err)
+		}
+		return
+	}
+	if *mode != modeAPI && *mode != modeAll {
+		log.Fatalf("Invalid -mode %q: must be %s or %s", *mode, modeAPI, modeAll)
+	}
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+
 	// 1. Load and Validate Configuration
-	cfg, err := config.LoadConfig("config.json")
+	store, err := config.OpenStore(watchCtx, *configSource)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 	log.Println("Configuration loaded and validated.")
+	go store.Watch(watchCtx)
 
-	// 2. Initialize Producer (for Event Ingestion)
-	producer, err := mq.NewProducer(cfg.MQ.NameServer, cfg.MQ.AccessKey, cfg.MQ.SecretKey)
+	// 2. Initialize Producer (for Event Ingestion) with secret references resolved
+	resolver := secrets.NewDefaultResolver()
+	cfg, err := resolver.ResolveConfig(watchCtx, store.Config())
+	if err != nil {
+		log.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	if err := mq.EnsureTopics(watchCtx, cfg.MQ, cfg.Topics()); err != nil {
+		log.Fatalf("%v", err)
+	}
+	broker, err := newProducer(cfg.MQ, cfg.API)
 	if err != nil {
 		log.Fatalf("Failed to start producer: %v", err)
 	}
-	defer producer.Shutdown()
-	log.Println("RocketMQ Producer initialized.")
+	log.Printf("%s producer initialized.", cfg.MQ.Broker)
+	if cfg.MQ.Faults != nil {
+		log.Printf("WARNING: mq.faults fails %.0f%% of sends; don't run this configuration in production", cfg.MQ.Faults.SendErrorRate*100)
+	}
+
+	// Schemas are compiled lazily and recompiled after config changes
+	schemas := schema.NewValidator()
+	store.OnChange(func(*config.Config) { schemas.Reset() })
+	quotas, err := ratelimit.Open(cfg.RateLimit.Redis)
+	if err != nil {
+		log.Fatalf("Failed to create rate limiter: %v", err)
+	}
+	in := &ingester{broker: broker, store: store, schemas: schemas, registry: schema.NewRegistry(), keyring: secrets.NewKeyring(resolver), quotas: quotas}
+	in.async = mq.NewAsyncSender(broker, cfg.API.AsyncBuffer, func(topic string, body []byte, err error) {
+		evt, _ := mq.DecodeEvent(body, "")
+		log.Printf("Async send of event %s to %s failed: %v", evt.ID, topic, err)
+	})
+	if cfg.Archive.URL != "" {
+		if in.archive, err = archive.Open(watchCtx, cfg.Archive.URL); err != nil {
+			log.Fatalf("Failed to open event archive: %v", err)
+		}
+		log.Printf("Archiving ingested events to %s", cfg.Archive.URL)
+	}
 
 	// 3. Setup HTTP Server (Event Ingestion API)
-	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
-		handleEventIngestion(w, r, producer, cfg)
+	http.HandleFunc("/events", in.handleEvents)
+	http.HandleFunc("/events/batch", in.handleEventBatch)
+	registerAdminHandlers(http.DefaultServeMux, store)
+	registerPauseHandlers(http.DefaultServeMux, store)
+	registerAuditHandler(http.DefaultServeMux, store)
+	registerReplayHandler(http.DefaultServeMux, in)
+	registerOpenAPIHandler(http.DefaultServeMux)
+
+	checks := health.NewRegistry()
+	checks.Register("config", func(ctx context.Context) error {
+		if store.Config() == nil {
+			return fmt.Errorf("configuration not loaded")
+		}
+		return nil
 	})
+	checks.Register("mq", func(ctx context.Context) error {
+		return mq.Ping(ctx, store.Config().MQ)
+	})
+	checks.Register("producer", broker.check)
+
+	// In combined mode the worker runs alongside the servers and reports through their endpoints
+	var w *worker.Worker
+	if *mode == modeAll {
+		if w, err = worker.NewWorker(cfg); err != nil {
+			log.Fatalf("Failed to initialize worker: %v", err)
+		}
+	}
+	// Pick up config changes and refreshed secrets at runtime: the producer is re-created
+	// with them, and the worker applies them like in cmd/worker
+	resolver.Track(watchCtx, store, func(cfg *config.Config) {
+		broker.setConfig(cfg.MQ)
+		if w == nil {
+			return
+		}
+		if err := w.UpdateConfig(cfg); err != nil {
+			log.Printf("Failed to apply config change to worker: %v", err)
+		}
+	})
+	if w != nil {
+		if err := w.Start(watchCtx); err != nil {
+			log.Fatalf("Failed to start worker: %v", err)
+		}
+		log.Printf("%s subscriber (worker) started.", cfg.MQ.Broker)
+		checks.Register("subscriptions", func(ctx context.Context) error {
+			return w.Ready()
+		})
+	}
+	checks.RegisterHandlers(http.DefaultServeMux)
+
+	// Delivery status comes from the worker in this process, or else from mq.status_topic
+	var feed *delivery.Feed
+	if w != nil {
+		feed = w.Feed
+	} else if cfg.MQ.StatusTopic != "" {
+		var statusBroker mq.Broker
+		if feed, statusBroker, err = subscribeStatus(cfg.MQ); err != nil {
+			log.Fatalf("Failed to subscribe to %s: %v", cfg.MQ.StatusTopic, err)
+		}
+		defer statusBroker.Close()
+		log.Printf("Streaming delivery status from %s", cfg.MQ.StatusTopic)
+	}
+	stopStreams := make(chan struct{})
+	registerStreamHandler(http.DefaultServeMux, feed, stopStreams)
+	var stats *delivery.Stats
+	if feed != nil {
+		stats = delivery.NewStats()
+		go collectStats(feed, stats)
+	}
+	registerStatsHandlers(http.DefaultServeMux, store, stats)
+	registerAssignmentHandler(http.DefaultServeMux, store, w)
+	var dlq *dlqBrowser
+	if cfg.API.DLQBrowser != nil {
+		if dlq, err = startDLQBrowser(cfg, store); err != nil {
+			log.Fatalf("Failed to browse the DLQ topics: %v", err)
+		}
+		defer dlq.close()
+	}
+	registerDLQHandlers(http.DefaultServeMux, store, in, dlq)
+
+	if *debugAddr != "" {
+		debugServer := diag.Serve(*debugAddr, func() map[string]interface{} {
+			status := map[string]interface{}{
+				"notifications":        len(store.Config().Notifications),
+				"quotas":               in.quotas.Stats(),
+				"async_pending":        in.async.Pending(),
+				"async_failed":         in.async.Failed(),
+				"producer_recreations": broker.Recreations(),
+				"spool":                broker.spoolStatus(),
+				"shed_requests":        in.shed.Load(),
+			}
+			if w != nil {
+				for k, v := range w.Status() {
+					status[k] = v
+				}
+			}
+			return status
+		})
+		defer debugServer.Close()
+	}
 
 	// 4. Start Server
-	server := &http.Server{Addr: ":8080"}
+	requests := &inflight{}
+	handler := requests.handler(withBodyLimit(withCompression(withAdminAuth(withActor(http.DefaultServeMux, store), store), store), store))
+	if *addr == "" {
+		*addr = cfg.API.Addr
+	}
+	server, err := newServer(watchCtx, *addr, cfg.API, withCORS(handler, store))
+	if err != nil {
+		log.Fatalf("Failed to configure server: %v", err)
+	}
+	// Shutdown waits for idle connections, which streams never are
+	server.RegisterOnShutdown(func() { close(stopStreams) })
 	go func() {
-		log.Println("API Server started on :8080")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("API Server started on %s (protocols %s, TLS %t)", *addr, server.Protocols, server.TLSConfig != nil)
+		if err := serve(server); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
-	// 5. Graceful Shutdown
+	// 5. Setup and Start gRPC Server (Event Ingestion API for internal services)
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(requests.unaryInterceptor))
+	eventpb.RegisterEventIngestionServer(grpcServer, &ingestionServer{ingester: in})
+	lis, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		log.Printf("gRPC Server started on %s", *grpcAddr)
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+
+	// 6. Graceful Shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 
 	log.Println("Shutting down API Server...")
-
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer shutdownCancel()
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	shutdown(server, grpcServer, requests, in, broker, cfg.API.ShutdownTimeout.Std())
+	if w != nil {
+		log.Println("Shutting down Worker...")
+		if err := w.Shutdown(); err != nil {
+			log.Printf("Worker shutdown error: %v", err)
+		}
 	}
 
 	log.Println("API Server exited")
 }
 
-func handleEventIngestion(w http.ResponseWriter, r *http.Request, producer rocketmq.Producer, cfg *config.Config) {
+// handleEvents serves POST /events.
+func (in *ingester) handleEvents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	var evt event.Event
-	if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	release, err := in.admit()
+	if err != nil {
+		writeOverloaded(w, err)
 		return
 	}
+	defer release()
 
-	// Basic validation
-	if evt.Type == "" {
-		http.Error(w, "Event type is required", http.StatusBadRequest)
+	key := requestAPIKey(r)
+	tenant, err := in.authenticate(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
-
-	// Find config to get Topic (QueueName)
-	notifyConfig := cfg.FindNotificationConfig(evt.Type)
-	if notifyConfig == nil {
-		http.Error(w, "Unknown event type: "+evt.Type, http.StatusBadRequest)
+	if err := in.checkQuota(tenant, key); err != nil {
+		writeQuotaError(w, err)
 		return
 	}
-	topic := notifyConfig.QueueName
 
-	// Ensure timestamp is set
-	if evt.Timestamp.IsZero() {
-		evt.Timestamp = time.Now()
+	async, err := in.sendMode(r.URL.Query().Get("send_mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	body, _ := json.Marshal(evt)
+	var evt event.Event
+	if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	inheritMetadata(&evt, r.Header.Get(correlationIDHeader), r.Header.Get(traceparentHeader))
 
-	if err := mq.SendMessage(context.Background(), producer, topic, body); err != nil {
+	if err := in.publish(r.Context(), tenant, &evt, async); err != nil {
+		var vErr *validationError
+		if errors.As(err, &vErr) {
+			http.Error(w, vErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, mq.ErrBufferFull) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		log.Printf("Failed to send message: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -106,3 +305,107 @@ func handleEventIngestion(w http.ResponseWriter, r *http.Request, producer rocke
 	w.WriteHeader(http.StatusAccepted)
 	fmt.Fprintf(w, "Event accepted")
 }
+
+// eventBatch is the body of POST /events/batch.
+type eventBatch struct {
+	Events []event.Event `json:"events"`
+}
+
+// eventBatchResult is the response of POST /events/batch.
+type eventBatchResult struct {
+	Results []batchResult `json:"results"`
+}
+
+// handleEventBatch serves POST /events/batch, the HTTP counterpart of PublishEventBatch.
+// It answers 200 with a result per event; events over quota fail individually.
+func (in *ingester) handleEventBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	release, err := in.admit()
+	if err != nil {
+		writeOverloaded(w, err)
+		return
+	}
+	defer release()
+
+	key := requestAPIKey(r)
+	tenant, err := in.authenticate(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	async, err := in.sendMode(r.URL.Query().Get("send_mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var batch eventBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if len(batch.Events) == 0 {
+		http.Error(w, "At least one event is required", http.StatusBadRequest)
+		return
+	}
+	for i := range batch.Events {
+		inheritMetadata(&batch.Events[i], r.Header.Get(correlationIDHeader), r.Header.Get(traceparentHeader))
+	}
+
+	writeJSON(w, http.StatusOK, eventBatchResult{Results: in.publishBatch(r.Context(), tenant, key, batch.Events, async)})
+}
+
+// describeEvents describes the ingestion endpoints.
+func describeEvents(doc *openapi.Document) {
+	evt := doc.Schema(event.Event{})
+	schema := doc.Component(event.Event{})
+	schema.Description = "A business event that occurred in the system."
+	schema.Required = []string{"type"}
+	// Set by the API from the caller's credentials and by the worker
+	schema.Properties["tenant_id"].ReadOnly = true
+	schema.Properties["digest"].ReadOnly = true
+
+	security := []map[string][]string{{securityAPIKey: {}}, {securityBearer: {}}}
+	sendMode := openapi.Query("send_mode", "string", "sync waits for the broker, async returns once the event is buffered (default api.send_mode)")
+	sendMode.Schema.Enum = []interface{}{config.SendModeSync, config.SendModeAsync}
+
+	doc.Add(http.MethodPost, "/events", &openapi.Operation{
+		OperationID: "publishEvent",
+		Summary:     "Publish an event",
+		Description: "Validates the event and publishes it to the queue of its notification.",
+		Tags:        []string{"events"},
+		Parameters:  []*openapi.Parameter{sendMode},
+		RequestBody: openapi.Body("The event.", evt),
+		Security:    security,
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusAccepted:              {Description: "The event was accepted."},
+			http.StatusBadRequest:            openapi.Error("The event is invalid or its type has no notification."),
+			http.StatusUnauthorized:          openapi.Error("The API key is missing or unknown."),
+			http.StatusRequestEntityTooLarge: openapi.Error("The body exceeds api.max_body_bytes."),
+			http.StatusTooManyRequests:       quotaExceeded(),
+			http.StatusInternalServerError:   openapi.Error("The event could not be sent to the broker."),
+			http.StatusServiceUnavailable:    openapi.Error("The async send buffer is full or the API is overloaded; retry after Retry-After seconds."),
+		}),
+	})
+	doc.Add(http.MethodPost, "/events/batch", &openapi.Operation{
+		OperationID: "publishEventBatch",
+		Summary:     "Publish a batch of events",
+		Description: "Publishes each event independently and reports a result per event; " +
+			"an empty error means the event was accepted.",
+		Tags:        []string{"events"},
+		Parameters:  []*openapi.Parameter{sendMode},
+		RequestBody: openapi.Body("The events.", doc.Schema(eventBatch{})),
+		Security:    security,
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                    {Description: "A result per event.", Content: openapi.JSON(doc.Schema(eventBatchResult{}))},
+			http.StatusBadRequest:            openapi.Error("The body is invalid or has no events."),
+			http.StatusUnauthorized:          openapi.Error("The API key is missing or unknown."),
+			http.StatusRequestEntityTooLarge: openapi.Error("The body exceeds api.max_body_bytes."),
+			http.StatusServiceUnavailable:    openapi.Error("The API is overloaded; retry after Retry-After seconds."),
+		}),
+	})
+}
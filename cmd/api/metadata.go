@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	"notification-system/pkg/event"
+)
+
+// Request headers whose values events published with the request inherit, unless they
+// carry their own correlation_id and trace_id.
+const (
+	correlationIDHeader = "X-Correlation-ID"
+	traceparentHeader   = "traceparent"
+)
+
+// inheritMetadata sets the correlation ID and trace ID of evt that are unset from the
+// X-Correlation-ID and W3C traceparent headers of the request publishing it.
+func inheritMetadata(evt *event.Event, correlationID, traceparent string) {
+	if evt.CorrelationID == "" {
+		evt.CorrelationID = strings.TrimSpace(correlationID)
+	}
+	if evt.TraceID == "" {
+		evt.TraceID = traceIDOf(traceparent)
+	}
+}
+
+// grpcRequestMetadata returns the correlation ID and traceparent headers of a gRPC call.
+func grpcRequestMetadata(ctx context.Context) (correlationID, traceparent string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(strings.ToLower(correlationIDHeader)); len(v) > 0 {
+		correlationID = v[0]
+	}
+	if v := md.Get(traceparentHeader); len(v) > 0 {
+		traceparent = v[0]
+	}
+	return correlationID, traceparent
+}
+
+// traceIDOf returns the trace ID of a traceparent header such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", or "" if it is invalid.
+func traceIDOf(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || !validTraceID(parts[1]) {
+		return ""
+	}
+	return parts[1]
+}
+
+// validTraceID reports whether id is a W3C trace ID: 32 lowercase hex digits, not all zero.
+func validTraceID(id string) bool {
+	if len(id) != 32 || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMetadata validates the metadata of evt, whose keys must be usable in {$.meta.<key>}
+// placeholders.
+func checkMetadata(evt event.Event) error {
+	if evt.TraceID != "" && !validTraceID(evt.TraceID) {
+		return &validationError{msg: "Invalid trace_id: must be 32 lowercase hex digits"}
+	}
+	for k := range evt.Metadata {
+		if k == "" || strings.ContainsAny(k, ".{}| ") {
+			return &validationError{msg: fmt.Sprintf("Invalid metadata key %q", k)}
+		}
+		if k == "source" || k == "correlation_id" || k == "trace_id" {
+			return &validationError{msg: fmt.Sprintf("Metadata key %q is reserved; set the event field instead", k)}
+		}
+	}
+	return nil
+}
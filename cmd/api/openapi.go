@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+
+	"notification-system/pkg/openapi"
+)
+
+// apiVersion is the version of the HTTP API in /openapi.json. Raise it when requests or
+// responses change, so generated clients can tell.
+const apiVersion = "1.0.0"
+
+// Security schemes of the ingestion endpoints, matching requestAPIKey.
+const (
+	securityAPIKey = "apiKey"
+	securityBearer = "bearer"
+)
+
+// apiSpec describes the HTTP API. Each group of handlers describes its own operations
+// next to the code that serves them.
+func apiSpec() *openapi.Document {
+	doc := openapi.New("Notification System API", apiVersion,
+		"Ingests business events and manages the notifications they trigger.")
+	doc.SecurityScheme(securityAPIKey, &openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: apiKeyHeader,
+		Description: "API key of a tenant; required when tenants are configured.",
+	})
+	doc.SecurityScheme(securityBearer, &openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer",
+		Description: "The API key of a tenant as a bearer token.",
+	})
+
+	describeEvents(doc)
+	describeAdmin(doc)
+	describePauses(doc)
+	describeAudit(doc)
+	describeReplay(doc)
+	describeStats(doc)
+	describeAssignments(doc)
+	describeDLQ(doc)
+	describeStream(doc)
+	describeOpenAPI(doc)
+	describeAdminAuth(doc)
+	return doc
+}
+
+// registerOpenAPIHandler serves GET /openapi.json, the OpenAPI 3 description of the HTTP
+// API for generating client SDKs.
+func registerOpenAPIHandler(mux *http.ServeMux) {
+	doc := apiSpec()
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	})
+}
+
+func describeOpenAPI(doc *openapi.Document) {
+	doc.Add(http.MethodGet, "/openapi.json", &openapi.Operation{
+		Summary: "Get this OpenAPI description",
+		Tags:    []string{"meta"},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK: {Content: openapi.JSON(&openapi.Schema{Type: "object"})},
+		}),
+	})
+}
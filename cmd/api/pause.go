@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/openapi"
+)
+
+// registerPauseHandlers exposes the maintenance pauses of config.pauses:
+//
+//	GET    /admin/pauses  list all pauses
+//	POST   /admin/pauses  pause an event type or target: {"event_type": "order.*", "target": "https://partner.example.com", "until": "2024-05-01T06:00:00Z"}
+//	DELETE /admin/pauses?event_type=...&target=...&tenant=...  resume
+//
+// Workers hold back matching messages without using up their retries and deliver them
+// once the pause is removed or expires.
+func registerPauseHandlers(mux *http.ServeMux, store *config.Store) {
+	mux.HandleFunc("/admin/pauses", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, store.Pauses())
+		case http.MethodPost:
+			var p config.PauseConfig
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+			if err := store.Pause(r.Context(), p); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, p)
+		case http.MethodDelete:
+			q := r.URL.Query()
+			p := config.PauseConfig{EventType: q.Get("event_type"), Target: q.Get("target"), Tenant: q.Get("tenant")}
+			if err := store.Resume(r.Context(), p); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// describePauses describes the endpoints of registerPauseHandlers.
+func describePauses(doc *openapi.Document) {
+	pause := doc.Schema(config.PauseConfig{})
+	doc.Add(http.MethodGet, "/admin/pauses", &openapi.Operation{
+		OperationID: "listPauses",
+		Summary:     "List maintenance pauses",
+		Tags:        []string{"admin"},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK: {Content: openapi.JSON(&openapi.Schema{Type: "array", Items: pause})},
+		}),
+	})
+	doc.Add(http.MethodPost, "/admin/pauses", &openapi.Operation{
+		OperationID: "pause",
+		Summary:     "Pause an event type or target",
+		Tags:        []string{"admin"},
+		RequestBody: openapi.Body("The pause.", pause),
+		Responses:   storeResponses(http.StatusCreated, pause),
+	})
+	doc.Add(http.MethodDelete, "/admin/pauses", &openapi.Operation{
+		OperationID: "resume",
+		Summary:     "Resume a paused event type or target",
+		Tags:        []string{"admin"},
+		Parameters: []*openapi.Parameter{
+			openapi.Query("event_type", "string", "the event type of the pause"),
+			openapi.Query("target", "string", "the target of the pause"),
+			openapi.Query("tenant", "string", "the tenant of the pause"),
+		},
+		Responses: storeResponses(http.StatusNoContent, nil),
+	})
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// producer is the broker the API publishes with. A producer that keeps failing, e.g. a
+// RocketMQ producer whose routes went stale during a broker outage, may never recover by
+// itself, so after api.reconnect_after failed sends in a row it is degraded: /readyz
+// fails and the broker is re-created until that succeeds. With api.spool, messages the
+// broker did not accept are spooled to disk and count as sent; they are replayed
+// periodically.
+type producer struct {
+	after    int
+	interval time.Duration
+	spool    *mq.Spool
+	// window aggregates the latency and failures of recent sends for load shedding.
+	window sendWindow
+
+	mu sync.RWMutex
+	// cfg is the MQ config with its secrets resolved that the broker is re-created with.
+	cfg          config.MQConfig
+	gen          *producerGen
+	failures     int // sends failed in a row
+	failingSince time.Time
+	lastErr      error
+	recreations  int
+
+	stop  chan struct{}
+	loops sync.WaitGroup
+}
+
+// producerGen is a broker together with the sends still using it, so a replaced broker
+// is only closed once they are done.
+type producerGen struct {
+	b     mq.Broker
+	sends sync.WaitGroup
+}
+
+// newProducer connects to the broker of cfg, opens the spool of api.spool if set, and
+// watches the broker's health and replays the spool until Close.
+func newProducer(cfg config.MQConfig, api config.APIConfig) (*producer, error) {
+	var spool *mq.Spool
+	if api.Spool != nil {
+		var err error
+		if spool, err = mq.OpenSpool(api.Spool.Dir, api.Spool.MaxBytes, api.Spool.MaxAge.Std()); err != nil {
+			return nil, err
+		}
+	}
+	b, err := mq.NewBroker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &producer{
+		cfg:      cfg,
+		after:    api.ReconnectAfter,
+		interval: api.ReconnectInterval.Std(),
+		spool:    spool,
+		gen:      &producerGen{b: b},
+		stop:     make(chan struct{}),
+	}
+	p.loops.Add(1)
+	go p.watch()
+	if spool != nil {
+		p.loops.Add(1)
+		go p.replay(api.Spool.ReplayInterval.Std())
+	}
+	return p, nil
+}
+
+// acquire returns the current broker generation; callers call gen.sends.Done once their
+// send is done.
+func (p *producer) acquire() *producerGen {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.gen.sends.Add(1)
+	return p.gen
+}
+
+// observe records the outcome of a send started at start, for health and load shedding.
+func (p *producer) observe(err error, start time.Time) {
+	if !errors.Is(err, context.Canceled) {
+		p.window.add(err != nil, time.Since(start))
+	}
+	p.record(err)
+}
+
+// record counts the outcome of a send. Sends cancelled by their caller say nothing about
+// the broker.
+func (p *producer) record(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.failures, p.lastErr = 0, nil
+		return
+	}
+	if p.failures == 0 {
+		p.failingSince = time.Now()
+	}
+	p.failures++
+	p.lastErr = err
+}
+
+func (p *producer) Publish(ctx context.Context, topic string, body []byte, opts ...mq.SendOption) error {
+	gen := p.acquire()
+	defer gen.sends.Done()
+	start := time.Now()
+	err := gen.b.Publish(ctx, topic, body, opts...)
+	p.observe(err, start)
+	return p.spoolFailed(err, topic, body, opts)
+}
+
+func (p *producer) PublishBatch(ctx context.Context, topic string, msgs []mq.Message) (int, error) {
+	gen := p.acquire()
+	defer gen.sends.Done()
+	start := time.Now()
+	sent, err := mq.PublishBatch(ctx, gen.b, topic, msgs)
+	p.observe(err, start)
+	for ; err != nil && sent < len(msgs); sent++ {
+		if p.spoolFailed(err, topic, msgs[sent].Body, msgs[sent].Options) != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// spoolFailed keeps a message the broker did not accept with err in the spool. It
+// returns nil once the message is spooled, and err if there is no spool or it failed.
+func (p *producer) spoolFailed(err error, topic string, body []byte, opts []mq.SendOption) error {
+	if err == nil || p.spool == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	if serr := p.spool.Put(topic, body, opts...); serr != nil {
+		log.Printf("Failed to spool a message to %s: %v", topic, serr)
+		return err
+	}
+	return nil
+}
+
+// PublishAsync sends natively asynchronously if the broker can, and from a goroutine
+// otherwise, like mq.AsyncSender does for brokers that can't.
+func (p *producer) PublishAsync(topic string, body []byte, opts []mq.SendOption, done func(error)) error {
+	gen := p.acquire()
+	start := time.Now()
+	finish := func(err error) {
+		p.observe(err, start)
+		gen.sends.Done()
+		done(p.spoolFailed(err, topic, body, opts))
+	}
+	ap, ok := gen.b.(mq.AsyncPublisher)
+	if !ok {
+		go func() { finish(gen.b.Publish(context.Background(), topic, body, opts...)) }()
+		return nil
+	}
+	if err := ap.PublishAsync(topic, body, opts, finish); err != nil {
+		p.observe(err, start)
+		gen.sends.Done()
+		return p.spoolFailed(err, topic, body, opts)
+	}
+	return nil
+}
+
+func (p *producer) Subscribe(topic string, h mq.Handler) error {
+	return errors.New("the API producer does not consume")
+}
+
+func (p *producer) Start() error { return nil }
+
+// Close stops watching and replaying, then closes the spool and the current broker.
+// Spooled messages are replayed by the next process.
+func (p *producer) Close() error {
+	close(p.stop)
+	p.loops.Wait()
+	if p.spool != nil {
+		if err := p.spool.Close(); err != nil {
+			log.Printf("Failed to close the spool: %v", err)
+		}
+	}
+	p.mu.RLock()
+	gen := p.gen
+	p.mu.RUnlock()
+	return gen.b.Close()
+}
+
+// check is the readiness check of the producer.
+func (p *producer) check(ctx context.Context) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.failures < p.after {
+		return nil
+	}
+	return fmt.Errorf("degraded: %d sends failed in a row since %s: %v", p.failures, p.failingSince.Format(time.RFC3339), p.lastErr)
+}
+
+// Recreations returns how often the broker was re-created.
+func (p *producer) Recreations() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.recreations
+}
+
+// spoolStatus returns the spool fields of /debug/status, or nil without a spool.
+func (p *producer) spoolStatus() map[string]int64 {
+	if p.spool == nil {
+		return nil
+	}
+	return map[string]int64{"bytes": p.spool.Size(), "dropped": p.spool.Dropped()}
+}
+
+// replay sends the spooled messages every interval. A failed replay counts as a failed
+// send, so a broker that stays down is re-created.
+func (p *producer) replay(interval time.Duration) {
+	defer p.loops.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		if p.spool.Size() == 0 {
+			continue
+		}
+		gen := p.acquire()
+		sent, err := p.spool.Replay(ctx, gen.b)
+		gen.sends.Done()
+		p.record(err)
+		if sent > 0 {
+			log.Printf("Replayed %d spooled messages", sent)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Spooled messages not replayed yet: %v", err)
+		}
+	}
+}
+
+// watch re-creates the broker every interval while the producer is degraded.
+func (p *producer) watch() {
+	defer p.loops.Done()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		if p.check(context.Background()) != nil {
+			p.recreate()
+		}
+	}
+}
+
+// setConfig changes the MQ config the broker is re-created with, e.g. after its
+// credentials were rotated. The current broker is kept until it is degraded.
+func (p *producer) setConfig(cfg config.MQConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cfg = cfg
+}
+
+// recreate replaces the broker with a new one from the latest config. The old broker is
+// closed once the sends using it are done; a failed re-creation keeps it and is retried on
+// the next tick.
+func (p *producer) recreate() {
+	p.mu.RLock()
+	cfg := p.cfg
+	p.mu.RUnlock()
+	b, err := mq.NewBroker(cfg)
+	if err != nil {
+		log.Printf("Failed to re-create the %s producer: %v", cfg.Broker, err)
+		return
+	}
+
+	p.mu.Lock()
+	old := p.gen
+	p.gen = &producerGen{b: b}
+	failures := p.failures
+	// The new broker has to fail on its own to be degraded again
+	p.failures, p.lastErr = 0, nil
+	p.recreations++
+	p.mu.Unlock()
+	log.Printf("Re-created the %s producer after %d failed sends", cfg.Broker, failures)
+
+	go func() {
+		old.sends.Wait()
+		if err := old.b.Close(); err != nil {
+			log.Printf("Failed to close the replaced producer: %v", err)
+		}
+	}()
+}
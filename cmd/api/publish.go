@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"notification-system/pkg/archive"
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/ratelimit"
+	"notification-system/pkg/render"
+	"notification-system/pkg/schema"
+	"notification-system/pkg/secrets"
+)
+
+// validationError is returned by publishEvent when the event itself is invalid,
+// as opposed to a failure while handing it to the message queue.
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+// sendMode resolves the send mode of a request; "" selects the configured default.
+func (in *ingester) sendMode(mode string) (async bool, err error) {
+	if mode == "" {
+		mode = in.store.Config().API.SendMode
+	}
+	switch mode {
+	case config.SendModeSync:
+		return false, nil
+	case config.SendModeAsync:
+		return true, nil
+	}
+	return false, &validationError{msg: "Invalid send_mode: " + mode}
+}
+
+// ingester validates events and publishes them to the message queue.
+// It is shared by the HTTP and gRPC ingestion paths.
+type ingester struct {
+	broker  *producer
+	store   *config.Store
+	schemas *schema.Validator
+	// registry looks up the schemas of notifications with a registry.
+	registry *schema.Registry
+	// keyring supplies the keys of mq.encryption.
+	keyring *secrets.Keyring
+	quotas  ratelimit.Limiter
+	// async sends events without waiting for the broker when the send mode is async.
+	async *mq.AsyncSender
+	// archive, if set, keeps a copy of every published event for replay.
+	archive archive.Archive
+	// ingesting counts the ingestion requests in flight, shed those answered with 503
+	// (see admit).
+	ingesting atomic.Int64
+	shed      atomic.Int64
+}
+
+// publish validates the event, resolves the tenant's topic and sends it to the message queue.
+// The tenant comes from the caller's credentials and overrides any tenant_id in the event.
+// With async set it returns once the event is buffered, or mq.ErrBufferFull if the buffer is full.
+func (in *ingester) publish(ctx context.Context, tenant string, evt *event.Event, async bool) error {
+	out, err := in.prepare(ctx, tenant, evt)
+	if err != nil {
+		return err
+	}
+
+	if async {
+		err = in.async.Send(out.topic, out.body, out.opts...)
+	} else {
+		err = in.broker.Publish(ctx, out.topic, out.body, out.opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Async events are archived before the broker confirms them, so lost ones can be replayed
+	in.archiveEvent(ctx, *evt)
+	return nil
+}
+
+// batchResult reports the outcome of one event of a batch. An empty Error means the
+// event was accepted.
+type batchResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// publishBatch publishes each event independently and reports a result per event.
+// A failure of one event does not prevent the others from being published. In sync mode
+// the valid events are sent with one batch request per topic.
+func (in *ingester) publishBatch(ctx context.Context, tenant, key string, events []event.Event, async bool) []batchResult {
+	type prepared struct {
+		result *batchResult
+		evt    event.Event
+		out    *outgoing
+	}
+	var topics []string
+	byTopic := make(map[string][]prepared)
+
+	results := make([]batchResult, len(events))
+	for i := range events {
+		evt := events[i]
+		result := &results[i]
+		*result = batchResult{Index: i, ID: evt.ID}
+		if err := in.checkQuota(tenant, key); err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		if async {
+			if err := in.publish(ctx, tenant, &evt, true); err != nil {
+				result.Error = errorMessage(err)
+			}
+			continue
+		}
+
+		out, err := in.prepare(ctx, tenant, &evt)
+		if err != nil {
+			result.Error = errorMessage(err)
+			continue
+		}
+		if _, ok := byTopic[out.topic]; !ok {
+			topics = append(topics, out.topic)
+		}
+		byTopic[out.topic] = append(byTopic[out.topic], prepared{result: result, evt: evt, out: out})
+	}
+
+	for _, topic := range topics {
+		batch := byTopic[topic]
+		msgs := make([]mq.Message, len(batch))
+		for i, p := range batch {
+			msgs[i] = mq.Message{Body: p.out.body, Options: p.out.opts}
+		}
+
+		sent, err := mq.PublishBatch(ctx, in.broker, topic, msgs)
+		var msg string
+		if err != nil {
+			msg = errorMessage(err)
+		}
+		for i, p := range batch {
+			if i < sent {
+				in.archiveEvent(ctx, p.evt)
+			} else {
+				p.result.Error = msg
+			}
+		}
+	}
+	return results
+}
+
+// errorMessage is what clients are told about an error of publish: the reason an event
+// was rejected, or a generic message for failures of the broker, which are logged.
+func errorMessage(err error) string {
+	var vErr *validationError
+	if errors.As(err, &vErr) || errors.Is(err, mq.ErrBufferFull) {
+		return err.Error()
+	}
+	log.Printf("Failed to send message: %v", err)
+	return "internal server error"
+}
+
+// outgoing is a validated event ready to be sent.
+type outgoing struct {
+	topic string
+	body  []byte
+	opts  []mq.SendOption
+}
+
+// prepare validates the event and encodes it as a message for its topic.
+func (in *ingester) prepare(ctx context.Context, tenant string, evt *event.Event) (*outgoing, error) {
+	cfg := in.store.Config()
+	evt.TenantID = tenant
+	evt.Digest = false
+
+	// Basic validation
+	if evt.Type == "" {
+		return nil, &validationError{msg: "Event type is required"}
+	}
+
+	if evt.CallbackURL != "" {
+		if err := config.ValidateCallbackURL(evt.CallbackURL); err != nil {
+			return nil, &validationError{msg: "Invalid callback_url: " + err.Error()}
+		}
+	}
+
+	if err := checkMetadata(*evt); err != nil {
+		return nil, err
+	}
+
+	if evt.ExpiresAt != nil && !evt.ExpiresAt.After(time.Now()) {
+		return nil, &validationError{msg: "Event has already expired"}
+	}
+
+	if evt.Version < 0 {
+		return nil, &validationError{msg: "Event version cannot be negative"}
+	}
+
+	// Find config to get Topic (QueueName), for the version the worker upcasts the event to
+	version := cfg.UpcastVersion(tenant, evt.Type, evt.Version)
+	notifyConfig := cfg.FindNotificationConfig(tenant, evt.Type, version)
+	if notifyConfig == nil {
+		if evt.Version == 0 {
+			return nil, &validationError{msg: "Unknown event type: " + evt.Type}
+		}
+		return nil, &validationError{msg: fmt.Sprintf("Unknown event type: %s version %d", evt.Type, evt.Version)}
+	}
+
+	// Reject malformed events before they turn into broken webhooks downstream. Schemas
+	// describe the current version, so events that are upcast later are not checked.
+	if notifyConfig.Schema != "" && version == evt.Version {
+		if err := in.schemas.Validate(notifyConfig.Schema, evt.Data); err != nil {
+			var sErr *schema.ValidationError
+			if errors.As(err, &sErr) {
+				return nil, &validationError{msg: sErr.Error()}
+			}
+			return nil, err
+		}
+	}
+
+	// Ensure timestamp is set
+	if evt.Timestamp.IsZero() {
+		evt.Timestamp = time.Now()
+	}
+
+	body, opts, err := in.encode(ctx, cfg, notifyConfig, *evt)
+	if err != nil {
+		return nil, err
+	}
+	return &outgoing{topic: notifyConfig.QueueName, body: body, opts: opts}, nil
+}
+
+// encode returns the message body and send options of evt for the notification n. With a
+// registry, the data is checked against the latest schema of the subject and Avro-encoded
+// for Avro schemas; like schema, this is skipped for events that are upcast later. The
+// body is encrypted last, after compression, when mq.encryption is set.
+func (in *ingester) encode(ctx context.Context, cfg *config.Config, n *config.NotificationConfig, evt event.Event) ([]byte, []mq.SendOption, error) {
+	opts, err := sendOptions(cfg, n, evt)
+	if err != nil {
+		return nil, nil, &validationError{msg: fmt.Sprintf("Invalid sharding key: %v", err)}
+	}
+	// Async sends apply the options after the request has ended
+	encrypt := mq.WithEncryption(context.WithoutCancel(ctx), in.keyring, cfg.MQ.Encryption)
+	if n.Registry == nil || cfg.SchemaRegistry == nil || cfg.UpcastVersion(evt.TenantID, evt.Type, evt.Version) != evt.Version {
+		body, err := mq.EncodeEvent(evt, cfg.EventEncoding(n))
+		if err != nil {
+			return nil, nil, &validationError{msg: fmt.Sprintf("Invalid event data: %v", err)}
+		}
+		return body, append(opts, encrypt), nil
+	}
+
+	subject := n.Registry.Subject
+	if subject == "" {
+		subject = evt.Type
+	}
+	s, err := in.registry.Latest(ctx, cfg.SchemaRegistry, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, props, err := s.EncodeEvent(evt, cfg.EventEncoding(n))
+	if err != nil {
+		var sErr *schema.ValidationError
+		if errors.As(err, &sErr) {
+			return nil, nil, &validationError{msg: sErr.Error()}
+		}
+		return nil, nil, &validationError{msg: fmt.Sprintf("Invalid event data: %v", err)}
+	}
+	return body, append(opts, mq.WithProperties(props), encrypt), nil
+}
+
+// sendOptions keys the message by event ID, routes it by the notification's sharding key,
+// copies the event metadata into properties, flags the encoding of the body and applies
+// the configured compression.
+func sendOptions(cfg *config.Config, n *config.NotificationConfig, evt event.Event) ([]mq.SendOption, error) {
+	opts := []mq.SendOption{mq.WithEventMetadata(evt), mq.WithEncoding(cfg.EventEncoding(n)), mq.Compression(cfg.MQ)}
+	if evt.ID != "" {
+		opts = append(opts, mq.WithKeys(evt.ID))
+	}
+	if n.ShardingKey != "" {
+		key, err := render.String(n.ShardingKey, evt)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, mq.WithShardingKey(key))
+	}
+	return opts, nil
+}
+
+// archiveEvent keeps a copy of an accepted event. The event is already accepted, so a
+// failed archive write must not make the caller retry it.
+func (in *ingester) archiveEvent(ctx context.Context, evt event.Event) {
+	if in.archive == nil {
+		return
+	}
+	if err := in.archive.Put(ctx, evt); err != nil {
+		log.Printf("Failed to archive event %s: %v", evt.ID, err)
+	}
+}
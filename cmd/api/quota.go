@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"notification-system/pkg/openapi"
+	"notification-system/pkg/ratelimit"
+)
+
+// checkQuota counts one event against the API key's and the tenant's quotas and returns a
+// *ratelimit.ExceededError when either is used up. The key quota is checked first so a
+// rejected key does not consume tenant volume.
+func (in *ingester) checkQuota(tenant, key string) error {
+	t := in.store.Config().Tenant(tenant)
+	if t == nil {
+		return nil
+	}
+	if t.KeyQuota != nil {
+		if err := in.quotas.Allow(keyQuotaName(tenant, key), *t.KeyQuota); err != nil {
+			return err
+		}
+	}
+	if t.Quota != nil {
+		return in.quotas.Allow(tenant, *t.Quota)
+	}
+	return nil
+}
+
+// keyQuotaName identifies an API key in quota stats without exposing it.
+func keyQuotaName(tenant, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return tenant + "/key-" + hex.EncodeToString(sum[:4])
+}
+
+// writeQuotaError responds 429 with a Retry-After header in whole seconds.
+func writeQuotaError(w http.ResponseWriter, err error) {
+	var qErr *ratelimit.ExceededError
+	if errors.As(err, &qErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(qErr.RetryAfter.Seconds()))))
+	}
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// quotaExceeded describes the responses of writeQuotaError.
+func quotaExceeded() *openapi.Response {
+	return &openapi.Response{
+		Description: "A quota of the API key or the tenant is used up.",
+		Headers: map[string]*openapi.Header{
+			"Retry-After": {Description: "Seconds until the quota allows the next event.", Schema: &openapi.Schema{Type: "integer"}},
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"notification-system/pkg/archive"
+	"notification-system/pkg/event"
+	"notification-system/pkg/openapi"
+)
+
+// replayRequest is the body of POST /admin/replay.
+type replayRequest struct {
+	archive.Filter
+	// Limit caps the number of events replayed; 0 means no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// replayResult reports what a replay did.
+type replayResult struct {
+	Replayed int `json:"replayed"`
+	// Skipped counts archived events whose type no longer has a notification configured.
+	Skipped int    `json:"skipped"`
+	Error   string `json:"error,omitempty"`
+}
+
+var errReplayLimit = errors.New("replay limit reached")
+
+// registerReplayHandler exposes POST /admin/replay, which re-publishes archived events
+// matching a filter to their current topics:
+//
+//	{"event_type": "order.*", "from": "2024-05-01T00:00:00Z", "to": "2024-05-02T00:00:00Z", "limit": 1000}
+//
+// Events keep their original ID and timestamp so targets can deduplicate.
+func registerReplayHandler(mux *http.ServeMux, in *ingester) {
+	mux.HandleFunc("/admin/replay", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if in.archive == nil {
+			http.Error(w, "Event archive is not configured", http.StatusNotImplemented)
+			return
+		}
+
+		var req replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		disableWriteTimeout(w)
+		result, err := in.replay(r.Context(), req)
+		if err != nil {
+			log.Printf("Replay failed after %d events: %v", result.Replayed, err)
+			result.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, result)
+			return
+		}
+		log.Printf("Replayed %d archived events (%d skipped)", result.Replayed, result.Skipped)
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// describeReplay describes the endpoint of registerReplayHandler.
+func describeReplay(doc *openapi.Document) {
+	result := doc.Schema(replayResult{})
+	doc.Add(http.MethodPost, "/admin/replay", &openapi.Operation{
+		OperationID: "replay",
+		Summary:     "Replay archived events",
+		Description: "Re-publishes the archived events matching the filter to their current topics, " +
+			"with their original ID and timestamp.",
+		Tags:        []string{"admin"},
+		RequestBody: openapi.Body("The filter and limit.", doc.Schema(replayRequest{})),
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:                  {Content: openapi.JSON(result)},
+			http.StatusBadRequest:          openapi.Error("The filter is invalid."),
+			http.StatusInternalServerError: {Description: "The replay failed after replaying some events.", Content: openapi.JSON(result)},
+			http.StatusNotImplemented:      openapi.Error("No event archive is configured."),
+		}),
+	})
+}
+
+// replay re-publishes archived events without archiving them again.
+func (in *ingester) replay(ctx context.Context, req replayRequest) (replayResult, error) {
+	var result replayResult
+	err := in.archive.Query(ctx, req.Filter, func(evt event.Event) error {
+		if req.Limit > 0 && result.Replayed >= req.Limit {
+			return errReplayLimit
+		}
+		cfg := in.store.Config()
+		notifyConfig := cfg.FindNotificationConfig(evt.TenantID, evt.Type, cfg.UpcastVersion(evt.TenantID, evt.Type, evt.Version))
+		if notifyConfig == nil {
+			result.Skipped++
+			return nil
+		}
+		body, opts, err := in.encode(ctx, cfg, notifyConfig, evt)
+		if err != nil {
+			return err
+		}
+		if err := in.broker.Publish(ctx, notifyConfig.QueueName, body, opts...); err != nil {
+			return err
+		}
+		result.Replayed++
+		return nil
+	})
+	if errors.Is(err, errReplayLimit) {
+		err = nil
+	}
+	return result, err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sendWindowSeconds is how many seconds of sends the load shedding thresholds look at.
+const sendWindowSeconds = 10
+
+// minShedSends is how many sends the window needs before its latency and failure rate
+// count, so a single slow or failed send doesn't shed load.
+const minShedSends = 20
+
+// sendWindow aggregates the sends of the last sendWindowSeconds seconds, in buckets of a
+// second.
+type sendWindow struct {
+	mu      sync.Mutex
+	buckets [sendWindowSeconds]sendBucket
+}
+
+type sendBucket struct {
+	second   int64
+	sends    int
+	failures int
+	latency  time.Duration // summed
+}
+
+// add counts a send that took latency.
+func (w *sendWindow) add(failed bool, latency time.Duration) {
+	now := time.Now().Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[now%sendWindowSeconds]
+	if b.second != now {
+		*b = sendBucket{second: now}
+	}
+	b.sends++
+	b.latency += latency
+	if failed {
+		b.failures++
+	}
+}
+
+// stats returns the sends of the window, their mean latency and the share that failed.
+func (w *sendWindow) stats() (sends int, latency time.Duration, failureRate float64) {
+	now := time.Now().Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var failures int
+	var total time.Duration
+	for _, b := range w.buckets {
+		if now-b.second < sendWindowSeconds {
+			sends += b.sends
+			failures += b.failures
+			total += b.latency
+		}
+	}
+	if sends == 0 {
+		return 0, 0, 0
+	}
+	return sends, total / time.Duration(sends), float64(failures) / float64(sends)
+}
+
+// overloadError is returned by admit while the API sheds load.
+type overloadError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *overloadError) Error() string {
+	return "server overloaded: " + e.reason
+}
+
+// retryAfterSeconds returns the Retry-After of e in whole seconds.
+func (e *overloadError) retryAfterSeconds() string {
+	return strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds())))
+}
+
+// admit counts an ingestion request as in flight, or returns an *overloadError when a
+// threshold of api.load_shedding is exceeded. Admitted requests call release when done.
+// The thresholds are read per request, so config changes apply at once.
+func (in *ingester) admit() (release func(), err error) {
+	n := in.ingesting.Add(1)
+	release = func() { in.ingesting.Add(-1) }
+
+	c := in.store.Config().API.LoadShedding
+	if c == nil {
+		return release, nil
+	}
+	var reason string
+	sends, latency, failureRate := in.broker.window.stats()
+	switch {
+	case c.MaxInFlight > 0 && int(n) > c.MaxInFlight:
+		reason = fmt.Sprintf("more than %d requests in flight", c.MaxInFlight)
+	case c.MaxSendLatency > 0 && sends >= minShedSends && latency > c.MaxSendLatency.Std():
+		reason = fmt.Sprintf("mean send latency %v exceeds %v", latency.Round(time.Millisecond), c.MaxSendLatency.Std())
+	case c.MaxFailureRate > 0 && sends >= minShedSends && failureRate > c.MaxFailureRate:
+		reason = fmt.Sprintf("%.0f%% of sends failed", failureRate*100)
+	default:
+		return release, nil
+	}
+	release()
+	in.shed.Add(1)
+	return nil, &overloadError{reason: reason, retryAfter: c.RetryAfter.Std()}
+}
+
+// writeOverloaded responds 503 with a Retry-After header in whole seconds.
+func writeOverloaded(w http.ResponseWriter, err error) {
+	var oErr *overloadError
+	if errors.As(err, &oErr) {
+		w.Header().Set("Retry-After", oErr.retryAfterSeconds())
+	}
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// overloadedStatus returns the gRPC status of a shed call, and sends the Retry-After
+// seconds in the retry-after header.
+func overloadedStatus(ctx context.Context, err error) error {
+	var oErr *overloadError
+	if errors.As(err, &oErr) {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", oErr.retryAfterSeconds()))
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"notification-system/pkg/mq"
+)
+
+// forcedStopGrace is how long shutdown waits for handlers to return after their
+// connections were closed, e.g. while a send to the broker notices the cancellation.
+const forcedStopGrace = 5 * time.Second
+
+// inflight counts the HTTP requests and gRPC calls being handled, so shutdown can wait
+// for them before closing the producer they publish with.
+type inflight struct {
+	wg sync.WaitGroup
+	n  atomic.Int64
+}
+
+func (f *inflight) begin() {
+	f.wg.Add(1)
+	f.n.Add(1)
+}
+
+func (f *inflight) end() {
+	f.n.Add(-1)
+	f.wg.Done()
+}
+
+// handler counts the requests served by next.
+func (f *inflight) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.begin()
+		defer f.end()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unaryInterceptor counts the gRPC calls being handled.
+func (f *inflight) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	f.begin()
+	defer f.end()
+	return handler(ctx, req)
+}
+
+// wait waits until no request is in flight or ctx ends.
+func (f *inflight) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d requests still running: %w", f.n.Load(), ctx.Err())
+	}
+}
+
+// shutdown stops the API in an order that loses no accepted event: stop accepting
+// requests and wait for the ones in flight, which may still publish; then wait for the
+// async sends to be confirmed; then close the producer. Each wait is bounded by timeout,
+// after which requests are cut off and unconfirmed events are counted as dropped.
+func shutdown(server *http.Server, grpcServer *grpc.Server, requests *inflight, in *ingester, broker mq.Broker, timeout time.Duration) {
+	// 1. Stop accepting and drain the requests in flight
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("HTTP requests still running after %s, closing their connections: %v", timeout, err)
+		server.Close()
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		log.Printf("gRPC calls still running after %s, cancelling them", timeout)
+		grpcServer.Stop()
+	}
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), forcedStopGrace)
+	defer graceCancel()
+	if err := requests.wait(graceCtx); err != nil {
+		log.Printf("Closing the producer with %v; events they publish may be lost", err)
+	}
+
+	// 2. Wait for the broker to confirm the async sends
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), timeout)
+	defer flushCancel()
+	dropped := 0
+	if err := in.async.Flush(flushCtx); err != nil {
+		dropped = in.async.Pending()
+		log.Printf("Async sends not flushed: %v", err)
+	}
+
+	// 3. Close the producer
+	if err := broker.Close(); err != nil {
+		log.Printf("Producer shutdown error: %v", err)
+	}
+	if failed := in.async.Failed(); dropped > 0 || failed > 0 {
+		log.Printf("Shutdown: %d accepted async events dropped unconfirmed, %d rejected by the broker since start", dropped, failed)
+	} else {
+		log.Println("Shutdown: all accepted events were handed to the broker")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/openapi"
+)
+
+const (
+	// statsBuffer bounds the records queued for aggregation.
+	statsBuffer = 4096
+	// dlqRefresh is how long DLQ depths are cached, so dashboards polling them don't
+	// query the broker on every request.
+	dlqRefresh = 30 * time.Second
+)
+
+// collectStats adds every record published to feed to stats.
+func collectStats(feed *delivery.Feed, stats *delivery.Stats) {
+	sub := feed.Subscribe(statsBuffer)
+	for rec := range sub.C {
+		stats.Add(rec)
+	}
+}
+
+// statsResponse is the body of GET /admin/stats.
+type statsResponse struct {
+	Since      time.Time                 `json:"since"`
+	Totals     totalStats                `json:"totals"`
+	EventTypes []delivery.EventTypeStats `json:"event_types"`
+}
+
+type totalStats struct {
+	Deliveries  int64   `json:"deliveries"`
+	Failures    int64   `json:"failures"`
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// dlqResponse is the body of GET /admin/dlq.
+type dlqResponse struct {
+	// Topics maps each DLQ topic to the number of messages it retains.
+	Topics map[string]int64 `json:"topics"`
+	// Errors holds the topics whose depth could not be read.
+	Errors    map[string]string `json:"errors,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// dlqDepths caches the depths of the DLQ topics for dlqRefresh.
+type dlqDepths struct {
+	mu   sync.Mutex
+	last *dlqResponse
+}
+
+func (d *dlqDepths) get(ctx context.Context, cfg *config.Config) *dlqResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last != nil && time.Since(d.last.UpdatedAt) < dlqRefresh {
+		return d.last
+	}
+
+	resp := &dlqResponse{Topics: make(map[string]int64), UpdatedAt: time.Now()}
+	seen := make(map[string]bool)
+	for _, n := range cfg.Notifications {
+		topic := "DLQ_" + n.QueueName
+		if seen[topic] {
+			continue
+		}
+		seen[topic] = true
+		depth, err := mq.TopicDepth(ctx, cfg.MQ, topic)
+		if err != nil {
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]string)
+			}
+			resp.Errors[topic] = err.Error()
+			continue
+		}
+		resp.Topics[topic] = depth
+	}
+	d.last = resp
+	return resp
+}
+
+// registerStatsHandlers exposes read-only endpoints for an operations dashboard:
+//
+//	GET /admin/stats     deliveries, success rate and latency per tenant and event type
+//	GET /admin/failures  the latest failed deliveries with their errors
+//	GET /admin/dlq       messages retained per DLQ topic
+//
+// ?tenant= scopes stats and failures to one tenant, ?event_type= and ?limit= (default
+// 20) narrow the failures. stats is nil when no delivery status is available, in which
+// case only /admin/dlq is served.
+func registerStatsHandlers(mux *http.ServeMux, store *config.Store, stats *delivery.Stats) {
+	mux.HandleFunc("/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if stats == nil {
+			http.Error(w, noDeliveryStatus, http.StatusNotImplemented)
+			return
+		}
+
+		resp := statsResponse{Since: stats.Since(), EventTypes: []delivery.EventTypeStats{}}
+		var successes int64
+		for _, s := range stats.EventTypes() {
+			if r.URL.Query().Has("tenant") && s.Tenant != r.URL.Query().Get("tenant") {
+				continue
+			}
+			resp.EventTypes = append(resp.EventTypes, s)
+			resp.Totals.Deliveries += s.Deliveries
+			resp.Totals.Failures += s.Failures
+			successes += s.Successes
+		}
+		if resp.Totals.Deliveries > 0 {
+			resp.Totals.SuccessRate = float64(successes) / float64(resp.Totals.Deliveries)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/admin/failures", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if stats == nil {
+			http.Error(w, noDeliveryStatus, http.StatusNotImplemented)
+			return
+		}
+
+		q := r.URL.Query()
+		limit := 20
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit: "+v, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		failures := []delivery.Record{}
+		for _, rec := range stats.Failures(0) {
+			if len(failures) == limit {
+				break
+			}
+			if q.Has("tenant") && rec.Tenant != q.Get("tenant") {
+				continue
+			}
+			if et := q.Get("event_type"); et != "" && rec.EventType != et {
+				continue
+			}
+			failures = append(failures, rec)
+		}
+		writeJSON(w, http.StatusOK, failures)
+	})
+
+	var dlq dlqDepths
+	mux.HandleFunc("/admin/dlq", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, dlq.get(r.Context(), store.Config()))
+	})
+}
+
+// describeStats describes the endpoints of registerStatsHandlers.
+func describeStats(doc *openapi.Document) {
+	tenant := openapi.Query("tenant", "string", "only the deliveries of this tenant")
+	noStatus := openapi.Error(noDeliveryStatus)
+	doc.Add(http.MethodGet, "/admin/stats", &openapi.Operation{
+		OperationID: "getStats",
+		Summary:     "Get delivery stats",
+		Description: "Deliveries, success rate and latency per tenant and event type since the API started.",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{tenant},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:             {Content: openapi.JSON(doc.Schema(statsResponse{}))},
+			http.StatusNotImplemented: noStatus,
+		}),
+	})
+	doc.Add(http.MethodGet, "/admin/failures", &openapi.Operation{
+		OperationID: "listFailures",
+		Summary:     "List the latest failed deliveries",
+		Tags:        []string{"admin"},
+		Parameters: []*openapi.Parameter{
+			tenant,
+			openapi.Query("event_type", "string", "only the deliveries of this event type"),
+			openapi.Query("limit", "integer", "the maximum number of failures (default 20)"),
+		},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK:             {Content: openapi.JSON(&openapi.Schema{Type: "array", Items: doc.Schema(delivery.Record{})})},
+			http.StatusBadRequest:     openapi.Error("The limit is invalid."),
+			http.StatusNotImplemented: noStatus,
+		}),
+	})
+	doc.Add(http.MethodGet, "/admin/dlq", &openapi.Operation{
+		OperationID: "getDLQDepths",
+		Summary:     "Get the messages retained per DLQ topic",
+		Tags:        []string{"admin"},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK: {Content: openapi.JSON(doc.Schema(dlqResponse{}))},
+		}),
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/openapi"
+)
+
+const (
+	// streamBuffer bounds the records queued for one stream client; a client that falls
+	// further behind misses records and is told how many.
+	streamBuffer = 256
+	// streamKeepAlive is the interval of keep-alive comments on idle streams, so proxies
+	// don't close them.
+	streamKeepAlive = 15 * time.Second
+)
+
+// noDeliveryStatus answers requests for delivery status when the API has none.
+const noDeliveryStatus = "Delivery status is not available: set mq.status_topic or run with -mode all"
+
+// subscribeStatus consumes mq.status_topic into a feed. Each API instance joins its own
+// consumer group so it receives every status, not a share of them.
+func subscribeStatus(cfg config.MQConfig) (*delivery.Feed, mq.Broker, error) {
+	mqCfg := cfg
+	mqCfg.GroupName = statusGroup(cfg)
+	b, err := mq.NewBroker(mqCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feed := delivery.NewFeed()
+	err = b.Subscribe(cfg.StatusTopic, func(ctx context.Context, d *mq.Delivery) error {
+		var rec delivery.Record
+		if err := json.Unmarshal(d.Body, &rec); err != nil {
+			log.Printf("Skipping invalid delivery status %s: %v", d.ID, err)
+			return nil
+		}
+		feed.Publish(rec)
+		return nil
+	})
+	if err == nil {
+		err = b.Start()
+	}
+	if err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	return feed, b, nil
+}
+
+// statusGroup returns the consumer group of this API instance on mq.status_topic:
+// mq.group_name suffixed with mq.instance_name, or else the host name.
+func statusGroup(cfg config.MQConfig) string {
+	instance := cfg.InstanceName
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	return cfg.GroupName + "_status_" + instance
+}
+
+// streamFilter selects the records sent to a stream client.
+type streamFilter struct {
+	tenant    *string
+	eventType string
+	failed    bool
+}
+
+func (f streamFilter) match(r delivery.Record) bool {
+	if f.tenant != nil && r.Tenant != *f.tenant {
+		return false
+	}
+	if f.eventType != "" && r.EventType != f.eventType {
+		return false
+	}
+	return !f.failed || !r.Success
+}
+
+// registerStreamHandler exposes GET /deliveries/stream, which pushes the outcome of every
+// delivery as server-sent events:
+//
+//	event: delivery
+//	data: {"event_id":"...","event_type":"order.created","success":false,"attempts":3,...}
+//
+// ?tenant=, ?event_type= and ?failed=true narrow the stream. When the client falls
+// behind, a "dropped" event reports how many records it missed. feed is nil when no
+// status is available; streams end when stop is closed.
+func registerStreamHandler(mux *http.ServeMux, feed *delivery.Feed, stop <-chan struct{}) {
+	mux.HandleFunc("/deliveries/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if feed == nil {
+			http.Error(w, noDeliveryStatus, http.StatusNotImplemented)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		q := r.URL.Query()
+		filter := streamFilter{eventType: q.Get("event_type")}
+		if q.Has("tenant") {
+			tenant := q.Get("tenant")
+			filter.tenant = &tenant
+		}
+		if v := q.Get("failed"); v != "" {
+			failed, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "Invalid failed: "+v, http.StatusBadRequest)
+				return
+			}
+			filter.failed = failed
+		}
+
+		sub := feed.Subscribe(streamBuffer)
+		defer sub.Close()
+
+		disableWriteTimeout(w)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		var dropped int64
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-stop:
+				return
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			case rec := <-sub.C:
+				if n := sub.Dropped(); n > dropped {
+					err = writeEvent(w, "dropped", map[string]int64{"dropped": n - dropped})
+					dropped = n
+				}
+				if err == nil && filter.match(rec) {
+					err = writeEvent(w, "delivery", rec)
+				}
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// describeStream describes the endpoint of registerStreamHandler.
+func describeStream(doc *openapi.Document) {
+	doc.Add(http.MethodGet, "/deliveries/stream", &openapi.Operation{
+		OperationID: "streamDeliveries",
+		Summary:     "Stream delivery outcomes",
+		Description: "Server-sent events: a \"delivery\" event with a delivery record per delivery, and a " +
+			"\"dropped\" event with the number of records missed when the client falls behind.",
+		Tags: []string{"deliveries"},
+		Parameters: []*openapi.Parameter{
+			openapi.Query("tenant", "string", "only the deliveries of this tenant"),
+			openapi.Query("event_type", "string", "only the deliveries of this event type"),
+			openapi.Query("failed", "boolean", "only failed deliveries"),
+		},
+		Responses: openapi.Responses(map[int]*openapi.Response{
+			http.StatusOK: {
+				Description: "The stream; each data line is a delivery record as JSON.",
+				Content:     map[string]openapi.MediaType{"text/event-stream": {Schema: &openapi.Schema{Type: "string"}}},
+			},
+			http.StatusBadRequest:     openapi.Error("The failed parameter is invalid."),
+			http.StatusNotImplemented: openapi.Error(noDeliveryStatus),
+		}),
+	})
+}
+
+// writeEvent writes v as a server-sent event of the given type.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
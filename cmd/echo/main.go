@@ -0,0 +1,55 @@
+// Command echo is a webhook receiver for integration tests of the notification system.
+// It records every request, verifies the X-Signature-256 HMAC when a secret is given and
+// lets tests inspect and assert on what was received:
+//
+//	GET    /_echo/requests   list recorded requests (filters: method, path, contains, signature)
+//	DELETE /_echo/requests   forget all recorded requests
+//	GET    /_echo/assert     wait until the expected requests arrived (filters plus count, min, timeout)
+//
+// Any other path is a notification target and answers with -status. Package echo serves
+// the same in process.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"notification-system/pkg/echo"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	secret := flag.String("secret", os.Getenv("ECHO_SIGNING_SECRET"), "signing secret to verify X-Signature-256 (default $ECHO_SIGNING_SECRET)")
+	rejectInvalid := flag.Bool("reject-invalid", false, "answer 401 to requests with a missing or invalid signature")
+	status := flag.Int("status", http.StatusOK, "status code returned to notification requests")
+	max := flag.Int("max", 1000, "number of requests kept in memory")
+	flag.Parse()
+	if *max <= 0 {
+		log.Fatalf("Invalid -max %d: must be positive", *max)
+	}
+
+	s := echo.NewServer(echo.Options{Secret: *secret, RejectInvalid: *rejectInvalid, Status: *status, Max: *max})
+	srv := &http.Server{Addr: *addr, Handler: s}
+
+	go func() {
+		log.Printf("Echo server listening on %s", *addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Echo server failed: %v", err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
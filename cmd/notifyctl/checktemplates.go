@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/worker"
+)
+
+// checkTemplates implements "notifyctl check-templates [config]". It renders every
+// template version of each notification for each of its samples and reports renders that
+// fail, placeholders without a value, JSON bodies that don't parse and values whose type
+// differs from the sample's types. Lookups (enrich) are not run, so samples should carry
+// the data they add.
+func checkTemplates(ctx context.Context, source string, args []string) error {
+	fs := flag.NewFlagSet("check-templates", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		source = fs.Arg(0)
+	}
+
+	provider, err := config.NewProvider(source)
+	if err != nil {
+		return err
+	}
+	raw, _, err := provider.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	cfg, err := config.ParseConfig(raw)
+	if err != nil {
+		return err
+	}
+
+	problems, rendered := 0, 0
+	now := time.Now()
+	for i := range cfg.Notifications {
+		n := &cfg.Notifications[i]
+		if len(n.Samples) == 0 {
+			continue
+		}
+		fmt.Printf("notifications[%d] (%s)\n", i, n.EventType)
+		for j, sample := range n.Samples {
+			name := sample.Name
+			if name == "" {
+				name = strconv.Itoa(j)
+			}
+			evt := event.Event{ID: fmt.Sprintf("sample-%d-%d", i, j), Type: n.EventType, TenantID: n.Tenant, Timestamp: now, Data: sample.Data}
+			for _, v := range templateVersions(n) {
+				label := "sample " + name
+				if v.version != "" {
+					label += " (version " + v.version + ")"
+				}
+				rendered++
+				issues := checkSample(v.cfg, sample, evt)
+				if len(issues) == 0 {
+					fmt.Printf("  %s: ok\n", label)
+					continue
+				}
+				for _, issue := range issues {
+					problems++
+					fmt.Printf("  - %s: %s\n", label, issue)
+				}
+			}
+		}
+	}
+
+	if rendered == 0 {
+		fmt.Println("No notification has samples.")
+		return nil
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	fmt.Printf("All %d render(s) passed.\n", rendered)
+	return nil
+}
+
+type templateVersion struct {
+	version string
+	cfg     *config.NotificationConfig
+}
+
+// templateVersions returns the notification once per body it may be rendered with: its
+// own and each of its template versions.
+func templateVersions(n *config.NotificationConfig) []templateVersion {
+	base := *n
+	base.Templates = nil
+	versions := []templateVersion{{n.Version, &base}}
+	for _, t := range n.Templates {
+		v := base
+		v.Body, v.BodyText = t.Body, t.BodyText
+		versions = append(versions, templateVersion{t.Version, &v})
+	}
+	return versions
+}
+
+// checkSample renders n for evt and returns the problems found.
+func checkSample(n *config.NotificationConfig, sample config.SampleEvent, evt event.Event) []string {
+	req, err := worker.RenderRequest(n, evt)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var issues []string
+	for _, p := range req.Missing {
+		issues = append(issues, fmt.Sprintf("%s has no value", p))
+	}
+
+	if !isJSON(req.Header.Get("Content-Type")) {
+		if len(sample.Types) > 0 {
+			issues = append(issues, "types are only checked for JSON bodies")
+		}
+		return issues
+	}
+	var body interface{}
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return append(issues, fmt.Sprintf("body is not valid JSON: %v", err))
+	}
+	paths := make([]string, 0, len(sample.Types))
+	for path := range sample.Types {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		v, ok := jsonPath(body, path)
+		if !ok {
+			issues = append(issues, fmt.Sprintf("%s is not in the body", path))
+		} else if got := jsonType(v); got != sample.Types[path] {
+			issues = append(issues, fmt.Sprintf("%s is %s, want %s", path, got, sample.Types[path]))
+		}
+	}
+	return issues
+}
+
+func isJSON(contentType string) bool {
+	media, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (media == "application/json" || strings.HasSuffix(media, "+json"))
+}
+
+// jsonPath looks up a dot-separated path of object keys and array indexes.
+func jsonPath(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
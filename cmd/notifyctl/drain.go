@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/worker"
+)
+
+// drainGroupSuffix is appended to mq.group_name for the consumer group of drain, which
+// keeps its own offsets on the parking topics.
+const drainGroupSuffix = "_drain"
+
+// drain implements "notifyctl drain -target url [-idle d]". It moves the messages parked
+// for the target back to the topics they came from, one at a time to keep the order they
+// were parked in, and stops once no message arrived for -idle. Messages still matched by
+// a pause or for a still degraded target are parked again by the workers.
+func drain(ctx context.Context, source string, args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	target := fs.String("target", "", "target URL (or channel://<name>) whose parked messages are replayed")
+	idle := fs.Duration("idle", 10*time.Second, "stop once no parked message arrived for this long")
+	fs.Parse(args)
+
+	if *target == "" {
+		return fmt.Errorf("-target is required")
+	}
+	cfg, err := loadConfig(ctx, source)
+	if err != nil {
+		return err
+	}
+	if cfg.MQ.Broker == config.BrokerMemory {
+		return fmt.Errorf("drain is not supported with mq.broker %s, whose topics live in the worker process", config.BrokerMemory)
+	}
+
+	mqCfg := cfg.MQ
+	mqCfg.GroupName += drainGroupSuffix
+	mqCfg.ConsumeFrom = config.ConsumeFromFirst
+	mqCfg.ConsumeGoroutines = 1
+	b, err := mq.NewBroker(mqCfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the broker: %w", err)
+	}
+	defer b.Close()
+
+	topic := worker.ParkTopic(*target)
+	var drained, busy atomic.Int64
+	var last atomic.Int64
+	last.Store(time.Now().UnixNano())
+	err = b.Subscribe(topic, func(ctx context.Context, d *mq.Delivery) error {
+		busy.Add(1)
+		defer func() {
+			last.Store(time.Now().UnixNano())
+			busy.Add(-1)
+		}()
+
+		if d.Err != nil {
+			fmt.Printf("Skipping message %s: %v\n", d.ID, d.Err)
+			return d.Err
+		}
+		dest := d.Properties[worker.ParkTopicProperty]
+		if dest == "" {
+			fmt.Printf("Skipping message %s without %s\n", d.ID, worker.ParkTopicProperty)
+			return nil
+		}
+		props := make(map[string]string, len(d.Properties))
+		for k, v := range d.Properties {
+			if k != worker.ParkTopicProperty && k != worker.ParkReasonProperty {
+				props[k] = v
+			}
+		}
+		if err := b.Publish(ctx, dest, d.Body, mq.WithProperties(props), mq.Compression(cfg.MQ)); err != nil {
+			fmt.Printf("Failed to replay message %s to %s: %v\n", d.ID, dest, err)
+			return err
+		}
+		drained.Add(1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	if err := b.Start(); err != nil {
+		return fmt.Errorf("failed to start consuming %s: %w", topic, err)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for busy.Load() > 0 || time.Since(time.Unix(0, last.Load())) < *idle {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	fmt.Printf("Drained %d messages from %s\n", drained.Load(), topic)
+	return nil
+}
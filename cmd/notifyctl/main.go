@@ -0,0 +1,147 @@
+// Command notifyctl provides operational commands for the notification system.
+//
+// Usage:
+//
+//	notifyctl [-config source] <command> [flags]
+//
+// Commands:
+//
+//	check-templates render each notification for its sample events and check the output
+//	drain          replay the messages parked for a target to their topics
+//	reset-offset   move the worker consumer group's offsets to a point in time
+//	test           render the notification for an event and optionally send it
+//	validate       check a configuration and report every problem
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/secrets"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, source string, args []string) error
+}
+
+var commands = []command{
+	{"check-templates", "render each notification for its sample events and check the output", checkTemplates},
+	{"drain", "replay the messages parked for a target to their topics", drain},
+	{"reset-offset", "move the worker consumer group's offsets to a point in time", resetOffset},
+	{"test", "render the notification for an event and optionally send it", testEvent},
+	{"validate", "check a configuration and report every problem", validate},
+}
+
+func main() {
+	configSource := flag.String("config", "config.json", "config file path or etcd://, consul:// source")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(context.Background(), *configSource, flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "notifyctl %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "notifyctl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: notifyctl [-config source] <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nGlobal flags:\n")
+	flag.PrintDefaults()
+}
+
+// loadConfig loads the configuration with secret references (MQ credentials) resolved.
+func loadConfig(ctx context.Context, source string) (*config.Config, error) {
+	store, err := config.OpenStore(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return secrets.NewDefaultResolver().ResolveConfig(ctx, store.Config())
+}
+
+// resetOffset implements "notifyctl reset-offset [-topic t] [-to earliest|latest|RFC3339]".
+// Without -topic, every topic of the configured notifications is reset.
+func resetOffset(ctx context.Context, source string, args []string) error {
+	fs := flag.NewFlagSet("reset-offset", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to reset (default: all configured notification topics)")
+	to := fs.String("to", "", "target position: earliest, latest or an RFC 3339 time")
+	fs.Parse(args)
+
+	ts, err := parsePosition(*to)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(ctx, source)
+	if err != nil {
+		return err
+	}
+	if cfg.MQ.Broker != config.BrokerRocketMQ {
+		return fmt.Errorf("reset-offset is only supported with mq.broker %s", config.BrokerRocketMQ)
+	}
+
+	topics := []string{*topic}
+	if *topic == "" {
+		topics = notificationTopics(cfg)
+	}
+	for _, t := range topics {
+		mqCfg := cfg.MQ
+		mqCfg.GroupName = cfg.ConsumerGroup(t)
+		if err := mq.ResetOffset(ctx, mqCfg, t, ts); err != nil {
+			return fmt.Errorf("topic %s: %w", t, err)
+		}
+		fmt.Printf("Reset group %s on topic %s to %s\n", mqCfg.GroupName, t, ts.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func parsePosition(s string) (time.Time, error) {
+	switch s {
+	case "":
+		return time.Time{}, fmt.Errorf("-to is required (earliest, latest or an RFC 3339 time)")
+	case "earliest":
+		return time.Unix(0, 0), nil
+	case "latest":
+		return time.Now(), nil
+	}
+	ts, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -to '%s': %v", s, err)
+	}
+	return ts, nil
+}
+
+func notificationTopics(cfg *config.Config) []string {
+	seen := make(map[string]bool)
+	var topics []string
+	for _, n := range cfg.Notifications {
+		if !seen[n.QueueName] {
+			seen[n.QueueName] = true
+			topics = append(topics, n.QueueName)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"notification-system/pkg/enrich"
+	"notification-system/pkg/event"
+	"notification-system/pkg/worker"
+)
+
+// testEvent implements "notifyctl test -event-type t [-version n] [-data json] [-tenant id] [-send] [-target url]".
+// It upcasts the event to its latest version, runs the lookups of the notification
+// matching the event, renders it locally and prints the request. With -send the request
+// is delivered to the configured URL; -target sends it to another URL instead, e.g. an
+// echo server, to preview what the target would receive.
+func testEvent(ctx context.Context, source string, args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	eventType := fs.String("event-type", "", "event type to render")
+	data := fs.String("data", "{}", "event data as JSON, or @file to read it from a file")
+	version := fs.Int("version", 0, "version of the event (default: unversioned)")
+	tenant := fs.String("tenant", "", "tenant of the event (default: none)")
+	id := fs.String("id", "", "event ID (default: test-<unix nanoseconds>)")
+	send := fs.Bool("send", false, "deliver the rendered request to the notification URL")
+	target := fs.String("target", "", "deliver the rendered request to this URL instead of the notification URL")
+	fs.Parse(args)
+
+	if *eventType == "" {
+		return fmt.Errorf("-event-type is required")
+	}
+	evt := event.Event{ID: *id, Type: *eventType, Version: *version, TenantID: *tenant, Timestamp: time.Now()}
+	if evt.ID == "" {
+		evt.ID = fmt.Sprintf("test-%d", evt.Timestamp.UnixNano())
+	}
+	raw := []byte(*data)
+	if strings.HasPrefix(*data, "@") {
+		var err error
+		if raw, err = os.ReadFile((*data)[1:]); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(raw, &evt.Data); err != nil {
+		return fmt.Errorf("invalid -data: %v", err)
+	}
+
+	cfg, err := loadConfig(ctx, source)
+	if err != nil {
+		return err
+	}
+	if evt, err = worker.Upcast(cfg, evt); err != nil {
+		return err
+	}
+	n := cfg.FindNotificationConfig(evt.TenantID, evt.Type, evt.Version)
+	if n == nil {
+		return fmt.Errorf("no notification for event type '%s' version %d (tenant %q)", evt.Type, evt.Version, evt.TenantID)
+	}
+	if len(n.Enrich) > 0 {
+		enricher := enrich.New()
+		defer enricher.Close()
+		if evt, err = enricher.Apply(ctx, n.Enrich, evt); err != nil {
+			return err
+		}
+	}
+	req, err := worker.RenderRequest(n, evt)
+	if err != nil {
+		return err
+	}
+
+	if req.TemplateVersion != "" {
+		fmt.Fprintf(os.Stderr, "template version: %s\n", req.TemplateVersion)
+	}
+	if req.MirrorURL != "" {
+		fmt.Fprintf(os.Stderr, "mirrored to: %s\n", req.MirrorURL)
+	}
+	fmt.Printf("%s %s\n", req.Method, req.URL)
+	names := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Printf("%s: %s\n", k, strings.Join(req.Header[k], ", "))
+	}
+	fmt.Printf("\n%s\n", req.Body)
+	for _, p := range req.Missing {
+		fmt.Fprintf(os.Stderr, "warning: %s has no value (renders as null or \"\")\n", p)
+	}
+
+	if !*send && *target == "" {
+		return nil
+	}
+	if *target != "" {
+		req.URL = *target
+	} else if n.Channel != "" {
+		return fmt.Errorf("notifications of channel %s are delivered by its plugin; use -target to send the request elsewhere", n.Channel)
+	}
+	fmt.Printf("\nSending to %s\n", req.URL)
+	status, body, err := worker.Send(ctx, n, req)
+	if status != 0 {
+		fmt.Printf("Status: %d\n%s\n", status, body)
+	}
+	return err
+}
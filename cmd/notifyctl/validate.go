@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/render"
+	"notification-system/pkg/worker"
+)
+
+// dialTimeout bounds each reachability check of validate.
+const dialTimeout = 5 * time.Second
+
+// validate implements "notifyctl validate [-sample events.json] [-offline] [config]".
+// It reports every configuration problem instead of stopping at the first, renders the
+// matching notifications for each sample event, and checks that the target hosts accept
+// TCP connections. No request is sent.
+func validate(ctx context.Context, source string, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	sample := fs.String("sample", "", "JSON file with a sample event or an array of events to render")
+	offline := fs.Bool("offline", false, "skip the reachability check of notification URLs")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		source = fs.Arg(0)
+	}
+
+	provider, err := config.NewProvider(source)
+	if err != nil {
+		return err
+	}
+	raw, _, err := provider.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	var cfg config.Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	problems := 0
+	report := func(format string, args ...interface{}) {
+		problems++
+		fmt.Printf("  - "+format+"\n", args...)
+	}
+
+	fmt.Printf("Checking %s\n", source)
+	for _, err := range flatten(cfg.Validate()) {
+		report("%v", err)
+	}
+
+	if *sample != "" {
+		events, err := readSampleEvents(*sample)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rendering %d sample event(s) from %s\n", len(events), *sample)
+		for i, evt := range events {
+			evt, err := worker.Upcast(&cfg, evt)
+			if err != nil {
+				report("sample[%d]: %v", i, err)
+				continue
+			}
+			n := cfg.FindNotificationConfig(evt.TenantID, evt.Type, evt.Version)
+			if n == nil {
+				report("sample[%d]: no notification for event type '%s' version %d (tenant %q)", i, evt.Type, evt.Version, evt.TenantID)
+				continue
+			}
+			req, err := worker.RenderRequest(n, evt)
+			if err != nil {
+				report("sample[%d] -> %s: %v", i, n.EventType, err)
+				continue
+			}
+			fmt.Printf("  sample[%d] -> %s: %s %s\n", i, n.EventType, req.Method, req.URL)
+			for _, p := range req.Missing {
+				fmt.Printf("    warning: %s has no value (renders as null or \"\")\n", p)
+			}
+		}
+	}
+
+	if !*offline {
+		fmt.Println("Checking that notification URLs are reachable (no request is sent)")
+		checked := make(map[string]bool)
+		for i, n := range cfg.Notifications {
+			if n.Channel != "" {
+				continue // Delivered by a plugin
+			}
+			var urls [][2]string // field and URL
+			if n.URL != "" {
+				urls = append(urls, [2]string{"http_url", n.URL})
+			}
+			for j, t := range n.Targets {
+				urls = append(urls, [2]string{fmt.Sprintf("targets[%d].url", j), t.URL})
+			}
+			for _, u := range urls {
+				field := u[0]
+				addr, err := dialAddr(u[1])
+				if err != nil {
+					fmt.Printf("  notifications[%d].%s: skipped: %v\n", i, field, err)
+					continue
+				}
+				if checked[addr] {
+					continue
+				}
+				checked[addr] = true
+				if err := dial(ctx, addr); err != nil {
+					report("notifications[%d].%s: %s unreachable: %v", i, field, addr, err)
+				} else {
+					fmt.Printf("  %s: ok\n", addr)
+				}
+			}
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	fmt.Println("Configuration is valid.")
+	return nil
+}
+
+// flatten splits joined and wrapped errors into the individual problems.
+func flatten(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if multi, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []error
+		for _, e := range multi.Unwrap() {
+			out = append(out, flatten(e)...)
+		}
+		return out
+	}
+	return []error{err}
+}
+
+// readSampleEvents reads a single event or an array of events.
+func readSampleEvents(path string) ([]event.Event, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var events []event.Event
+		if err := json.Unmarshal(data, &events); err != nil {
+			return nil, fmt.Errorf("invalid sample events: %w", err)
+		}
+		return events, nil
+	}
+	var evt event.Event
+	if err := json.Unmarshal(data, &evt); err != nil {
+		return nil, fmt.Errorf("invalid sample event: %w", err)
+	}
+	return []event.Event{evt}, nil
+}
+
+// dialAddr returns host:port of a notification URL template. Hosts that contain
+// placeholders depend on the event and cannot be checked.
+func dialAddr(tmpl string) (string, error) {
+	if err := render.CheckString(tmpl); err != nil {
+		return "", err
+	}
+	if _, rest, ok := strings.Cut(tmpl, "://"); ok {
+		if host, _, _ := strings.Cut(rest, "/"); strings.Contains(host, "{$") {
+			return "", errors.New("host is templated")
+		}
+	}
+	u, err := url.Parse(render.Sample(tmpl))
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("URL has no host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+func dial(ctx context.Context, addr string) error {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -2,41 +2,115 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"notification-system/pkg/config"
+	"notification-system/pkg/diag"
+	"notification-system/pkg/health"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/secrets"
 	"notification-system/pkg/worker"
 )
 
 func main() {
+	configSource := flag.String("config", "config.json", "config file path or etcd://, consul:// source")
+	debugAddr := flag.String("debug-addr", "", "enable pprof and /debug/status on this address (e.g. localhost:6060)")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// 1. Load and Validate Configuration
-	cfg, err := config.LoadConfig("config.json")
+	store, err := config.OpenStore(ctx, *configSource)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 	log.Println("Configuration loaded and validated.")
 
-	// 2. Initialize Worker (Core Processing Logic & RocketMQ Consumer)
+	// 2. Resolve secret references (vault://, aws-sm://)
+	resolver := secrets.NewDefaultResolver()
+	cfg, err := resolver.ResolveConfig(ctx, store.Config())
+	if err != nil {
+		log.Fatalf("Failed to resolve secrets: %v", err)
+	}
+
+	if err := mq.EnsureTopics(ctx, cfg.MQ, cfg.Topics()); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// 3. Initialize Worker (Core Processing Logic & Message Queue Consumer)
 	w, err := worker.NewWorker(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize worker: %v", err)
 	}
 
-	// 3. Start Worker (Subscribe and Consume)
-	if err := w.Start(context.Background()); err != nil {
+	// Pick up config changes and refreshed secrets at runtime
+	resolver.Track(ctx, store, func(cfg *config.Config) {
+		if err := w.UpdateConfig(cfg); err != nil {
+			log.Printf("Failed to apply config change: %v", err)
+		}
+	})
+	go store.Watch(ctx)
+
+	// 4. Start Worker (Subscribe and Consume)
+	if err := w.Start(ctx); err != nil {
 		log.Fatalf("Failed to start worker: %v", err)
 	}
-	defer w.Shutdown()
-	log.Println("RocketMQ Subscriber (Worker) started.")
+	log.Printf("%s subscriber (worker) started.", cfg.MQ.Broker)
 
-	// 4. Wait for termination signal
+	// 5. Start Health Endpoints (Kubernetes liveness/readiness probes)
+	healthServer := startHealthServer(store, w)
+
+	if *debugAddr != "" {
+		debugServer := diag.Serve(*debugAddr, w.Status)
+		defer debugServer.Close()
+	}
+
+	// 6. Wait for termination signal
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 
 	log.Println("Shutting down Worker...")
+	healthServer.Close()
+	if err := w.Shutdown(); err != nil {
+		log.Printf("Worker shutdown error: %v", err)
+	}
 	log.Println("Worker exited")
 }
+
+// startHealthServer exposes /healthz and /readyz reporting config, MQ connectivity and subscriptions.
+func startHealthServer(store *config.Store, w *worker.Worker) *http.Server {
+	checks := health.NewRegistry()
+	checks.Register("config", func(ctx context.Context) error {
+		if store.Config() == nil {
+			return fmt.Errorf("configuration not loaded")
+		}
+		return nil
+	})
+	checks.Register("mq", func(ctx context.Context) error {
+		return mq.Ping(ctx, store.Config().MQ)
+	})
+	checks.Register("subscriptions", func(ctx context.Context) error {
+		return w.Ready()
+	})
+
+	mux := http.NewServeMux()
+	checks.RegisterHandlers(mux)
+
+	addr := store.Config().Worker.HealthAddr
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Health server started on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server failed: %v", err)
+		}
+	}()
+	return server
+}
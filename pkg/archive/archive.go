@@ -0,0 +1,89 @@
+// Package archive keeps a copy of every ingested event so that events can be replayed
+// after a downstream outage.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"notification-system/pkg/event"
+)
+
+// Archive stores ingested events.
+type Archive interface {
+	// Put stores an event.
+	Put(ctx context.Context, evt event.Event) error
+	// Query calls fn for every archived event matching f, oldest first, until fn returns an error.
+	Query(ctx context.Context, f Filter, fn func(event.Event) error) error
+}
+
+// Filter selects archived events. Zero fields match everything.
+type Filter struct {
+	// Tenant selects the events of one tenant; "" matches every tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// EventType is an exact event type or a wildcard pattern such as "order.*".
+	EventType string `json:"event_type,omitempty"`
+	// From (inclusive) and To (exclusive) bound the event timestamp.
+	From time.Time `json:"from,omitempty"`
+	To   time.Time `json:"to,omitempty"`
+}
+
+// Match reports whether evt is selected by the filter.
+func (f Filter) Match(evt event.Event) bool {
+	if f.Tenant != "" && f.Tenant != evt.TenantID {
+		return false
+	}
+	if f.EventType != "" && f.EventType != evt.Type {
+		if matched, _ := path.Match(f.EventType, evt.Type); !matched {
+			return false
+		}
+	}
+	if !f.From.IsZero() && evt.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !evt.Timestamp.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// days returns the UTC days covered by the filter, or nil when it is unbounded.
+func (f Filter) days() []time.Time {
+	if f.From.IsZero() || f.To.IsZero() {
+		return nil
+	}
+	var days []time.Time
+	for d := truncateDay(f.From); d.Before(f.To); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Open creates an archive from a URL: a directory path or file:///dir for a local
+// archive, or s3://bucket/prefix for S3.
+func Open(ctx context.Context, rawURL string) (Archive, error) {
+	if !strings.Contains(rawURL, "://") {
+		return NewFileArchive(rawURL)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive url: %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		return NewFileArchive(u.Path)
+	case "s3":
+		return NewS3Archive(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	default:
+		return nil, fmt.Errorf("unsupported archive scheme '%s'", u.Scheme)
+	}
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"notification-system/pkg/event"
+)
+
+// FileArchive appends events as JSON lines to one file per UTC day (2006-01-02.jsonl).
+type FileArchive struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileArchive creates the archive directory if needed.
+func NewFileArchive(dir string) (*FileArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &FileArchive{dir: dir}, nil
+}
+
+func (a *FileArchive) Put(ctx context.Context, evt event.Event) error {
+	line, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.fileFor(evt.Timestamp), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (a *FileArchive) Query(ctx context.Context, f Filter, fn func(event.Event) error) error {
+	files, err := a.files(f)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := a.scan(file, f, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *FileArchive) scan(file string, f Filter, fn func(event.Event) error) error {
+	fh, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var evt event.Event
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			return fmt.Errorf("%s: corrupt archive entry: %w", file, err)
+		}
+		if !f.Match(evt) {
+			continue
+		}
+		if err := fn(evt); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// files lists the day files that may contain events matching f, oldest first.
+func (a *FileArchive) files(f Filter) ([]string, error) {
+	if days := f.days(); days != nil {
+		files := make([]string, len(days))
+		for i, d := range days {
+			files[i] = a.fileFor(d)
+		}
+		return files, nil
+	}
+	files, err := filepath.Glob(filepath.Join(a.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (a *FileArchive) fileFor(t time.Time) string {
+	return filepath.Join(a.dir, t.UTC().Format("2006-01-02")+".jsonl")
+}
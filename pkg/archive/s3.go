@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"notification-system/pkg/event"
+)
+
+// S3Archive stores each event as a JSON object under
+// <prefix>/YYYY/MM/DD/<unix nanos>-<event id>.json, so keys sort by time within a day.
+type S3Archive struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Archive creates an archive in bucket using the default AWS credential chain.
+func NewS3Archive(ctx context.Context, bucket, prefix string) (*S3Archive, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 archive requires a bucket")
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &S3Archive{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: prefix}, nil
+}
+
+func (a *S3Archive) Put(ctx context.Context, evt event.Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	ts := evt.Timestamp.UTC()
+	key := path.Join(a.prefix, ts.Format("2006/01/02"), strconv.FormatInt(ts.UnixNano(), 10)+"-"+evt.ID+".json")
+	_, err = a.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(a.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (a *S3Archive) Query(ctx context.Context, f Filter, fn func(event.Event) error) error {
+	prefixes := []string{a.prefix}
+	if days := f.days(); days != nil {
+		prefixes = prefixes[:0]
+		for _, d := range days {
+			prefixes = append(prefixes, path.Join(a.prefix, d.Format("2006/01/02"))+"/")
+		}
+	}
+
+	for _, prefix := range prefixes {
+		pages := s3.NewListObjectsV2Paginator(a.client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(a.bucket),
+			Prefix: aws.String(prefix),
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, obj := range page.Contents {
+				evt, err := a.get(ctx, aws.ToString(obj.Key))
+				if err != nil {
+					return err
+				}
+				if !f.Match(evt) {
+					continue
+				}
+				if err := fn(evt); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (a *S3Archive) get(ctx context.Context, key string) (event.Event, error) {
+	var evt event.Event
+	out, err := a.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return evt, err
+	}
+	defer out.Body.Close()
+	if err := json.NewDecoder(out.Body).Decode(&evt); err != nil {
+		return evt, fmt.Errorf("%s: corrupt archive entry: %w", key, err)
+	}
+	return evt, nil
+}
@@ -0,0 +1,267 @@
+// Package audit exports delivery records to object storage for long-term retention and
+// keeps the audit trail of config changes made through the admin API.
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+)
+
+const (
+	// maxBufferedFlushes bounds the records kept while the object store is unavailable to
+	// this many flushes' worth; older records are dropped beyond that.
+	maxBufferedFlushes = 10
+	// closeTimeout bounds the final flush on Close.
+	closeTimeout = 30 * time.Second
+)
+
+// Exporter buffers delivery records and periodically writes them to object storage as
+// one object per partition, e.g. date=2024-05-01/event_type=order.created/<file>.jsonl.gz.
+// Records whose object could not be written are kept for the next flush.
+type Exporter struct {
+	cfg    config.AuditConfig
+	store  objectStore
+	prefix string
+	// host distinguishes the objects of workers flushing at the same time.
+	host string
+	seq  atomic.Int64
+
+	mu      sync.Mutex
+	buf     []delivery.Record
+	dropped int64
+
+	full chan struct{} // signalled when MaxRecords are buffered
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New opens the object store of cfg.URL. cfg must have been validated.
+func New(ctx context.Context, cfg config.AuditConfig) (*Exporter, error) {
+	store, prefix, err := openStore(ctx, cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &Exporter{
+		cfg:    cfg,
+		store:  store,
+		prefix: prefix,
+		host:   host,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Add buffers r for the next flush.
+func (e *Exporter) Add(r delivery.Record) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if limit := maxBufferedFlushes * e.cfg.MaxRecords; len(e.buf) >= limit {
+		e.buf = e.buf[1:]
+		e.dropped++
+	}
+	e.buf = append(e.buf, r)
+	if len(e.buf) == e.cfg.MaxRecords {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run flushes every audit.flush_interval, or earlier once audit.max_records are
+// buffered, until Close is called.
+func (e *Exporter) Run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.FlushInterval.Std())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		case <-e.full:
+		}
+		if err := e.Flush(context.Background()); err != nil {
+			log.Printf("Audit export failed: %v", err)
+		}
+	}
+}
+
+// Close stops Run and flushes the remaining records.
+func (e *Exporter) Close() error {
+	close(e.stop)
+	<-e.done
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return e.Flush(ctx)
+}
+
+// Flush writes the buffered records. Records of partitions that failed are put back
+// into the buffer.
+func (e *Exporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	records := e.buf
+	e.buf = nil
+	if e.dropped > 0 {
+		log.Printf("Audit export dropped %d records while the object store was unavailable", e.dropped)
+		e.dropped = 0
+	}
+	e.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+
+	partitions := make(map[string][]delivery.Record)
+	for _, r := range records {
+		p := e.partition(r)
+		partitions[p] = append(partitions[p], r)
+	}
+	keys := make([]string, 0, len(partitions))
+	for p := range partitions {
+		keys = append(keys, p)
+	}
+	sort.Strings(keys)
+
+	var failed []delivery.Record
+	var firstErr error
+	now := time.Now().UTC()
+	for _, p := range keys {
+		if err := e.write(ctx, p, now, partitions[p]); err != nil {
+			failed = append(failed, partitions[p]...)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("partition %s: %w", p, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		e.mu.Lock()
+		e.buf = append(failed, e.buf...)
+		e.mu.Unlock()
+		return fmt.Errorf("%d records kept for retry: %w", len(failed), firstErr)
+	}
+	return nil
+}
+
+// partition returns the partition path of r, such as "date=2024-05-01/event_type=signup".
+func (e *Exporter) partition(r delivery.Record) string {
+	t := r.Time.UTC()
+	segments := make([]string, 0, len(e.cfg.PartitionBy))
+	for _, key := range e.cfg.PartitionBy {
+		var value string
+		switch key {
+		case config.PartitionDate:
+			value = t.Format("2006-01-02")
+		case config.PartitionHour:
+			value = t.Format("15")
+		case config.PartitionEventType:
+			value = r.EventType
+		case config.PartitionTenant:
+			value = r.Tenant
+		}
+		if value == "" {
+			value = "_default"
+		}
+		segments = append(segments, key+"="+url.PathEscape(value))
+	}
+	return path.Join(segments...)
+}
+
+// write encodes records and stores them as a new object of partition p.
+func (e *Exporter) write(ctx context.Context, p string, now time.Time, records []delivery.Record) error {
+	var (
+		body        []byte
+		ext         string
+		contentType string
+		err         error
+	)
+	switch e.cfg.Format {
+	case config.AuditFormatParquet:
+		body, err = encodeParquet(records)
+		ext, contentType = ".parquet", "application/vnd.apache.parquet"
+	default:
+		body, err = encodeJSONL(records)
+		ext, contentType = ".jsonl.gz", "application/gzip"
+	}
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%d%s", now.Format("20060102T150405Z"), e.host, e.seq.Add(1), ext)
+	return e.store.put(ctx, path.Join(e.prefix, p, name), body, contentType)
+}
+
+// encodeJSONL returns records as gzip-compressed JSON lines.
+func encodeJSONL(records []delivery.Record) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// row is the Parquet schema of a delivery record.
+type row struct {
+	Tenant          string    `parquet:"tenant"`
+	EventID         string    `parquet:"event_id"`
+	EventType       string    `parquet:"event_type"`
+	TemplateVersion string    `parquet:"template_version"`
+	URL             string    `parquet:"url"`
+	Success         bool      `parquet:"success"`
+	Attempts        int32     `parquet:"attempts"`
+	StatusCode      int32     `parquet:"status_code"`
+	Error           string    `parquet:"error"`
+	ResponseBody    string    `parquet:"response_body"`
+	DurationMs      int64     `parquet:"duration_ms"`
+	Time            time.Time `parquet:"time,timestamp(millisecond)"`
+}
+
+// encodeParquet returns records as a zstd-compressed Parquet file.
+func encodeParquet(records []delivery.Record) ([]byte, error) {
+	rows := make([]row, len(records))
+	for i, r := range records {
+		rows[i] = row{
+			Tenant:          r.Tenant,
+			EventID:         r.EventID,
+			EventType:       r.EventType,
+			TemplateVersion: r.TemplateVersion,
+			URL:             r.URL,
+			Success:         r.Success,
+			Attempts:        int32(r.Attempts),
+			StatusCode:      int32(r.StatusCode),
+			Error:           r.Error,
+			ResponseBody:    r.ResponseBody,
+			DurationMs:      r.DurationMs,
+			Time:            r.Time,
+		}
+	}
+	var buf bytes.Buffer
+	if err := parquet.Write(&buf, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// secretFields are the fields of notifications whose values are masked in the config log,
+// unless they are secret references; the values of headers are masked too.
+var secretFields = map[string]bool{"signing_secret": true, "client_secret": true}
+
+// MaskedValue replaces secrets in the config log and in the responses of the admin API.
+const MaskedValue = "***"
+
+// ConfigEntry is a change made through the admin API, as kept in the config log.
+type ConfigEntry struct {
+	Time time.Time `json:"time"`
+	// Actor identifies the user (see config.AuditConfig.ActorHeader); empty when unknown.
+	Actor      string `json:"actor,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// Action is one of the config.Action* constants.
+	Action    string `json:"action"`
+	Tenant    string `json:"tenant,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+	Target    string `json:"target,omitempty"`
+	// Diff lists the changed fields in path order.
+	Diff []FieldChange `json:"diff"`
+	// Before and After are the notification or pause before and after the change, with
+	// secrets masked.
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// FieldChange is a changed field of a ConfigEntry. Path is dot-separated, e.g.
+// "retry_policy.max_attempts"; arrays are compared as a whole.
+type FieldChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// NewConfigEntry describes c, made by actor from remoteAddr at t. Changed secrets show up
+// in the diff, masked.
+func NewConfigEntry(c config.Change, actor, remoteAddr string, t time.Time) (ConfigEntry, error) {
+	before, err := decodedJSON(c.Before)
+	if err != nil {
+		return ConfigEntry{}, err
+	}
+	after, err := decodedJSON(c.After)
+	if err != nil {
+		return ConfigEntry{}, err
+	}
+	changes := []FieldChange{}
+	diff("", before, after, &changes)
+	for i, fc := range changes {
+		changes[i].From, changes[i].To = maskPath(fc.Path, fc.From), maskPath(fc.Path, fc.To)
+	}
+	return ConfigEntry{
+		Time:       t.UTC(),
+		Actor:      actor,
+		RemoteAddr: remoteAddr,
+		Action:     c.Action,
+		Tenant:     c.Tenant,
+		EventType:  c.EventType,
+		Target:     c.Target,
+		Diff:       changes,
+		Before:     maskPath("", before),
+		After:      maskPath("", after),
+	}, nil
+}
+
+// decodedJSON returns v as decoded from its JSON encoding.
+func decodedJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
+
+// Masked returns v as decoded from its JSON encoding, with its secrets masked as in the
+// config log.
+func Masked(v interface{}) (interface{}, error) {
+	decoded, err := decodedJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return maskPath("", decoded), nil
+}
+
+// maskPath masks v, the decoded JSON value at path, if it is a secret, or else the
+// secrets nested in it. Objects are masked in place.
+func maskPath(path string, v interface{}) interface{} {
+	segments := strings.Split(path, ".")
+	last := segments[len(segments)-1]
+	switch v := v.(type) {
+	case string:
+		if secretFields[last] || (len(segments) > 1 && segments[len(segments)-2] == "headers") {
+			return maskSecret(v)
+		}
+	case map[string]interface{}:
+		if last == "headers" {
+			for name, value := range v {
+				if s, ok := value.(string); ok {
+					v[name] = maskSecret(s)
+				}
+			}
+			break
+		}
+		mask(v)
+	case []interface{}:
+		for _, item := range v {
+			if nested, ok := item.(map[string]interface{}); ok {
+				mask(nested)
+			}
+		}
+	}
+	return v
+}
+
+// mask masks the secret fields and header values of m and the objects nested in it.
+func mask(m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = maskPath(k, v)
+	}
+}
+
+// maskSecret masks a secret value. Secret references such as vault://... are kept, as
+// they show which secret is used without revealing it.
+func maskSecret(s string) string {
+	if s == "" || strings.Contains(s, "://") {
+		return s
+	}
+	return MaskedValue
+}
+
+// diff appends the changes between two decoded JSON values at path to out.
+func diff(path string, from, to interface{}, out *[]FieldChange) {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if (fromIsMap || from == nil) && (toIsMap || to == nil) && (fromIsMap || toIsMap) {
+		keys := make([]string, 0, len(fromMap)+len(toMap))
+		for k := range fromMap {
+			keys = append(keys, k)
+		}
+		for k := range toMap {
+			if _, ok := fromMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diff(p, fromMap[k], toMap[k], out)
+		}
+		return
+	}
+	if !reflect.DeepEqual(from, to) {
+		*out = append(*out, FieldChange{Path: path, From: from, To: to})
+	}
+}
+
+// ConfigLog is the audit trail of config changes: a file of ConfigEntry JSON lines. It is
+// safe for concurrent use.
+type ConfigLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewConfigLog creates a ConfigLog appending to the file at path, which is created on the
+// first Append.
+func NewConfigLog(path string) *ConfigLog {
+	return &ConfigLog{path: path}
+}
+
+// Append writes e to the end of the log and syncs it to disk.
+func (l *ConfigLog) Append(e ConfigEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Entries returns up to limit entries for which keep returns true, newest first.
+func (l *ConfigLog) Entries(keep func(ConfigEntry) bool, limit int) ([]ConfigEntry, error) {
+	l.mu.Lock()
+	f, err := os.Open(l.path)
+	l.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return []ConfigEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ConfigEntry
+	dec := json.NewDecoder(f)
+	for {
+		var e ConfigEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("corrupt config log %s: %w", l.path, err)
+		}
+		if keep(e) {
+			entries = append(entries, e)
+		}
+	}
+	out := make([]ConfigEntry, 0, min(limit, len(entries)))
+	for i := len(entries) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, entries[i])
+	}
+	return out, nil
+}
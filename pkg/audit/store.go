@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// gcsEndpoint is the S3-compatible (XML API) endpoint of Google Cloud Storage.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// objectStore writes whole objects.
+type objectStore interface {
+	put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// openStore opens the store of an audit URL and returns the key prefix within it.
+func openStore(ctx context.Context, rawURL string) (objectStore, string, error) {
+	if !strings.Contains(rawURL, "://") {
+		return fileStore{dir: rawURL}, "", nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid audit url: %w", err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return fileStore{dir: u.Path}, "", nil
+	case "s3", "gs":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("audit url %s requires a bucket", rawURL)
+		}
+		store, err := newS3Store(ctx, u.Host, u.Scheme == "gs")
+		return store, prefix, err
+	default:
+		return nil, "", fmt.Errorf("unsupported audit scheme '%s'", u.Scheme)
+	}
+}
+
+// fileStore writes objects as files below dir.
+type fileStore struct {
+	dir string
+}
+
+func (s fileStore) put(ctx context.Context, key string, body []byte, contentType string) error {
+	file := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	// Written under a temporary name so readers never see partial files
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// s3Store writes objects to an S3 bucket using the default AWS credential chain. Google
+// Cloud Storage buckets are written through its S3-compatible API, authenticated with an
+// HMAC key passed as AWS credentials.
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Store(ctx context.Context, bucket string, gcs bool) (*s3Store, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if !gcs {
+			return
+		}
+		o.BaseEndpoint = aws.String(gcsEndpoint)
+		o.UsePathStyle = true
+		if o.Region == "" {
+			o.Region = "auto"
+		}
+		// GCS rejects the checksum trailers the SDK adds by default
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+	return &s3Store{client: client, bucket: bucket}, nil
+}
+
+func (s *s3Store) put(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
@@ -0,0 +1,261 @@
+// Package client publishes events to the ingestion API of the notification system
+// (POST /events and POST /events/batch):
+//
+//	c := client.New("http://notify-api:8080", client.Options{APIKey: os.Getenv("NOTIFY_API_KEY")})
+//	id, err := c.Publish(ctx, event.Event{Type: "order.created", Data: map[string]interface{}{"order_id": "42"}})
+//
+// Events without an ID are given a random one before the first attempt, so every retry
+// publishes the same event: the worker derives the Idempotency-Key of its deliveries from
+// the ID and skips deliveries it already made. Requests are retried with full-jitter
+// exponential backoff on network errors and temporary errors (see Error.Temporary),
+// honouring Retry-After. A Client is safe for concurrent use and reuses its connections.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-system/pkg/event"
+)
+
+// Defaults of Options.
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultRetries      = 3
+	DefaultBackoffBase  = 200 * time.Millisecond
+	DefaultBackoffMax   = 5 * time.Second
+	DefaultMaxIdleConns = 32
+)
+
+// apiKeyHeader carries the API key, as accepted by cmd/api.
+const apiKeyHeader = "X-API-Key"
+
+// Send modes of Options.SendMode.
+const (
+	SendModeSync  = "sync"
+	SendModeAsync = "async"
+)
+
+// Options configure a Client. The zero value publishes without an API key using the
+// defaults above.
+type Options struct {
+	// APIKey identifies the tenant; required when the API has tenants configured.
+	APIKey string
+	// SendMode is sync or async; empty uses the API's api.send_mode.
+	SendMode string
+	// Retries is the number of retries after the first attempt (default 3); negative
+	// disables retries.
+	Retries int
+	// BackoffBase and BackoffMax bound the delay before each retry: retry n waits a random
+	// duration up to min(BackoffMax, BackoffBase * 2^(n-1)).
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// Timeout bounds each attempt (default 10s).
+	Timeout time.Duration
+	// MaxIdleConns is the number of idle connections kept to the API (default 32).
+	MaxIdleConns int
+	// HTTPClient replaces the client built from Timeout and MaxIdleConns.
+	HTTPClient *http.Client
+}
+
+// Client publishes events to one API.
+type Client struct {
+	baseURL string
+	opts    Options
+	http    *http.Client
+}
+
+// New creates a Client for the API at baseURL, e.g. "http://notify-api:8080".
+func New(baseURL string, opts Options) *Client {
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	}
+	if opts.BackoffBase <= 0 {
+		opts.BackoffBase = DefaultBackoffBase
+	}
+	if opts.BackoffMax <= 0 {
+		opts.BackoffMax = DefaultBackoffMax
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = DefaultMaxIdleConns
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// The transport only talks to the API, so the per-host limit is the total limit
+		transport.MaxIdleConns = opts.MaxIdleConns
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConns
+		httpClient = &http.Client{Timeout: opts.Timeout, Transport: transport}
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), opts: opts, http: httpClient}
+}
+
+// Error is a response of the API that rejected a request.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay the API asked for with Retry-After, if any.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("notification API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed when retried: the API was
+// overloaded, over quota or failed to reach the broker.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Result is the outcome of one event of a batch. An empty Error means the event was
+// accepted.
+type Result struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Publish publishes evt and returns its ID, which is generated when evt has none.
+func (c *Client) Publish(ctx context.Context, evt event.Event) (string, error) {
+	if evt.ID == "" {
+		evt.ID = NewEventID()
+	}
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event: %w", err)
+	}
+	return evt.ID, c.post(ctx, "/events", body, nil)
+}
+
+// PublishBatch publishes events with one request and returns a result per event, in
+// order. Events are accepted or rejected individually; the error is only set when the
+// request as a whole failed. Events without an ID are given one, as with Publish.
+func (c *Client) PublishBatch(ctx context.Context, events []event.Event) ([]Result, error) {
+	batch := struct {
+		Events []event.Event `json:"events"`
+	}{Events: make([]event.Event, len(events))}
+	for i, evt := range events {
+		if evt.ID == "" {
+			evt.ID = NewEventID()
+		}
+		batch.Events[i] = evt
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode events: %w", err)
+	}
+	var resp struct {
+		Results []Result `json:"results"`
+	}
+	if err := c.post(ctx, "/events/batch", body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// post sends body to path, retrying temporary failures, and decodes a successful
+// response into out unless it is nil.
+func (c *Client) post(ctx context.Context, path string, body []byte, out interface{}) error {
+	u := c.baseURL + path
+	if c.opts.SendMode != "" {
+		u += "?send_mode=" + url.QueryEscape(c.opts.SendMode)
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.do(ctx, u, body, out)
+		if err == nil {
+			return nil
+		}
+		var apiErr *Error
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			return err
+		}
+		if attempt >= c.opts.Retries || ctx.Err() != nil {
+			break
+		}
+		delay := c.backoff(attempt + 1)
+		if apiErr != nil && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+func (c *Client) do(ctx context.Context, u string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.opts.APIKey != "" {
+		req.Header.Set(apiKeyHeader, c.opts.APIKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			apiErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return apiErr
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// backoff returns the delay before retry n (1-based) with full jitter.
+func (c *Client) backoff(n int) time.Duration {
+	base, ceiling := c.opts.BackoffBase, max(c.opts.BackoffMax, c.opts.BackoffBase)
+	d := ceiling
+	if shift := n - 1; shift < 32 && base<<shift > 0 && base<<shift < ceiling {
+		d = base << shift
+	}
+	return mrand.N(d + 1)
+}
+
+// NewEventID returns a random event ID.
+func NewEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
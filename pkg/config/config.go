@@ -1,37 +1,1347 @@
 package config
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/netip"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
+
+	"notification-system/pkg/ratelimit"
+	"notification-system/pkg/redact"
+	"notification-system/pkg/render"
+	"notification-system/pkg/transform"
 )
 
 // NotificationConfig defines how to notify an external system for a specific event type.
 type NotificationConfig struct {
-	EventType string                 `json:"event_type"`
-	QueueName string                 `json:"queue_name"`
-	Method    string                 `json:"http_method"`
-	URL       string                 `json:"http_url"`
-	Headers   map[string]string      `json:"headers"`
-	Body      map[string]interface{} `json:"body"`
+	// Tenant scopes the notification to events ingested by that tenant. Notifications without
+	// a tenant handle events of the default tenant, used when no tenants are configured.
+	Tenant    string `json:"tenant,omitempty"`
+	EventType string `json:"event_type"`
+	QueueName string `json:"queue_name"`
+	Method    string `json:"http_method"`
+	URL       string `json:"http_url"`
+	// Targets split the deliveries between several URLs by weight instead of http_url,
+	// e.g. to shift traffic from an old endpoint to a new one. Events are assigned by ID.
+	Targets []WeightedTarget `json:"targets,omitempty"`
+	// Channel names a notifier plugin (see ChannelConfig) that delivers the rendered
+	// notification instead of an HTTP request; http_method and http_url are optional then.
+	Channel string                 `json:"channel,omitempty"`
+	Headers map[string]string      `json:"headers"`
+	Body    map[string]interface{} `json:"body"`
+	// MirrorURL is a template like URL that receives a copy of each delivery in the
+	// background, e.g. the new endpoint of a partner being migrated. Its responses are
+	// only logged when they disagree with the target's.
+	MirrorURL string `json:"mirror_url,omitempty"`
+	// Enrich looks up additional data for the event before it is transformed and rendered.
+	Enrich []EnrichConfig `json:"enrich,omitempty"`
+	// Transform reshapes the event data before the body, URL and headers are rendered.
+	Transform []transform.Spec `json:"transform,omitempty"`
+	// Redact lists sensitive fields that are masked in logs and delivery records.
+	Redact *RedactConfig `json:"redact,omitempty"`
+	// BodyFormat selects how the rendered body is encoded: json (default), form, xml or text.
+	BodyFormat string `json:"body_format,omitempty"`
+	// BodyText is the template sent as-is when body_format is text.
+	BodyText string `json:"body_text,omitempty"`
+	// Version labels body and body_text in delivery records when Templates roll out
+	// other versions.
+	Version string `json:"version,omitempty"`
+	// Templates are alternative versions of the body, each rendered for a percentage of
+	// events; the remaining events get body.
+	Templates []TemplateVersion `json:"templates,omitempty"`
+	// EventVersions limits the notification to events of these versions, after upcasting;
+	// events without a version are version 0. Notifications of the same event type with
+	// different event_versions render each version with its own templates. Empty accepts
+	// every version.
+	EventVersions []int `json:"event_versions,omitempty"`
+	// SigningSecret, when set, is used to sign the request body with HMAC-SHA256.
+	SigningSecret string `json:"signing_secret,omitempty"`
+	// TLS configures the connection to the target, e.g. a private CA or a client certificate for mTLS.
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// Auth configures how the worker obtains credentials for the target.
+	Auth *AuthConfig `json:"auth,omitempty"`
+	// HTTPClient tunes the HTTP client used for the target (timeout, proxy, connection reuse).
+	HTTPClient *HTTPClientConfig `json:"http_client,omitempty"`
+	// Success defines when a response counts as delivered. Defaults to any 2xx status.
+	Success *SuccessConfig `json:"success,omitempty"`
+	// Schema references a JSON Schema (file path or URL) that event data must match at ingestion.
+	Schema string `json:"schema,omitempty"`
+	// Registry validates event data against the latest schema of a subject of the schema
+	// registry at ingestion. Data of Avro subjects is also sent Avro-encoded.
+	Registry *RegistrySchemaConfig `json:"registry,omitempty"`
+	// ShardingKey is a template such as "{$.event.order_id}". Events with the same rendered key
+	// are produced to the same queue in ingestion order.
+	ShardingKey string `json:"sharding_key,omitempty"`
+	// Encoding overrides mq.encoding for the events of this notification, e.g. protobuf for
+	// a high-volume queue.
+	Encoding string `json:"encoding,omitempty"`
+	// Priority is high, normal (default) or low. Delivery concurrency is split between
+	// priorities so a flood of low-value events can't starve important ones. Notifications
+	// sharing a queue_name must share a priority.
+	Priority string `json:"priority,omitempty"`
+	// Retries is the number of local delivery attempts before the message is handed back to the MQ (default 3).
+	Retries int `json:"retries,omitempty"`
+	// Backoff tunes the delay between local delivery attempts.
+	Backoff *BackoffConfig `json:"backoff,omitempty"`
+	// DeliveryTimeout bounds a delivery with all its local attempts and the waits between
+	// them, e.g. 30s; a delivery that runs out of time is handed back to the MQ like one
+	// whose local attempts failed. Zero leaves deliveries unbounded but for the HTTP timeout.
+	DeliveryTimeout Duration `json:"delivery_timeout,omitempty"`
+	// Digest combines events into one notification per key and window; nil delivers each
+	// event on its own.
+	Digest *DigestConfig `json:"digest,omitempty"`
+	// Samples are example events "notifyctl check-templates" renders the notification for,
+	// to catch template mistakes before real events do. The worker ignores them.
+	Samples []SampleEvent `json:"samples,omitempty"`
+	// TTL drops events older than this, by their timestamp, instead of delivering them late,
+	// e.g. after an outage. Events may also carry an expires_at of their own.
+	TTL Duration `json:"ttl,omitempty"`
+	// CallbackURL receives a receipt with the final outcome of each delivery, unless the
+	// event carries its own callback_url.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// RateLimit limits the requests sent to the target of this notification. With
+	// rate_limit.redis the limit applies to all workers together, otherwise to each worker.
+	RateLimit *ratelimit.Quota `json:"rate_limit,omitempty"`
+	// DryRun renders and logs the notification of each event, and records it in the
+	// delivery records, without sending it, so a new or changed integration can be checked
+	// against real traffic first. Dry runs send no receipts and don't count as deliveries.
+	DryRun bool `json:"dry_run,omitempty"`
+	// RawData has the worker read the fields its templates use straight from the message
+	// instead of decoding the whole event data first, which saves allocations on
+	// high-volume topics with large events. It can't be combined with enrich, transform,
+	// redact or digest, which need the decoded data.
+	RawData bool `json:"raw_data,omitempty"`
+
+	// body is Body compiled by Validate, shared by the copies of the notification.
+	body *render.Template
+}
+
+// BodyTemplate returns the compiled body template. Notifications that weren't validated
+// have theirs compiled on every call.
+func (n *NotificationConfig) BodyTemplate() (*render.Template, error) {
+	if n.body != nil {
+		return n.body, nil
+	}
+	return render.Compile(n.Body)
+}
+
+// WithTemplate returns a copy of n that renders the body of the template version t.
+func (n NotificationConfig) WithTemplate(t TemplateVersion) *NotificationConfig {
+	n.Body, n.BodyText, n.body = t.Body, t.BodyText, t.body
+	return &n
+}
+
+// compileTemplates compiles the body templates of n, which Validate has checked. The
+// template versions are copied first, as other copies of the config may be in use.
+func (n *NotificationConfig) compileTemplates() {
+	n.body, _ = render.Compile(n.Body)
+	n.Templates = slices.Clone(n.Templates)
+	for i := range n.Templates {
+		n.Templates[i].body, _ = render.Compile(n.Templates[i].Body)
+	}
+}
+
+// WeightedTarget is one of the URLs the deliveries of a notification are split between.
+// Events are assigned to the targets in proportion to their weights, so changing the
+// weights (see Store.SetTargetWeights) moves traffic gradually and can roll it back.
+type WeightedTarget struct {
+	// Name identifies the target, e.g. blue or green, in admin calls and delivery records.
+	Name string `json:"name"`
+	// URL is a template like http_url.
+	URL string `json:"url"`
+	// Weight is the share of events relative to the other targets; 0 sends none.
+	Weight int `json:"weight"`
+}
+
+// TemplateVersion is a version of a notification body rolled out to a share of events.
+// Events are assigned by ID, so all deliveries of an event use the same version, and
+// raising Percent keeps the events already assigned to the version.
+type TemplateVersion struct {
+	Version string `json:"version"`
+	// Percent of events, from 1 to 100, rendered with this version.
+	Percent  int                    `json:"percent"`
+	Body     map[string]interface{} `json:"body,omitempty"`
+	BodyText string                 `json:"body_text,omitempty"`
+
+	body *render.Template // compiled by Validate
+}
+
+// EnrichConfig looks up data with an HTTP or gRPC request and stores the JSON response in
+// the event data under Field, where templates and later lookups reach it as
+// {$.event.<field>}.
+type EnrichConfig struct {
+	Field string `json:"field"`
+	// URL and Method (default GET) make an HTTP lookup; URL and Body are templates.
+	URL    string                 `json:"url,omitempty"`
+	Method string                 `json:"method,omitempty"`
+	Body   map[string]interface{} `json:"body,omitempty"`
+	// GRPC makes a gRPC lookup instead.
+	GRPC *GRPCLookupConfig `json:"grpc,omitempty"`
+	// Headers are sent as HTTP headers or gRPC metadata; values are templates.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout bounds the lookup (default 2s).
+	Timeout Duration `json:"timeout,omitempty"`
+	// CacheTTL keeps responses for identical lookups this long (default: not cached).
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+	// OnError is the policy for failed lookups: retry (default) fails the delivery so it
+	// is retried, skip delivers without the field and default stores Default instead.
+	OnError string      `json:"on_error,omitempty"`
+	Default interface{} `json:"default,omitempty"`
+}
+
+// GRPCLookupConfig calls a unary gRPC method. The server must support reflection, which
+// provides the message types.
+type GRPCLookupConfig struct {
+	// Target is the server address, e.g. "users.internal:50051".
+	Target string `json:"target"`
+	// Method is the full method name, e.g. "users.v1.UserService/GetUser".
+	Method string `json:"method"`
+	// Request is the request message in its JSON form; values are templates.
+	Request map[string]interface{} `json:"request,omitempty"`
+	// TLS connects over TLS, verifying the server against the system roots.
+	TLS bool `json:"tls,omitempty"`
+}
+
+// Enrichment failure policies.
+const (
+	EnrichOnErrorRetry   = "retry"
+	EnrichOnErrorSkip    = "skip"
+	EnrichOnErrorDefault = "default"
+)
+
+// BackoffConfig tunes the full-jitter exponential backoff between local delivery attempts:
+// retry n waits a random duration up to min(Max, Base * 2^(n-1)).
+type BackoffConfig struct {
+	// Base is the upper bound of the first retry's delay (default 200ms).
+	Base Duration `json:"base,omitempty"`
+	// Max caps the delay of any retry (default 10s).
+	Max Duration `json:"max,omitempty"`
+}
+
+// DefaultsConfig holds settings inherited by every notification. Values set on a
+// notification override the defaults; headers are merged key by key.
+type DefaultsConfig struct {
+	Headers         map[string]string `json:"headers,omitempty"`
+	Timeout         Duration          `json:"timeout,omitempty"`
+	Retries         int               `json:"retries,omitempty"`
+	Backoff         *BackoffConfig    `json:"backoff,omitempty"`
+	DeliveryTimeout Duration          `json:"delivery_timeout,omitempty"`
+	SigningSecret   string            `json:"signing_secret,omitempty"`
+}
+
+// RedactConfig defines which event fields are sensitive.
+type RedactConfig struct {
+	// Fields are dot-separated paths into the event data, e.g. "email" or "user.phone".
+	Fields []string `json:"fields"`
+	// Keep is the number of trailing characters left visible, e.g. 4 for phone numbers.
+	Keep int `json:"keep,omitempty"`
+	// Payload also masks the fields in the delivered payload, not just in logs.
+	Payload bool `json:"payload,omitempty"`
+}
+
+// Body formats supported by NotificationConfig.BodyFormat.
+const (
+	BodyFormatJSON = "json"
+	BodyFormatForm = "form"
+	BodyFormatXML  = "xml"
+	BodyFormatText = "text"
+)
+
+// SuccessConfig defines success criteria for a target's response.
+// All configured criteria must hold for the delivery to succeed.
+type SuccessConfig struct {
+	// StatusCodes lists accepted status codes instead of the default 2xx range.
+	StatusCodes []int `json:"status_codes,omitempty"`
+	// JSONField is a dot-separated path into the JSON response body, e.g. "result" or "data.status",
+	// whose value must equal JSONValue.
+	JSONField string      `json:"json_field,omitempty"`
+	JSONValue interface{} `json:"json_value,omitempty"`
+	// BodyRegex must match the raw response body.
+	BodyRegex string `json:"body_regex,omitempty"`
+}
+
+// HTTPClientConfig defines HTTP client settings for a notification target.
+// Zero values fall back to the worker defaults.
+type HTTPClientConfig struct {
+	Timeout           Duration `json:"timeout,omitempty"`
+	ProxyURL          string   `json:"proxy_url,omitempty"`
+	MaxIdleConns      int      `json:"max_idle_conns,omitempty"`
+	DisableKeepAlives bool     `json:"disable_keep_alives,omitempty"`
+}
+
+// AuthTypeOAuth2ClientCredentials fetches bearer tokens with the OAuth2 client credentials grant.
+const AuthTypeOAuth2ClientCredentials = "oauth2_client_credentials"
+
+// AuthConfig defines how to authenticate against a notification target.
+type AuthConfig struct {
+	Type         string   `json:"type"`
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes,omitempty"`
 }
 
-// MQConfig holds the configuration for RocketMQ.
+// TLSConfig defines TLS options for outbound webhook requests.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// MQConfig holds the configuration of the message queue.
 type MQConfig struct {
+	// Broker selects the message queue: "rocketmq" (default), "rocketmq5" (RocketMQ 5.x
+	// through its gRPC proxy), "kafka", "nats" (JetStream), "sqs" (AWS SQS, optionally fed
+	// by SNS), "pubsub" (Google Cloud Pub/Sub), "rabbitmq", "redis" (Redis Streams) or
+	// "memory", which passes messages in process for local development and tests. Pull
+	// mode, priority consumers and offset resets are only available with RocketMQ.
+	Broker string `json:"broker,omitempty"`
+	// RocketMQ5, Kafka, NATS, SQS, PubSub, RabbitMQ and Redis hold the connection settings
+	// of the other brokers. access_key and secret_key (SASL PLAIN for Kafka, user and
+	// password for NATS, RabbitMQ and Redis, AWS access keys for SQS) and, except for
+	// rocketmq5, sqs and pubsub, TLS apply to them as well.
+	RocketMQ5 *RocketMQ5Config `json:"rocketmq5,omitempty"`
+	Kafka     *KafkaConfig     `json:"kafka,omitempty"`
+	NATS      *NATSConfig      `json:"nats,omitempty"`
+	SQS       *SQSConfig       `json:"sqs,omitempty"`
+	PubSub    *PubSubConfig    `json:"pubsub,omitempty"`
+	RabbitMQ  *RabbitMQConfig  `json:"rabbitmq,omitempty"`
+	Redis     *RedisMQConfig   `json:"redis,omitempty"`
+
 	NameServer string `json:"name_server"`
 	AccessKey  string `json:"access_key"`
 	SecretKey  string `json:"secret_key"`
 	GroupName  string `json:"group_name"`
 	MaxRetries int    `json:"max_retries"`
+	// StatusTopic, when set, carries the outcome of every delivery from the workers to the
+	// API's /deliveries/stream.
+	StatusTopic string `json:"status_topic,omitempty"`
+
+	// Namespace isolates topics and groups of several environments sharing one instance
+	// (e.g. an Aliyun RocketMQ instance ID or "MQ_INST_xxx"). Names are used as configured.
+	Namespace string `json:"namespace,omitempty"`
+	// InstanceName distinguishes clients of the same group running in one process or host.
+	InstanceName string `json:"instance_name,omitempty"`
+	// SendRetries is how many times the producer retries a failed send on another broker (default 2).
+	SendRetries int `json:"send_retries,omitempty"`
+	// TLS, when set, encrypts connections to the name server and brokers.
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// Consumer tuning. Zero values keep the RocketMQ client defaults.
+	ConsumeGoroutines int      `json:"consume_goroutines,omitempty"`
+	PullBatchSize     int      `json:"pull_batch_size,omitempty"`
+	ConsumeBatchSize  int      `json:"consume_batch_size,omitempty"`
+	MaxCachedMessages int      `json:"max_cached_messages,omitempty"`
+	ConsumeTimeout    Duration `json:"consume_timeout,omitempty"`
+	// ConsumeParallelism bounds how many messages of a consume_batch_size batch are
+	// delivered at once (default 8).
+	ConsumeParallelism int `json:"consume_parallelism,omitempty"`
+
+	// ConsumeFrom selects where a consumer group without committed offsets starts:
+	// "last" (default), "first" or "timestamp". Existing groups keep their offsets;
+	// use notifyctl reset-offset to move them.
+	ConsumeFrom string `json:"consume_from,omitempty"`
+	// ConsumeTimestamp is the RFC 3339 start time used with consume_from "timestamp".
+	ConsumeTimestamp string `json:"consume_timestamp,omitempty"`
+
+	// Compression compresses produced message bodies with "gzip" or "zstd"; empty disables it.
+	// Workers decompress any flagged message regardless of their own setting.
+	Compression string `json:"compression,omitempty"`
+	// CompressThreshold is the minimum body size in bytes worth compressing (default 1024).
+	CompressThreshold int `json:"compress_threshold,omitempty"`
+	// Encoding serializes events in messages as "json" (default) or "protobuf", which is
+	// smaller and faster to parse. Notifications may override it for their queue. Workers
+	// decode either, by the content type property of the message.
+	Encoding string `json:"encoding,omitempty"`
+	// Encryption encrypts produced message bodies; nil disables it. Workers decrypt any
+	// flagged message they have the key of, regardless of their own setting.
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+
+	// TopicCheck has the API and worker check at startup that the topics of the
+	// notifications and their DLQ topics exist; nil disables the check.
+	TopicCheck *TopicCheckConfig `json:"topic_check,omitempty"`
+
+	// Faults injects send errors to test resilience, e.g. in staging; nil disables it.
+	Faults *MQFaultConfig `json:"faults,omitempty"`
+}
+
+// TopicCheckConfig checks at startup that the topics of Config.Topics exist on RocketMQ
+// or Kafka, and creates the missing ones or refuses to start. NATS streams are created on
+// demand and the in-memory broker needs no topics, so they are not checked.
+type TopicCheckConfig struct {
+	// Create creates missing topics; otherwise startup fails listing them.
+	Create bool `json:"create,omitempty"`
+	// Queues is the number of read and write queues per broker (RocketMQ) or of
+	// partitions (Kafka) of created topics (default 8).
+	Queues int `json:"queues,omitempty"`
+	// Cluster names the RocketMQ cluster whose master brokers get created topics
+	// (default every cluster of the name server).
+	Cluster string `json:"cluster,omitempty"`
+	// ReplicationFactor is the Kafka replication factor of created topics (default 1).
+	ReplicationFactor int `json:"replication_factor,omitempty"`
+}
+
+// MQFaultConfig injects failures into message sends of every broker.
+type MQFaultConfig struct {
+	// SendErrorRate is the fraction of sends, between 0 and 1, that fail without reaching
+	// the broker.
+	SendErrorRate float64 `json:"send_error_rate"`
+}
+
+// EncryptionConfig encrypts message bodies with AES-256-GCM, so event data can't be read
+// from the broker or its storage. Message properties, such as the event metadata, stay
+// readable.
+type EncryptionConfig struct {
+	// Provider supplies the keys: "aws-kms" encrypts every message with a data key
+	// generated by AWS KMS and carries the KMS-encrypted data key along; "static" uses
+	// the keys of Keys.
+	Provider string `json:"provider"`
+	// KeyID is the KMS key (ID, ARN or alias) for aws-kms, or the name of the key of Keys
+	// that new messages are encrypted with for static.
+	KeyID string `json:"key_id"`
+	// Keys are the base64-encoded 256-bit keys of the static provider by name, usually
+	// secret references. Keep retired keys until their messages have been consumed.
+	Keys map[string]string `json:"keys,omitempty"`
+	// DataKeyTTL is how long a key is reused before a new data key is generated or the
+	// static key is resolved again (default 5m).
+	DataKeyTTL Duration `json:"data_key_ttl,omitempty"`
+}
+
+// Values of EncryptionConfig.Provider.
+const (
+	EncryptionAWSKMS = "aws-kms"
+	EncryptionStatic = "static"
+)
+
+// validate checks the encryption settings and fills in defaults.
+func (e *EncryptionConfig) validate() error {
+	switch e.Provider {
+	case EncryptionAWSKMS, EncryptionStatic:
+	default:
+		return fmt.Errorf("provider '%s' is invalid", e.Provider)
+	}
+	if e.KeyID == "" {
+		return fmt.Errorf("key_id is required")
+	}
+	if _, ok := e.Keys[e.KeyID]; e.Provider == EncryptionStatic && !ok {
+		return fmt.Errorf("keys has no key '%s'", e.KeyID)
+	}
+	if e.DataKeyTTL < 0 {
+		return fmt.Errorf("data_key_ttl cannot be negative")
+	}
+	if e.DataKeyTTL == 0 {
+		e.DataKeyTTL = Duration(5 * time.Minute)
+	}
+	return nil
+}
+
+// RocketMQ5Config holds the connection settings of a RocketMQ 5.x proxy, which serves the
+// gRPC protocol of the 5.x clients. mq.namespace and mq.group_name apply as with RocketMQ.
+type RocketMQ5Config struct {
+	// Endpoint is the host:port of the proxy, e.g. "rmq-proxy:8081".
+	Endpoint string `json:"endpoint"`
+	// InvisibleDuration is how long a received message stays hidden from other consumers
+	// of the group; it is redelivered if not acknowledged by then (default 1m).
+	InvisibleDuration Duration `json:"invisible_duration,omitempty"`
+}
+
+// KafkaConfig holds the connection settings of a Kafka cluster.
+type KafkaConfig struct {
+	Brokers []string `json:"brokers"`
+}
+
+// NATSConfig holds the connection settings of a NATS server with JetStream enabled.
+type NATSConfig struct {
+	URL string `json:"url"`
+}
+
+// SQSConfig holds the settings of AWS SQS. Every topic is a queue named queue_prefix
+// followed by the topic (and .fifo for FIFO queues), consumed by one consumer group. With
+// sns_topic_arn_prefix, topics are SNS topics that fan out to a queue per consumer group
+// instead, named queue_prefix, the group name, "_" and the topic; the queues must be
+// subscribed with raw message delivery.
+type SQSConfig struct {
+	// Region of the queues and topics (default from the AWS environment).
+	Region string `json:"region,omitempty"`
+	// Endpoint overrides the AWS endpoints, e.g. for LocalStack.
+	Endpoint string `json:"endpoint,omitempty"`
+	// QueuePrefix is prepended to the queue names, e.g. "prod-".
+	QueuePrefix string `json:"queue_prefix,omitempty"`
+	// FIFO uses FIFO queues and topics: sharding keys become message group IDs, so
+	// messages with the same key are consumed in order.
+	FIFO bool `json:"fifo,omitempty"`
+	// SNSTopicARNPrefix, e.g. "arn:aws:sns:eu-west-1:123456789012:", enables SNS fan-out.
+	SNSTopicARNPrefix string `json:"sns_topic_arn_prefix,omitempty"`
+	// VisibilityTimeout is how long a received message stays hidden from other consumers;
+	// it is extended while the message is being handled (default 1m).
+	VisibilityTimeout Duration `json:"visibility_timeout,omitempty"`
+}
+
+// PubSubConfig holds the settings of Google Cloud Pub/Sub. Every topic is a Pub/Sub topic
+// consumed by consumer group g through the subscription named g, "_" and the topic.
+// PUBSUB_EMULATOR_HOST points the client at the emulator.
+type PubSubConfig struct {
+	ProjectID string `json:"project_id"`
+	// CredentialsFile is a service account key file (default: application default
+	// credentials).
+	CredentialsFile string `json:"credentials_file,omitempty"`
+	// Ordering publishes sharding keys as ordering keys, so messages with the same key are
+	// consumed in order by subscriptions with message ordering enabled.
+	Ordering bool `json:"ordering,omitempty"`
+	// MaxExtension is how long the ack deadline of a message is extended while it is being
+	// handled; it is redelivered after that (default 1h).
+	MaxExtension Duration `json:"max_extension,omitempty"`
+}
+
+// RabbitMQConfig holds the settings of RabbitMQ. Every topic is a fanout exchange, which
+// consumer group g consumes from the queue named g, "." and the topic; exchanges and queues
+// are declared by publishers and consumers. Failed messages wait for their retry in delay
+// queues, named after the queue and the delay level, that dead-letter them back.
+type RabbitMQConfig struct {
+	// URL of the server, e.g. "amqp://rabbitmq:5672/"; mq.tls requires an amqps URL.
+	URL string `json:"url"`
+	// QueueType of the declared queues: "quorum" (default) or "classic".
+	QueueType string `json:"queue_type,omitempty"`
+	// DeliveryLimit is how often quorum queues deliver a message that isn't acknowledged,
+	// e.g. because it crashes the worker, before dead-lettering it to DLQ_<topic>
+	// (default 10).
+	DeliveryLimit int `json:"delivery_limit,omitempty"`
+}
+
+// RedisMQConfig holds the settings of Redis Streams. Every topic is a stream, consumed by
+// a consumer group of the stream per consumer group.
+type RedisMQConfig struct {
+	Addr string `json:"addr"`
+	DB   int    `json:"db,omitempty"`
+	// KeyPrefix is prepended to the stream keys (default "notify:stream:").
+	KeyPrefix string `json:"key_prefix,omitempty"`
+	// MaxLen caps each stream at about this many entries, trimming the oldest (default
+	// 1000000).
+	MaxLen int64 `json:"max_len,omitempty"`
+	// ClaimIdle is how long an entry may go unacknowledged, e.g. because its consumer
+	// died, before another consumer claims it (default 1m).
+	ClaimIdle Duration `json:"claim_idle,omitempty"`
+}
+
+// Values of RabbitMQConfig.QueueType.
+const (
+	QueueTypeQuorum  = "quorum"
+	QueueTypeClassic = "classic"
+)
+
+// Values of MQConfig.Broker.
+const (
+	BrokerRocketMQ  = "rocketmq"
+	BrokerRocketMQ5 = "rocketmq5"
+	BrokerKafka     = "kafka"
+	BrokerNATS      = "nats"
+	BrokerSQS       = "sqs"
+	BrokerPubSub    = "pubsub"
+	BrokerRabbitMQ  = "rabbitmq"
+	BrokerRedis     = "redis"
+	BrokerMemory    = "memory"
+)
+
+// Values of MQConfig.Compression.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Values of MQConfig.Encoding and NotificationConfig.Encoding.
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// Values of MQConfig.ConsumeFrom.
+const (
+	ConsumeFromLast      = "last"
+	ConsumeFromFirst     = "first"
+	ConsumeFromTimestamp = "timestamp"
+)
+
+// SecretsConfig controls how secret references (vault://, aws-sm://) in the config are resolved.
+type SecretsConfig struct {
+	RefreshInterval Duration `json:"refresh_interval"`
+}
+
+// WorkerConfig holds settings for the worker process.
+type WorkerConfig struct {
+	// ShutdownTimeout bounds how long shutdown waits for in-flight deliveries (default 30s).
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// HealthAddr is the listen address of the health/readiness endpoints (default ":8081").
+	HealthAddr string `json:"health_addr"`
+	// Mode selects the RocketMQ consumer: "push" (default) or "pull". In pull mode messages are
+	// fetched only when a delivery slot is free, so a slow target can't flood the worker.
+	// Changing the mode requires a restart.
+	Mode string `json:"mode,omitempty"`
+	// MaxConcurrency bounds concurrent message batches in pull mode (default 20).
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// PriorityWeights splits delivery concurrency between priorities (default high 6, normal 3, low 1).
+	PriorityWeights map[string]int `json:"priority_weights,omitempty"`
+	// Adaptive enables per-target adaptive backoff; nil disables it.
+	Adaptive *AdaptiveConfig `json:"adaptive,omitempty"`
+	// Bulkhead bounds the concurrent deliveries per event type and target; nil disables it.
+	Bulkhead *BulkheadConfig `json:"bulkhead,omitempty"`
+	// LagMonitor watches the messages consumer groups have yet to consume; nil disables it.
+	LagMonitor *LagMonitorConfig `json:"lag_monitor,omitempty"`
+	// Quarantine moves messages whose event can't be decoded to QUARANTINE_<topic> and
+	// alerts when they pile up; nil skips such messages.
+	Quarantine *QuarantineConfig `json:"quarantine,omitempty"`
+	// DeliveryLog enables the detailed delivery log; nil disables it.
+	DeliveryLog *DeliveryLogConfig `json:"delivery_log,omitempty"`
+	// Park moves messages for unavailable targets to parking topics; nil disables it.
+	Park *ParkConfig `json:"park,omitempty"`
+	// Faults injects delivery failures, latency and dropped messages to test alerting and
+	// DLQ handling, e.g. in staging; nil disables it.
+	Faults *FaultConfig `json:"faults,omitempty"`
+	// Transport tunes the connection pools of the HTTP clients delivering notifications.
+	Transport TransportConfig `json:"transport,omitempty"`
+	// Assignment pins high-volume topics to dedicated worker replicas; nil has every
+	// replica consume every topic.
+	Assignment *AssignmentConfig `json:"assignment,omitempty"`
+	// Redrive moves dead-lettered messages back to their topics on a slow cadence; nil
+	// leaves them in the DLQ.
+	Redrive *RedriveConfig `json:"redrive,omitempty"`
+}
+
+// RedriveConfig has the worker move the messages of the DLQ_<topic> topics back to their
+// topics a few times, a while after they were dead-lettered, before leaving them for
+// manual intervention. Each worker redrives the DLQs of the topics it consumes, in the
+// consumer group "<group_name>_redrive"; messages wait for their redrive in REDRIVE_<topic>.
+// Enabling or disabling it requires a restart; policies apply to the next message.
+type RedriveConfig struct {
+	RedrivePolicy
+	// Priorities overrides the policy for the topics of a priority, e.g. to redrive high
+	// priority notifications sooner.
+	Priorities map[string]RedrivePolicy `json:"priorities,omitempty"`
+	// Topics overrides the policy for some topics, named without the DLQ_ prefix. It takes
+	// precedence over priorities.
+	Topics map[string]RedrivePolicy `json:"topics,omitempty"`
+}
+
+// RedrivePolicy tunes the redrives of a DLQ. Unset fields of overrides keep the value of
+// the policy they override.
+type RedrivePolicy struct {
+	// Interval is how long a message stays in the DLQ before it is redriven (default 30m).
+	Interval Duration `json:"interval,omitempty"`
+	// MaxRedrives bounds how often a message is redriven (default 3); a message
+	// dead-lettered again after that stays in the DLQ.
+	MaxRedrives int `json:"max_redrives,omitempty"`
+	// Reasons are the reasons of the messages redriven (default max_retries): failures
+	// such as client_error usually need a fix before a redrive can succeed.
+	Reasons []string `json:"reasons,omitempty"`
+	// Disabled leaves the messages of a priority or topic in the DLQ.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// Policy returns the redrive policy of the DLQ of topic, whose notifications have priority.
+func (r *RedriveConfig) Policy(topic, priority string) RedrivePolicy {
+	p := r.RedrivePolicy
+	if o, ok := r.Priorities[priority]; ok {
+		p = p.override(o)
+	}
+	if o, ok := r.Topics[topic]; ok {
+		p = p.override(o)
+	}
+	return p
+}
+
+func (p RedrivePolicy) override(o RedrivePolicy) RedrivePolicy {
+	if o.Interval > 0 {
+		p.Interval = o.Interval
+	}
+	if o.MaxRedrives > 0 {
+		p.MaxRedrives = o.MaxRedrives
+	}
+	if len(o.Reasons) > 0 {
+		p.Reasons = o.Reasons
+	}
+	p.Disabled = o.Disabled
+	return p
+}
+
+func (p RedrivePolicy) validate() error {
+	if p.Interval < 0 {
+		return fmt.Errorf("interval cannot be negative")
+	}
+	if p.MaxRedrives < 0 {
+		return fmt.Errorf("max_redrives cannot be negative")
+	}
+	if slices.Contains(p.Reasons, "") {
+		return fmt.Errorf("reasons: empty reason")
+	}
+	return nil
+}
+
+func (r *RedriveConfig) validate(broker string, topics map[string]bool) error {
+	if broker == BrokerMemory {
+		return fmt.Errorf("not supported with mq.broker %s", BrokerMemory)
+	}
+	if err := r.RedrivePolicy.validate(); err != nil {
+		return err
+	}
+	if r.Disabled {
+		return fmt.Errorf("disabled is only valid for priorities and topics")
+	}
+	for priority, p := range r.Priorities {
+		if !validPriority(priority) {
+			return fmt.Errorf("priorities: unknown priority '%s'", priority)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("priorities.%s.%v", priority, err)
+		}
+	}
+	for topic, p := range r.Topics {
+		if !topics[topic] {
+			return fmt.Errorf("topics.%s: no notification uses the topic", topic)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("topics.%s.%v", topic, err)
+		}
+	}
+	if r.Interval == 0 {
+		r.Interval = Duration(30 * time.Minute)
+	}
+	if r.MaxRedrives == 0 {
+		r.MaxRedrives = 3
+	}
+	if len(r.Reasons) == 0 {
+		r.Reasons = []string{"max_retries"}
+	}
+	return nil
+}
+
+// AssignmentConfig pins topics to some of the worker replicas. A replica is named by the
+// NOTIFY_WORKER_REPLICA environment variable, or else its hostname, e.g. the pod name of
+// a StatefulSet. Pinned topics are consumed in consumer groups of their own,
+// "<group_name>_<topic>", so the broker balances their queues only between the replicas
+// they are pinned to. Pins of topics a replica already consumes apply once it restarts.
+type AssignmentConfig struct {
+	// Pins maps topics to the replicas consuming them.
+	Pins map[string]TopicPin `json:"pins"`
+	// Dedicated has replicas with a pinned topic consume no other topics.
+	Dedicated bool `json:"dedicated,omitempty"`
+	// Redis holds the leases of pins by count and records the assignment of every replica
+	// for GET /admin/assignments.
+	Redis *ratelimit.RedisConfig `json:"redis,omitempty"`
+	// LeaseTTL is how long the leases and the recorded assignment of a replica outlive it
+	// (default 30s). Replicas renew them every third of it.
+	LeaseTTL Duration `json:"lease_ttl,omitempty"`
+}
+
+// TopicPin selects the replicas consuming a topic: the named ones, or any count of them.
+type TopicPin struct {
+	Replicas []string `json:"replicas,omitempty"`
+	// Count has the first replicas to take one of count leases in Redis consume the topic.
+	// A replica keeps its lease while it runs and gets it back when restarted under the same
+	// name before it expires; the leases of replicas gone for longer are taken over by
+	// others. With dedicated, replicas take leases only while they consume no other topic.
+	Count int `json:"count,omitempty"`
+}
+
+// Pinned reports whether topic is pinned to some replicas.
+func (a *AssignmentConfig) Pinned(topic string) bool {
+	if a == nil {
+		return false
+	}
+	_, ok := a.Pins[topic]
+	return ok
+}
+
+func (a *AssignmentConfig) validate(topics map[string]bool) error {
+	if len(a.Pins) == 0 {
+		return fmt.Errorf("pins is required")
+	}
+	for topic, p := range a.Pins {
+		if !topics[topic] {
+			return fmt.Errorf("pins.%s: no notification uses the topic", topic)
+		}
+		if (len(p.Replicas) > 0) == (p.Count > 0) {
+			return fmt.Errorf("pins.%s: either replicas or a positive count is required", topic)
+		}
+		if slices.Contains(p.Replicas, "") {
+			return fmt.Errorf("pins.%s.replicas: empty replica name", topic)
+		}
+		if p.Count > 0 && a.Redis == nil {
+			return fmt.Errorf("pins.%s.count requires redis", topic)
+		}
+	}
+	if a.Redis != nil && a.Redis.Addr == "" {
+		return fmt.Errorf("redis.addr is required")
+	}
+	if a.LeaseTTL < 0 {
+		return fmt.Errorf("lease_ttl cannot be negative")
+	}
+	if a.LeaseTTL == 0 {
+		a.LeaseTTL = Duration(30 * time.Second)
+	}
+	return nil
+}
+
+// TransportConfig tunes the connection pools used for deliveries. Every target host keeps
+// up to max_idle_conns_per_host connections open, so steady traffic to a few partner hosts
+// doesn't pay a TLS handshake per request. Changes apply once the worker is restarted.
+type TransportConfig struct {
+	// MaxIdleConns bounds the idle connections of the shared client (default 512).
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+	// MaxIdleConnsPerHost bounds the idle connections kept per host (default 64).
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+	// IdleConnTimeout closes connections idle for longer (default 90s).
+	IdleConnTimeout Duration `json:"idle_conn_timeout,omitempty"`
+	// TLSHandshakeTimeout bounds a TLS handshake (default 10s).
+	TLSHandshakeTimeout Duration `json:"tls_handshake_timeout,omitempty"`
+	// ExpectContinueTimeout is how long requests sent with "Expect: 100-continue" wait for
+	// the target to accept the body before sending it anyway (default 1s).
+	ExpectContinueTimeout Duration `json:"expect_continue_timeout,omitempty"`
+}
+
+// FaultConfig injects faults into deliveries. Rates are fractions between 0 and 1, drawn
+// independently for every message or attempt.
+type FaultConfig struct {
+	// FailureRate is the fraction of HTTP attempts that fail like a 503 response without
+	// being sent, so they go through the local retries, MQ redelivery and the DLQ.
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	// Latency is added before the attempts picked by LatencyRate.
+	Latency Duration `json:"latency,omitempty"`
+	// LatencyRate is the fraction of attempts delayed by Latency (default 1).
+	LatencyRate float64 `json:"latency_rate,omitempty"`
+	// DropRate is the fraction of messages acknowledged without being delivered.
+	DropRate float64 `json:"drop_rate,omitempty"`
+}
+
+// ParkConfig selects when messages are moved to the parking topic of their target,
+// PARK_<target>, instead of being retried. Parked messages stay there until drained back
+// to their topics with "notifyctl drain".
+type ParkConfig struct {
+	// Paused parks messages held back by a pause, instead of holding them in their topic.
+	Paused bool `json:"paused,omitempty"`
+	// Degraded parks messages for targets degraded by adaptive backoff.
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// DeliveryLogConfig tunes the detailed delivery log: one JSON line per HTTP attempt with
+// the request and response headers, truncated bodies and timings. Events are sampled by
+// ID, so all attempts and redeliveries of a sampled event are logged.
+type DeliveryLogConfig struct {
+	// Path is the file the log is appended to; empty writes to stdout.
+	Path string `json:"path,omitempty"`
+	// SampleRate is the fraction of events logged, between 0 and 1.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	// Failures logs every failed attempt, sampled or not.
+	Failures bool `json:"failures,omitempty"`
+	// MaxBody bounds each logged body in bytes (default 2048).
+	MaxBody int `json:"max_body,omitempty"`
+}
+
+// BulkheadConfig gives every event type and target (host or channel) its own bounded
+// share of the delivery concurrency, so a slow target can't occupy all consume goroutines
+// and delay unrelated notifications. A delivery that finds its bulkhead full is held back
+// like a paused one, without using up a retry.
+type BulkheadConfig struct {
+	// MaxConcurrency bounds the deliveries in progress per event type and target
+	// (default a quarter of worker.max_concurrency).
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// EventTypes overrides max_concurrency for some event types.
+	EventTypes map[string]int `json:"event_types,omitempty"`
+	// MaxWait is how long a delivery waits for a free slot before it is held back (default 1s).
+	MaxWait Duration `json:"max_wait,omitempty"`
+	// Delay is how long held back deliveries wait before they are consumed again (default 5s).
+	Delay Duration `json:"delay,omitempty"`
+}
+
+// Limit returns the bound of concurrent deliveries of eventType to one target.
+func (b *BulkheadConfig) Limit(eventType string) int {
+	if n, ok := b.EventTypes[eventType]; ok {
+		return n
+	}
+	return b.MaxConcurrency
+}
+
+// LagMonitorConfig has the worker periodically compare the messages of the subscribed
+// topics with the offsets its consumer groups committed, and raise an alert when the lag,
+// the number of messages not consumed yet, exceeds a threshold. Every worker monitors and
+// alerts, so it is usually enabled on a single one.
+type LagMonitorConfig struct {
+	// Interval is how often the lag is checked (default 1m).
+	Interval Duration `json:"interval,omitempty"`
+	// Threshold is the lag above which an alert is raised; 0 only records the lag.
+	Threshold int64 `json:"threshold,omitempty"`
+	// Topics overrides threshold for some topics.
+	Topics map[string]int64 `json:"topics,omitempty"`
+	// WebhookURL receives a LagAlert JSON object when the lag of a topic exceeds its
+	// threshold and when it is back under it.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// SlackURL is a Slack incoming webhook URL that receives the alerts as messages. It may
+	// be a secret reference (vault://, aws-sm://).
+	SlackURL string `json:"slack_url,omitempty"`
+}
+
+// TopicThreshold returns the lag of topic above which an alert is raised, or 0 for none.
+func (l *LagMonitorConfig) TopicThreshold(topic string) int64 {
+	if n, ok := l.Topics[topic]; ok {
+		return n
+	}
+	return l.Threshold
+}
+
+// QuarantineConfig has the worker move the messages whose event can't be decoded to the
+// QUARANTINE_<topic> topic instead of skipping them, diagnose why, and raise an alert when
+// a topic receives several within a window.
+type QuarantineConfig struct {
+	// Threshold is how many messages of a topic quarantined within Window raise an alert
+	// (default 5). Alerts are logged, and posted to the URLs below.
+	Threshold int `json:"threshold,omitempty"`
+	// Window is the period the quarantined messages are counted over (default 5m). A topic
+	// alerts at most once per window.
+	Window Duration `json:"window,omitempty"`
+	// Samples bounds the latest quarantined messages listed per topic (default 10).
+	Samples int `json:"samples,omitempty"`
+	// WebhookURL receives a PoisonAlert JSON object when a topic alerts.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// SlackURL is a Slack incoming webhook URL that receives the alerts as messages. It may
+	// be a secret reference (vault://, aws-sm://).
+	SlackURL string `json:"slack_url,omitempty"`
+}
+
+// AdaptiveConfig tunes per-target adaptive backoff. Failures (network errors, 5xx and 429)
+// are tracked per target host over a rolling window. A target whose failure rate exceeds
+// FailureThreshold is degraded: its local retry backoff is stretched and its concurrency
+// limit halved, down to MinConcurrency. Successful deliveries raise the limit gradually.
+type AdaptiveConfig struct {
+	// Window is the period failure rates are computed over (default 1m).
+	Window Duration `json:"window,omitempty"`
+	// FailureThreshold is the failure rate, between 0 and 1, above which a target is degraded (default 0.5).
+	FailureThreshold float64 `json:"failure_threshold,omitempty"`
+	// MinRequests is the number of requests in the window below which a target is never degraded (default 10).
+	MinRequests int `json:"min_requests,omitempty"`
+	// MaxConcurrency bounds concurrent requests per target (default worker.max_concurrency).
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// MinConcurrency is the lowest limit a degraded target is reduced to (default 1).
+	MinConcurrency int `json:"min_concurrency,omitempty"`
+	// RetryBudget caps local retries to this fraction of the target's requests in the
+	// window (default 0.2). Once spent, failed deliveries are left to the MQ redelivery.
+	RetryBudget float64 `json:"retry_budget,omitempty"`
+}
+
+// Notification priorities.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var defaultPriorityWeights = map[string]int{PriorityHigh: 6, PriorityNormal: 3, PriorityLow: 1}
+
+// Values of WorkerConfig.Mode.
+const (
+	WorkerModePush = "push"
+	WorkerModePull = "pull"
+)
+
+// APIConfig holds settings for the ingestion API.
+type APIConfig struct {
+	// SendMode is how ingested events are handed to RocketMQ: "sync" (default) waits for the
+	// broker, "async" returns once the event is buffered. HTTP clients may override it per
+	// request with ?send_mode=.
+	SendMode string `json:"send_mode,omitempty"`
+	// AsyncBuffer bounds the events awaiting broker confirmation in async mode (default 10000).
+	AsyncBuffer int `json:"async_buffer,omitempty"`
+	// MaxBodyBytes bounds request bodies, after decompression (default 4MB). Larger
+	// requests are answered with 413.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound how long the HTTP
+	// server waits for a client (defaults 10s, 30s, 60s and 120s), so slow clients can't
+	// hold connections open. Streams and replays are exempt from WriteTimeout. Changes
+	// take effect on restart.
+	ReadHeaderTimeout Duration `json:"read_header_timeout,omitempty"`
+	ReadTimeout       Duration `json:"read_timeout,omitempty"`
+	WriteTimeout      Duration `json:"write_timeout,omitempty"`
+	IdleTimeout       Duration `json:"idle_timeout,omitempty"`
+	// MaxHeaderBytes bounds the request line and headers (default 64KB).
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty"`
+	// CORS lets browser apps on other origins call the API; nil disables it.
+	CORS *CORSConfig `json:"cors,omitempty"`
+	// Addr is the HTTP listen address (default ":8080"); the -addr flag overrides it.
+	Addr string `json:"addr,omitempty"`
+	// TLS serves the API over HTTPS; nil serves plain HTTP.
+	TLS *ServerTLSConfig `json:"tls,omitempty"`
+	// HTTP2 enables HTTP/2 next to HTTP/1.1: negotiated over TLS, or h2c (HTTP/2 without
+	// TLS, for clients that know to use it) otherwise.
+	HTTP2 bool `json:"http2,omitempty"`
+	// ShutdownTimeout bounds how long shutdown waits for in-flight requests, and then for
+	// buffered async events to be confirmed (default 30s each).
+	ShutdownTimeout Duration `json:"shutdown_timeout,omitempty"`
+	// ReconnectAfter is how many sends in a row must fail before the producer is degraded
+	// (default 5): /readyz fails and the producer is re-created, which is retried every
+	// ReconnectInterval (default 10s) while the broker can't be connected to.
+	ReconnectAfter    int      `json:"reconnect_after,omitempty"`
+	ReconnectInterval Duration `json:"reconnect_interval,omitempty"`
+	// Spool keeps events the broker did not accept on local disk and sends them once it
+	// does; nil answers such events with an error. Changes take effect on restart.
+	Spool *SpoolConfig `json:"spool,omitempty"`
+	// LoadShedding answers ingestion requests with 503 and Retry-After while the API is
+	// overloaded, so clients back off instead of timing out; nil never sheds load.
+	LoadShedding *LoadSheddingConfig `json:"load_shedding,omitempty"`
+	// DLQBrowser keeps the messages of the DLQ topics for the /admin/dlq/messages
+	// endpoints; nil disables them. Changes take effect on restart.
+	DLQBrowser *DLQBrowserConfig `json:"dlq_browser,omitempty"`
+	// Admin authenticates the callers of the /admin/ endpoints; nil refuses every admin
+	// request.
+	Admin *AdminConfig `json:"admin,omitempty"`
+}
+
+// AdminConfig lists who may call the admin endpoints: the holders of a token, or of a TLS
+// client certificate with one of the subjects (which needs api.tls.client_ca_file).
+type AdminConfig struct {
+	// Tokens are the admin tokens by user name, sent as "Authorization: Bearer <token>"
+	// or in the X-Admin-Token header. The name identifies the user in the audit trail.
+	Tokens map[string]string `json:"tokens,omitempty"`
+	// ClientSubjects are the common names of the client certificates of admin users.
+	ClientSubjects []string `json:"client_subjects,omitempty"`
+}
+
+func (a *AdminConfig) validate(tls *ServerTLSConfig) error {
+	if len(a.Tokens) == 0 && len(a.ClientSubjects) == 0 {
+		return fmt.Errorf("tokens or client_subjects are required")
+	}
+	for user, token := range a.Tokens {
+		if user == "" || token == "" {
+			return fmt.Errorf("tokens: user names and tokens cannot be empty")
+		}
+	}
+	if len(a.ClientSubjects) > 0 && (tls == nil || tls.ClientCAFile == "") {
+		return fmt.Errorf("client_subjects requires api.tls.client_ca_file")
+	}
+	return nil
+}
+
+// AdminUser returns the user owning the admin token, or "" if no user does.
+func (a *AdminConfig) AdminUser(token string) string {
+	for user, t := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return user
+		}
+	}
+	return ""
+}
+
+// DLQBrowserConfig has the API consume the DLQ_<topic> topics and keep their messages, so
+// they can be listed, redriven and purged without access to the broker console.
+type DLQBrowserConfig struct {
+	// Capacity bounds the messages kept per DLQ topic (default 10000); the oldest are
+	// dropped when it is exceeded.
+	Capacity int `json:"capacity,omitempty"`
+	// Redis keeps the messages, shared by the API replicas and across restarts. It is
+	// required: each replica keeping its own copy would need a consumer group of its own.
+	Redis *ratelimit.RedisConfig `json:"redis"`
+}
+
+func (b *DLQBrowserConfig) validate() error {
+	if b.Capacity < 0 {
+		return fmt.Errorf("capacity cannot be negative")
+	}
+	if b.Capacity == 0 {
+		b.Capacity = 10000
+	}
+	if b.Redis == nil || b.Redis.Addr == "" {
+		return fmt.Errorf("redis.addr is required")
+	}
+	return nil
 }
 
+// LoadSheddingConfig sets when the API is overloaded. Zero thresholds are not checked;
+// send latency and failure rate are those of the sends of the last 10 seconds.
+type LoadSheddingConfig struct {
+	// MaxInFlight bounds the ingestion requests handled at once.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+	// MaxSendLatency bounds the mean latency of sends to the broker.
+	MaxSendLatency Duration `json:"max_send_latency,omitempty"`
+	// MaxFailureRate bounds the share of sends the broker did not accept, from 0 to 1.
+	MaxFailureRate float64 `json:"max_failure_rate,omitempty"`
+	// RetryAfter is sent with shed requests (default 5s).
+	RetryAfter Duration `json:"retry_after,omitempty"`
+}
+
+// SpoolConfig bounds the local spool of the API.
+type SpoolConfig struct {
+	// Dir holds the spool files; it must not be shared by several API processes.
+	Dir string `json:"dir"`
+	// MaxBytes bounds the spool (default 1GB); events that don't fit fail as without spool.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// MaxAge drops spooled events not sent within it (default 24h).
+	MaxAge Duration `json:"max_age,omitempty"`
+	// ReplayInterval is how often spooled events are sent again (default 5s).
+	ReplayInterval Duration `json:"replay_interval,omitempty"`
+}
+
+// ServerTLSConfig holds the certificate of a server. The certificate and key are reloaded
+// on SIGHUP, so renewed certificates are picked up without a restart.
+type ServerTLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile, if set, requires clients to present a certificate signed by one of
+	// these CAs (mTLS).
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// CORSConfig configures cross-origin requests to the API, e.g. from single-page apps that
+// publish events directly.
+type CORSConfig struct {
+	// AllowedOrigins are origins such as "https://app.example.com", "https://*.example.com"
+	// for any subdomain, or "*" for any origin.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods default to GET, POST and OPTIONS.
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	// AllowedHeaders default to Content-Type, Content-Encoding, X-API-Key, Authorization,
+	// X-Correlation-ID and traceparent.
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	// Paths are the paths open to other origins (default /events and /events/batch); a
+	// path ending in "/" covers the paths below it. Browsers calling the admin endpoints
+	// hold the credentials of api.admin, so open them with care.
+	Paths []string `json:"paths,omitempty"`
+	// MaxAge is how long browsers may cache a preflight response (default 10m).
+	MaxAge Duration `json:"max_age,omitempty"`
+}
+
+// Values of APIConfig.SendMode.
+const (
+	SendModeSync  = "sync"
+	SendModeAsync = "async"
+)
+
+// TenantConfig describes a team using the shared platform. Events ingested with one of
+// its API keys belong to the tenant and only match the tenant's notifications.
+type TenantConfig struct {
+	ID      string   `json:"id"`
+	APIKeys []string `json:"api_keys"`
+	// Quota limits the events accepted for the whole tenant, KeyQuota those of each of its API keys.
+	Quota    *ratelimit.Quota `json:"quota,omitempty"`
+	KeyQuota *ratelimit.Quota `json:"key_quota,omitempty"`
+}
+
+// SchemaRegistryConfig connects to a Confluent compatible schema registry holding the
+// schemas of event data, shared by the teams producing and consuming events.
+type SchemaRegistryConfig struct {
+	// URL is the base URL of the registry, e.g. "http://schema-registry:8081".
+	URL string `json:"url"`
+	// Username and Password authenticate with HTTP basic auth.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Timeout bounds each request to the registry (default 5s).
+	Timeout Duration `json:"timeout,omitempty"`
+	// CacheTTL is how long the latest schema of a subject is used before it is looked up
+	// again (default 1m). Schemas looked up by ID are cached for good, as IDs are immutable.
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+}
+
+// validate checks the registry settings and fills in defaults.
+func (r *SchemaRegistryConfig) validate() error {
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url '%s' must be an http(s) URL", r.URL)
+	}
+	if r.Timeout < 0 || r.CacheTTL < 0 {
+		return fmt.Errorf("timeout and cache_ttl cannot be negative")
+	}
+	if r.Timeout == 0 {
+		r.Timeout = Duration(5 * time.Second)
+	}
+	if r.CacheTTL == 0 {
+		r.CacheTTL = Duration(time.Minute)
+	}
+	return nil
+}
+
+// RegistrySchemaConfig selects the registry subject whose latest schema the data of a
+// notification's events must match.
+type RegistrySchemaConfig struct {
+	// Subject defaults to the type of each event.
+	Subject string `json:"subject,omitempty"`
+}
+
+// ArchiveConfig configures the event archive used for replay.
+type ArchiveConfig struct {
+	// URL is a directory (or file:///dir) or s3://bucket/prefix. Empty disables archiving.
+	URL string `json:"url,omitempty"`
+}
+
+// AuditConfig configures the export of delivery records to object storage for long-term
+// retention, and the audit trail of config changes. Each worker buffers the records of
+// its deliveries and writes them as one object per partition and flush. Changes take
+// effect after a restart.
+type AuditConfig struct {
+	// URL is a directory (or file:///dir), s3://bucket/prefix or gs://bucket/prefix.
+	// Empty disables the export.
+	URL string `json:"url,omitempty"`
+	// Format is "jsonl" (default, gzip-compressed) or "parquet".
+	Format string `json:"format,omitempty"`
+	// PartitionBy lists the partition keys of object paths, in order: "date", "hour",
+	// "event_type" and "tenant" (default date and event_type).
+	PartitionBy []string `json:"partition_by,omitempty"`
+	// FlushInterval is how often buffered records are written (default 5m).
+	FlushInterval Duration `json:"flush_interval,omitempty"`
+	// MaxRecords triggers an early flush once this many records are buffered (default 10000).
+	MaxRecords int `json:"max_records,omitempty"`
+
+	// ConfigLog is the file the API appends every change made through its admin
+	// endpoints to, as JSON lines, and serves GET /admin/audit from. Empty keeps no trail.
+	ConfigLog string `json:"config_log,omitempty"`
+	// ActorHeader names the request header identifying the user behind an admin change,
+	// as set by an authenticating proxy (default X-Forwarded-User). It is trusted only
+	// from TrustedProxies; otherwise the api.admin user identifies the user.
+	ActorHeader string `json:"actor_header,omitempty"`
+	// TrustedProxies are the addresses (IPs or CIDRs such as 10.0.0.0/8) of the proxies
+	// whose ActorHeader is trusted.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// TrustsProxy reports whether ip is the address of one of the TrustedProxies.
+func (a *AuditConfig) TrustsProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range a.TrustedProxies {
+		if prefix, err := netip.ParsePrefix(p); err == nil && prefix.Contains(addr) {
+			return true
+		}
+		if proxy, err := netip.ParseAddr(p); err == nil && proxy.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelConfig defines a notifier plugin: an executable delivering notifications over a
+// channel the worker has no built-in support for, such as SMS or a proprietary API. The
+// worker starts it on first use and exchanges JSON lines with it (see pkg/plugin).
+type ChannelConfig struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Env is added to the environment of the plugin process.
+	Env map[string]string `json:"env,omitempty"`
+	// Timeout bounds one delivery by the plugin (default 30s).
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// SampleEvent is an example event of a notification's event type and tenant.
+type SampleEvent struct {
+	// Name identifies the sample in reports (default its index).
+	Name string                 `json:"name,omitempty"`
+	Data map[string]interface{} `json:"data"`
+	// Types maps dot-separated paths into the rendered JSON body, such as "order.amount" or
+	// "items.0.sku", to the JSON type expected there.
+	Types map[string]string `json:"types,omitempty"`
+}
+
+// JSON types of SampleEvent.Types.
+var sampleTypes = map[string]bool{"string": true, "number": true, "boolean": true, "object": true, "array": true, "null": true}
+
+// DigestConfig buffers the events of a notification per rendered key and delivers them as
+// one notification, once Window has passed since the first of them or MaxEvents are
+// buffered. The body is rendered from the digest, whose data holds the key, the count and
+// the events.
+type DigestConfig struct {
+	// Key is a template such as "{$.event.user_id}"; empty puts all events in one digest.
+	Key string `json:"key,omitempty"`
+	// Window is how long events are collected, counted from the first one.
+	Window Duration `json:"window"`
+	// MaxEvents delivers a digest early once it holds this many events (default 100).
+	MaxEvents int `json:"max_events,omitempty"`
+}
+
+// PauseConfig holds back deliveries, e.g. during a partner's maintenance window. Messages
+// it matches stay queued, without using up their retries, until it is removed or expires.
+// At least one of EventType and Target is required.
+type PauseConfig struct {
+	// EventType is an event type or pattern, as in notifications.
+	EventType string `json:"event_type,omitempty"`
+	// Target is a target URL; deliveries to its scheme and host are paused.
+	Target string `json:"target,omitempty"`
+	// Tenant limits the pause to one tenant; empty pauses every tenant's deliveries.
+	Tenant string `json:"tenant,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Until ends the pause automatically.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// UpcasterConfig upgrades events of an older version to a newer one, so producers can
+// keep publishing old versions while notifications only handle current ones. Upcasters
+// chain: an event of version 1 goes through the 1 to 2 upcaster, then the 2 to 3 one.
+type UpcasterConfig struct {
+	// Tenant limits the upcaster to one tenant's events; empty upcasts every tenant's.
+	Tenant string `json:"tenant,omitempty"`
+	// EventType is an event type or pattern, as in notifications.
+	EventType string `json:"event_type"`
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	// Transform reshapes the event data. Steps registered with transform.Register hook in
+	// upgrades the built-in steps can't express.
+	Transform []transform.Spec `json:"transform,omitempty"`
+}
+
+// RateLimitConfig selects where the counters of ingestion quotas and notification rate
+// limits are kept.
+type RateLimitConfig struct {
+	// Redis, when set, shares the counters of all API instances and workers; otherwise each
+	// process counts on its own. Workers also share the IDs of successful deliveries
+	// through it, to skip redelivered messages another worker delivered.
+	Redis *ratelimit.RedisConfig `json:"redis,omitempty"`
+}
+
+// Audit export formats.
+const (
+	AuditFormatJSONL   = "jsonl"
+	AuditFormatParquet = "parquet"
+)
+
+// Audit partition keys.
+const (
+	PartitionDate      = "date"
+	PartitionHour      = "hour"
+	PartitionEventType = "event_type"
+	PartitionTenant    = "tenant"
+)
+
 // Config holds the list of all notification configurations.
 type Config struct {
-	MQ            MQConfig             `json:"mq"`
-	Notifications []NotificationConfig `json:"notifications"`
+	MQ             MQConfig              `json:"mq"`
+	API            APIConfig             `json:"api"`
+	Worker         WorkerConfig          `json:"worker"`
+	Secrets        SecretsConfig         `json:"secrets"`
+	Defaults       DefaultsConfig        `json:"defaults"`
+	Archive        ArchiveConfig         `json:"archive"`
+	SchemaRegistry *SchemaRegistryConfig `json:"schema_registry,omitempty"`
+	Audit          AuditConfig           `json:"audit"`
+	RateLimit      RateLimitConfig       `json:"rate_limit"`
+	Channels       []ChannelConfig       `json:"channels,omitempty"`
+	Pauses         []PauseConfig         `json:"pauses,omitempty"`
+	Upcasters      []UpcasterConfig      `json:"upcasters,omitempty"`
+	Tenants        []TenantConfig        `json:"tenants,omitempty"`
+	Notifications  []NotificationConfig  `json:"notifications"`
+
+	rawData bool               // some notification has raw_data, set by Validate
+	index   *notificationIndex // built by Validate, see Reindex
+}
+
+// RawData reports whether a notification reads its event data from the raw message, so
+// the worker has to leave the data of events undecoded until their notification is known.
+func (c *Config) RawData() bool {
+	return c.rawData
 }
 
 // LoadConfig reads the configuration from a JSON file.
@@ -47,8 +1357,13 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	return ParseConfig(bytes)
+}
+
+// ParseConfig decodes and validates a JSON configuration document.
+func ParseConfig(data []byte) (*Config, error) {
 	var config Config
-	if err := json.Unmarshal(bytes, &config); err != nil {
+	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
 
@@ -59,56 +1374,1582 @@ func LoadConfig(path string) (*Config, error) {
 	return &config, nil
 }
 
-// Validate checks if the configuration is valid.
+// Validate checks if the configuration is valid and fills in defaults. It reports every
+// problem found, joined into one error; each tenant and notification reports its first.
 func (c *Config) Validate() error {
-	if c.MQ.NameServer == "" {
-		return fmt.Errorf("mq.name_server is required")
-	}
-	if c.MQ.GroupName == "" {
-		return fmt.Errorf("mq.group_name is required")
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
 	}
 
-	if c.MQ.MaxRetries < 0 {
-		return fmt.Errorf("mq.max_retries cannot be negative")
-	}
-	if c.MQ.MaxRetries == 0 {
-		c.MQ.MaxRetries = 16 // Default RocketMQ behavior
-	}
+	switch c.MQ.Broker {
+	case "":
+		c.MQ.Broker = BrokerRocketMQ
+		fallthrough
+	case BrokerRocketMQ:
+		if c.MQ.NameServer == "" {
+			fail("mq.name_server is required")
+		}
+	case BrokerRocketMQ5:
+		if r := c.MQ.RocketMQ5; r == nil || r.Endpoint == "" {
+			fail("mq.rocketmq5.endpoint is required")
+		} else if r.InvisibleDuration < 0 {
+			fail("mq.rocketmq5.invisible_duration cannot be negative")
+		} else if r.InvisibleDuration == 0 {
+			r.InvisibleDuration = Duration(time.Minute)
+		}
+		if c.MQ.TLS != nil {
+			fail("mq.tls is not supported with mq.broker %s", BrokerRocketMQ5)
+		}
+	case BrokerKafka:
+		if c.MQ.Kafka == nil || len(c.MQ.Kafka.Brokers) == 0 {
+			fail("mq.kafka.brokers is required")
+		}
+	case BrokerNATS:
+		if c.MQ.NATS == nil || c.MQ.NATS.URL == "" {
+			fail("mq.nats.url is required")
+		}
+	case BrokerSQS:
+		if c.MQ.SQS == nil {
+			c.MQ.SQS = &SQSConfig{}
+		}
+		if err := c.MQ.SQS.validate(); err != nil {
+			fail("mq.sqs: %v", err)
+		}
+		if c.MQ.TLS != nil {
+			fail("mq.tls is not supported with mq.broker %s", BrokerSQS)
+		}
+	case BrokerPubSub:
+		if p := c.MQ.PubSub; p == nil || p.ProjectID == "" {
+			fail("mq.pubsub.project_id is required")
+		} else if p.MaxExtension < 0 {
+			fail("mq.pubsub.max_extension cannot be negative")
+		} else if p.MaxExtension == 0 {
+			p.MaxExtension = Duration(time.Hour)
+		}
+		if c.MQ.TLS != nil {
+			fail("mq.tls is not supported with mq.broker %s", BrokerPubSub)
+		}
+	case BrokerRabbitMQ:
+		if c.MQ.RabbitMQ == nil {
+			fail("mq.rabbitmq.url is required")
+		} else if err := c.MQ.RabbitMQ.validate(c.MQ.TLS != nil); err != nil {
+			fail("mq.rabbitmq: %v", err)
+		}
+	case BrokerRedis:
+		if c.MQ.Redis == nil || c.MQ.Redis.Addr == "" {
+			fail("mq.redis.addr is required")
+		} else if err := c.MQ.Redis.validate(); err != nil {
+			fail("mq.redis: %v", err)
+		}
+	case BrokerMemory:
+	default:
+		fail("mq.broker '%s' is invalid", c.MQ.Broker)
+	}
+	if c.MQ.GroupName == "" {
+		fail("mq.group_name is required")
+	}
+
+	if c.MQ.MaxRetries < 0 {
+		fail("mq.max_retries cannot be negative")
+	}
+	if c.MQ.MaxRetries == 0 {
+		c.MQ.MaxRetries = 16 // Default RocketMQ behavior
+	}
+	if c.MQ.TLS != nil && (c.MQ.TLS.CertFile == "") != (c.MQ.TLS.KeyFile == "") {
+		fail("mq.tls.cert_file and mq.tls.key_file must be set together")
+	}
+	if c.MQ.SendRetries < 0 {
+		fail("mq.send_retries cannot be negative")
+	}
+	if c.MQ.SendRetries == 0 {
+		c.MQ.SendRetries = 2
+	}
+	if c.MQ.ConsumeGoroutines < 0 || c.MQ.PullBatchSize < 0 || c.MQ.ConsumeBatchSize < 0 || c.MQ.MaxCachedMessages < 0 || c.MQ.ConsumeTimeout < 0 || c.MQ.ConsumeParallelism < 0 {
+		fail("mq consumer options cannot be negative")
+	}
+	if c.MQ.ConsumeParallelism == 0 {
+		c.MQ.ConsumeParallelism = 8
+	}
+	switch c.MQ.ConsumeFrom {
+	case "", ConsumeFromLast, ConsumeFromFirst:
+	case ConsumeFromTimestamp:
+		if _, err := time.Parse(time.RFC3339, c.MQ.ConsumeTimestamp); err != nil {
+			fail("mq.consume_timestamp '%s' is invalid: %v", c.MQ.ConsumeTimestamp, err)
+		}
+	default:
+		fail("mq.consume_from '%s' is invalid", c.MQ.ConsumeFrom)
+	}
+	switch c.MQ.Compression {
+	case "", CompressionGzip, CompressionZstd:
+	default:
+		fail("mq.compression '%s' is invalid", c.MQ.Compression)
+	}
+	if c.MQ.CompressThreshold < 0 {
+		fail("mq.compress_threshold cannot be negative")
+	}
+	if c.MQ.CompressThreshold == 0 {
+		c.MQ.CompressThreshold = 1024
+	}
+	switch c.MQ.Encoding {
+	case "":
+		c.MQ.Encoding = EncodingJSON
+	case EncodingJSON, EncodingProtobuf:
+	default:
+		fail("mq.encoding '%s' is invalid", c.MQ.Encoding)
+	}
+	if c.MQ.Encryption != nil {
+		if err := c.MQ.Encryption.validate(); err != nil {
+			fail("mq.encryption.%v", err)
+		}
+	}
+	if t := c.MQ.TopicCheck; t != nil {
+		if err := t.validate(); err != nil {
+			fail("mq.topic_check: %v", err)
+		}
+	}
+	if f := c.MQ.Faults; f != nil && (f.SendErrorRate < 0 || f.SendErrorRate > 1) {
+		fail("mq.faults.send_error_rate must be between 0 and 1")
+	}
+
+	switch c.API.SendMode {
+	case "":
+		c.API.SendMode = SendModeSync
+	case SendModeSync, SendModeAsync:
+	default:
+		fail("api.send_mode '%s' is invalid", c.API.SendMode)
+	}
+	if c.API.AsyncBuffer < 0 {
+		fail("api.async_buffer cannot be negative")
+	}
+	if c.API.AsyncBuffer == 0 {
+		c.API.AsyncBuffer = 10000
+	}
+	if c.API.MaxBodyBytes < 0 || c.API.MaxHeaderBytes < 0 {
+		fail("api.max_body_bytes and api.max_header_bytes cannot be negative")
+	}
+	if c.API.MaxBodyBytes == 0 {
+		c.API.MaxBodyBytes = 4 << 20
+	}
+	if c.API.MaxHeaderBytes == 0 {
+		c.API.MaxHeaderBytes = 64 << 10
+	}
+	if c.API.ReadHeaderTimeout < 0 || c.API.ReadTimeout < 0 || c.API.WriteTimeout < 0 || c.API.IdleTimeout < 0 {
+		fail("api timeouts cannot be negative")
+	}
+	if c.API.ReadHeaderTimeout == 0 {
+		c.API.ReadHeaderTimeout = Duration(10 * time.Second)
+	}
+	if c.API.ReadTimeout == 0 {
+		c.API.ReadTimeout = Duration(30 * time.Second)
+	}
+	if c.API.WriteTimeout == 0 {
+		c.API.WriteTimeout = Duration(60 * time.Second)
+	}
+	if c.API.IdleTimeout == 0 {
+		c.API.IdleTimeout = Duration(120 * time.Second)
+	}
+	if c.API.ShutdownTimeout < 0 {
+		fail("api.shutdown_timeout cannot be negative")
+	}
+	if c.API.ShutdownTimeout == 0 {
+		c.API.ShutdownTimeout = Duration(30 * time.Second)
+	}
+	if c.API.ReconnectAfter < 0 || c.API.ReconnectInterval < 0 {
+		fail("api.reconnect_after and api.reconnect_interval cannot be negative")
+	}
+	if c.API.ReconnectAfter == 0 {
+		c.API.ReconnectAfter = 5
+	}
+	if c.API.ReconnectInterval == 0 {
+		c.API.ReconnectInterval = Duration(10 * time.Second)
+	}
+	if c.API.LoadShedding != nil {
+		if err := c.API.LoadShedding.validate(); err != nil {
+			fail("api.load_shedding: %v", err)
+		}
+	}
+	if c.API.Spool != nil {
+		if err := c.API.Spool.validate(); err != nil {
+			fail("api.spool: %v", err)
+		}
+	}
+	if c.API.DLQBrowser != nil {
+		if err := c.API.DLQBrowser.validate(); err != nil {
+			fail("api.dlq_browser: %v", err)
+		}
+	}
+	if c.API.Addr == "" {
+		c.API.Addr = ":8080"
+	}
+	if t := c.API.TLS; t != nil && (t.CertFile == "" || t.KeyFile == "") {
+		fail("api.tls: cert_file and key_file are required")
+	}
+	if cors := c.API.CORS; cors != nil {
+		if err := cors.validate(); err != nil {
+			fail("api.cors: %v", err)
+		}
+	}
+	if c.API.Admin != nil {
+		if err := c.API.Admin.validate(c.API.TLS); err != nil {
+			fail("api.admin: %v", err)
+		}
+	}
+
+	if c.Worker.ShutdownTimeout < 0 {
+		fail("worker.shutdown_timeout cannot be negative")
+	}
+	if c.Worker.ShutdownTimeout == 0 {
+		c.Worker.ShutdownTimeout = Duration(30 * time.Second)
+	}
+
+	if c.Worker.HealthAddr == "" {
+		c.Worker.HealthAddr = ":8081"
+	}
+
+	switch c.Worker.Mode {
+	case "":
+		c.Worker.Mode = WorkerModePush
+	case WorkerModePush, WorkerModePull:
+	default:
+		fail("worker.mode '%s' is invalid", c.Worker.Mode)
+	}
+	if c.Worker.Mode == WorkerModePull && c.MQ.Broker != BrokerRocketMQ {
+		fail("worker.mode pull requires mq.broker %s", BrokerRocketMQ)
+	}
+	if c.Worker.MaxConcurrency < 0 {
+		fail("worker.max_concurrency cannot be negative")
+	}
+	if c.Worker.MaxConcurrency == 0 {
+		c.Worker.MaxConcurrency = 20
+	}
+	for p, weight := range c.Worker.PriorityWeights {
+		if !validPriority(p) {
+			fail("worker.priority_weights: unknown priority '%s'", p)
+		} else if weight <= 0 {
+			fail("worker.priority_weights.%s must be positive", p)
+		}
+	}
+	if a := c.Worker.Adaptive; a != nil {
+		if err := a.validate(c.Worker.MaxConcurrency); err != nil {
+			fail("worker.adaptive: %v", err)
+		}
+	}
+	if b := c.Worker.Bulkhead; b != nil {
+		if err := b.validate(c.Worker.MaxConcurrency); err != nil {
+			fail("worker.bulkhead: %v", err)
+		}
+	}
+
+	if l := c.Worker.LagMonitor; l != nil {
+		if err := l.validate(); err != nil {
+			fail("worker.lag_monitor: %v", err)
+		}
+	}
+
+	if l := c.Worker.DeliveryLog; l != nil {
+		if err := l.validate(); err != nil {
+			fail("worker.delivery_log: %v", err)
+		}
+	}
+
+	if q := c.Worker.Quarantine; q != nil {
+		if err := q.validate(); err != nil {
+			fail("worker.quarantine: %v", err)
+		}
+	}
+
+	if err := c.Worker.Transport.validate(); err != nil {
+		fail("worker.transport: %v", err)
+	}
+
+	if p := c.Worker.Park; p != nil {
+		if !p.Paused && !p.Degraded {
+			fail("worker.park: paused or degraded is required")
+		}
+		if p.Degraded && c.Worker.Adaptive == nil {
+			fail("worker.park.degraded requires worker.adaptive")
+		}
+	}
+
+	if f := c.Worker.Faults; f != nil {
+		if err := f.validate(); err != nil {
+			fail("worker.faults: %v", err)
+		}
+	}
+
+	topics := make(map[string]bool)
+	for _, n := range c.Notifications {
+		topics[n.QueueName] = true
+	}
+	if a := c.Worker.Assignment; a != nil {
+		if err := a.validate(topics); err != nil {
+			fail("worker.assignment: %v", err)
+		}
+	}
+	if r := c.Worker.Redrive; r != nil {
+		if err := r.validate(c.MQ.Broker, topics); err != nil {
+			fail("worker.redrive: %v", err)
+		}
+	}
+
+	if r := c.RateLimit.Redis; r != nil && r.Addr == "" {
+		fail("rate_limit.redis.addr is required")
+	}
+
+	if c.Audit.URL != "" {
+		if err := c.Audit.validate(); err != nil {
+			fail("audit: %v", err)
+		}
+	}
+	for _, p := range c.Audit.TrustedProxies {
+		if _, err := netip.ParsePrefix(p); err != nil {
+			if _, err := netip.ParseAddr(p); err != nil {
+				fail("audit.trusted_proxies: '%s' is not an IP or CIDR", p)
+			}
+		}
+	}
+	if c.Audit.ConfigLog != "" && c.Audit.ActorHeader == "" {
+		c.Audit.ActorHeader = "X-Forwarded-User"
+	}
+
+	if c.Secrets.RefreshInterval < 0 {
+		fail("secrets.refresh_interval cannot be negative")
+	}
+	if c.Secrets.RefreshInterval == 0 {
+		c.Secrets.RefreshInterval = Duration(5 * time.Minute)
+	}
+
+	if c.Defaults.Timeout < 0 {
+		fail("defaults.timeout cannot be negative")
+	}
+	if c.Defaults.Retries < 0 {
+		fail("defaults.retries cannot be negative")
+	}
+	if c.Defaults.DeliveryTimeout < 0 {
+		fail("defaults.delivery_timeout cannot be negative")
+	}
+	if c.Defaults.Backoff != nil {
+		if err := c.Defaults.Backoff.validate(); err != nil {
+			fail("defaults.backoff: %v", err)
+		}
+	}
+	if c.SchemaRegistry != nil {
+		if err := c.SchemaRegistry.validate(); err != nil {
+			fail("schema_registry.%v", err)
+		}
+	}
+
+	tenants := make(map[string]bool)
+	apiKeys := make(map[string]bool)
+	for i, t := range c.Tenants {
+		if err := validateTenant(i, t, tenants, apiKeys); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	channels := make(map[string]bool)
+	for i := range c.Channels {
+		if err := c.Channels[i].validate(i, channels); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	pauses := make(map[PauseConfig]bool)
+	for i := range c.Pauses {
+		if err := c.Pauses[i].validate(i, tenants, pauses); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	upcasters := make(map[upcasterKey]bool)
+	for i := range c.Upcasters {
+		if err := c.Upcasters[i].validate(i, tenants, upcasters); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(c.Notifications) == 0 {
+		fail("no notifications configured")
+	}
+
+	topicPriority := make(map[string]string)
+	c.rawData = false
+	for i, n := range c.Notifications {
+		if err := validateNotification(i, n, tenants, channels, topicPriority); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if n.Registry != nil && c.SchemaRegistry == nil {
+			fail("notifications[%d].registry requires schema_registry", i)
+		}
+		// Parse the placeholders of the body once instead of for every message
+		c.Notifications[i].compileTemplates()
+		c.rawData = c.rawData || n.RawData
+	}
+	c.Reindex()
+	return errors.Join(errs...)
+}
+
+// validateTenant checks tenants[i], registering its ID and API keys as taken.
+func validateTenant(i int, t TenantConfig, tenants, apiKeys map[string]bool) error {
+	if t.ID == "" {
+		return fmt.Errorf("tenants[%d].id is required", i)
+	}
+	if tenants[t.ID] {
+		return fmt.Errorf("tenants[%d].id '%s' is duplicated", i, t.ID)
+	}
+	tenants[t.ID] = true
+	if len(t.APIKeys) == 0 {
+		return fmt.Errorf("tenants[%d].api_keys is required", i)
+	}
+	for _, key := range t.APIKeys {
+		if key == "" {
+			return fmt.Errorf("tenants[%d].api_keys cannot contain empty keys", i)
+		}
+		if apiKeys[key] {
+			return fmt.Errorf("tenants[%d].api_keys: key is used by another tenant", i)
+		}
+		apiKeys[key] = true
+	}
+	if t.Quota != nil {
+		if err := t.Quota.Validate(); err != nil {
+			return fmt.Errorf("tenants[%d].quota: %v", i, err)
+		}
+	}
+	if t.KeyQuota != nil {
+		if err := t.KeyQuota.Validate(); err != nil {
+			return fmt.Errorf("tenants[%d].key_quota: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validate checks channels[i] and fills in defaults, registering its name as taken.
+func (c *ChannelConfig) validate(i int, names map[string]bool) error {
+	if c.Name == "" {
+		return fmt.Errorf("channels[%d].name is required", i)
+	}
+	if names[c.Name] {
+		return fmt.Errorf("channels[%d].name '%s' is duplicated", i, c.Name)
+	}
+	names[c.Name] = true
+	if c.Command == "" {
+		return fmt.Errorf("channels[%d].command is required", i)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("channels[%d].timeout cannot be negative", i)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = Duration(30 * time.Second)
+	}
+	return nil
+}
+
+// validate checks pauses[i], registering it as taken. Pauses are told apart by their
+// tenant, event type and target.
+func (p *PauseConfig) validate(i int, tenants map[string]bool, taken map[PauseConfig]bool) error {
+	if p.EventType == "" && p.Target == "" {
+		return fmt.Errorf("pauses[%d]: event_type or target is required", i)
+	}
+	if p.Tenant != "" && !tenants[p.Tenant] {
+		return fmt.Errorf("pauses[%d].tenant '%s' is not configured", i, p.Tenant)
+	}
+	if isPattern(p.EventType) {
+		if _, err := path.Match(p.EventType, ""); err != nil {
+			return fmt.Errorf("pauses[%d].event_type '%s' is an invalid pattern", i, p.EventType)
+		}
+	}
+	if p.Target != "" && targetOf(p.Target) == "" {
+		return fmt.Errorf("pauses[%d].target '%s' must be an absolute URL", i, p.Target)
+	}
+	key := p.key()
+	if taken[key] {
+		return fmt.Errorf("pauses[%d] is duplicated", i)
+	}
+	taken[key] = true
+	return nil
+}
+
+// upcasterKey identifies the version an upcaster upgrades.
+type upcasterKey struct {
+	tenant, eventType string
+	from              int
+}
+
+// validate checks upcasters[i], registering it as taken. Only one upcaster may upgrade
+// a version of a tenant's event type, so chains are unambiguous.
+func (u *UpcasterConfig) validate(i int, tenants map[string]bool, taken map[upcasterKey]bool) error {
+	if u.EventType == "" {
+		return fmt.Errorf("upcasters[%d].event_type is required", i)
+	}
+	if isPattern(u.EventType) {
+		if _, err := path.Match(u.EventType, ""); err != nil {
+			return fmt.Errorf("upcasters[%d].event_type '%s' is an invalid pattern", i, u.EventType)
+		}
+	}
+	if u.Tenant != "" && !tenants[u.Tenant] {
+		return fmt.Errorf("upcasters[%d].tenant '%s' is not configured", i, u.Tenant)
+	}
+	if u.From < 0 {
+		return fmt.Errorf("upcasters[%d].from cannot be negative", i)
+	}
+	// Versions only go up, so chains can't loop
+	if u.To <= u.From {
+		return fmt.Errorf("upcasters[%d].to must be greater than from", i)
+	}
+	if _, err := transform.Compile(u.Transform); err != nil {
+		return fmt.Errorf("upcasters[%d].transform%v", i, err)
+	}
+	key := upcasterKey{u.Tenant, u.EventType, u.From}
+	if taken[key] {
+		return fmt.Errorf("upcasters[%d]: version %d of '%s' is already upcast", i, u.From, u.EventType)
+	}
+	taken[key] = true
+	return nil
+}
+
+// key identifies the pause among the configured ones.
+func (p PauseConfig) key() PauseConfig {
+	return PauseConfig{EventType: p.EventType, Target: targetOf(p.Target), Tenant: p.Tenant}
+}
+
+// matches reports whether the pause holds back a delivery of the tenant's eventType to
+// rawURL at now. An empty rawURL matches only pauses without a target.
+func (p *PauseConfig) matches(tenant, eventType, rawURL string, now time.Time) bool {
+	if p.Until != nil && !now.Before(*p.Until) {
+		return false
+	}
+	if p.Tenant != "" && p.Tenant != tenant {
+		return false
+	}
+	if p.EventType != "" && p.EventType != eventType {
+		if matched, _ := path.Match(p.EventType, eventType); !isPattern(p.EventType) || !matched {
+			return false
+		}
+	}
+	return p.Target == "" || (rawURL != "" && targetOf(p.Target) == targetOf(rawURL))
+}
+
+// targetOf returns the scheme and host of rawURL, or "" if it isn't an absolute URL.
+func targetOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// validateNotification checks notifications[i] against the configured tenants and
+// channels, and the priorities of the queues checked so far.
+func validateNotification(i int, n NotificationConfig, tenants, channels map[string]bool, topicPriority map[string]string) error {
+	if n.Tenant != "" && !tenants[n.Tenant] {
+		return fmt.Errorf("notifications[%d].tenant '%s' is not configured", i, n.Tenant)
+	}
+	if n.EventType == "" {
+		return fmt.Errorf("notifications[%d].event_type is required", i)
+	}
+	if isPattern(n.EventType) {
+		if _, err := path.Match(n.EventType, ""); err != nil {
+			return fmt.Errorf("notifications[%d].event_type pattern '%s' is invalid: %v", i, n.EventType, err)
+		}
+	}
+	if n.QueueName == "" {
+		return fmt.Errorf("notifications[%d].queue_name is required", i)
+	}
+	if n.Encoding != "" && n.Encoding != EncodingJSON && n.Encoding != EncodingProtobuf {
+		return fmt.Errorf("notifications[%d].encoding '%s' is invalid", i, n.Encoding)
+	}
+	if n.Priority != "" && !validPriority(n.Priority) {
+		return fmt.Errorf("notifications[%d].priority '%s' is invalid", i, n.Priority)
+	}
+	if p, ok := topicPriority[n.QueueName]; ok && p != priorityOf(n) {
+		return fmt.Errorf("notifications[%d].priority conflicts with another notification on queue %s", i, n.QueueName)
+	}
+	topicPriority[n.QueueName] = priorityOf(n)
+	if n.Channel != "" && !channels[n.Channel] {
+		return fmt.Errorf("notifications[%d].channel '%s' is not configured", i, n.Channel)
+	}
+	// Plugins get the rendered URL and method, but don't need them
+	if n.Method == "" && n.Channel == "" {
+		return fmt.Errorf("notifications[%d].http_method is required", i)
+	}
+	validMethods := map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true}
+	if n.Method != "" && !validMethods[strings.ToUpper(n.Method)] {
+		return fmt.Errorf("notifications[%d].http_method '%s' is invalid", i, n.Method)
+	}
+	if n.URL == "" && n.Channel == "" && len(n.Targets) == 0 {
+		return fmt.Errorf("notifications[%d].http_url is required", i)
+	}
+	if n.URL != "" {
+		if err := render.CheckString(n.URL); err != nil {
+			return fmt.Errorf("notifications[%d].http_url: %v", i, err)
+		}
+		if _, err := url.ParseRequestURI(render.Sample(n.URL)); err != nil {
+			return fmt.Errorf("notifications[%d].http_url '%s' is invalid: %v", i, n.URL, err)
+		}
+	}
+	if len(n.Targets) > 0 {
+		if n.URL != "" || n.Channel != "" {
+			return fmt.Errorf("notifications[%d].targets can't be combined with http_url or channel", i)
+		}
+		names := make(map[string]bool)
+		weight := 0
+		for j, t := range n.Targets {
+			if t.Name == "" {
+				return fmt.Errorf("notifications[%d].targets[%d].name is required", i, j)
+			}
+			if names[t.Name] {
+				return fmt.Errorf("notifications[%d].targets[%d].name '%s' is duplicated", i, j, t.Name)
+			}
+			names[t.Name] = true
+			if err := render.CheckString(t.URL); err != nil {
+				return fmt.Errorf("notifications[%d].targets[%d].url: %v", i, j, err)
+			}
+			if _, err := url.ParseRequestURI(render.Sample(t.URL)); err != nil {
+				return fmt.Errorf("notifications[%d].targets[%d].url '%s' is invalid: %v", i, j, t.URL, err)
+			}
+			if t.Weight < 0 {
+				return fmt.Errorf("notifications[%d].targets[%d].weight cannot be negative", i, j)
+			}
+			weight += t.Weight
+		}
+		if weight == 0 {
+			return fmt.Errorf("notifications[%d].targets: at least one target needs a weight", i)
+		}
+	}
+	if n.MirrorURL != "" {
+		if n.Channel != "" {
+			return fmt.Errorf("notifications[%d].mirror_url is not supported with a channel", i)
+		}
+		if err := render.CheckString(n.MirrorURL); err != nil {
+			return fmt.Errorf("notifications[%d].mirror_url: %v", i, err)
+		}
+		if _, err := url.ParseRequestURI(render.Sample(n.MirrorURL)); err != nil {
+			return fmt.Errorf("notifications[%d].mirror_url '%s' is invalid: %v", i, n.MirrorURL, err)
+		}
+	}
+	for k, v := range n.Headers {
+		if err := render.CheckString(v); err != nil {
+			return fmt.Errorf("notifications[%d].headers.%s: %v", i, k, err)
+		}
+	}
+	if err := render.CheckString(n.ShardingKey); err != nil {
+		return fmt.Errorf("notifications[%d].sharding_key: %v", i, err)
+	}
+	fields := make(map[string]bool)
+	for j, e := range n.Enrich {
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("notifications[%d].enrich[%d]: %v", i, j, err)
+		}
+		if fields[e.Field] {
+			return fmt.Errorf("notifications[%d].enrich[%d]: field '%s' is looked up twice", i, j, e.Field)
+		}
+		fields[e.Field] = true
+	}
+	if _, err := transform.Compile(n.Transform); err != nil {
+		return fmt.Errorf("notifications[%d].transform%v", i, err)
+	}
+	if n.Redact != nil {
+		if len(n.Redact.Fields) == 0 {
+			return fmt.Errorf("notifications[%d].redact.fields is required", i)
+		}
+		if _, err := redact.New(n.Redact.Fields, n.Redact.Keep); err != nil {
+			return fmt.Errorf("notifications[%d].redact: %v", i, err)
+		}
+	}
+	if n.RawData && (len(n.Enrich) > 0 || len(n.Transform) > 0 || n.Redact != nil || n.Digest != nil) {
+		return fmt.Errorf("notifications[%d].raw_data can't be combined with enrich, transform, redact or digest", i)
+	}
+	if err := render.Check(n.Body); err != nil {
+		return fmt.Errorf("notifications[%d].body: %v", i, err)
+	}
+	if err := checkBody(n.BodyFormat, n.Body, n.BodyText); err != nil {
+		return fmt.Errorf("notifications[%d].%v", i, err)
+	}
+	versions := map[string]bool{n.Version: true}
+	percent := 0
+	for j, t := range n.Templates {
+		if t.Version == "" {
+			return fmt.Errorf("notifications[%d].templates[%d].version is required", i, j)
+		}
+		if versions[t.Version] {
+			return fmt.Errorf("notifications[%d].templates[%d].version '%s' is duplicated", i, j, t.Version)
+		}
+		versions[t.Version] = true
+		if t.Percent < 1 || t.Percent > 100 {
+			return fmt.Errorf("notifications[%d].templates[%d].percent must be between 1 and 100", i, j)
+		}
+		percent += t.Percent
+		if err := render.Check(t.Body); err != nil {
+			return fmt.Errorf("notifications[%d].templates[%d].body: %v", i, j, err)
+		}
+		if err := checkBody(n.BodyFormat, t.Body, t.BodyText); err != nil {
+			return fmt.Errorf("notifications[%d].templates[%d].%v", i, j, err)
+		}
+	}
+	if percent > 100 {
+		return fmt.Errorf("notifications[%d].templates: percentages add up to more than 100", i)
+	}
+	for j, v := range n.EventVersions {
+		if v < 0 {
+			return fmt.Errorf("notifications[%d].event_versions[%d] cannot be negative", i, j)
+		}
+		if slices.Contains(n.EventVersions[:j], v) {
+			return fmt.Errorf("notifications[%d].event_versions[%d]: version %d is duplicated", i, j, v)
+		}
+	}
+	if n.Retries < 0 {
+		return fmt.Errorf("notifications[%d].retries cannot be negative", i)
+	}
+	if n.DeliveryTimeout < 0 {
+		return fmt.Errorf("notifications[%d].delivery_timeout cannot be negative", i)
+	}
+	if n.TTL < 0 {
+		return fmt.Errorf("notifications[%d].ttl cannot be negative", i)
+	}
+	if d := n.Digest; d != nil {
+		if d.Window <= 0 {
+			return fmt.Errorf("notifications[%d].digest.window must be positive", i)
+		}
+		if d.MaxEvents < 0 {
+			return fmt.Errorf("notifications[%d].digest.max_events cannot be negative", i)
+		}
+		if d.MaxEvents == 0 {
+			d.MaxEvents = 100
+		}
+		if err := render.CheckString(d.Key); err != nil {
+			return fmt.Errorf("notifications[%d].digest.key: %v", i, err)
+		}
+	}
+	for j, sample := range n.Samples {
+		for path, typ := range sample.Types {
+			if !sampleTypes[typ] {
+				return fmt.Errorf("notifications[%d].samples[%d].types.%s: unknown type '%s'", i, j, path, typ)
+			}
+		}
+	}
+	if n.Backoff != nil {
+		if err := n.Backoff.validate(); err != nil {
+			return fmt.Errorf("notifications[%d].backoff: %v", i, err)
+		}
+	}
+	if n.RateLimit != nil {
+		if err := n.RateLimit.Validate(); err != nil {
+			return fmt.Errorf("notifications[%d].rate_limit: %v", i, err)
+		}
+	}
+	if n.CallbackURL != "" {
+		if err := ValidateCallbackURL(n.CallbackURL); err != nil {
+			return fmt.Errorf("notifications[%d].callback_url: %v", i, err)
+		}
+	}
+	if n.TLS != nil && (n.TLS.CertFile == "") != (n.TLS.KeyFile == "") {
+		return fmt.Errorf("notifications[%d].tls.cert_file and tls.key_file must be set together", i)
+	}
+	if n.HTTPClient != nil {
+		if err := n.HTTPClient.validate(); err != nil {
+			return fmt.Errorf("notifications[%d].http_client: %v", i, err)
+		}
+	}
+	if n.Success != nil {
+		if err := n.Success.validate(); err != nil {
+			return fmt.Errorf("notifications[%d].success: %v", i, err)
+		}
+	}
+	if n.Auth != nil {
+		if err := n.Auth.validate(); err != nil {
+			return fmt.Errorf("notifications[%d].auth: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (b *BulkheadConfig) validate(maxConcurrency int) error {
+	if b.MaxConcurrency < 0 || b.MaxWait < 0 || b.Delay < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	for eventType, n := range b.EventTypes {
+		if n <= 0 {
+			return fmt.Errorf("event_types.%s must be positive", eventType)
+		}
+	}
+	if b.MaxConcurrency == 0 {
+		b.MaxConcurrency = max(maxConcurrency/4, 1)
+	}
+	if b.MaxWait == 0 {
+		b.MaxWait = Duration(time.Second)
+	}
+	if b.Delay == 0 {
+		b.Delay = Duration(5 * time.Second)
+	}
+	return nil
+}
 
-	if len(c.Notifications) == 0 {
-		return fmt.Errorf("no notifications configured")
+func (l *LoadSheddingConfig) validate() error {
+	if l.MaxInFlight < 0 || l.MaxSendLatency < 0 || l.RetryAfter < 0 {
+		return fmt.Errorf("max_in_flight, max_send_latency and retry_after cannot be negative")
+	}
+	if l.MaxFailureRate < 0 || l.MaxFailureRate > 1 {
+		return fmt.Errorf("max_failure_rate must be between 0 and 1")
+	}
+	if l.MaxInFlight == 0 && l.MaxSendLatency == 0 && l.MaxFailureRate == 0 {
+		return fmt.Errorf("at least one of max_in_flight, max_send_latency and max_failure_rate is required")
+	}
+	if l.RetryAfter == 0 {
+		l.RetryAfter = Duration(5 * time.Second)
 	}
+	return nil
+}
 
-	for i, n := range c.Notifications {
-		if n.EventType == "" {
-			return fmt.Errorf("notifications[%d].event_type is required", i)
+func (s *SpoolConfig) validate() error {
+	if s.Dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+	if s.MaxBytes < 0 || s.MaxAge < 0 || s.ReplayInterval < 0 {
+		return fmt.Errorf("max_bytes, max_age and replay_interval cannot be negative")
+	}
+	if s.MaxBytes == 0 {
+		s.MaxBytes = 1 << 30
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = Duration(24 * time.Hour)
+	}
+	if s.ReplayInterval == 0 {
+		s.ReplayInterval = Duration(5 * time.Second)
+	}
+	return nil
+}
+
+func (r *RabbitMQConfig) validate(tls bool) error {
+	u, err := url.Parse(r.URL)
+	switch {
+	case r.URL == "":
+		return fmt.Errorf("url is required")
+	case err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "":
+		return fmt.Errorf("url must be an amqp(s) URL")
+	case tls && u.Scheme != "amqps":
+		return fmt.Errorf("url must be an amqps URL with mq.tls")
+	}
+	switch r.QueueType {
+	case "":
+		r.QueueType = QueueTypeQuorum
+	case QueueTypeQuorum, QueueTypeClassic:
+	default:
+		return fmt.Errorf("queue_type must be %s or %s", QueueTypeQuorum, QueueTypeClassic)
+	}
+	if r.DeliveryLimit < 0 {
+		return fmt.Errorf("delivery_limit cannot be negative")
+	}
+	if r.DeliveryLimit == 0 {
+		r.DeliveryLimit = 10
+	}
+	return nil
+}
+
+func (r *RedisMQConfig) validate() error {
+	if r.DB < 0 || r.MaxLen < 0 || r.ClaimIdle < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	if r.KeyPrefix == "" {
+		r.KeyPrefix = "notify:stream:"
+	}
+	if r.MaxLen == 0 {
+		r.MaxLen = 1000000
+	}
+	if r.ClaimIdle == 0 {
+		r.ClaimIdle = Duration(time.Minute)
+	}
+	return nil
+}
+
+func (s *SQSConfig) validate() error {
+	if s.VisibilityTimeout < 0 {
+		return fmt.Errorf("visibility_timeout cannot be negative")
+	}
+	if s.VisibilityTimeout > Duration(12*time.Hour) {
+		return fmt.Errorf("visibility_timeout cannot exceed 12h")
+	}
+	if s.Endpoint != "" {
+		if u, err := url.Parse(s.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint must be an http(s) URL")
+		}
+	}
+	if s.SNSTopicARNPrefix != "" && !strings.HasPrefix(s.SNSTopicARNPrefix, "arn:") {
+		return fmt.Errorf("sns_topic_arn_prefix must be an ARN prefix, e.g. arn:aws:sns:<region>:<account>:")
+	}
+	if s.VisibilityTimeout == 0 {
+		s.VisibilityTimeout = Duration(time.Minute)
+	}
+	return nil
+}
+
+func (t *TopicCheckConfig) validate() error {
+	if t.Queues < 0 || t.ReplicationFactor < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	if t.Queues == 0 {
+		t.Queues = 8
+	}
+	if t.ReplicationFactor == 0 {
+		t.ReplicationFactor = 1
+	}
+	return nil
+}
+
+func (l *LagMonitorConfig) validate() error {
+	if l.Interval < 0 || l.Threshold < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	for topic, n := range l.Topics {
+		if n < 0 {
+			return fmt.Errorf("topics.%s cannot be negative", topic)
 		}
-		if n.QueueName == "" {
-			return fmt.Errorf("notifications[%d].queue_name is required", i)
+	}
+	for name, v := range map[string]string{"webhook_url": l.WebhookURL, "slack_url": l.SlackURL} {
+		if v == "" || (name == "slack_url" && isSecretReference(v)) {
+			continue
 		}
-		if n.Method == "" {
-			return fmt.Errorf("notifications[%d].http_method is required", i)
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http(s) URL", name)
 		}
-		validMethods := map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true}
-		if !validMethods[strings.ToUpper(n.Method)] {
-			return fmt.Errorf("notifications[%d].http_method '%s' is invalid", i, n.Method)
+	}
+	if l.Interval == 0 {
+		l.Interval = Duration(time.Minute)
+	}
+	return nil
+}
+
+// isSecretReference reports whether v may be a secret reference, which pkg/secrets
+// resolves before the value is used: any scheme:// other than http(s), so backends
+// registered with secrets.NewResolver are accepted as well as vault:// and aws-sm://.
+func isSecretReference(v string) bool {
+	scheme, _, ok := strings.Cut(v, "://")
+	return ok && scheme != "" && scheme != "http" && scheme != "https" && !strings.ContainsAny(scheme, "/?#")
+}
+
+func (q *QuarantineConfig) validate() error {
+	if q.Threshold < 0 || q.Window < 0 || q.Samples < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	for name, v := range map[string]string{"webhook_url": q.WebhookURL, "slack_url": q.SlackURL} {
+		if v == "" || (name == "slack_url" && isSecretReference(v)) {
+			continue
 		}
-		if n.URL == "" {
-			return fmt.Errorf("notifications[%d].http_url is required", i)
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http(s) URL", name)
 		}
-		if _, err := url.ParseRequestURI(n.URL); err != nil {
-			return fmt.Errorf("notifications[%d].http_url '%s' is invalid: %v", i, n.URL, err)
+	}
+	if q.Threshold == 0 {
+		q.Threshold = 5
+	}
+	if q.Window == 0 {
+		q.Window = Duration(5 * time.Minute)
+	}
+	if q.Samples == 0 {
+		q.Samples = 10
+	}
+	return nil
+}
+
+func (a *AdaptiveConfig) validate(maxConcurrency int) error {
+	if a.Window < 0 || a.MinRequests < 0 || a.MaxConcurrency < 0 || a.MinConcurrency < 0 || a.RetryBudget < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	if a.FailureThreshold < 0 || a.FailureThreshold > 1 {
+		return fmt.Errorf("failure_threshold must be between 0 and 1")
+	}
+	if a.Window == 0 {
+		a.Window = Duration(time.Minute)
+	}
+	if a.FailureThreshold == 0 {
+		a.FailureThreshold = 0.5
+	}
+	if a.MinRequests == 0 {
+		a.MinRequests = 10
+	}
+	if a.MaxConcurrency == 0 {
+		a.MaxConcurrency = maxConcurrency
+	}
+	if a.MinConcurrency == 0 {
+		a.MinConcurrency = 1
+	}
+	if a.MinConcurrency > a.MaxConcurrency {
+		return fmt.Errorf("min_concurrency cannot exceed max_concurrency")
+	}
+	if a.RetryBudget == 0 {
+		a.RetryBudget = 0.2
+	}
+	return nil
+}
+
+func (a *AuditConfig) validate() error {
+	switch a.Format {
+	case "":
+		a.Format = AuditFormatJSONL
+	case AuditFormatJSONL, AuditFormatParquet:
+	default:
+		return fmt.Errorf("format '%s' is invalid", a.Format)
+	}
+	if len(a.PartitionBy) == 0 {
+		a.PartitionBy = []string{PartitionDate, PartitionEventType}
+	}
+	seen := make(map[string]bool)
+	for _, p := range a.PartitionBy {
+		switch p {
+		case PartitionDate, PartitionHour, PartitionEventType, PartitionTenant:
+		default:
+			return fmt.Errorf("partition_by '%s' is invalid", p)
+		}
+		if seen[p] {
+			return fmt.Errorf("partition_by '%s' is listed twice", p)
+		}
+		seen[p] = true
+	}
+	if a.FlushInterval < 0 || a.MaxRecords < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	if a.FlushInterval == 0 {
+		a.FlushInterval = Duration(5 * time.Minute)
+	}
+	if a.MaxRecords == 0 {
+		a.MaxRecords = 10000
+	}
+	return nil
+}
+
+// ValidateCallbackURL checks that a receipt callback URL is an absolute http(s) URL.
+func ValidateCallbackURL(s string) error {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return fmt.Errorf("'%s' is invalid: %v", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'%s' must be an absolute http or https URL", s)
+	}
+	return nil
+}
+
+// checkBody checks a body template against the body format. Errors name the field.
+func checkBody(format string, body map[string]interface{}, text string) error {
+	switch format {
+	case "", BodyFormatJSON, BodyFormatForm:
+	case BodyFormatXML:
+		if len(body) != 1 {
+			return fmt.Errorf("body must have exactly one top-level key (the XML root element)")
+		}
+	case BodyFormatText:
+		if err := render.CheckString(text); err != nil {
+			return fmt.Errorf("body_text: %v", err)
+		}
+	default:
+		return fmt.Errorf("body_format '%s' is invalid", format)
+	}
+	return nil
+}
+
+func (e *EnrichConfig) validate() error {
+	if e.Field == "" {
+		return fmt.Errorf("field is required")
+	}
+	if (e.URL == "") == (e.GRPC == nil) {
+		return fmt.Errorf("exactly one of url and grpc is required")
+	}
+	if e.URL != "" {
+		if err := render.CheckString(e.URL); err != nil {
+			return fmt.Errorf("url: %v", err)
+		}
+		if _, err := url.ParseRequestURI(render.Sample(e.URL)); err != nil {
+			return fmt.Errorf("url '%s' is invalid: %v", e.URL, err)
+		}
+		switch strings.ToUpper(e.Method) {
+		case "", "GET", "POST":
+		default:
+			return fmt.Errorf("method '%s' is invalid", e.Method)
+		}
+		if err := render.Check(e.Body); err != nil {
+			return fmt.Errorf("body: %v", err)
+		}
+	}
+	if g := e.GRPC; g != nil {
+		if g.Target == "" {
+			return fmt.Errorf("grpc.target is required")
+		}
+		if service, method, ok := strings.Cut(strings.TrimPrefix(g.Method, "/"), "/"); !ok || service == "" || method == "" {
+			return fmt.Errorf("grpc.method '%s' must be <package>.<service>/<method>", g.Method)
+		}
+		if err := render.Check(g.Request); err != nil {
+			return fmt.Errorf("grpc.request: %v", err)
+		}
+	}
+	for k, v := range e.Headers {
+		if err := render.CheckString(v); err != nil {
+			return fmt.Errorf("headers.%s: %v", k, err)
+		}
+	}
+	if e.Timeout < 0 || e.CacheTTL < 0 {
+		return fmt.Errorf("timeout and cache_ttl cannot be negative")
+	}
+	switch e.OnError {
+	case "", EnrichOnErrorRetry, EnrichOnErrorSkip, EnrichOnErrorDefault:
+	default:
+		return fmt.Errorf("on_error '%s' is invalid", e.OnError)
+	}
+	return nil
+}
+
+func (l *DeliveryLogConfig) validate() error {
+	if l.SampleRate < 0 || l.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be between 0 and 1")
+	}
+	if l.SampleRate == 0 && !l.Failures {
+		return fmt.Errorf("sample_rate or failures is required")
+	}
+	if l.MaxBody < 0 {
+		return fmt.Errorf("max_body cannot be negative")
+	}
+	if l.MaxBody == 0 {
+		l.MaxBody = 2048
+	}
+	return nil
+}
+
+func (t *TransportConfig) validate() error {
+	if t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.IdleConnTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.ExpectContinueTimeout < 0 {
+		return fmt.Errorf("options cannot be negative")
+	}
+	if t.MaxIdleConns == 0 {
+		t.MaxIdleConns = 512
+	}
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = 64
+	}
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = Duration(90 * time.Second)
+	}
+	if t.TLSHandshakeTimeout == 0 {
+		t.TLSHandshakeTimeout = Duration(10 * time.Second)
+	}
+	if t.ExpectContinueTimeout == 0 {
+		t.ExpectContinueTimeout = Duration(time.Second)
+	}
+	return nil
+}
+
+func (c *CORSConfig) validate() error {
+	if len(c.AllowedOrigins) == 0 {
+		return fmt.Errorf("allowed_origins is required")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") ||
+			strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fmt.Errorf("allowed_origins: '%s' is not an origin like https://app.example.com", origin)
+		}
+	}
+	for i, m := range c.AllowedMethods {
+		c.AllowedMethods[i] = strings.ToUpper(m)
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = []string{"GET", "POST", "OPTIONS"}
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = []string{"Content-Type", "Content-Encoding", "X-API-Key", "Authorization", "X-Correlation-ID", "traceparent"}
+	}
+	if len(c.Paths) == 0 {
+		c.Paths = []string{"/events", "/events/batch"}
+	}
+	for _, p := range c.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("paths: '%s' must start with /", p)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = Duration(10 * time.Minute)
+	}
+	return nil
+}
+
+func (f *FaultConfig) validate() error {
+	rates := []struct {
+		name string
+		rate float64
+	}{{"failure_rate", f.FailureRate}, {"latency_rate", f.LatencyRate}, {"drop_rate", f.DropRate}}
+	for _, r := range rates {
+		if r.rate < 0 || r.rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", r.name)
+		}
+	}
+	if f.Latency < 0 {
+		return fmt.Errorf("latency cannot be negative")
+	}
+	if f.Latency > 0 && f.LatencyRate == 0 {
+		f.LatencyRate = 1
+	}
+	return nil
+}
+
+func (b *BackoffConfig) validate() error {
+	if b.Base < 0 || b.Max < 0 {
+		return fmt.Errorf("base and max cannot be negative")
+	}
+	if b.Base > 0 && b.Max > 0 && b.Max < b.Base {
+		return fmt.Errorf("max cannot be less than base")
+	}
+	return nil
+}
+
+func (h *HTTPClientConfig) validate() error {
+	if h.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if h.MaxIdleConns < 0 {
+		return fmt.Errorf("max_idle_conns cannot be negative")
+	}
+	if h.ProxyURL != "" {
+		if _, err := url.ParseRequestURI(h.ProxyURL); err != nil {
+			return fmt.Errorf("proxy_url '%s' is invalid: %v", h.ProxyURL, err)
 		}
 	}
 	return nil
 }
 
-// FindNotificationConfig returns the notification configuration for a given event type.
-func (c *Config) FindNotificationConfig(eventType string) *NotificationConfig {
+func (sc *SuccessConfig) validate() error {
+	for _, code := range sc.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("status code %d is invalid", code)
+		}
+	}
+	if (sc.JSONField == "") != (sc.JSONValue == nil) {
+		return fmt.Errorf("json_field and json_value must be set together")
+	}
+	if sc.BodyRegex != "" {
+		if _, err := regexp.Compile(sc.BodyRegex); err != nil {
+			return fmt.Errorf("body_regex is invalid: %v", err)
+		}
+	}
+	return nil
+}
+
+func (a *AuthConfig) validate() error {
+	if a.Type != AuthTypeOAuth2ClientCredentials {
+		return fmt.Errorf("type '%s' is invalid", a.Type)
+	}
+	if a.TokenURL == "" {
+		return fmt.Errorf("token_url is required")
+	}
+	if _, err := url.ParseRequestURI(a.TokenURL); err != nil {
+		return fmt.Errorf("token_url '%s' is invalid: %v", a.TokenURL, err)
+	}
+	if a.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	return nil
+}
+
+// Topics returns the topics the system publishes to, sorted: the queues of the
+// notifications, their DLQ_ topics, QUARANTINE_ topics with worker.quarantine, REDRIVE_
+// topics with worker.redrive, and mq.status_topic. Parking topics are derived from target
+// URLs at runtime and are not included.
+func (c *Config) Topics() []string {
+	var topics []string
+	for _, n := range c.Notifications {
+		topics = append(topics, n.QueueName, "DLQ_"+n.QueueName)
+		if c.Worker.Quarantine != nil {
+			topics = append(topics, "QUARANTINE_"+n.QueueName)
+		}
+		if c.Worker.Redrive != nil {
+			topics = append(topics, "REDRIVE_"+n.QueueName)
+		}
+	}
+	if c.MQ.StatusTopic != "" {
+		topics = append(topics, c.MQ.StatusTopic)
+	}
+	slices.Sort(topics)
+	return slices.Compact(topics)
+}
+
+// ConsumerGroup returns the consumer group that consumes topic. Pull mode uses one pull
+// consumer per topic, each in its own group "<group_name>_<topic>", as do topics pinned
+// by worker.assignment. In push mode high and low priority topics get their own consumer
+// in "<group_name>_high" / "<group_name>_low".
+func (c *Config) ConsumerGroup(topic string) string {
+	if c.Worker.Mode == WorkerModePull || c.Worker.Assignment.Pinned(topic) {
+		return c.MQ.GroupName + "_" + topic
+	}
+	if p := c.TopicPriority(topic); p != PriorityNormal {
+		return c.MQ.GroupName + "_" + p
+	}
+	return c.MQ.GroupName
+}
+
+// TopicPriority returns the priority of the notifications consuming topic.
+func (c *Config) TopicPriority(topic string) string {
+	if idx := c.lookupIndex(); idx != nil {
+		if ns := idx.topics[topic]; len(ns) > 0 {
+			return priorityOf(*ns[0])
+		}
+		return PriorityNormal
+	}
+	for _, n := range c.Notifications {
+		if n.QueueName == topic {
+			return priorityOf(n)
+		}
+	}
+	return PriorityNormal
+}
+
+// PriorityShare returns the part of total concurrency allotted to priority, weighted
+// against the other priorities in use. Every priority in use gets at least 1.
+func (c *Config) PriorityShare(total int, priority string) int {
+	weights := c.Worker.PriorityWeights
+	weight := func(p string) int {
+		if w, ok := weights[p]; ok {
+			return w
+		}
+		return defaultPriorityWeights[p]
+	}
+
+	used := map[string]bool{priority: true}
 	for _, n := range c.Notifications {
+		used[priorityOf(n)] = true
+	}
+	sum := 0
+	for p := range used {
+		sum += weight(p)
+	}
+	return max(1, total*weight(priority)/sum)
+}
+
+func priorityOf(n NotificationConfig) string {
+	if n.Priority == "" {
+		return PriorityNormal
+	}
+	return n.Priority
+}
+
+func validPriority(p string) bool {
+	_, ok := defaultPriorityWeights[p]
+	return ok
+}
+
+// TenantForAPIKey returns the ID of the tenant owning key, or "" if no tenant does.
+func (c *Config) TenantForAPIKey(key string) string {
+	for _, t := range c.Tenants {
+		for _, k := range t.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				return t.ID
+			}
+		}
+	}
+	return ""
+}
+
+// Tenant returns the tenant with the given ID, or nil.
+func (c *Config) Tenant(id string) *TenantConfig {
+	for i := range c.Tenants {
+		if c.Tenants[i].ID == id {
+			return &c.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// FindNotificationConfig returns the tenant's notification configuration for a given event
+// type and version, with the defaults section applied. Validated configurations look it up
+// in their index and return the same pointer for the same notification, which callers
+// must not modify. Notifications whose event_versions don't include the version are
+// ignored. An exact event_type match wins, preferring one that lists the version;
+// otherwise the most specific matching pattern is used, where specificity is the number
+// of literal (non-wildcard) characters in the pattern; a bracket expression counts as a
+// wildcard.
+func (c *Config) FindNotificationConfig(tenant, eventType string, version int) *NotificationConfig {
+	if idx := c.lookupIndex(); idx != nil {
+		return idx.find(tenant, eventType, version)
+	}
+	var best, exact *NotificationConfig
+	bestScore := -1
+	for i := range c.Notifications {
+		n := &c.Notifications[i]
+		if n.Tenant != tenant || !n.AcceptsVersion(version) {
+			continue
+		}
 		if n.EventType == eventType {
-			return &n
+			if len(n.EventVersions) > 0 {
+				return c.Defaults.apply(*n)
+			}
+			if exact == nil {
+				exact = n
+			}
+			continue
+		}
+		if !isPattern(n.EventType) {
+			continue
+		}
+		if matched, _ := path.Match(n.EventType, eventType); !matched {
+			continue
+		}
+		if score := patternSpecificity(n.EventType); score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if exact != nil {
+		return c.Defaults.apply(*exact)
+	}
+	if best == nil {
+		return nil
+	}
+	return c.Defaults.apply(*best)
+}
+
+// EventEncoding returns the encoding of the notification's events in messages.
+func (c *Config) EventEncoding(n *NotificationConfig) string {
+	if n.Encoding != "" {
+		return n.Encoding
+	}
+	return c.MQ.Encoding
+}
+
+// AcceptsVersion reports whether the notification handles events of the given version.
+func (n *NotificationConfig) AcceptsVersion(version int) bool {
+	return len(n.EventVersions) == 0 || slices.Contains(n.EventVersions, version)
+}
+
+// UpcastChain returns the upcasters that upgrade the tenant's events of the given type and
+// version to the latest version, in the order they apply. An upcaster for the exact event
+// type wins over patterns, and a tenant's own over those of every tenant.
+func (c *Config) UpcastChain(tenant, eventType string, version int) []*UpcasterConfig {
+	var chain []*UpcasterConfig
+	for {
+		var next *UpcasterConfig
+		bestScore := -1
+		for i := range c.Upcasters {
+			u := &c.Upcasters[i]
+			if u.From != version || (u.Tenant != "" && u.Tenant != tenant) {
+				continue
+			}
+			score := -1
+			if u.EventType == eventType {
+				score = len(eventType) + 1
+			} else if matched, _ := path.Match(u.EventType, eventType); isPattern(u.EventType) && matched {
+				score = patternSpecificity(u.EventType)
+			}
+			if score < 0 {
+				continue
+			}
+			// Rank exact types above patterns, then a tenant's own upcasters first
+			score *= 2
+			if u.Tenant != "" {
+				score++
+			}
+			if score > bestScore {
+				next, bestScore = u, score
+			}
+		}
+		if next == nil {
+			return chain
+		}
+		chain = append(chain, next)
+		version = next.To
+	}
+}
+
+// UpcastVersion returns the version the tenant's events of the given type and version have
+// after upcasting.
+func (c *Config) UpcastVersion(tenant, eventType string, version int) int {
+	if chain := c.UpcastChain(tenant, eventType, version); len(chain) > 0 {
+		return chain[len(chain)-1].To
+	}
+	return version
+}
+
+// Paused returns the active pause holding back a delivery of the tenant's eventType to
+// rawURL, or nil. Pass an empty rawURL before the target URL is rendered.
+func (c *Config) Paused(tenant, eventType, rawURL string, now time.Time) *PauseConfig {
+	for i := range c.Pauses {
+		if c.Pauses[i].matches(tenant, eventType, rawURL, now) {
+			return &c.Pauses[i]
 		}
 	}
 	return nil
 }
+
+// apply returns a copy of n with unset fields filled from the defaults.
+// The stored notifications are left untouched so persisted configs keep inheriting.
+func (d DefaultsConfig) apply(n NotificationConfig) *NotificationConfig {
+	if len(d.Headers) > 0 {
+		headers := make(map[string]string, len(d.Headers)+len(n.Headers))
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		for k, v := range n.Headers {
+			headers[k] = v
+		}
+		n.Headers = headers
+	}
+	if d.Timeout > 0 {
+		if n.HTTPClient == nil {
+			n.HTTPClient = &HTTPClientConfig{Timeout: d.Timeout}
+		} else if n.HTTPClient.Timeout == 0 {
+			hc := *n.HTTPClient
+			hc.Timeout = d.Timeout
+			n.HTTPClient = &hc
+		}
+	}
+	if n.Retries == 0 {
+		n.Retries = d.Retries
+	}
+	if n.DeliveryTimeout == 0 {
+		n.DeliveryTimeout = d.DeliveryTimeout
+	}
+	if d.Backoff != nil {
+		b := *d.Backoff
+		if n.Backoff != nil {
+			if n.Backoff.Base > 0 {
+				b.Base = n.Backoff.Base
+			}
+			if n.Backoff.Max > 0 {
+				b.Max = n.Backoff.Max
+			}
+		}
+		n.Backoff = &b
+	}
+	if n.SigningSecret == "" {
+		n.SigningSecret = d.SigningSecret
+	}
+	return &n
+}
+
+// isPattern reports whether an event_type contains wildcard syntax ("*", "?" or "[...]").
+func isPattern(eventType string) bool {
+	return strings.ContainsAny(eventType, "*?[")
+}
+
+// patternSpecificity counts the literal characters of a pattern. Wildcards and bracket
+// expressions such as "[a-z]" count as none, escaped characters as one.
+func patternSpecificity(pattern string) int {
+	literals := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			for i++; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+		case '\\':
+			i++
+			literals++
+		default:
+			literals++
+		}
+	}
+	return literals
+}
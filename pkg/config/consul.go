@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulProvider stores the configuration document under a single Consul KV key.
+// It talks to the Consul HTTP API directly and uses blocking queries for Watch.
+type ConsulProvider struct {
+	address string
+	key     string
+	token   string
+	client  *http.Client
+}
+
+// NewConsulProvider creates a provider for the Consul agent at address (host:port).
+func NewConsulProvider(address, key, token string) *ConsulProvider {
+	return &ConsulProvider{
+		address: address,
+		key:     key,
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Load reads the configuration key. Its revision is the key's ModifyIndex.
+func (p *ConsulProvider) Load(ctx context.Context) ([]byte, int64, error) {
+	data, index, err := p.get(ctx, 0)
+	return data, int64(index), err
+}
+
+// Save writes the configuration key with a check-and-set on its ModifyIndex rev.
+func (p *ConsulProvider) Save(ctx context.Context, data []byte, rev int64) (int64, error) {
+	req, err := p.newRequest(ctx, http.MethodPut, "?cas="+strconv.FormatInt(rev, 10), bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("consul put failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if strings.TrimSpace(string(body)) != "true" {
+		return 0, ErrConflict
+	}
+
+	// The PUT does not return the new index. If the key moved on again, the next Save
+	// conflicts and reloads.
+	saved, index, err := p.get(ctx, 0)
+	if err != nil || !bytes.Equal(saved, data) {
+		return 0, nil
+	}
+	return int64(index), nil
+}
+
+// Watch issues blocking queries and calls onChange when the key's index moves.
+func (p *ConsulProvider) Watch(ctx context.Context, onChange func()) error {
+	_, index, err := p.get(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	for {
+		_, next, err := p.get(ctx, index)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// Back off on errors instead of spinning against an unavailable agent
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		if next != index {
+			index = next
+			onChange()
+		}
+	}
+}
+
+// get reads the key, blocking until its index exceeds waitIndex when waitIndex > 0.
+func (p *ConsulProvider) get(ctx context.Context, waitIndex uint64) ([]byte, uint64, error) {
+	query := "?raw"
+	if waitIndex > 0 {
+		query += "&wait=5m&index=" + strconv.FormatUint(waitIndex, 10)
+	}
+	req, err := p.newRequest(ctx, http.MethodGet, query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, fmt.Errorf("consul key '%s' not found", p.key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul get failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return body, index, nil
+}
+
+func (p *ConsulProvider) newRequest(ctx context.Context, method, query string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+p.address+"/v1/kv/"+p.key+query, body)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Consul-Token", p.token)
+	}
+	return req, nil
+}
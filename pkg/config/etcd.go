@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdProvider stores the configuration document under a single etcd v3 key.
+type EtcdProvider struct {
+	client *clientv3.Client
+	key    string
+}
+
+// NewEtcdProvider connects to the given etcd endpoints.
+func NewEtcdProvider(endpoints []string, key, username, password string) (*EtcdProvider, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+		Username:    username,
+		Password:    password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to etcd: %w", err)
+	}
+	return &EtcdProvider{client: client, key: key}, nil
+}
+
+// Load reads the configuration key. Its revision is the key's ModRevision.
+func (p *EtcdProvider) Load(ctx context.Context) ([]byte, int64, error) {
+	resp, err := p.client.Get(ctx, p.key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, fmt.Errorf("etcd key '%s' not found", p.key)
+	}
+	return resp.Kvs[0].Value, resp.Kvs[0].ModRevision, nil
+}
+
+// Save writes the configuration key in a transaction that requires its ModRevision to
+// still be rev.
+func (p *EtcdProvider) Save(ctx context.Context, data []byte, rev int64) (int64, error) {
+	resp, err := p.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(p.key), "=", rev)).
+		Then(clientv3.OpPut(p.key, string(data))).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, ErrConflict
+	}
+	return resp.Header.Revision, nil
+}
+
+// Watch uses an etcd watch on the configuration key.
+func (p *EtcdProvider) Watch(ctx context.Context, onChange func()) error {
+	for resp := range p.client.Watch(ctx, p.key) {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if len(resp.Events) > 0 {
+			onChange()
+		}
+	}
+	return ctx.Err()
+}
+
+// Close releases the etcd connection.
+func (p *EtcdProvider) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"path"
+	"slices"
+)
+
+// notificationIndex finds the notification of an event without scanning every
+// notification. Its entries are the notifications with the defaults section applied, so
+// lookups return the same pointer for the same notification.
+type notificationIndex struct {
+	// notifications is the slice the index was built from; a config whose notifications
+	// were replaced since isn't served by the index.
+	notifications []NotificationConfig
+	// exact holds the notifications of each tenant and literal event type, in config order.
+	exact map[eventKey][]*NotificationConfig
+	// patterns holds the notifications with event type patterns of each tenant, most
+	// specific first and in config order among equally specific ones.
+	patterns map[string][]*NotificationConfig
+	// topics holds the notifications consuming each queue, in config order.
+	topics map[string][]*NotificationConfig
+}
+
+type eventKey struct {
+	tenant, eventType string
+}
+
+// Reindex rebuilds the lookup index of the notifications. Validate builds it; call Reindex
+// after changing Notifications or Defaults of a validated configuration.
+func (c *Config) Reindex() {
+	idx := &notificationIndex{
+		notifications: c.Notifications,
+		exact:         make(map[eventKey][]*NotificationConfig),
+		patterns:      make(map[string][]*NotificationConfig),
+		topics:        make(map[string][]*NotificationConfig),
+	}
+	for _, n := range c.Notifications {
+		applied := c.Defaults.apply(n)
+		if isPattern(n.EventType) {
+			idx.patterns[n.Tenant] = append(idx.patterns[n.Tenant], applied)
+		} else {
+			key := eventKey{n.Tenant, n.EventType}
+			idx.exact[key] = append(idx.exact[key], applied)
+		}
+		idx.topics[n.QueueName] = append(idx.topics[n.QueueName], applied)
+	}
+	for _, patterns := range idx.patterns {
+		slices.SortStableFunc(patterns, func(a, b *NotificationConfig) int {
+			return patternSpecificity(b.EventType) - patternSpecificity(a.EventType)
+		})
+	}
+	c.index = idx
+}
+
+// lookupIndex returns the index of c's notifications, or nil if they have none.
+func (c *Config) lookupIndex() *notificationIndex {
+	idx := c.index
+	if idx == nil || len(idx.notifications) != len(c.Notifications) {
+		return nil
+	}
+	if len(c.Notifications) > 0 && &idx.notifications[0] != &c.Notifications[0] {
+		return nil
+	}
+	return idx
+}
+
+// find is FindNotificationConfig on the index.
+func (idx *notificationIndex) find(tenant, eventType string, version int) *NotificationConfig {
+	var exact *NotificationConfig
+	for _, n := range idx.exact[eventKey{tenant, eventType}] {
+		if !n.AcceptsVersion(version) {
+			continue
+		}
+		if len(n.EventVersions) > 0 {
+			return n
+		}
+		if exact == nil {
+			exact = n
+		}
+	}
+	if exact != nil {
+		return exact
+	}
+	for _, n := range idx.patterns[tenant] {
+		if !n.AcceptsVersion(version) {
+			continue
+		}
+		if matched, _ := path.Match(n.EventType, eventType); matched {
+			return n
+		}
+	}
+	return nil
+}
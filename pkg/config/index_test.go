@@ -0,0 +1,76 @@
+package config_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"notification-system/pkg/config"
+)
+
+const patternConfig = `{
+  "mq": {"name_server": "127.0.0.1:9876", "group_name": "notification_group"},
+  "notifications": [
+    {"event_type": "[a-z]*.created.v[0-9]", "queue_name": "any_created", "http_method": "POST", "http_url": "http://target.example/any"},
+    {"event_type": "order.created.*", "queue_name": "order_created", "http_method": "POST", "http_url": "http://target.example/orders"},
+    {"event_type": "order.*", "queue_name": "order", "http_method": "POST", "http_url": "http://target.example/orders"},
+    {"event_type": "order.created", "queue_name": "order_exact", "http_method": "POST", "http_url": "http://target.example/orders"}
+  ]
+}`
+
+func TestFindNotificationConfigSpecificity(t *testing.T) {
+	cfg, err := config.ParseConfig([]byte(patternConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A copy without the index takes the linear path of FindNotificationConfig
+	unindexed := &config.Config{Notifications: cfg.Notifications}
+
+	tests := []struct {
+		eventType string
+		want      string
+	}{
+		// 14 literal characters beat 10 plus two bracket expressions
+		{"order.created.v1", "order_created"},
+		{"user.created.v1", "any_created"},
+		{"order.created", "order_exact"},
+		{"order.paid", "order"},
+		{"user.deleted", ""},
+	}
+	for _, tt := range tests {
+		for name, c := range map[string]*config.Config{"indexed": cfg, "unindexed": unindexed} {
+			got := ""
+			if n := c.FindNotificationConfig("", tt.eventType, 0); n != nil {
+				got = n.QueueName
+			}
+			if got != tt.want {
+				t.Errorf("%s: FindNotificationConfig(%q) = %q, want %q", name, tt.eventType, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestParseConfigEventTypePatterns(t *testing.T) {
+	tests := []struct {
+		eventType string
+		valid     bool
+	}{
+		{"order.*", true},
+		{"order.[a-z]*", true},
+		{"order.?", true},
+		{`order\*`, true},
+		{"order.[", false},
+		{"order.[]", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		eventType, _ := json.Marshal(tt.eventType)
+		settings := `{
+  "mq": {"name_server": "127.0.0.1:9876", "group_name": "notification_group"},
+  "notifications": [{"event_type": ` + string(eventType) + `, "queue_name": "q", "http_method": "POST", "http_url": "http://target.example"}]
+}`
+		_, err := config.ParseConfig([]byte(settings))
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("ParseConfig with event_type %q: error %v, want valid %v", tt.eventType, err, tt.valid)
+		}
+	}
+}
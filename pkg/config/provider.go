@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Provider loads, persists and watches the raw configuration document in a backend.
+// Parsing and validation stay in this package so every backend behaves the same.
+type Provider interface {
+	// Load returns the current configuration document and its revision.
+	Load(ctx context.Context) ([]byte, int64, error)
+	// Save replaces the configuration document if it is still at rev, and returns its new
+	// revision. It returns ErrConflict if the document was changed since.
+	Save(ctx context.Context, data []byte, rev int64) (int64, error)
+	// Watch calls onChange whenever the document may have changed. It blocks until ctx is cancelled.
+	Watch(ctx context.Context, onChange func()) error
+}
+
+// NewProvider creates a Provider from a source string:
+//
+//	config.json                          local file
+//	file:///etc/notify/config.json       local file
+//	etcd://host1:2379,host2:2379/key     etcd v3 key (user:password@ for auth)
+//	consul://host:8500/key               Consul KV key (token from CONSUL_HTTP_TOKEN)
+func NewProvider(source string) (Provider, error) {
+	if !strings.Contains(source, "://") {
+		return &FileProvider{Path: source}, nil
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config source '%s': %w", source, err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "file":
+		return &FileProvider{Path: u.Host + u.Path}, nil
+	case "etcd":
+		if key == "" {
+			return nil, fmt.Errorf("config source '%s' is missing a key", source)
+		}
+		password, _ := u.User.Password()
+		return NewEtcdProvider(strings.Split(u.Host, ","), key, u.User.Username(), password)
+	case "consul":
+		if key == "" {
+			return nil, fmt.Errorf("config source '%s' is missing a key", source)
+		}
+		return NewConsulProvider(u.Host, key, os.Getenv("CONSUL_HTTP_TOKEN")), nil
+	default:
+		return nil, fmt.Errorf("unsupported config source scheme '%s'", u.Scheme)
+	}
+}
+
+// FileProvider stores the configuration in a local JSON file.
+type FileProvider struct {
+	Path string
+	// Interval is how often Watch checks the file for changes (default 5s).
+	Interval time.Duration
+}
+
+// Load reads the configuration file. Its revision is the modification time.
+func (p *FileProvider) Load(ctx context.Context) ([]byte, int64, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, info.ModTime().UnixNano(), nil
+}
+
+// Save atomically replaces the configuration file unless its modification time moved
+// from rev. The check is best-effort: a file has no compare-and-swap, so a write between
+// the check and the rename is lost. Share the file between processes through etcd or
+// Consul instead.
+func (p *FileProvider) Save(ctx context.Context, data []byte, rev int64) (int64, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return 0, err
+	}
+	if info.ModTime().UnixNano() != rev {
+		return 0, ErrConflict
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.Path), filepath.Base(p.Path)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), p.Path); err != nil {
+		return 0, err
+	}
+	if info, err = os.Stat(p.Path); err != nil {
+		return 0, err
+	}
+	return info.ModTime().UnixNano(), nil
+}
+
+// Watch polls the file modification time.
+func (p *FileProvider) Watch(ctx context.Context, onChange func()) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last time.Time
+	if info, err := os.Stat(p.Path); err == nil {
+		last = info.ModTime()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(p.Path)
+			if err != nil || info.ModTime().Equal(last) {
+				continue
+			}
+			last = info.ModTime()
+			onChange()
+		}
+	}
+}
+
+// sameDocument reports whether two configuration documents are byte-identical
+// ignoring surrounding whitespace, used to skip reloads triggered by our own writes.
+func sameDocument(a, b []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+}
@@ -0,0 +1,374 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrNotificationExists is returned when creating a notification whose event type is already configured.
+	ErrNotificationExists = errors.New("notification already exists")
+	// ErrNotificationNotFound is returned when a notification for the given event type does not exist.
+	ErrNotificationNotFound = errors.New("notification not found")
+	// ErrPersist is returned when a valid change could not be written to the config file.
+	ErrPersist = errors.New("failed to persist config")
+	// ErrPauseExists is returned when adding a pause for a tenant, event type and target that are already paused.
+	ErrPauseExists = errors.New("pause already exists")
+	// ErrPauseNotFound is returned when removing a pause that does not exist.
+	ErrPauseNotFound = errors.New("pause not found")
+	// ErrConflict is returned when the configuration kept changing in the provider while a
+	// change was saved, e.g. by other API replicas.
+	ErrConflict = errors.New("config was changed concurrently")
+)
+
+// maxUpdateAttempts is how often a change is applied to a reloaded configuration after
+// its save conflicted.
+const maxUpdateAttempts = 3
+
+// Store holds the live configuration and persists notification changes back to its Provider.
+// Every process that shares the provider (API, workers) picks up changes through Watch.
+type Store struct {
+	provider Provider
+
+	mu        sync.RWMutex
+	cfg       *Config
+	raw       []byte
+	rev       int64
+	listeners []func(*Config)
+	recorders []func(context.Context, Change)
+}
+
+// Change describes a change made through the Store.
+type Change struct {
+	// Action is one of the Action* constants.
+	Action string
+	// Tenant, EventType and Target identify the changed notification or pause.
+	Tenant    string
+	EventType string
+	Target    string
+	// Before and After are the notification or pause before and after the change; nil
+	// when it did not exist.
+	Before, After interface{}
+}
+
+// Values of Change.Action.
+const (
+	ActionCreateNotification = "create_notification"
+	ActionUpdateNotification = "update_notification"
+	ActionDeleteNotification = "delete_notification"
+	ActionPause              = "pause"
+	ActionResume             = "resume"
+)
+
+// NewStore loads the configuration from the provider and returns a Store backed by it.
+func NewStore(ctx context.Context, provider Provider) (*Store, error) {
+	raw, rev, err := provider.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{provider: provider, cfg: cfg, raw: raw, rev: rev}, nil
+}
+
+// OpenStore creates a Store from a source string understood by NewProvider.
+func OpenStore(ctx context.Context, source string) (*Store, error) {
+	provider, err := NewProvider(source)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(ctx, provider)
+}
+
+// Config returns the current configuration. The returned value must be treated as read-only.
+func (s *Store) Config() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// OnChange registers a callback invoked with the new configuration after every change.
+func (s *Store) OnChange(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// OnUpdate registers a callback invoked with every change made through the Store, after
+// it was persisted. ctx is the context of the change, e.g. of the admin request.
+// Changes picked up by Watch are not reported.
+func (s *Store) OnUpdate(fn func(ctx context.Context, c Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorders = append(s.recorders, fn)
+}
+
+// Notifications returns a copy of all configured notifications.
+func (s *Store) Notifications() []NotificationConfig {
+	cfg := s.Config()
+	return append([]NotificationConfig(nil), cfg.Notifications...)
+}
+
+// GetNotification returns the tenant's notification configured for exactly the given event type.
+func (s *Store) GetNotification(tenant, eventType string) (NotificationConfig, error) {
+	cfg := s.Config()
+	if i := indexOf(cfg.Notifications, tenant, eventType); i >= 0 {
+		return cfg.Notifications[i], nil
+	}
+	return NotificationConfig{}, ErrNotificationNotFound
+}
+
+// CreateNotification adds a new notification and persists the configuration.
+func (s *Store) CreateNotification(ctx context.Context, n NotificationConfig) error {
+	c := Change{Action: ActionCreateNotification, Tenant: n.Tenant, EventType: n.EventType, After: n}
+	return s.update(ctx, c, func(cfg *Config, c *Change) error {
+		if indexOf(cfg.Notifications, n.Tenant, n.EventType) >= 0 {
+			return ErrNotificationExists
+		}
+		cfg.Notifications = append(cfg.Notifications, n)
+		return nil
+	})
+}
+
+// UpdateNotification replaces the tenant's notification for eventType and persists the
+// configuration. The replacement keeps the tenant.
+func (s *Store) UpdateNotification(ctx context.Context, tenant, eventType string, n NotificationConfig) error {
+	n.Tenant = tenant
+	c := Change{Action: ActionUpdateNotification, Tenant: tenant, EventType: eventType, After: n}
+	return s.update(ctx, c, func(cfg *Config, c *Change) error {
+		i := indexOf(cfg.Notifications, tenant, eventType)
+		if i < 0 {
+			return ErrNotificationNotFound
+		}
+		if n.EventType != eventType && indexOf(cfg.Notifications, tenant, n.EventType) >= 0 {
+			return ErrNotificationExists
+		}
+		c.Before = cfg.Notifications[i]
+		cfg.Notifications[i] = n
+		return nil
+	})
+}
+
+// SetTargetWeights changes the weights of the named targets of the tenant's notification
+// for eventType, keeping the weights of the others, and persists the configuration. It
+// returns the changed notification.
+func (s *Store) SetTargetWeights(ctx context.Context, tenant, eventType string, weights map[string]int) (NotificationConfig, error) {
+	var changed NotificationConfig
+	c := Change{Action: ActionUpdateNotification, Tenant: tenant, EventType: eventType}
+	err := s.update(ctx, c, func(cfg *Config, c *Change) error {
+		i := indexOf(cfg.Notifications, tenant, eventType)
+		if i < 0 {
+			return ErrNotificationNotFound
+		}
+		n := cfg.Notifications[i]
+		n.Targets = append([]WeightedTarget(nil), n.Targets...)
+		for name, weight := range weights {
+			j := slices.IndexFunc(n.Targets, func(t WeightedTarget) bool { return t.Name == name })
+			if j < 0 {
+				return fmt.Errorf("notification of event type %s has no target '%s'", eventType, name)
+			}
+			n.Targets[j].Weight = weight
+		}
+		c.Before, c.After = cfg.Notifications[i], n
+		cfg.Notifications[i] = n
+		changed = n
+		return nil
+	})
+	return changed, err
+}
+
+// DeleteNotification removes the tenant's notification for eventType and persists the configuration.
+func (s *Store) DeleteNotification(ctx context.Context, tenant, eventType string) error {
+	c := Change{Action: ActionDeleteNotification, Tenant: tenant, EventType: eventType}
+	return s.update(ctx, c, func(cfg *Config, c *Change) error {
+		i := indexOf(cfg.Notifications, tenant, eventType)
+		if i < 0 {
+			return ErrNotificationNotFound
+		}
+		c.Before = cfg.Notifications[i]
+		cfg.Notifications = append(cfg.Notifications[:i], cfg.Notifications[i+1:]...)
+		return nil
+	})
+}
+
+// Pauses returns a copy of all configured pauses, including expired ones.
+func (s *Store) Pauses() []PauseConfig {
+	cfg := s.Config()
+	return append([]PauseConfig(nil), cfg.Pauses...)
+}
+
+// Pause adds a pause and persists the configuration.
+func (s *Store) Pause(ctx context.Context, p PauseConfig) error {
+	c := Change{Action: ActionPause, Tenant: p.Tenant, EventType: p.EventType, Target: p.Target, After: p}
+	return s.update(ctx, c, func(cfg *Config, c *Change) error {
+		if indexOfPause(cfg.Pauses, p) >= 0 {
+			return ErrPauseExists
+		}
+		cfg.Pauses = append(cfg.Pauses, p)
+		return nil
+	})
+}
+
+// Resume removes the pause with the tenant, event type and target of p and persists the
+// configuration. Held messages are delivered when they are next redelivered.
+func (s *Store) Resume(ctx context.Context, p PauseConfig) error {
+	c := Change{Action: ActionResume, Tenant: p.Tenant, EventType: p.EventType, Target: p.Target}
+	return s.update(ctx, c, func(cfg *Config, c *Change) error {
+		i := indexOfPause(cfg.Pauses, p)
+		if i < 0 {
+			return ErrPauseNotFound
+		}
+		c.Before = cfg.Pauses[i]
+		cfg.Pauses = append(cfg.Pauses[:i], cfg.Pauses[i+1:]...)
+		return nil
+	})
+}
+
+// Watch reloads the configuration whenever the provider reports a change made by
+// another process. It blocks until ctx is cancelled.
+func (s *Store) Watch(ctx context.Context) {
+	err := s.provider.Watch(ctx, func() {
+		if err := s.reload(ctx); err != nil {
+			log.Printf("[Config] Failed to reload configuration: %v", err)
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("[Config] Watch stopped: %v", err)
+	}
+}
+
+func (s *Store) reload(ctx context.Context) error {
+	raw, rev, err := s.provider.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	// A load older than our own last save must not roll the revision back
+	stale := rev < s.rev
+	unchanged := sameDocument(raw, s.raw)
+	if !stale && unchanged {
+		s.rev = rev
+	}
+	s.mu.Unlock()
+	if stale || unchanged {
+		return nil
+	}
+
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if rev < s.rev {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cfg = cfg
+	s.raw = raw
+	s.rev = rev
+	listeners := append(([]func(*Config))(nil), s.listeners...)
+	s.mu.Unlock()
+
+	log.Println("[Config] Reloaded configuration")
+	for _, fn := range listeners {
+		fn(cfg)
+	}
+	return nil
+}
+
+// update applies fn to a copy of the current configuration as persisted, validates and
+// persists it, then swaps it in and notifies listeners and recorders. fn completes c, which
+// describes the change to recorders. The defaults Validate fills in are not persisted.
+//
+// The save only succeeds if nobody else saved the configuration since it was loaded. On a
+// conflict the configuration is reloaded and fn applied again, up to maxUpdateAttempts
+// times before ErrConflict is returned.
+func (s *Store) update(ctx context.Context, c Change, fn func(cfg *Config, c *Change) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.tryUpdate(ctx, c, fn)
+		if !errors.Is(err, ErrConflict) || attempt == maxUpdateAttempts {
+			return err
+		}
+		if err := s.reload(ctx); err != nil {
+			return fmt.Errorf("%w: %v", ErrPersist, err)
+		}
+	}
+}
+
+// tryUpdate makes one attempt of update.
+func (s *Store) tryUpdate(ctx context.Context, c Change, fn func(cfg *Config, c *Change) error) error {
+	s.mu.Lock()
+
+	var doc Config
+	if err := json.Unmarshal(s.raw, &doc); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+	if err := fn(&doc, &c); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	raw, err := json.MarshalIndent(&doc, "", "    ")
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+	raw = append(raw, '\n')
+	// Validating a separate copy keeps the defaults out of raw
+	next, err := ParseConfig(raw)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	rev, err := s.provider.Save(ctx, raw, s.rev)
+	if errors.Is(err, ErrConflict) {
+		s.mu.Unlock()
+		return err
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	s.cfg = next
+	s.raw = raw
+	s.rev = rev
+	listeners := append(([]func(*Config))(nil), s.listeners...)
+	recorders := append(([]func(context.Context, Change))(nil), s.recorders...)
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		l(next)
+	}
+	for _, r := range recorders {
+		r(ctx, c)
+	}
+	return nil
+}
+
+func indexOf(notifications []NotificationConfig, tenant, eventType string) int {
+	for i, n := range notifications {
+		if n.Tenant == tenant && n.EventType == eventType {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfPause(pauses []PauseConfig, p PauseConfig) int {
+	for i := range pauses {
+		if pauses[i].key() == p.key() {
+			return i
+		}
+	}
+	return -1
+}
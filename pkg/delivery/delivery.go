@@ -0,0 +1,89 @@
+// Package delivery records the outcome of webhook deliveries for debugging and reporting.
+package delivery
+
+import (
+	"sync"
+	"time"
+)
+
+// Record describes the outcome of delivering one event to one notification target.
+type Record struct {
+	Tenant    string `json:"tenant,omitempty"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	// DeliveryID identifies the delivery of the event to the target across retries; it is
+	// sent as the Idempotency-Key header.
+	DeliveryID string `json:"delivery_id,omitempty"`
+	// TemplateVersion is the version of the body template used (see templates).
+	TemplateVersion string `json:"template_version,omitempty"`
+	URL             string `json:"url"`
+	// Target is the name of the weighted target the event was sent to, if any.
+	Target       string `json:"target,omitempty"`
+	Success      bool   `json:"success"`
+	Attempts     int    `json:"attempts"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+	// DryRun marks notifications rendered but not sent (see dry_run); RequestBody is the
+	// body that would have been sent.
+	DryRun      bool      `json:"dry_run,omitempty"`
+	RequestBody string    `json:"request_body,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	Time        time.Time `json:"time"`
+}
+
+// Store keeps delivery records.
+type Store interface {
+	Add(r Record)
+	// Recent returns up to limit records, newest first.
+	Recent(limit int) []Record
+}
+
+// MemoryStore is a bounded in-memory Store that keeps the most recent records.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewMemoryStore creates a MemoryStore holding at most capacity records.
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	return &MemoryStore{records: make([]Record, capacity)}
+}
+
+// Add stores r, evicting the oldest record when full.
+func (s *MemoryStore) Add(r Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[s.next] = r
+	s.next = (s.next + 1) % len(s.records)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Recent returns up to limit records, newest first.
+func (s *MemoryStore) Recent(limit int) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := s.next
+	if s.full {
+		size = len(s.records)
+	}
+	if limit <= 0 || limit > size {
+		limit = size
+	}
+
+	out := make([]Record, 0, limit)
+	for i := 1; i <= limit; i++ {
+		idx := (s.next - i + len(s.records)) % len(s.records)
+		out = append(out, s.records[idx])
+	}
+	return out
+}
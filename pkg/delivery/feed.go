@@ -0,0 +1,76 @@
+package delivery
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Feed broadcasts delivery records to live subscribers such as streaming dashboards.
+// Publishing never blocks: a subscriber that falls behind misses records.
+type Feed struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewFeed creates a Feed without subscribers.
+func NewFeed() *Feed {
+	return &Feed{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the records published to a Feed after it subscribed.
+type Subscription struct {
+	// C delivers the records; it is closed by Close.
+	C <-chan Record
+
+	c       chan Record
+	feed    *Feed
+	dropped atomic.Int64
+}
+
+// Subscribe returns a subscription buffering up to buffer records.
+func (f *Feed) Subscribe(buffer int) *Subscription {
+	c := make(chan Record, buffer)
+	s := &Subscription{C: c, c: c, feed: f}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[s] = struct{}{}
+	return s
+}
+
+// Publish hands r to every subscriber with room in its buffer.
+func (f *Feed) Publish(r Record) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for s := range f.subs {
+		select {
+		case s.c <- r:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribers returns the number of current subscribers.
+func (f *Feed) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+// Dropped returns the number of records missed because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes C.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	if _, ok := s.feed.subs[s]; ok {
+		delete(s.feed.subs, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,160 @@
+package delivery
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is the number of recent deliveries per event type that latency
+	// percentiles are computed over.
+	latencySamples = 1000
+	// recentFailures is the number of failed deliveries kept for inspection.
+	recentFailures = 100
+)
+
+// EventTypeStats summarizes the deliveries of one event type of a tenant, or of one
+// template version of it.
+type EventTypeStats struct {
+	Tenant          string  `json:"tenant,omitempty"`
+	EventType       string  `json:"event_type"`
+	TemplateVersion string  `json:"template_version,omitempty"`
+	Deliveries      int64   `json:"deliveries"`
+	Successes       int64   `json:"successes"`
+	Failures        int64   `json:"failures"`
+	SuccessRate     float64 `json:"success_rate"`
+	// P50LatencyMs and P95LatencyMs cover the last latencySamples deliveries.
+	P50LatencyMs int64     `json:"p50_latency_ms"`
+	P95LatencyMs int64     `json:"p95_latency_ms"`
+	LastDelivery time.Time `json:"last_delivery"`
+	LastFailure  time.Time `json:"last_failure,omitempty"`
+}
+
+type statsKey struct {
+	tenant, eventType, version string
+}
+
+type typeStats struct {
+	deliveries, successes int64
+	latencies             []int64 // ring of the latest latencySamples durations
+	next                  int
+	lastDelivery          time.Time
+	lastFailure           time.Time
+}
+
+// Stats aggregates delivery records per tenant, event type and template version and keeps
+// the latest failures. Counts start when the Stats is created.
+type Stats struct {
+	mu       sync.Mutex
+	since    time.Time
+	byType   map[statsKey]*typeStats
+	failures []Record // ring of the latest recentFailures failures
+	next     int
+}
+
+// NewStats creates an empty Stats.
+func NewStats() *Stats {
+	return &Stats{since: time.Now(), byType: make(map[statsKey]*typeStats)}
+}
+
+// Add counts r. Dry runs are not counted.
+func (s *Stats) Add(r Record) {
+	if r.DryRun {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := statsKey{r.Tenant, r.EventType, r.TemplateVersion}
+	t, ok := s.byType[k]
+	if !ok {
+		t = &typeStats{}
+		s.byType[k] = t
+	}
+	t.deliveries++
+	t.lastDelivery = r.Time
+	if r.Success {
+		t.successes++
+	} else {
+		t.lastFailure = r.Time
+		if len(s.failures) < recentFailures {
+			s.failures = append(s.failures, r)
+		} else {
+			s.failures[s.next] = r
+		}
+		s.next = (s.next + 1) % recentFailures
+	}
+	if len(t.latencies) < latencySamples {
+		t.latencies = append(t.latencies, r.DurationMs)
+	} else {
+		t.latencies[t.next] = r.DurationMs
+	}
+	t.next = (t.next + 1) % latencySamples
+}
+
+// Since returns when counting started.
+func (s *Stats) Since() time.Time {
+	return s.since
+}
+
+// EventTypes returns the stats of every tenant, event type and template version, ordered
+// by tenant, event type and version.
+func (s *Stats) EventTypes() []EventTypeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]EventTypeStats, 0, len(s.byType))
+	for k, t := range s.byType {
+		latencies := append([]int64(nil), t.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		out = append(out, EventTypeStats{
+			Tenant:          k.tenant,
+			EventType:       k.eventType,
+			TemplateVersion: k.version,
+			Deliveries:      t.deliveries,
+			Successes:       t.successes,
+			Failures:        t.deliveries - t.successes,
+			SuccessRate:     float64(t.successes) / float64(t.deliveries),
+			P50LatencyMs:    percentile(latencies, 0.50),
+			P95LatencyMs:    percentile(latencies, 0.95),
+			LastDelivery:    t.lastDelivery,
+			LastFailure:     t.lastFailure,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tenant != out[j].Tenant {
+			return out[i].Tenant < out[j].Tenant
+		}
+		if out[i].EventType != out[j].EventType {
+			return out[i].EventType < out[j].EventType
+		}
+		return out[i].TemplateVersion < out[j].TemplateVersion
+	})
+	return out
+}
+
+// Failures returns up to limit of the latest failed deliveries, newest first.
+func (s *Stats) Failures(limit int) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit <= 0 || limit > len(s.failures) {
+		limit = len(s.failures)
+	}
+	out := make([]Record, 0, limit)
+	for i := 1; i <= limit; i++ {
+		out = append(out, s.failures[(s.next-i+len(s.failures))%len(s.failures)])
+	}
+	return out
+}
+
+// percentile returns the p-th percentile of sorted values by the nearest-rank method.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
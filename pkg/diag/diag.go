@@ -0,0 +1,67 @@
+// Package diag serves runtime diagnostics: net/http/pprof and a JSON /debug/status endpoint.
+// It is meant for an internal-only listener enabled with the -debug-addr flag.
+package diag
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// StatusFunc returns process-specific fields merged into /debug/status.
+type StatusFunc func() map[string]interface{}
+
+var startTime = time.Now()
+
+// NewMux returns a mux serving /debug/pprof/* and /debug/status.
+func NewMux(status StatusFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/status", func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		body := map[string]interface{}{
+			"uptime_seconds": int64(time.Since(startTime).Seconds()),
+			"goroutines":     runtime.NumGoroutine(),
+			"memory": map[string]interface{}{
+				"heap_alloc_bytes":  mem.HeapAlloc,
+				"heap_inuse_bytes":  mem.HeapInuse,
+				"heap_objects":      mem.HeapObjects,
+				"sys_bytes":         mem.Sys,
+				"num_gc":            mem.NumGC,
+				"pause_total_ns":    mem.PauseTotalNs,
+				"total_alloc_bytes": mem.TotalAlloc,
+			},
+		}
+		if status != nil {
+			for k, v := range status() {
+				body[k] = v
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})
+	return mux
+}
+
+// Serve starts the diagnostics listener on addr in the background.
+func Serve(addr string, status StatusFunc) *http.Server {
+	server := &http.Server{Addr: addr, Handler: NewMux(status)}
+	go func() {
+		log.Printf("Diagnostics server started on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Diagnostics server failed: %v", err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,265 @@
+// Package e2etest runs the notification system end to end for integration tests: a
+// broker (the in-memory broker, or RocketMQ started in Docker with dockertest), the API
+// with its worker (cmd/api -mode all) and an echo webhook receiver as the target:
+//
+//	h, err := e2etest.Start(ctx, e2etest.Options{
+//		Notifications: []config.NotificationConfig{{
+//			EventType: "order.created", QueueName: "order_queue",
+//			Method: "POST", URL: "/orders", Body: map[string]interface{}{"id": "{$.event.id}"},
+//		}},
+//	})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer h.Close()
+//	h.Publish(ctx, event.Event{Type: "order.created", Data: map[string]interface{}{"id": "42"}})
+//	records, err := h.Await(ctx, echo.Filter{Path: "/orders"}, echo.Exactly(1), 10*time.Second)
+//
+// The API is built from this module with "go build" unless Options.APIBinary is set.
+package e2etest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"notification-system/pkg/client"
+	"notification-system/pkg/config"
+	"notification-system/pkg/echo"
+	"notification-system/pkg/event"
+)
+
+// apiPackage is built when Options.APIBinary is empty.
+const apiPackage = "notification-system/cmd/api"
+
+// Options configure a Harness.
+type Options struct {
+	// Notifications are the notifications under test. An http_url or target url starting
+	// with "/" is a path on the echo receiver.
+	Notifications []config.NotificationConfig
+	// Config holds further top-level settings of the generated config, such as tenants,
+	// pauses or worker; mq and notifications are set by the harness.
+	Config map[string]interface{}
+	// RocketMQ runs the flow through RocketMQ in Docker instead of the in-memory broker.
+	RocketMQ bool
+	// Echo configures the echo receiver, e.g. the secret to verify signatures with.
+	Echo echo.Options
+	// APIBinary is a prebuilt cmd/api; by default it is built into a temporary directory.
+	APIBinary string
+	// Output receives the logs of the API and the worker (default discarded).
+	Output io.Writer
+}
+
+// Harness is a running notification system.
+type Harness struct {
+	// APIURL is the base URL of the API, e.g. for /events and /admin.
+	APIURL string
+	// EchoURL is the base URL of the echo receiver.
+	EchoURL string
+	// Echo records the notifications received.
+	Echo *echo.Server
+
+	dir      string
+	echoSrv  *http.Server
+	api      *exec.Cmd
+	exited   chan error
+	rocketMQ *rocketMQ
+	client   *client.Client
+}
+
+// Start starts the broker, the echo receiver and the API and waits until the API is ready.
+// On error, everything started so far is stopped again.
+func Start(ctx context.Context, opts Options) (h *Harness, err error) {
+	dir, err := os.MkdirTemp("", "notify-e2e-")
+	if err != nil {
+		return nil, err
+	}
+	h = &Harness{dir: dir}
+	defer func() {
+		if err != nil {
+			h.Close()
+			h = nil
+		}
+	}()
+
+	// 1. Echo receiver
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return h, fmt.Errorf("failed to listen for echo receiver: %w", err)
+	}
+	h.Echo = echo.NewServer(opts.Echo)
+	h.EchoURL = "http://" + lis.Addr().String()
+	h.echoSrv = &http.Server{Handler: h.Echo}
+	go h.echoSrv.Serve(lis)
+
+	// 2. Broker and config
+	suffix := randomSuffix()
+	mqSettings := map[string]interface{}{"broker": config.BrokerMemory, "group_name": "notify_e2e_" + suffix}
+	if opts.RocketMQ {
+		if h.rocketMQ, err = startRocketMQ(ctx, suffix, opts.Notifications); err != nil {
+			return h, fmt.Errorf("failed to start RocketMQ: %w", err)
+		}
+		mqSettings = map[string]interface{}{"name_server": h.rocketMQ.nameServer, "group_name": "notify_e2e_" + suffix}
+	}
+	configPath, err := h.writeConfig(opts, mqSettings)
+	if err != nil {
+		return h, err
+	}
+
+	// 3. API with its worker
+	binary := opts.APIBinary
+	if binary == "" {
+		binary = filepath.Join(dir, "api")
+		build := exec.CommandContext(ctx, "go", "build", "-o", binary, apiPackage)
+		if out, err := build.CombinedOutput(); err != nil {
+			return h, fmt.Errorf("failed to build %s: %v\n%s", apiPackage, err, out)
+		}
+	}
+	addr, err := freeAddr()
+	if err != nil {
+		return h, err
+	}
+	grpcAddr, err := freeAddr()
+	if err != nil {
+		return h, err
+	}
+	h.APIURL = "http://" + addr
+	h.client = client.New(h.APIURL, client.Options{Retries: -1})
+	h.api = exec.Command(binary, "-config", configPath, "-mode", "all", "-addr", addr, "-grpc-addr", grpcAddr)
+	h.api.Dir = dir
+	output := opts.Output
+	if output == nil {
+		output = io.Discard
+	}
+	h.api.Stdout, h.api.Stderr = output, output
+	if err := h.api.Start(); err != nil {
+		return h, fmt.Errorf("failed to start API: %w", err)
+	}
+	h.exited = make(chan error, 1)
+	go func() { h.exited <- h.api.Wait() }()
+
+	return h, h.waitReady(ctx)
+}
+
+// writeConfig writes the config of the harness and returns its path.
+func (h *Harness) writeConfig(opts Options, mqSettings map[string]interface{}) (string, error) {
+	notifications := make([]config.NotificationConfig, len(opts.Notifications))
+	for i, n := range opts.Notifications {
+		if strings.HasPrefix(n.URL, "/") {
+			n.URL = h.EchoURL + n.URL
+		}
+		n.Targets = append([]config.WeightedTarget(nil), n.Targets...)
+		for j, t := range n.Targets {
+			if strings.HasPrefix(t.URL, "/") {
+				n.Targets[j].URL = h.EchoURL + t.URL
+			}
+		}
+		notifications[i] = n
+	}
+	settings := make(map[string]interface{}, len(opts.Config)+2)
+	for k, v := range opts.Config {
+		settings[k] = v
+	}
+	settings["mq"] = mqSettings
+	settings["notifications"] = notifications
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if _, err := config.ParseConfig(data); err != nil {
+		return "", err
+	}
+	path := filepath.Join(h.dir, "config.json")
+	return path, os.WriteFile(path, data, 0o600)
+}
+
+// waitReady polls /readyz until the API and its worker are ready.
+func (h *Harness) waitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		resp, err := http.Get(h.APIURL + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case err := <-h.exited:
+			h.exited <- err
+			return fmt.Errorf("API exited before it was ready: %v", err)
+		case <-ctx.Done():
+			return fmt.Errorf("API not ready: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish sends evt to POST /events, without retries.
+func (h *Harness) Publish(ctx context.Context, evt event.Event) error {
+	_, err := h.client.Publish(ctx, evt)
+	return err
+}
+
+// Await waits up to timeout until the echo receiver got the expected notifications.
+func (h *Harness) Await(ctx context.Context, f echo.Filter, e echo.Expectation, timeout time.Duration) ([]echo.Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return h.Echo.Await(ctx, f, e)
+}
+
+// Close stops the API, the echo receiver and RocketMQ and removes the temporary files.
+func (h *Harness) Close() error {
+	var errs []error
+	if h.api != nil && h.api.Process != nil {
+		h.api.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-h.exited:
+		case <-time.After(30 * time.Second):
+			h.api.Process.Kill()
+			<-h.exited
+			errs = append(errs, errors.New("API did not shut down in time"))
+		}
+	}
+	if h.echoSrv != nil {
+		errs = append(errs, h.echoSrv.Close())
+	}
+	if h.rocketMQ != nil {
+		errs = append(errs, h.rocketMQ.close())
+	}
+	errs = append(errs, os.RemoveAll(h.dir))
+	return errors.Join(errs...)
+}
+
+// freeAddr returns a local address that is free to listen on.
+func freeAddr() (string, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer lis.Close()
+	return lis.Addr().String(), nil
+}
+
+// randomSuffix keeps the consumer groups and containers of concurrent harnesses apart.
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
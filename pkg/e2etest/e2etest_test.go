@@ -0,0 +1,60 @@
+package e2etest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/echo"
+	"notification-system/pkg/event"
+)
+
+func TestOrderCreated(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testOrderCreated(t, false)
+	})
+	t.Run("rocketmq", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("RocketMQ in Docker is skipped in short mode")
+		}
+		pool, err := dockertest.NewPool("")
+		if err == nil {
+			err = pool.Client.Ping()
+		}
+		if err != nil {
+			t.Skipf("Docker is not available: %v", err)
+		}
+		testOrderCreated(t, true)
+	})
+}
+
+// testOrderCreated publishes an event and expects its notification on the echo receiver.
+func testOrderCreated(t *testing.T, rocketMQ bool) {
+	ctx := context.Background()
+	h, err := Start(ctx, Options{
+		Notifications: []config.NotificationConfig{{
+			EventType: "order.created", QueueName: "order_queue",
+			Method: "POST", URL: "/orders", Body: map[string]interface{}{"id": "{$.event.id}"},
+		}},
+		RocketMQ: rocketMQ,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if err := h.Publish(ctx, event.Event{Type: "order.created", Data: map[string]interface{}{"id": "42"}}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := h.Await(ctx, echo.Filter{Path: "/orders"}, echo.Exactly(1), 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := records[0].Body; !strings.Contains(body, `"id":"42"`) {
+		t.Errorf("body = %s, want the id of the event", body)
+	}
+}
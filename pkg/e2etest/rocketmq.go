@@ -0,0 +1,118 @@
+package e2etest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// The RocketMQ image started for Options.RocketMQ.
+const (
+	rocketMQRepository = "apache/rocketmq"
+	rocketMQTag        = "5.3.1"
+)
+
+// rocketMQ is a name server and a broker running in Docker.
+type rocketMQ struct {
+	pool       *dockertest.Pool
+	network    *dockertest.Network
+	containers []*dockertest.Resource
+	nameServer string
+}
+
+// startRocketMQ starts a name server and a broker and waits until messages can be sent
+// to the queues of notifications, which are created on the way. The broker advertises
+// 127.0.0.1:10911, so only one harness with RocketMQ can run on a host at a time.
+func startRocketMQ(ctx context.Context, suffix string, notifications []config.NotificationConfig) (r *rocketMQ, err error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	r = &rocketMQ{pool: pool}
+	defer func() {
+		if err != nil {
+			r.close()
+		}
+	}()
+	if r.network, err = pool.CreateNetwork("notify-e2e-" + suffix); err != nil {
+		return r, err
+	}
+	autoRemove := func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	}
+
+	nsName := "notify-e2e-namesrv-" + suffix
+	ns, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Name:         nsName,
+		Repository:   rocketMQRepository,
+		Tag:          rocketMQTag,
+		Cmd:          []string{"sh", "mqnamesrv"},
+		ExposedPorts: []string{"9876/tcp"},
+		Networks:     []*dockertest.Network{r.network},
+	}, autoRemove)
+	if err != nil {
+		return r, fmt.Errorf("failed to start name server: %w", err)
+	}
+	r.containers = append(r.containers, ns)
+	r.nameServer = "127.0.0.1:" + ns.GetPort("9876/tcp")
+
+	broker, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Name:       "notify-e2e-broker-" + suffix,
+		Repository: rocketMQRepository,
+		Tag:        rocketMQTag,
+		Cmd: []string{"sh", "-c", "printf 'brokerIP1=127.0.0.1\\nautoCreateTopicEnable=true\\n' > /tmp/broker.conf && " +
+			"exec sh mqbroker -n " + nsName + ":9876 -c /tmp/broker.conf"},
+		ExposedPorts: []string{"10911/tcp"},
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			"10911/tcp": {{HostIP: "127.0.0.1", HostPort: "10911"}},
+		},
+		Networks: []*dockertest.Network{r.network},
+	}, autoRemove)
+	if err != nil {
+		return r, fmt.Errorf("failed to start broker: %w", err)
+	}
+	r.containers = append(r.containers, broker)
+
+	p, err := mq.NewProducer(config.MQConfig{NameServer: r.nameServer, SendRetries: 2})
+	if err != nil {
+		return r, err
+	}
+	defer p.Shutdown()
+	// Messages nobody is configured for ("{}" has no event type) are skipped by the worker
+	return r, pool.Retry(func() error {
+		if err := ctx.Err(); err != nil {
+			return backoff.Permanent(err)
+		}
+		for _, n := range notifications {
+			if err := mq.SendMessage(ctx, p, n.QueueName, []byte("{}")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *rocketMQ) close() error {
+	var errs []error
+	for _, c := range r.containers {
+		errs = append(errs, r.pool.Purge(c))
+	}
+	if r.network != nil {
+		errs = append(errs, r.network.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,257 @@
+// Package echo is a webhook receiver for integration tests of the notification system.
+// It records every request, verifies the X-Signature-256 HMAC when a secret is given and
+// lets tests inspect and assert on what was received, in process or over HTTP:
+//
+//	GET    /_echo/requests   list recorded requests (filters: method, path, contains, signature)
+//	DELETE /_echo/requests   forget all recorded requests
+//	GET    /_echo/assert     wait until the expected requests arrived (filters plus count, min, timeout)
+//
+// Any other path is a notification target and answers with Options.Status.
+package echo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notification-system/pkg/worker"
+)
+
+const (
+	echoPrefix = "/_echo/"
+	// maxBody bounds how much of a request body is recorded.
+	maxBody = 1 << 20
+	// maxAssertTimeout bounds how long an assertion over HTTP may wait.
+	maxAssertTimeout = time.Minute
+)
+
+// Options configure a Server.
+type Options struct {
+	// Secret verifies the X-Signature-256 header; empty leaves signatures unchecked.
+	Secret string
+	// RejectInvalid answers 401 to requests with a missing or invalid signature.
+	RejectInvalid bool
+	// Status is returned to notification requests (default 200).
+	Status int
+	// Max is the number of requests kept in memory (default 1000).
+	Max int
+}
+
+// Server records the notifications it receives.
+type Server struct {
+	store *store
+	opts  Options
+	mux   *http.ServeMux
+}
+
+// NewServer creates a Server without records.
+func NewServer(opts Options) *Server {
+	if opts.Status == 0 {
+		opts.Status = http.StatusOK
+	}
+	if opts.Max <= 0 {
+		opts.Max = 1000
+	}
+	s := &Server{store: newStore(opts.Max), opts: opts, mux: http.NewServeMux()}
+	s.mux.HandleFunc(echoPrefix+"requests", s.handleRequests)
+	s.mux.HandleFunc(echoPrefix+"assert", s.handleAssert)
+	s.mux.HandleFunc("/", s.handleNotification)
+	return s
+}
+
+// ServeHTTP serves the notification targets and the /_echo/ endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Records returns the matching records, oldest first.
+func (s *Server) Records(f Filter) []Record {
+	records, _ := s.store.find(f)
+	return records
+}
+
+// Reset forgets all records.
+func (s *Server) Reset() {
+	s.store.reset()
+}
+
+// Expectation is how many matching records Await waits for; see Exactly and AtLeast.
+type Expectation struct {
+	count int // exact count, or negative for min
+	min   int
+}
+
+// Exactly expects n matching records.
+func Exactly(n int) Expectation { return Expectation{count: n} }
+
+// AtLeast expects n or more matching records.
+func AtLeast(n int) Expectation { return Expectation{count: -1, min: n} }
+
+func (e Expectation) String() string {
+	if e.count >= 0 {
+		return fmt.Sprintf("exactly %d", e.count)
+	}
+	return fmt.Sprintf("at least %d", e.min)
+}
+
+func (e Expectation) satisfied(n int) bool {
+	if e.count >= 0 {
+		return n == e.count
+	}
+	return n >= e.min
+}
+
+// Await waits until the matching records satisfy e and returns them. It returns the
+// records seen so far and an error once ctx is done or more than the exact count arrived.
+func (s *Server) Await(ctx context.Context, f Filter, e Expectation) ([]Record, error) {
+	for {
+		records, changed := s.store.find(f)
+		if e.satisfied(len(records)) {
+			return records, nil
+		}
+		if e.count >= 0 && len(records) > e.count {
+			return records, fmt.Errorf("expected %s matching requests, got %d", e, len(records))
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			records, _ = s.store.find(f)
+			return records, fmt.Errorf("expected %s matching requests, got %d", e, len(records))
+		}
+	}
+}
+
+func (s *Server) handleNotification(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	rec := s.store.add(Record{
+		Time:      time.Now(),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Header:    r.Header.Clone(),
+		Body:      string(body),
+		Signature: s.verify(r.Header.Get(worker.SignatureHeader), body),
+	})
+	log.Printf("#%d %s %s (%d bytes, signature %s)", rec.Seq, rec.Method, rec.Path, len(body), rec.Signature)
+
+	if s.opts.RejectInvalid && (rec.Signature == SignatureMissing || rec.Signature == SignatureInvalid) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, s.opts.Status, map[string]int64{"seq": rec.Seq})
+}
+
+func (s *Server) verify(signature string, body []byte) string {
+	switch {
+	case s.opts.Secret == "":
+		return SignatureUnchecked
+	case signature == "":
+		return SignatureMissing
+	case worker.VerifySignature(s.opts.Secret, body, signature):
+		return SignatureValid
+	default:
+		return SignatureInvalid
+	}
+}
+
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Records(parseFilter(r)))
+	case http.MethodDelete:
+		s.store.reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAssert waits until the matching requests satisfy the expectation: exactly count
+// requests, or at least min (default 1). It answers 200 as soon as the expectation holds,
+// and 417 with the requests seen so far once the timeout (default 5s) expires or more
+// than count requests arrived.
+func (s *Server) handleAssert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	count, err := intParam(q.Get("count"), -1)
+	if err != nil {
+		http.Error(w, "Invalid count", http.StatusBadRequest)
+		return
+	}
+	min, err := intParam(q.Get("min"), 1)
+	if err != nil {
+		http.Error(w, "Invalid min", http.StatusBadRequest)
+		return
+	}
+	timeout := 5 * time.Second
+	if v := q.Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil || timeout < 0 {
+			http.Error(w, "Invalid timeout", http.StatusBadRequest)
+			return
+		}
+	}
+	if timeout > maxAssertTimeout {
+		timeout = maxAssertTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	records, err := s.Await(ctx, parseFilter(r), Expectation{count: count, min: min})
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusExpectationFailed, assertResult{Matched: len(records), Error: err.Error(), Requests: records})
+		return
+	}
+	writeJSON(w, http.StatusOK, assertResult{OK: true, Matched: len(records), Requests: records})
+}
+
+type assertResult struct {
+	OK       bool     `json:"ok"`
+	Matched  int      `json:"matched"`
+	Error    string   `json:"error,omitempty"`
+	Requests []Record `json:"requests"`
+}
+
+func parseFilter(r *http.Request) Filter {
+	q := r.URL.Query()
+	return Filter{
+		Method:    q.Get("method"),
+		Path:      q.Get("path"),
+		Contains:  q.Get("contains"),
+		Signature: q.Get("signature"),
+	}
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value %q", v)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package echo
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signature verification results of a record.
+const (
+	SignatureUnchecked = "unchecked" // no secret configured
+	SignatureMissing   = "missing"
+	SignatureValid     = "valid"
+	SignatureInvalid   = "invalid"
+)
+
+// Record is a request received by the echo server.
+type Record struct {
+	Seq       int64       `json:"seq"`
+	Time      time.Time   `json:"time"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Query     string      `json:"query,omitempty"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+	Signature string      `json:"signature"`
+}
+
+// Filter selects records, like the query parameters of /_echo/requests and /_echo/assert.
+// Empty fields match any record.
+type Filter struct {
+	Method    string
+	Path      string
+	Contains  string
+	Signature string
+}
+
+func (f Filter) match(r Record) bool {
+	return (f.Method == "" || strings.EqualFold(f.Method, r.Method)) &&
+		(f.Path == "" || f.Path == r.Path) &&
+		(f.Contains == "" || strings.Contains(r.Body, f.Contains)) &&
+		(f.Signature == "" || f.Signature == r.Signature)
+}
+
+// store keeps the most recent records in memory.
+type store struct {
+	mu      sync.Mutex
+	max     int
+	seq     int64
+	records []Record
+	// changed is closed and replaced whenever a record is added, waking up assertions.
+	changed chan struct{}
+}
+
+func newStore(max int) *store {
+	return &store{max: max, changed: make(chan struct{})}
+}
+
+func (s *store) add(r Record) Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	r.Seq = s.seq
+	s.records = append(s.records, r)
+	if len(s.records) > s.max {
+		s.records = s.records[len(s.records)-s.max:]
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return r
+}
+
+// find returns the matching records, oldest first, and a channel closed on the next change.
+func (s *store) find(f Filter) ([]Record, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := []Record{}
+	for _, r := range s.records {
+		if f.match(r) {
+			matched = append(matched, r)
+		}
+	}
+	return matched, s.changed
+}
+
+func (s *store) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = nil
+}
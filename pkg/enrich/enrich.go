@@ -0,0 +1,249 @@
+// Package enrich augments event data with HTTP or gRPC lookups before notifications are
+// rendered, so producers don't have to denormalize everything into their events.
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/render"
+)
+
+const (
+	// DefaultTimeout bounds a lookup without a timeout.
+	DefaultTimeout = 2 * time.Second
+	// maxResponse bounds the response of an HTTP lookup.
+	maxResponse = 1 << 20
+	// maxCacheEntries bounds the cached responses; expired entries are evicted first.
+	maxCacheEntries = 10000
+)
+
+// Error is a failed lookup whose policy is retry.
+type Error struct {
+	Field string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to enrich %s: %v", e.Field, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Enricher runs lookups, caching their responses as configured. It is safe for
+// concurrent use.
+type Enricher struct {
+	client *http.Client
+	grpc   *grpcClients
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// cacheEntry holds a response as JSON, so events never share (and transforms never
+// modify) a cached value.
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+func New() *Enricher {
+	return &Enricher{
+		client: &http.Client{},
+		grpc:   newGRPCClients(),
+		cache:  make(map[string]cacheEntry),
+	}
+}
+
+// Apply runs the lookups in order and returns evt with their results added to its data.
+// A lookup sees the results of the lookups before it. Failed lookups are handled by their
+// on_error policy; with retry, an *Error is returned.
+func (e *Enricher) Apply(ctx context.Context, lookups []config.EnrichConfig, evt event.Event) (event.Event, error) {
+	if len(lookups) == 0 {
+		return evt, nil
+	}
+	data := make(map[string]interface{}, len(evt.Data)+len(lookups))
+	for k, v := range evt.Data {
+		data[k] = v
+	}
+	evt.Data = data
+
+	for _, l := range lookups {
+		value, err := e.lookup(ctx, l, evt)
+		if err != nil {
+			switch l.OnError {
+			case config.EnrichOnErrorSkip:
+				log.Printf("Enrichment of %s for event %s failed, skipping it: %v", l.Field, evt.ID, err)
+				continue
+			case config.EnrichOnErrorDefault:
+				log.Printf("Enrichment of %s for event %s failed, using the default: %v", l.Field, evt.ID, err)
+				value = l.Default
+			default:
+				return evt, &Error{Field: l.Field, Err: err}
+			}
+		}
+		data[l.Field] = value
+	}
+	return evt, nil
+}
+
+// lookup returns the response of one lookup, from the cache if possible.
+func (e *Enricher) lookup(ctx context.Context, l config.EnrichConfig, evt event.Event) (interface{}, error) {
+	var (
+		key  string
+		call func(context.Context) ([]byte, error)
+	)
+	headers := make(map[string]string, len(l.Headers))
+	for k, v := range l.Headers {
+		value, err := render.String(v, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render header %s: %w", k, err)
+		}
+		headers[k] = value
+	}
+	if l.GRPC != nil {
+		req, err := render.JSON(l.GRPC.Request, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render request: %w", err)
+		}
+		key = "grpc " + l.GRPC.Target + " " + l.GRPC.Method + " " + string(req)
+		call = func(ctx context.Context) ([]byte, error) {
+			return e.grpc.call(ctx, l.GRPC, headers, req)
+		}
+	} else {
+		url, err := render.String(l.URL, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render url: %w", err)
+		}
+		method := strings.ToUpper(l.Method)
+		if method == "" {
+			method = http.MethodGet
+		}
+		var body []byte
+		if l.Body != nil {
+			if body, err = render.JSON(l.Body, evt); err != nil {
+				return nil, fmt.Errorf("failed to render body: %w", err)
+			}
+		}
+		key = method + " " + url + " " + string(body)
+		call = func(ctx context.Context) ([]byte, error) {
+			return e.httpLookup(ctx, method, url, headers, body)
+		}
+	}
+
+	ttl := l.CacheTTL.Std()
+	var (
+		body []byte
+		ok   bool
+	)
+	if ttl > 0 {
+		body, ok = e.cached(key)
+	}
+	if !ok {
+		timeout := l.Timeout.Std()
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		var err error
+		if body, err = call(ctx); err != nil {
+			return nil, err
+		}
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("lookup returned invalid JSON: %s", truncate(body, 200))
+		}
+		if ttl > 0 {
+			e.store(key, body, ttl)
+		}
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (e *Enricher) httpLookup(ctx context.Context, method, url string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("lookup failed with status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("lookup failed with status %d: %s", resp.StatusCode, truncate(data, 200))
+	}
+	return data, nil
+}
+
+func (e *Enricher) cached(key string) ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry, ok := e.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (e *Enricher) store(key string, body []byte, ttl time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if len(e.cache) >= maxCacheEntries {
+		for k, entry := range e.cache {
+			if now.After(entry.expires) {
+				delete(e.cache, k)
+			}
+		}
+	}
+	// Still full of live entries: make room by evicting arbitrary ones
+	for k := range e.cache {
+		if len(e.cache) < maxCacheEntries {
+			break
+		}
+		delete(e.cache, k)
+	}
+	e.cache[key] = cacheEntry{body: body, expires: now.Add(ttl)}
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) <= n {
+		return string(b)
+	}
+	return string(b[:n]) + "...(truncated)"
+}
+
+// Close closes the gRPC connections.
+func (e *Enricher) Close() {
+	e.grpc.close()
+}
@@ -0,0 +1,200 @@
+package enrich
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"notification-system/pkg/config"
+)
+
+// responseJSON encodes gRPC responses with the field names of the .proto file, as
+// templates refer to them, and with zero values present.
+var responseJSON = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// grpcClients keeps one connection per server and the method descriptors obtained from
+// its reflection service.
+type grpcClients struct {
+	mu      sync.Mutex
+	conns   map[string]*grpc.ClientConn
+	methods map[string]protoreflect.MethodDescriptor
+}
+
+func newGRPCClients() *grpcClients {
+	return &grpcClients{
+		conns:   make(map[string]*grpc.ClientConn),
+		methods: make(map[string]protoreflect.MethodDescriptor),
+	}
+}
+
+// call invokes the unary method of cfg with req, the request message as JSON, and returns
+// the response message as JSON.
+func (c *grpcClients) call(ctx context.Context, cfg *config.GRPCLookupConfig, headers map[string]string, req []byte) ([]byte, error) {
+	conn, err := c.conn(cfg)
+	if err != nil {
+		return nil, err
+	}
+	md, err := c.method(ctx, conn, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	in := dynamicpb.NewMessage(md.Input())
+	if err := protojson.Unmarshal(req, in); err != nil {
+		return nil, fmt.Errorf("invalid request for %s: %w", md.FullName(), err)
+	}
+	out := dynamicpb.NewMessage(md.Output())
+	if len(headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(headers))
+	}
+	name := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	if err := conn.Invoke(ctx, name, in, out); err != nil {
+		return nil, err
+	}
+	return responseJSON.Marshal(out)
+}
+
+func (c *grpcClients) conn(cfg *config.GRPCLookupConfig) (*grpc.ClientConn, error) {
+	key := cfg.Target
+	if cfg.TLS {
+		key += " tls"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[key]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if cfg.TLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.NewClient(cfg.Target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	c.conns[key] = conn
+	return conn, nil
+}
+
+// method returns the descriptor of cfg.Method, asking the server's reflection service
+// for it on first use.
+func (c *grpcClients) method(ctx context.Context, conn *grpc.ClientConn, cfg *config.GRPCLookupConfig) (protoreflect.MethodDescriptor, error) {
+	key := cfg.Target + " " + cfg.Method
+	c.mu.Lock()
+	md, ok := c.methods[key]
+	c.mu.Unlock()
+	if ok {
+		return md, nil
+	}
+
+	service, method, _ := strings.Cut(strings.TrimPrefix(cfg.Method, "/"), "/")
+	files, err := reflectFiles(ctx, conn, service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s with server reflection: %w", service, err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md = sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", service, method)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is not unary", cfg.Method)
+	}
+
+	c.mu.Lock()
+	c.methods[key] = md
+	c.mu.Unlock()
+	return md, nil
+}
+
+// reflectFiles fetches the file defining symbol and its dependencies from the reflection
+// service. Dependencies compiled into this binary, like the well-known types, are not
+// requested.
+func reflectFiles(ctx context.Context, conn *grpc.ClientConn, symbol string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	requested := make(map[string]bool)
+	queue := []*rpb.ServerReflectionRequest{{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	}}
+	for len(queue) > 0 {
+		if err := stream.Send(queue[0]); err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("%s", e.GetErrorMessage())
+		}
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, fd); err != nil {
+				return nil, err
+			}
+			files[fd.GetName()] = fd
+		}
+		for _, fd := range files {
+			for _, dep := range fd.GetDependency() {
+				if files[dep] != nil || requested[dep] {
+					continue
+				}
+				if known, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					files[dep] = protodesc.ToFileDescriptorProto(known)
+					continue
+				}
+				requested[dep] = true
+				queue = append(queue, &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				})
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range files {
+		set.File = append(set.File, fd)
+	}
+	return protodesc.NewFiles(set)
+}
+
+func (c *grpcClients) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, key)
+	}
+}
@@ -1,11 +1,72 @@
 package event
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Event represents a business event that occurred in the system.
 type Event struct {
-	ID        string                 `json:"id"`
-	Type      string                 `json:"type"`
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Version is the version of the event's data schema; 0 means unversioned. Upcasters
+	// upgrade older versions before notifications are matched.
+	Version   int                    `json:"version,omitempty"`
 	Data      map[string]interface{} `json:"data"`
 	Timestamp time.Time              `json:"timestamp"`
+	// Source names the system that produced the event, e.g. "billing-service".
+	Source string `json:"source,omitempty"`
+	// CorrelationID ties the event to the request or business process that caused it, so
+	// it can be followed through downstream systems.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// TraceID is the W3C trace ID (32 hex digits) of the trace the event was published in.
+	TraceID string `json:"trace_id,omitempty"`
+	// Metadata carries other values of the producer. Templates reach it and the fields
+	// above as {$.meta.<key>}, e.g. {$.meta.correlation_id}.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// TenantID is set at ingestion from the caller's API key; clients cannot choose it.
+	TenantID string `json:"tenant_id,omitempty"`
+	// CallbackURL receives a receipt with the final outcome of the delivery. It overrides
+	// the callback_url of the notification.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// ExpiresAt is when the event stops being worth notifying about; later deliveries are
+	// dropped instead of sent.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Digest marks an event the worker combined from the events of a digest window; it is
+	// delivered as is. Clients cannot set it.
+	Digest bool `json:"digest,omitempty"`
+	// RawData is the undecoded JSON data of an event decoded without it (see
+	// mq.DecodeEnvelope); Data is nil until DecodeData. Templates read fields from it
+	// directly.
+	RawData json.RawMessage `json:"-"`
+}
+
+// DecodeData decodes RawData into Data, for code that needs the data as a map.
+func (e *Event) DecodeData() error {
+	if e.RawData == nil {
+		return nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(e.RawData, &data); err != nil {
+		return err
+	}
+	e.Data, e.RawData = data, nil
+	return nil
+}
+
+// Meta returns the metadata value key, where source, correlation_id and trace_id name
+// the fields of the event. ok is false when the event has no such value.
+func (e Event) Meta(key string) (value string, ok bool) {
+	switch key {
+	case "source":
+		value = e.Source
+	case "correlation_id":
+		value = e.CorrelationID
+	case "trace_id":
+		value = e.TraceID
+	default:
+		value, ok = e.Metadata[key]
+		return value, ok
+	}
+	return value, value != ""
 }
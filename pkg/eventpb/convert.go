@@ -0,0 +1,71 @@
+// Package eventpb contains the protobuf definitions of the ingestion API and
+// helpers to convert between the wire types and pkg/event.
+package eventpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative event.proto
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"notification-system/pkg/event"
+)
+
+// ToEvent converts a protobuf Event into an event.Event.
+func ToEvent(pe *Event) event.Event {
+	evt := event.Event{
+		ID:            pe.GetId(),
+		Type:          pe.GetType(),
+		Version:       int(pe.GetVersion()),
+		Source:        pe.GetSource(),
+		CorrelationID: pe.GetCorrelationId(),
+		TraceID:       pe.GetTraceId(),
+		Metadata:      pe.GetMetadata(),
+		TenantID:      pe.GetTenantId(),
+		CallbackURL:   pe.GetCallbackUrl(),
+		Digest:        pe.GetDigest(),
+	}
+	if pe.GetData() != nil {
+		evt.Data = pe.GetData().AsMap()
+	}
+	if pe.GetTimestamp() != nil {
+		evt.Timestamp = pe.GetTimestamp().AsTime()
+	}
+	if pe.GetExpiresAt() != nil {
+		expiresAt := pe.GetExpiresAt().AsTime()
+		evt.ExpiresAt = &expiresAt
+	}
+	return evt
+}
+
+// FromEvent converts an event.Event into its protobuf representation.
+func FromEvent(evt event.Event) (*Event, error) {
+	pe := &Event{
+		Id:            evt.ID,
+		Type:          evt.Type,
+		Version:       int32(evt.Version),
+		Source:        evt.Source,
+		CorrelationId: evt.CorrelationID,
+		TraceId:       evt.TraceID,
+		Metadata:      evt.Metadata,
+		TenantId:      evt.TenantID,
+		CallbackUrl:   evt.CallbackURL,
+		Digest:        evt.Digest,
+	}
+	if evt.Data != nil {
+		data, err := structpb.NewStruct(evt.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event data: %w", err)
+		}
+		pe.Data = data
+	}
+	if !evt.Timestamp.IsZero() {
+		pe.Timestamp = timestamppb.New(evt.Timestamp)
+	}
+	if evt.ExpiresAt != nil {
+		pe.ExpiresAt = timestamppb.New(*evt.ExpiresAt)
+	}
+	return pe, nil
+}
@@ -0,0 +1,163 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v5.28.3
+// source: event.proto
+
+package eventpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	EventIngestion_PublishEvent_FullMethodName      = "/notification.event.v1.EventIngestion/PublishEvent"
+	EventIngestion_PublishEventBatch_FullMethodName = "/notification.event.v1.EventIngestion/PublishEventBatch"
+)
+
+// EventIngestionClient is the client API for EventIngestion service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// EventIngestion is the gRPC counterpart of POST /events.
+type EventIngestionClient interface {
+	PublishEvent(ctx context.Context, in *PublishEventRequest, opts ...grpc.CallOption) (*PublishEventResponse, error)
+	PublishEventBatch(ctx context.Context, in *PublishEventBatchRequest, opts ...grpc.CallOption) (*PublishEventBatchResponse, error)
+}
+
+type eventIngestionClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventIngestionClient(cc grpc.ClientConnInterface) EventIngestionClient {
+	return &eventIngestionClient{cc}
+}
+
+func (c *eventIngestionClient) PublishEvent(ctx context.Context, in *PublishEventRequest, opts ...grpc.CallOption) (*PublishEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishEventResponse)
+	err := c.cc.Invoke(ctx, EventIngestion_PublishEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventIngestionClient) PublishEventBatch(ctx context.Context, in *PublishEventBatchRequest, opts ...grpc.CallOption) (*PublishEventBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishEventBatchResponse)
+	err := c.cc.Invoke(ctx, EventIngestion_PublishEventBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EventIngestionServer is the server API for EventIngestion service.
+// All implementations must embed UnimplementedEventIngestionServer
+// for forward compatibility.
+//
+// EventIngestion is the gRPC counterpart of POST /events.
+type EventIngestionServer interface {
+	PublishEvent(context.Context, *PublishEventRequest) (*PublishEventResponse, error)
+	PublishEventBatch(context.Context, *PublishEventBatchRequest) (*PublishEventBatchResponse, error)
+	mustEmbedUnimplementedEventIngestionServer()
+}
+
+// UnimplementedEventIngestionServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEventIngestionServer struct{}
+
+func (UnimplementedEventIngestionServer) PublishEvent(context.Context, *PublishEventRequest) (*PublishEventResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method PublishEvent not implemented")
+}
+func (UnimplementedEventIngestionServer) PublishEventBatch(context.Context, *PublishEventBatchRequest) (*PublishEventBatchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method PublishEventBatch not implemented")
+}
+func (UnimplementedEventIngestionServer) mustEmbedUnimplementedEventIngestionServer() {}
+func (UnimplementedEventIngestionServer) testEmbeddedByValue()                        {}
+
+// UnsafeEventIngestionServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventIngestionServer will
+// result in compilation errors.
+type UnsafeEventIngestionServer interface {
+	mustEmbedUnimplementedEventIngestionServer()
+}
+
+func RegisterEventIngestionServer(s grpc.ServiceRegistrar, srv EventIngestionServer) {
+	// If the following call panics, it indicates UnimplementedEventIngestionServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&EventIngestion_ServiceDesc, srv)
+}
+
+func _EventIngestion_PublishEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventIngestionServer).PublishEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventIngestion_PublishEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventIngestionServer).PublishEvent(ctx, req.(*PublishEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventIngestion_PublishEventBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishEventBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventIngestionServer).PublishEventBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventIngestion_PublishEventBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventIngestionServer).PublishEventBatch(ctx, req.(*PublishEventBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EventIngestion_ServiceDesc is the grpc.ServiceDesc for EventIngestion service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventIngestion_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "notification.event.v1.EventIngestion",
+	HandlerType: (*EventIngestionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PublishEvent",
+			Handler:    _EventIngestion_PublishEvent_Handler,
+		},
+		{
+			MethodName: "PublishEventBatch",
+			Handler:    _EventIngestion_PublishEventBatch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "event.proto",
+}
@@ -0,0 +1,113 @@
+// Package health provides liveness and readiness HTTP handlers backed by named checks.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds how long a single readiness probe may take.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is healthy. A nil error means healthy.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the readiness checks of a process.
+type Registry struct {
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a named readiness check.
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+// Report is the JSON body returned by the readiness handler.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Run executes all checks concurrently and returns the aggregated report.
+func (r *Registry) Run(ctx context.Context) (Report, bool) {
+	r.mu.RLock()
+	checks := append([]namedCheck(nil), r.checks...)
+	r.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			results[i] = c.check(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok", Checks: make(map[string]string, len(checks))}
+	healthy := true
+	for i, c := range checks {
+		if results[i] != nil {
+			healthy = false
+			report.Checks[c.name] = results[i].Error()
+		} else {
+			report.Checks[c.name] = "ok"
+		}
+	}
+	if !healthy {
+		report.Status = "unavailable"
+	}
+	return report, healthy
+}
+
+// LivenessHandler reports that the process is running. It does not run any checks,
+// so a broken dependency never causes a restart loop.
+func (r *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: "ok"})
+	})
+}
+
+// ReadinessHandler runs all checks and answers 200 when they pass, 503 otherwise.
+func (r *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report, healthy := r.Run(req.Context())
+		status := http.StatusOK
+		if !healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeReport(w, status, report)
+	})
+}
+
+// RegisterHandlers mounts /healthz and /readyz on mux.
+func (r *Registry) RegisterHandlers(mux *http.ServeMux) {
+	mux.Handle("/healthz", r.LivenessHandler())
+	mux.Handle("/readyz", r.ReadinessHandler())
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
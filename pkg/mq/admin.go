@@ -0,0 +1,293 @@
+package mq
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// RocketMQ remoting request codes used by the admin operations below. The Go client
+// does not expose offset resets, so they are sent over the wire like mqadmin does.
+const (
+	reqSearchOffsetByTimestamp   = 29
+	reqUpdateConsumerOffset      = 36
+	reqGetRouteInfoByTopic       = 105
+	reqInvokeBrokerToResetOffset = 222
+	respConsumerNotOnline        = 206
+)
+
+const (
+	remotingVersion               = 317
+	remotingTimeout               = 10 * time.Second
+	remotingMaxFrame              = 16 << 20
+	remotingResponseSuccess       = 0
+	remotingHeaderSerializeMask   = 0xFFFFFF
+	remotingJSONSerializationType = 0
+)
+
+var remotingOpaque int32
+
+type remotingCommand struct {
+	Code      int               `json:"code"`
+	Language  string            `json:"language"`
+	Version   int               `json:"version"`
+	Opaque    int32             `json:"opaque"`
+	Flag      int               `json:"flag"`
+	Remark    string            `json:"remark"`
+	ExtFields map[string]string `json:"extFields"`
+	Body      []byte            `json:"-"`
+}
+
+// ResetOffset moves the consumer offsets of cfg.GroupName on topic to the first message
+// stored at or after ts, on every broker serving the topic. Use time.Unix(0, 0) for the
+// earliest and time.Now() for the latest offset.
+//
+// Online consumers are reset by the broker; for an offline group the offsets are searched
+// and committed queue by queue, which is why workers should be stopped first when possible.
+func ResetOffset(ctx context.Context, cfg config.MQConfig, topic string, ts time.Time) error {
+	// Raw requests bypass the client, which would otherwise add the namespace
+	topic = withNamespace(cfg, topic)
+	cfg.GroupName = withNamespace(cfg, cfg.GroupName)
+
+	brokers, err := topicRoute(ctx, cfg, topic)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(ts.UnixMilli(), 10)
+
+	for _, b := range brokers {
+		resp, err := invoke(ctx, cfg, b.addr, reqInvokeBrokerToResetOffset, map[string]string{
+			"topic":     topic,
+			"group":     cfg.GroupName,
+			"timestamp": timestamp,
+			"isForce":   "true",
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("broker %s: %w", b.addr, err)
+		}
+		switch resp.Code {
+		case remotingResponseSuccess:
+			continue
+		case respConsumerNotOnline:
+			for q := 0; q < b.queues; q++ {
+				if err := resetQueueOffset(ctx, cfg, b.addr, topic, q, timestamp); err != nil {
+					return fmt.Errorf("broker %s queue %d: %w", b.addr, q, err)
+				}
+			}
+		default:
+			return fmt.Errorf("broker %s: reset offset failed (code %d): %s", b.addr, resp.Code, resp.Remark)
+		}
+	}
+	return nil
+}
+
+func resetQueueOffset(ctx context.Context, cfg config.MQConfig, addr, topic string, queueID int, timestamp string) error {
+	queue := strconv.Itoa(queueID)
+	resp, err := invoke(ctx, cfg, addr, reqSearchOffsetByTimestamp, map[string]string{
+		"topic":     topic,
+		"queueId":   queue,
+		"timestamp": timestamp,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if resp.Code != remotingResponseSuccess {
+		return fmt.Errorf("search offset failed (code %d): %s", resp.Code, resp.Remark)
+	}
+
+	resp, err = invoke(ctx, cfg, addr, reqUpdateConsumerOffset, map[string]string{
+		"consumerGroup": cfg.GroupName,
+		"topic":         topic,
+		"queueId":       queue,
+		"commitOffset":  resp.ExtFields["offset"],
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if resp.Code != remotingResponseSuccess {
+		return fmt.Errorf("update offset failed (code %d): %s", resp.Code, resp.Remark)
+	}
+	return nil
+}
+
+// withNamespace prefixes a topic or group with the configured namespace like the client does.
+func withNamespace(cfg config.MQConfig, name string) string {
+	if cfg.Namespace == "" || strings.HasPrefix(name, cfg.Namespace+"%") {
+		return name
+	}
+	return cfg.Namespace + "%" + name
+}
+
+type brokerRoute struct {
+	name   string
+	addr   string
+	queues int
+}
+
+// unquotedKeyPattern matches the unquoted numeric map keys ({0:"host:port"}) that
+// RocketMQ's route JSON uses for broker addresses and encoding/json rejects.
+var unquotedKeyPattern = regexp.MustCompile(`([{,]\s*)(-?\d+)\s*:`)
+
+// topicRoute asks the name server which master brokers serve topic and how many read queues each has.
+func topicRoute(ctx context.Context, cfg config.MQConfig, topic string) ([]brokerRoute, error) {
+	resp, err := invoke(ctx, cfg, cfg.NameServer, reqGetRouteInfoByTopic, map[string]string{"topic": topic}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("name server: %w", err)
+	}
+	if resp.Code == respTopicNotExist {
+		return nil, fmt.Errorf("topic %s: %w", topic, errTopicNotExist)
+	}
+	if resp.Code != remotingResponseSuccess {
+		return nil, fmt.Errorf("topic %s route not found (code %d): %s", topic, resp.Code, resp.Remark)
+	}
+
+	var route struct {
+		QueueDatas []struct {
+			BrokerName    string `json:"brokerName"`
+			ReadQueueNums int    `json:"readQueueNums"`
+		} `json:"queueDatas"`
+		BrokerDatas []struct {
+			BrokerName  string            `json:"brokerName"`
+			BrokerAddrs map[string]string `json:"brokerAddrs"`
+		} `json:"brokerDatas"`
+	}
+	body := unquotedKeyPattern.ReplaceAll(resp.Body, []byte(`$1"$2":`))
+	if err := json.Unmarshal(body, &route); err != nil {
+		return nil, fmt.Errorf("invalid route for topic %s: %w", topic, err)
+	}
+
+	queues := make(map[string]int)
+	for _, q := range route.QueueDatas {
+		queues[q.BrokerName] = q.ReadQueueNums
+	}
+	var brokers []brokerRoute
+	for _, b := range route.BrokerDatas {
+		if addr := b.BrokerAddrs["0"]; addr != "" {
+			brokers = append(brokers, brokerRoute{name: b.BrokerName, addr: addr, queues: queues[b.BrokerName]})
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no master broker found for topic %s", topic)
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].name < brokers[j].name })
+	return brokers, nil
+}
+
+// invoke sends a single request over a fresh connection and waits for its response.
+func invoke(ctx context.Context, cfg config.MQConfig, addr string, code int, ext map[string]string, body []byte) (*remotingCommand, error) {
+	ctx, cancel := context.WithTimeout(ctx, remotingTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, cfg, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	cmd := &remotingCommand{
+		Code:      code,
+		Language:  "GO",
+		Version:   remotingVersion,
+		Opaque:    atomic.AddInt32(&remotingOpaque, 1),
+		ExtFields: ext,
+		Body:      body,
+	}
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		signRequest(cmd, cfg.AccessKey, cfg.SecretKey)
+	}
+	if err := writeCommand(conn, cmd); err != nil {
+		return nil, err
+	}
+	return readCommand(conn)
+}
+
+// dial connects to a name server or broker, over TLS when configured. Unlike the RocketMQ
+// client, admin connections verify the server certificate and can present a client certificate.
+func dial(ctx context.Context, cfg config.MQConfig, addr string) (net.Conn, error) {
+	if cfg.TLS == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	tlsConfig, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+	d := tls.Dialer{Config: tlsConfig}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// signRequest adds RocketMQ ACL fields: an HMAC-SHA1 over the ext field values in key
+// order followed by the body.
+func signRequest(cmd *remotingCommand, accessKey, secretKey string) {
+	cmd.ExtFields["AccessKey"] = accessKey
+	keys := make([]string, 0, len(cmd.ExtFields))
+	for k := range cmd.ExtFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	for _, k := range keys {
+		mac.Write([]byte(cmd.ExtFields[k]))
+	}
+	mac.Write(cmd.Body)
+	cmd.ExtFields["Signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func writeCommand(w io.Writer, cmd *remotingCommand) error {
+	header, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 8, 8+len(header)+len(cmd.Body))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(4+len(header)+len(cmd.Body)))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(header))|remotingJSONSerializationType<<24)
+	frame = append(frame, header...)
+	frame = append(frame, cmd.Body...)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readCommand(r io.Reader) (*remotingCommand, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint32(prefix[0:4]))
+	headerLen := int(binary.BigEndian.Uint32(prefix[4:8]) & remotingHeaderSerializeMask)
+	if length > remotingMaxFrame || headerLen > length-4 {
+		return nil, fmt.Errorf("invalid remoting frame")
+	}
+	if prefix[4] != remotingJSONSerializationType {
+		return nil, fmt.Errorf("unsupported remoting serialization type %d", prefix[4])
+	}
+
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	var cmd remotingCommand
+	if err := json.Unmarshal(data[:headerLen], &cmd); err != nil {
+		return nil, fmt.Errorf("invalid remoting header: %w", err)
+	}
+	cmd.Body = data[headerLen:]
+	return &cmd, nil
+}
@@ -0,0 +1,86 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrBufferFull is returned by AsyncSender.Send when too many messages await confirmation.
+var ErrBufferFull = errors.New("async send buffer is full")
+
+// AsyncSender publishes messages without waiting for the broker. At most buffer messages
+// may be unconfirmed; beyond that Send fails fast so callers can push back. Brokers without
+// native asynchronous sends are published to from a goroutine per message.
+type AsyncSender struct {
+	b       Broker
+	slots   chan struct{}
+	onError func(topic string, body []byte, err error)
+	pending sync.WaitGroup
+	failed  atomic.Int64
+}
+
+// NewAsyncSender creates an AsyncSender on top of b. onError is called from a client
+// goroutine for every message the broker did not accept.
+func NewAsyncSender(b Broker, buffer int, onError func(topic string, body []byte, err error)) *AsyncSender {
+	return &AsyncSender{b: b, slots: make(chan struct{}, buffer), onError: onError}
+}
+
+// Send hands the message to the broker client and returns once it is queued for sending.
+func (s *AsyncSender) Send(topic string, body []byte, opts ...SendOption) error {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		return ErrBufferFull
+	}
+	s.pending.Add(1)
+
+	done := func(err error) {
+		if err != nil {
+			s.failed.Add(1)
+			s.onError(topic, body, err)
+		}
+		<-s.slots
+		s.pending.Done()
+	}
+
+	ap, ok := s.b.(AsyncPublisher)
+	if !ok {
+		// The request context ends before the broker answers, so the send gets its own
+		go func() { done(s.b.Publish(context.Background(), topic, body, opts...)) }()
+		return nil
+	}
+	if err := ap.PublishAsync(topic, body, opts, done); err != nil {
+		<-s.slots
+		s.pending.Done()
+		return err
+	}
+	return nil
+}
+
+// Pending returns the number of messages awaiting confirmation.
+func (s *AsyncSender) Pending() int {
+	return len(s.slots)
+}
+
+// Failed returns the number of messages the broker did not accept.
+func (s *AsyncSender) Failed() int64 {
+	return s.failed.Load()
+}
+
+// Flush waits until every pending message is confirmed or ctx ends.
+func (s *AsyncSender) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d async messages unconfirmed: %w", s.Pending(), ctx.Err())
+	}
+}
@@ -0,0 +1,301 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+)
+
+// RetryTimesProperty carries the failed attempts of a message re-published to its topic,
+// by consumers that cannot hand failed messages back to the broker or that hold a message
+// back without counting an attempt. It adds to Delivery.Attempts.
+const RetryTimesProperty = "NOTIFY_RETRY_TIMES"
+
+// Delivery is a message received from a Broker.
+type Delivery struct {
+	Topic string
+	ID    string
+	// Body is the plain body; compressed messages are decompressed before delivery.
+	// Encrypted messages are delivered as they are, see Decrypt.
+	Body []byte
+	// Properties are the properties set by the producer, except CompressionProperty of
+	// decompressed messages.
+	Properties map[string]string
+	// Attempts is the number of earlier deliveries of this message that failed.
+	Attempts int
+	BornTime time.Time
+	// Err is set, to a *DecompressError, when the body can't be decompressed; Body and
+	// Properties are then those of the message as consumed.
+	Err error
+}
+
+// Handler processes a delivery. Returning an error has the message redelivered later,
+// except for the Err of a delivery, which drops the message: the broker deletes it, or
+// dead-letters it if it can (RabbitMQ). Handlers that can't set undecodable messages
+// aside return their Err.
+type Handler func(ctx context.Context, d *Delivery) error
+
+// Broker publishes and consumes messages on a message queue. Consumers of the same
+// mq.group_name share the messages of a topic.
+type Broker interface {
+	// Publish sends a message and returns once the broker has accepted it.
+	Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error
+	// Subscribe has h consume topic. Topics subscribed after Start are consumed right away.
+	Subscribe(topic string, h Handler) error
+	// Start starts consuming the subscribed topics.
+	Start() error
+	// Close stops consuming and closes the connections. Handlers still running may fail to publish.
+	Close() error
+}
+
+// BatchPublisher is implemented by brokers that can send several messages in one request.
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, topic string, msgs []Message) (sent int, err error)
+}
+
+// AsyncPublisher is implemented by brokers that can send without waiting for the broker.
+// done is called exactly once unless PublishAsync itself returns an error.
+type AsyncPublisher interface {
+	PublishAsync(topic string, body []byte, opts []SendOption, done func(error)) error
+}
+
+// NewBroker connects to the broker selected by cfg.Broker. Consumers join cfg.GroupName.
+func NewBroker(cfg config.MQConfig) (Broker, error) {
+	var (
+		b   Broker
+		err error
+	)
+	switch cfg.Broker {
+	case config.BrokerRocketMQ5:
+		b, err = newRocketMQ5Broker(cfg)
+	case config.BrokerKafka:
+		b, err = newKafkaBroker(cfg)
+	case config.BrokerNATS:
+		b, err = newNATSBroker(cfg)
+	case config.BrokerSQS:
+		b, err = newSQSBroker(cfg)
+	case config.BrokerPubSub:
+		b, err = newPubSubBroker(cfg)
+	case config.BrokerRabbitMQ:
+		b, err = newRabbitMQBroker(cfg)
+	case config.BrokerRedis:
+		b, err = newRedisBroker(cfg)
+	case config.BrokerMemory:
+		b = newMemoryBroker(cfg.GroupName, cfg.ConsumeGoroutines)
+	default:
+		// The producer injects the faults of mq.faults itself
+		return newRocketMQBroker(cfg)
+	}
+	if err != nil || cfg.Faults == nil {
+		return b, err
+	}
+	return &faultyBroker{Broker: b, cfg: cfg.Faults}, nil
+}
+
+// PublishBatch sends msgs to topic, in batch requests if b supports them and one by one
+// otherwise. On error, sent reports how many leading messages were accepted.
+func PublishBatch(ctx context.Context, b Broker, topic string, msgs []Message) (sent int, err error) {
+	if bp, ok := b.(BatchPublisher); ok {
+		return bp.PublishBatch(ctx, topic, msgs)
+	}
+	for _, m := range msgs {
+		if err := b.Publish(ctx, topic, m.Body, m.Options...); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// delayLevels are the delays of RocketMQ's message delay levels 1 to 18.
+var delayLevels = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute,
+	3 * time.Minute, 4 * time.Minute, 5 * time.Minute, 6 * time.Minute, 7 * time.Minute, 8 * time.Minute,
+	9 * time.Minute, 10 * time.Minute, 20 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour,
+}
+
+// retryDelays mirror RocketMQ's consumer retry delays (delay level 3 and up) for brokers
+// that redeliver failed messages themselves.
+var retryDelays = delayLevels[2:]
+
+// retryDelay returns how long to wait before redelivering a message that failed attempts+1 times.
+func retryDelay(attempts int) time.Duration {
+	return retryDelays[min(attempts, len(retryDelays)-1)]
+}
+
+// DelayLevel returns the smallest RocketMQ delay level whose delay is at least d,
+// or the highest level (2h) for longer delays.
+func DelayLevel(d time.Duration) int {
+	for i, delay := range delayLevels {
+		if delay >= d {
+			return i + 1
+		}
+	}
+	return len(delayLevels)
+}
+
+// RetryError is returned by a Handler to have the message redelivered no earlier than
+// Delay, e.g. when the target asked to retry later. The regular retry delay still applies
+// when it is longer.
+type RetryError struct {
+	Err   error
+	Delay time.Duration
+	// Hold marks a message held back rather than failed: it is redelivered after Delay
+	// without counting as an attempt.
+	Hold bool
+}
+
+func (e *RetryError) Error() string { return e.Err.Error() }
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// redeliveryDelay returns how long to wait before redelivering a message whose handler
+// failed with err after attempts earlier failures.
+func redeliveryDelay(err error, attempts int) time.Duration {
+	delay := retryDelay(attempts)
+	var retry *RetryError
+	if errors.As(err, &retry) {
+		delay = max(delay, retry.Delay)
+	}
+	return delay
+}
+
+// held returns the delay of a handler error holding the message back (see RetryError.Hold).
+func held(err error) (time.Duration, bool) {
+	var retry *RetryError
+	if errors.As(err, &retry) && retry.Hold {
+		return retry.Delay, true
+	}
+	return 0, false
+}
+
+// newDelivery builds the delivery of a consumed message, decompressing its body unless it
+// is encrypted. A body that can't be decompressed is left as it is, see Delivery.Err.
+func newDelivery(topic, id string, body []byte, props map[string]string, attempts int, born time.Time) *Delivery {
+	encrypted := Encrypted(props)
+	plain := body
+	var decodeErr error
+	if !encrypted {
+		var err error
+		if plain, err = decompress(props[CompressionProperty], body); err != nil {
+			plain, decodeErr = body, err
+		}
+	}
+	if n, err := strconv.Atoi(props[RetryTimesProperty]); err == nil {
+		attempts += n
+	}
+	out := make(map[string]string, len(props))
+	for k, v := range props {
+		if k != CompressionProperty || encrypted || decodeErr != nil {
+			out[k] = v
+		}
+	}
+	return &Delivery{Topic: topic, ID: id, Body: plain, Properties: out, Attempts: attempts, BornTime: born, Err: decodeErr}
+}
+
+// dropped reports whether the handler of d dropped it, returning its Err.
+func dropped(d *Delivery, err error) bool {
+	if d.Err == nil || !errors.Is(err, d.Err) {
+		return false
+	}
+	log.Printf("[MQ] Dropping undecodable message %s: %v", d.ID, d.Err)
+	return true
+}
+
+// WithProperties sets message properties, e.g. to carry those of a consumed message over.
+// Properties set by earlier options are kept unless props replaces them.
+func WithProperties(props map[string]string) SendOption {
+	return func(msg *primitive.Message) error {
+		for k, v := range props {
+			msg.WithProperty(k, v)
+		}
+		return nil
+	}
+}
+
+// Ping checks that the configured broker accepts TCP connections. The in-memory broker
+// is always available, as is SQS without a region or endpoint, which the AWS SDK
+// resolves itself.
+func Ping(ctx context.Context, cfg config.MQConfig) error {
+	addr, name := cfg.NameServer, "name server"
+	switch cfg.Broker {
+	case config.BrokerRocketMQ5:
+		addr, name = cfg.RocketMQ5.Endpoint, "rocketmq proxy"
+	case config.BrokerKafka:
+		addr, name = cfg.Kafka.Brokers[0], "kafka broker"
+	case config.BrokerNATS:
+		addr, name = natsAddr(cfg.NATS.URL), "nats server"
+	case config.BrokerSQS:
+		addr, name = sqsAddr(cfg.SQS), "sqs endpoint"
+		if addr == "" {
+			return nil
+		}
+	case config.BrokerPubSub:
+		addr, name = pubSubAddr(), "pubsub endpoint"
+	case config.BrokerRabbitMQ:
+		addr, name = rabbitMQAddr(cfg.RabbitMQ.URL), "rabbitmq server"
+	case config.BrokerRedis:
+		addr, name = cfg.Redis.Addr, "redis server"
+	case config.BrokerMemory:
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%s %s unreachable: %w", name, addr, err)
+	}
+	return conn.Close()
+}
+
+// natsAddr returns host:port of the first server of a NATS URL list.
+func natsAddr(urls string) string {
+	first, _, _ := strings.Cut(urls, ",")
+	u, err := url.Parse(strings.TrimSpace(first))
+	if err != nil || u.Host == "" {
+		return first
+	}
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return u.Host
+}
+
+// sqsAddr returns host:port of the SQS endpoint, or of the regional endpoint; it is empty
+// when the region is left to the AWS environment.
+func sqsAddr(cfg *config.SQSConfig) string {
+	if cfg.Endpoint == "" {
+		if cfg.Region == "" {
+			return ""
+		}
+		return net.JoinHostPort("sqs."+cfg.Region+".amazonaws.com", "443")
+	}
+	u, _ := url.Parse(cfg.Endpoint) // validated by the config
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+	return net.JoinHostPort(u.Hostname(), "443")
+}
+
+// pubSubAddr returns host:port of the Pub/Sub emulator set by PUBSUB_EMULATOR_HOST, or of
+// the Pub/Sub API.
+func pubSubAddr() string {
+	if addr := os.Getenv("PUBSUB_EMULATOR_HOST"); addr != "" {
+		return addr
+	}
+	return "pubsub.googleapis.com:443"
+}
@@ -0,0 +1,94 @@
+package mq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/klauspost/compress/zstd"
+
+	"notification-system/pkg/config"
+)
+
+// CompressionProperty names the algorithm a message body was compressed with.
+// Messages without it carry the plain body.
+const CompressionProperty = "NOTIFY_COMPRESSION"
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compress replaces the body of msg with its compressed form and flags the algorithm.
+func compress(msg *primitive.Message, algorithm string) error {
+	var body []byte
+	switch algorithm {
+	case config.CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(msg.Body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	case config.CompressionZstd:
+		body = zstdEncoder.EncodeAll(msg.Body, nil)
+	default:
+		return fmt.Errorf("unknown compression '%s'", algorithm)
+	}
+	msg.Body = body
+	msg.WithProperty(CompressionProperty, algorithm)
+	return nil
+}
+
+// Body returns the plain body of a consumed message, decompressing it if the producer
+// compressed it. Encrypted bodies are returned as they are, see Decrypt. The message
+// itself is left untouched so it can be re-published as is.
+func Body(msg *primitive.MessageExt) ([]byte, error) {
+	if msg.GetProperty(EncryptionProperty) != "" {
+		return msg.Body, nil
+	}
+	return decompress(msg.GetProperty(CompressionProperty), msg.Body)
+}
+
+// DecompressError is returned for a body that can't be decompressed, e.g. because it was
+// truncated or flagged with an unknown algorithm.
+type DecompressError struct {
+	Algorithm string
+	Err       error
+}
+
+func (e *DecompressError) Error() string {
+	return fmt.Sprintf("failed to decompress %s body: %v", e.Algorithm, e.Err)
+}
+
+func (e *DecompressError) Unwrap() error { return e.Err }
+
+// decompress reverses compress for a body flagged with algorithm. Errors are
+// *DecompressError.
+func decompress(algorithm string, body []byte) ([]byte, error) {
+	var plain []byte
+	var err error
+	switch algorithm {
+	case "":
+		return body, nil
+	case config.CompressionGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+			defer zr.Close()
+			plain, err = io.ReadAll(zr)
+		}
+	case config.CompressionZstd:
+		plain, err = zstdDecoder.DecodeAll(body, nil)
+	default:
+		err = fmt.Errorf("unknown compression")
+	}
+	if err != nil {
+		return nil, &DecompressError{Algorithm: algorithm, Err: err}
+	}
+	return plain, nil
+}
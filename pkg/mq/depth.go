@@ -0,0 +1,346 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+
+	"notification-system/pkg/config"
+)
+
+// RocketMQ remoting codes for reading queue offsets.
+const (
+	reqQueryConsumerOffset = 14
+	reqGetMaxOffset        = 30
+	reqGetMinOffset        = 31
+	respTopicNotExist      = 17
+	respQueryNotFound      = 22
+)
+
+// errTopicNotExist is returned by topicRoute for topics the name server doesn't know.
+var errTopicNotExist = errors.New("topic does not exist")
+
+// errNoOffsets is returned for brokers whose queue offsets can't be read.
+var errNoOffsets = errors.New("queue offsets are not available through the RocketMQ 5 proxy")
+
+// errNoBacklog is returned for Pub/Sub, whose backlog is only reported to Cloud Monitoring.
+var errNoBacklog = errors.New("the Pub/Sub backlog is only available in Cloud Monitoring")
+
+// TopicDepth returns the number of messages retained on topic: the messages between the
+// first and last offset of every queue (RocketMQ), partition (Kafka), stored on the
+// subject (NATS) or stream (Redis), in the group's queue, in flight included (SQS), or
+// ready in the group's queue (RabbitMQ). For the in-memory broker it is the number of
+// messages not yet consumed by the slowest consumer group. Topics that don't exist have a
+// depth of 0.
+func TopicDepth(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	switch cfg.Broker {
+	case config.BrokerRocketMQ5:
+		return 0, errNoOffsets
+	case config.BrokerKafka:
+		return kafkaTopicDepth(ctx, cfg, topic)
+	case config.BrokerNATS:
+		return natsTopicDepth(cfg, topic)
+	case config.BrokerSQS:
+		return sqsQueueDepth(ctx, cfg, topic, true)
+	case config.BrokerPubSub:
+		return 0, errNoBacklog
+	case config.BrokerRabbitMQ:
+		return rabbitMQQueueDepth(cfg, topic)
+	case config.BrokerRedis:
+		return redisTopicDepth(ctx, cfg, topic)
+	case config.BrokerMemory:
+		return hub.depth(topic), nil
+	}
+	return rocketMQTopicDepth(ctx, cfg, topic)
+}
+
+func rocketMQTopicDepth(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	topic = withNamespace(cfg, topic)
+	brokers, err := topicRoute(ctx, cfg, topic)
+	if errors.Is(err, errTopicNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var depth int64
+	for _, b := range brokers {
+		for q := 0; q < b.queues; q++ {
+			first, err := queueOffset(ctx, cfg, b.addr, reqGetMinOffset, topic, q)
+			if err != nil {
+				return 0, fmt.Errorf("broker %s queue %d: %w", b.addr, q, err)
+			}
+			last, err := queueOffset(ctx, cfg, b.addr, reqGetMaxOffset, topic, q)
+			if err != nil {
+				return 0, fmt.Errorf("broker %s queue %d: %w", b.addr, q, err)
+			}
+			depth += last - first
+		}
+	}
+	return depth, nil
+}
+
+// queueOffset reads the min or max offset of a queue.
+func queueOffset(ctx context.Context, cfg config.MQConfig, addr string, code int, topic string, queueID int) (int64, error) {
+	resp, err := invoke(ctx, cfg, addr, code, map[string]string{
+		"topic":   topic,
+		"queueId": strconv.Itoa(queueID),
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Code != remotingResponseSuccess {
+		return 0, fmt.Errorf("get offset failed (code %d): %s", resp.Code, resp.Remark)
+	}
+	return strconv.ParseInt(resp.ExtFields["offset"], 10, 64)
+}
+
+func kafkaTopicDepth(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newKafkaBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	partitions, err := b.dialer.LookupPartitions(ctx, "tcp", cfg.Kafka.Brokers[0], topic)
+	if errors.Is(err, kafka.UnknownTopicOrPartition) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var depth int64
+	for _, p := range partitions {
+		addr := net.JoinHostPort(p.Leader.Host, strconv.Itoa(p.Leader.Port))
+		conn, err := b.dialer.DialLeader(ctx, "tcp", addr, topic, p.ID)
+		if err != nil {
+			return 0, fmt.Errorf("partition %d: %w", p.ID, err)
+		}
+		first, last, err := conn.ReadOffsets()
+		conn.Close()
+		if err != nil {
+			return 0, fmt.Errorf("partition %d: %w", p.ID, err)
+		}
+		depth += last - first
+	}
+	return depth, nil
+}
+
+func natsTopicDepth(cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newNATSBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	stream, err := b.js.StreamNameBySubject(topic)
+	if errors.Is(err, nats.ErrNoMatchingStream) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	info, err := b.js.StreamInfo(stream, &nats.StreamInfoRequest{SubjectsFilter: topic})
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.State.Subjects[topic]), nil
+}
+
+// GroupLag returns the number of messages on topic that consumer group cfg.GroupName
+// has not consumed yet: the messages after the group's committed offset of every queue
+// (RocketMQ) or partition (Kafka), or after the first message where it has none, the
+// messages pending for its durable consumer (NATS), not read or acknowledged by it
+// (Redis) or waiting in its queue (SQS, RabbitMQ and in-memory broker). Topics that don't
+// exist have no lag.
+func GroupLag(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	switch cfg.Broker {
+	case config.BrokerRocketMQ5:
+		return 0, errNoOffsets
+	case config.BrokerKafka:
+		return kafkaGroupLag(ctx, cfg, topic)
+	case config.BrokerNATS:
+		return natsGroupLag(cfg, topic)
+	case config.BrokerSQS:
+		return sqsQueueDepth(ctx, cfg, topic, false)
+	case config.BrokerPubSub:
+		return 0, errNoBacklog
+	case config.BrokerRabbitMQ:
+		return rabbitMQQueueDepth(cfg, topic)
+	case config.BrokerRedis:
+		return redisGroupLag(ctx, cfg, topic)
+	case config.BrokerMemory:
+		return hub.groupLag(topic, cfg.GroupName), nil
+	}
+	return rocketMQGroupLag(ctx, cfg, topic)
+}
+
+func rocketMQGroupLag(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	topic = withNamespace(cfg, topic)
+	group := withNamespace(cfg, cfg.GroupName)
+	brokers, err := topicRoute(ctx, cfg, topic)
+	if errors.Is(err, errTopicNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var lag int64
+	for _, b := range brokers {
+		for q := 0; q < b.queues; q++ {
+			first, err := queueOffset(ctx, cfg, b.addr, reqGetMinOffset, topic, q)
+			if err != nil {
+				return 0, fmt.Errorf("broker %s queue %d: %w", b.addr, q, err)
+			}
+			last, err := queueOffset(ctx, cfg, b.addr, reqGetMaxOffset, topic, q)
+			if err != nil {
+				return 0, fmt.Errorf("broker %s queue %d: %w", b.addr, q, err)
+			}
+			consumed, err := consumerOffset(ctx, cfg, b.addr, group, topic, q)
+			if err != nil {
+				return 0, fmt.Errorf("broker %s queue %d: %w", b.addr, q, err)
+			}
+			lag += last - max(consumed, first)
+		}
+	}
+	return lag, nil
+}
+
+// consumerOffset reads the offset group committed for a queue, or -1 if it has none.
+func consumerOffset(ctx context.Context, cfg config.MQConfig, addr, group, topic string, queueID int) (int64, error) {
+	resp, err := invoke(ctx, cfg, addr, reqQueryConsumerOffset, map[string]string{
+		"consumerGroup": group,
+		"topic":         topic,
+		"queueId":       strconv.Itoa(queueID),
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	switch resp.Code {
+	case remotingResponseSuccess:
+		return strconv.ParseInt(resp.ExtFields["offset"], 10, 64)
+	case respQueryNotFound:
+		return -1, nil
+	}
+	return 0, fmt.Errorf("query consumer offset failed (code %d): %s", resp.Code, resp.Remark)
+}
+
+func kafkaGroupLag(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newKafkaBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	partitions, err := b.dialer.LookupPartitions(ctx, "tcp", cfg.Kafka.Brokers[0], topic)
+	if errors.Is(err, kafka.UnknownTopicOrPartition) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]int, len(partitions))
+	for i, p := range partitions {
+		ids[i] = p.ID
+	}
+	client := &kafka.Client{Addr: kafka.TCP(cfg.Kafka.Brokers...), Transport: b.writer.Transport}
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: cfg.GroupName, Topics: map[string][]int{topic: ids}})
+	if err != nil {
+		return 0, err
+	}
+	if committed.Error != nil {
+		return 0, committed.Error
+	}
+	consumed := make(map[int]int64, len(ids))
+	for _, p := range committed.Topics[topic] {
+		if p.Error != nil {
+			return 0, fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		consumed[p.Partition] = p.CommittedOffset
+	}
+
+	var lag int64
+	for _, p := range partitions {
+		addr := net.JoinHostPort(p.Leader.Host, strconv.Itoa(p.Leader.Port))
+		conn, err := b.dialer.DialLeader(ctx, "tcp", addr, topic, p.ID)
+		if err != nil {
+			return 0, fmt.Errorf("partition %d: %w", p.ID, err)
+		}
+		first, last, err := conn.ReadOffsets()
+		conn.Close()
+		if err != nil {
+			return 0, fmt.Errorf("partition %d: %w", p.ID, err)
+		}
+		offset, ok := consumed[p.ID]
+		if !ok {
+			offset = -1
+		}
+		lag += last - max(offset, first)
+	}
+	return lag, nil
+}
+
+func natsGroupLag(cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newNATSBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	stream, err := b.js.StreamNameBySubject(topic)
+	if errors.Is(err, nats.ErrNoMatchingStream) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	info, err := b.js.ConsumerInfo(stream, durableName(cfg, topic))
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		// Like a group without offsets, the consumer will start at the first message
+		return natsTopicDepth(cfg, topic)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.NumPending), nil
+}
+
+// groupLag returns the messages of topic waiting in the queue of group, or in the backlog
+// before the group joined.
+func (h *memoryHub) groupLag(name, group string) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[name]
+	if !ok {
+		return 0
+	}
+	if q, ok := t.groups[group]; ok {
+		return int64(len(q))
+	}
+	return int64(len(t.backlog))
+}
+
+// depth returns the messages of topic waiting in the backlog or the fullest group queue.
+func (h *memoryHub) depth(name string) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[name]
+	if !ok {
+		return 0
+	}
+	n := len(t.backlog)
+	for _, q := range t.groups {
+		n = max(n, len(q))
+	}
+	return int64(n)
+}
@@ -0,0 +1,94 @@
+package mq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"google.golang.org/protobuf/proto"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/eventpb"
+)
+
+// ContentTypeProperty names the encoding of an event in a message body. Messages without
+// it carry JSON.
+const ContentTypeProperty = "NOTIFY_CONTENT_TYPE"
+
+// ContentTypeProtobuf flags a body holding an eventpb.Event.
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// EncodeEvent serializes evt as the message body for encoding (see config.MQConfig.Encoding).
+// Send the body with WithEncoding(encoding) so consumers can decode it.
+func EncodeEvent(evt event.Event, encoding string) ([]byte, error) {
+	if encoding != config.EncodingProtobuf {
+		return json.Marshal(evt)
+	}
+	pe, err := eventpb.FromEvent(evt)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(pe)
+}
+
+// WithEncoding flags the content type of a body encoded by EncodeEvent.
+func WithEncoding(encoding string) SendOption {
+	return func(msg *primitive.Message) error {
+		if encoding == config.EncodingProtobuf {
+			msg.WithProperty(ContentTypeProperty, ContentTypeProtobuf)
+		}
+		return nil
+	}
+}
+
+// DecodeEvent parses a message body carrying an event, given the ContentTypeProperty of
+// the message. An empty content type is told from the body itself: JSON events start
+// with '{', which no protobuf event does.
+func DecodeEvent(body []byte, contentType string) (event.Event, error) {
+	if trimmed := bytes.TrimLeft(body, " \t\r\n"); contentType == "" && len(trimmed) > 0 && trimmed[0] != '{' {
+		contentType = ContentTypeProtobuf
+	}
+	switch contentType {
+	case "", "application/json":
+		var evt event.Event
+		err := json.Unmarshal(body, &evt)
+		return evt, err
+	case ContentTypeProtobuf:
+		var pe eventpb.Event
+		if err := proto.Unmarshal(body, &pe); err != nil {
+			return event.Event{}, err
+		}
+		return eventpb.ToEvent(&pe), nil
+	default:
+		return event.Event{}, fmt.Errorf("unknown content type '%s'", contentType)
+	}
+}
+
+// DecodeEnvelope is DecodeEvent for JSON events that leaves their data undecoded in
+// RawData, so templates can read the few fields they need without building the whole
+// map. Events of other encodings are decoded in full.
+func DecodeEnvelope(body []byte, contentType string) (event.Event, error) {
+	if contentType != "" && contentType != "application/json" {
+		return DecodeEvent(body, contentType)
+	}
+	if trimmed := bytes.TrimLeft(body, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '{' {
+		return DecodeEvent(body, contentType)
+	}
+	var envelope struct {
+		event.Event
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return event.Event{}, err
+	}
+	evt := envelope.Event
+	if len(envelope.Data) > 0 && string(envelope.Data) != "null" {
+		if envelope.Data[0] != '{' {
+			return evt, fmt.Errorf("event data is not an object")
+		}
+		evt.RawData = envelope.Data
+	}
+	return evt, nil
+}
@@ -0,0 +1,113 @@
+package mq
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+)
+
+// EncryptionProperty names the cipher a message body was encrypted with. The body is the
+// nonce followed by the sealed, possibly compressed, plain body. Consumers leave such
+// bodies as they are, see Decrypt.
+const EncryptionProperty = "NOTIFY_ENCRYPTION"
+
+// CipherAES256GCM is the value of EncryptionProperty.
+const CipherAES256GCM = "AES-256-GCM"
+
+// Keyring supplies the keys of encrypted messages (see secrets.Keyring).
+type Keyring interface {
+	// EncryptionKey returns the key to encrypt a message with and the properties that
+	// identify it to DecryptionKey.
+	EncryptionKey(ctx context.Context, cfg *config.EncryptionConfig) ([]byte, map[string]string, error)
+	// DecryptionKey returns the key of a message with the given properties.
+	DecryptionKey(ctx context.Context, cfg *config.EncryptionConfig, props map[string]string) ([]byte, error)
+}
+
+// KeyError reports that the key of a message could not be obtained, which may succeed
+// when retried.
+type KeyError struct {
+	Err error
+}
+
+func (e *KeyError) Error() string { return e.Err.Error() }
+
+func (e *KeyError) Unwrap() error { return e.Err }
+
+// WithEncryption encrypts the body with a key of kr as configured by cfg (nil disables
+// encryption). It must follow the options that set the body, such as WithCompression.
+// Messages that are already encrypted, e.g. when moved to the DLQ, are left as they are.
+func WithEncryption(ctx context.Context, kr Keyring, cfg *config.EncryptionConfig) SendOption {
+	return func(msg *primitive.Message) error {
+		if cfg == nil || msg.GetProperty(EncryptionProperty) != "" {
+			return nil
+		}
+		key, props, err := kr.EncryptionKey(ctx, cfg)
+		if err != nil {
+			return &KeyError{fmt.Errorf("failed to get encryption key: %w", err)}
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(msg.Body)+gcm.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		msg.Body = gcm.Seal(nonce, nonce, msg.Body, nil)
+		for k, v := range props {
+			msg.WithProperty(k, v)
+		}
+		msg.WithProperty(EncryptionProperty, CipherAES256GCM)
+		return nil
+	}
+}
+
+// Encrypted reports whether a message with the given properties has an encrypted body.
+func Encrypted(props map[string]string) bool {
+	return props[EncryptionProperty] != ""
+}
+
+// Decrypt returns the plain body of a consumed message with the given properties,
+// decrypting and decompressing it if it was encrypted. A *KeyError means the key is
+// unavailable for now; other errors mean the body can't be decrypted with it.
+func Decrypt(ctx context.Context, kr Keyring, cfg *config.EncryptionConfig, body []byte, props map[string]string) ([]byte, error) {
+	if !Encrypted(props) {
+		return body, nil
+	}
+	if props[EncryptionProperty] != CipherAES256GCM {
+		return nil, fmt.Errorf("unknown cipher '%s'", props[EncryptionProperty])
+	}
+	key, err := kr.DecryptionKey(ctx, cfg, props)
+	if err != nil {
+		return nil, &KeyError{fmt.Errorf("failed to get decryption key: %w", err)}
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted body is too short")
+	}
+	plain, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt body: %w", err)
+	}
+	return decompress(props[CompressionProperty], plain)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 256 bits, got %d", len(key)*8)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
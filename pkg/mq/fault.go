@@ -0,0 +1,43 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+)
+
+// ErrInjectedFault is returned by sends failed by mq.faults.
+var ErrInjectedFault = errors.New("injected send error")
+
+// injectFault reports whether a send should fail according to cfg.
+func injectFault(cfg *config.MQFaultConfig) bool {
+	return cfg != nil && rand.Float64() < cfg.SendErrorRate
+}
+
+// faultInterceptor fails RocketMQ sends as configured by mq.faults.
+func faultInterceptor(cfg *config.MQFaultConfig) primitive.Interceptor {
+	return func(ctx context.Context, req, reply interface{}, next primitive.Invoker) error {
+		if injectFault(cfg) {
+			return ErrInjectedFault
+		}
+		return next(ctx, req, reply)
+	}
+}
+
+// faultyBroker fails publishes of the brokers that don't use a RocketMQ producer as
+// configured by mq.faults.
+type faultyBroker struct {
+	Broker
+	cfg *config.MQFaultConfig
+}
+
+func (b *faultyBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	if injectFault(b.cfg) {
+		return ErrInjectedFault
+	}
+	return b.Broker.Publish(ctx, topic, body, opts...)
+}
@@ -0,0 +1,233 @@
+package mq
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+
+	"notification-system/pkg/config"
+)
+
+// kafkaBroker publishes to Kafka topics and consumes them in a consumer group. Kafka has
+// no redelivery of single messages, so a failed message is appended to its topic again
+// with an incremented RetryTimesProperty header and the original offset is committed.
+//
+// The partitions of a topic are consumed one message at a time in offset order; consumers
+// scale out up to the number of partitions.
+type kafkaBroker struct {
+	cfg    config.MQConfig
+	writer *kafka.Writer
+	dialer *kafka.Dialer
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	readers  []*kafka.Reader
+	ctx      context.Context // set by Start
+	cancel   context.CancelFunc
+}
+
+func newKafkaBroker(cfg config.MQConfig) (*kafkaBroker, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		if tlsConfig, err = cfg.TLS.Load(); err != nil {
+			return nil, err
+		}
+	}
+	var mechanism sasl.Mechanism
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		mechanism = plain.Mechanism{Username: cfg.AccessKey, Password: cfg.SecretKey}
+	}
+
+	return &kafkaBroker{
+		cfg: cfg,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Kafka.Brokers...),
+			Balancer:               &kafka.Hash{},
+			MaxAttempts:            cfg.SendRetries + 1,
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           10 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+			Transport:              &kafka.Transport{TLS: tlsConfig, SASL: mechanism, ClientID: cfg.InstanceName},
+		},
+		dialer: &kafka.Dialer{
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+			ClientID:      cfg.InstanceName,
+		},
+		handlers: make(map[string]Handler),
+	}, nil
+}
+
+// kafkaMessage converts a message built by the send options. The sharding key becomes
+// the record key, so records with the same key land on the same partition.
+func kafkaMessage(topic string, body []byte, opts []SendOption) (kafka.Message, error) {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	km := kafka.Message{Topic: topic, Value: msg.Body}
+	if key := msg.GetShardingKey(); key != "" {
+		km.Key = []byte(key)
+	}
+	for k, v := range msg.GetProperties() {
+		km.Headers = append(km.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return km, nil
+}
+
+func (b *kafkaBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := kafkaMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	return b.writer.WriteMessages(ctx, msg)
+}
+
+// PublishBatch writes msgs in one call; the writer groups them into produce requests per partition.
+func (b *kafkaBroker) PublishBatch(ctx context.Context, topic string, msgs []Message) (int, error) {
+	batch := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		msg, err := kafkaMessage(topic, m.Body, m.Options)
+		if err != nil {
+			return 0, err
+		}
+		batch = append(batch, msg)
+	}
+	if err := b.writer.WriteMessages(ctx, batch...); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+func (b *kafkaBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.ctx != nil {
+		b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *kafkaBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	for topic, h := range b.handlers {
+		b.consume(topic, h)
+	}
+	return nil
+}
+
+// consume starts a reader for topic. Callers hold b.mu.
+func (b *kafkaBroker) consume(topic string, h Handler) {
+	// Where a group without committed offsets starts; timestamps are not supported by the reader
+	start := kafka.LastOffset
+	if b.cfg.ConsumeFrom == config.ConsumeFromFirst {
+		start = kafka.FirstOffset
+	}
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     b.cfg.Kafka.Brokers,
+		GroupID:     b.cfg.GroupName,
+		Topic:       topic,
+		Dialer:      b.dialer,
+		StartOffset: start,
+	})
+	b.readers = append(b.readers, r)
+
+	go b.read(b.ctx, r, h)
+}
+
+func (b *kafkaBroker) read(ctx context.Context, r *kafka.Reader, h Handler) {
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+			log.Printf("[MQ] Failed to fetch from topic %s: %v", r.Config().Topic, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		props := make(map[string]string, len(m.Headers))
+		for _, hdr := range m.Headers {
+			props[hdr.Key] = string(hdr.Value)
+		}
+		attempts, _ := strconv.Atoi(props[RetryTimesProperty])
+		id := fmt.Sprintf("%d-%d", m.Partition, m.Offset)
+
+		// newDelivery counts the attempts carried by RetryTimesProperty
+		d := newDelivery(m.Topic, id, m.Value, props, 0, m.Time)
+		if err := h(ctx, d); err != nil && !dropped(d, err) {
+			retries := attempts + 1
+			if delay, ok := held(err); ok {
+				// Kafka can't delay a message: wait here, holding back the rest of the
+				// partition, then re-publish it without counting the attempt
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				retries = attempts
+			}
+			// Committing a later offset would skip the message, so the retry must be stored first
+			for err := b.retry(m, retries); err != nil; err = b.retry(m, retries) {
+				log.Printf("[MQ] Failed to re-publish message %s for retry: %v", id, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}
+		if err := r.CommitMessages(context.Background(), m); err != nil {
+			log.Printf("[MQ] Failed to commit message %s: %v", id, err)
+		}
+	}
+}
+
+// retry appends m to its topic again, flagged with its retry count.
+func (b *kafkaBroker) retry(m kafka.Message, retries int) error {
+	out := kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value}
+	for _, hdr := range m.Headers {
+		if hdr.Key != RetryTimesProperty {
+			out.Headers = append(out.Headers, hdr)
+		}
+	}
+	out.Headers = append(out.Headers, kafka.Header{Key: RetryTimesProperty, Value: []byte(strconv.Itoa(retries))})
+	return b.writer.WriteMessages(context.Background(), out)
+}
+
+func (b *kafkaBroker) Close() error {
+	b.mu.Lock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+	var errs []error
+	for _, r := range b.readers {
+		errs = append(errs, r.Close())
+	}
+	b.mu.Unlock()
+
+	errs = append(errs, b.writer.Close())
+	return errors.Join(errs...)
+}
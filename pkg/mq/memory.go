@@ -0,0 +1,201 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memoryQueueSize bounds the messages waiting in one consumer group of a topic; Publish
+// blocks while it is full.
+const memoryQueueSize = 10000
+
+// memoryMessage is a message held by the in-memory broker.
+type memoryMessage struct {
+	id       string
+	topic    string
+	body     []byte
+	props    map[string]string
+	attempts int
+	born     time.Time
+}
+
+// memoryHub holds the topics of the in-memory broker. It is shared by every memory broker
+// of the process, so an API and a worker running in one binary exchange messages.
+type memoryHub struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	nextID atomic.Int64
+}
+
+type memoryTopic struct {
+	// groups holds a queue per consumer group; each group receives every message
+	groups map[string]chan *memoryMessage
+	// backlog keeps messages published before any group subscribed, for the first one that does
+	backlog []*memoryMessage
+}
+
+var hub = &memoryHub{topics: make(map[string]*memoryTopic)}
+
+func (h *memoryHub) topic(name string) *memoryTopic {
+	t, ok := h.topics[name]
+	if !ok {
+		t = &memoryTopic{groups: make(map[string]chan *memoryMessage)}
+		h.topics[name] = t
+	}
+	return t
+}
+
+// queues returns the queues msg must be put on, or nil after keeping it in the backlog.
+func (h *memoryHub) queues(msg *memoryMessage) []chan *memoryMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(msg.topic)
+	if len(t.groups) == 0 {
+		if len(t.backlog) >= memoryQueueSize {
+			t.backlog = t.backlog[1:]
+		}
+		t.backlog = append(t.backlog, msg)
+		return nil
+	}
+	queues := make([]chan *memoryMessage, 0, len(t.groups))
+	for _, q := range t.groups {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// join returns the queue of group on topic, handing it the backlog if it is the first group.
+func (h *memoryHub) join(topic, group string) chan *memoryMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(topic)
+	if q, ok := t.groups[group]; ok {
+		return q
+	}
+	q := make(chan *memoryMessage, memoryQueueSize)
+	for _, msg := range t.backlog {
+		q <- msg
+	}
+	t.backlog = nil
+	t.groups[group] = q
+	return q
+}
+
+// memoryBroker passes messages through process memory. It needs no message queue, which
+// suits local development and integration tests, but messages are lost when the process
+// exits. Failed messages are redelivered after RocketMQ-like delays.
+type memoryBroker struct {
+	group      string
+	goroutines int
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	ctx      context.Context // set by Start
+	cancel   context.CancelFunc
+}
+
+func newMemoryBroker(group string, goroutines int) *memoryBroker {
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	return &memoryBroker{group: group, goroutines: goroutines, handlers: make(map[string]Handler)}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	m := &memoryMessage{
+		id:    strconv.FormatInt(hub.nextID.Add(1), 10),
+		topic: topic,
+		body:  msg.Body,
+		props: msg.GetProperties(),
+		born:  time.Now(),
+	}
+	for _, q := range hub.queues(m) {
+		select {
+		case q <- m:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.ctx != nil {
+		b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	for topic, h := range b.handlers {
+		b.consume(topic, h)
+	}
+	return nil
+}
+
+// consume starts the goroutines handling topic. Callers hold b.mu.
+func (b *memoryBroker) consume(topic string, h Handler) {
+	q := hub.join(topic, b.group)
+	for i := 0; i < b.goroutines; i++ {
+		go b.run(b.ctx, q, h)
+	}
+}
+
+func (b *memoryBroker) run(ctx context.Context, q chan *memoryMessage, h Handler) {
+	for {
+		select {
+		case m := <-q:
+			b.handle(ctx, q, m, h)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *memoryBroker) handle(ctx context.Context, q chan *memoryMessage, m *memoryMessage, h Handler) {
+	d := newDelivery(m.topic, m.id, m.body, m.props, m.attempts, m.born)
+	err := h(ctx, d)
+	if err == nil || dropped(d, err) {
+		return
+	}
+
+	retry := *m
+	delay, hold := held(err)
+	if !hold {
+		retry.attempts++
+		delay = redeliveryDelay(err, m.attempts)
+	}
+	time.AfterFunc(delay, func() { q <- &retry })
+}
+
+// Close stops consuming. Queued messages stay in memory for other consumers of the group.
+func (b *memoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cancel != nil {
+		b.cancel()
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package mq
+
+import (
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/event"
+)
+
+// Properties carrying the metadata of an event, so consumers and broker tooling can
+// filter and trace messages without decoding their bodies. Each key of Event.Metadata is
+// a MetadataPropertyPrefix property.
+const (
+	SourceProperty         = "NOTIFY_SOURCE"
+	CorrelationIDProperty  = "NOTIFY_CORRELATION_ID"
+	TraceIDProperty        = "NOTIFY_TRACE_ID"
+	MetadataPropertyPrefix = "NOTIFY_META_"
+)
+
+// WithEventMetadata sets the metadata properties of evt.
+func WithEventMetadata(evt event.Event) SendOption {
+	return func(msg *primitive.Message) error {
+		for k, v := range map[string]string{
+			SourceProperty:        evt.Source,
+			CorrelationIDProperty: evt.CorrelationID,
+			TraceIDProperty:       evt.TraceID,
+		} {
+			if v != "" {
+				msg.WithProperty(k, v)
+			}
+		}
+		for k, v := range evt.Metadata {
+			msg.WithProperty(MetadataPropertyPrefix+k, v)
+		}
+		return nil
+	}
+}
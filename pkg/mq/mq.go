@@ -2,24 +2,42 @@ package mq
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/apache/rocketmq-client-go/v2"
 	"github.com/apache/rocketmq-client-go/v2/consumer"
 	"github.com/apache/rocketmq-client-go/v2/primitive"
 	"github.com/apache/rocketmq-client-go/v2/producer"
+
+	"notification-system/pkg/config"
 )
 
-// NewProducer creates and starts a RocketMQ producer.
-func NewProducer(endpoint, accessKey, secretKey string) (rocketmq.Producer, error) {
+// NewProducer creates and starts a RocketMQ producer. Messages with a sharding key are
+// routed to a queue by hashing the key; others are spread randomly.
+func NewProducer(cfg config.MQConfig) (rocketmq.Producer, error) {
 	opts := []producer.Option{
-		producer.WithNsResolver(primitive.NewPassthroughResolver([]string{endpoint})),
-		producer.WithRetry(2),
+		producer.WithNsResolver(primitive.NewPassthroughResolver([]string{cfg.NameServer})),
+		producer.WithRetry(cfg.SendRetries),
+		producer.WithQueueSelector(producer.NewHashQueueSelector()),
 	}
 
-	if accessKey != "" && secretKey != "" {
+	if cfg.Namespace != "" {
+		opts = append(opts, producer.WithNamespace(cfg.Namespace))
+	}
+	if cfg.InstanceName != "" {
+		opts = append(opts, producer.WithInstanceName(cfg.InstanceName))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, producer.WithTls(true))
+	}
+	if cfg.Faults != nil {
+		opts = append(opts, producer.WithInterceptor(faultInterceptor(cfg.Faults)))
+	}
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
 		opts = append(opts, producer.WithCredentials(primitive.Credentials{
-			AccessKey: accessKey,
-			SecretKey: secretKey,
+			AccessKey: cfg.AccessKey,
+			SecretKey: cfg.SecretKey,
 		}))
 	}
 
@@ -37,18 +55,24 @@ func NewProducer(endpoint, accessKey, secretKey string) (rocketmq.Producer, erro
 
 // NewPushConsumer creates and starts a RocketMQ push consumer.
 // Note: You must call Subscribe and then Start on the returned consumer.
-func NewPushConsumer(endpoint, accessKey, secretKey, groupName string) (rocketmq.PushConsumer, error) {
-	opts := []consumer.Option{
-		consumer.WithNsResolver(primitive.NewPassthroughResolver([]string{endpoint})),
-		consumer.WithGroupName(groupName),
-		consumer.WithConsumeFromWhere(consumer.ConsumeFromLastOffset),
+func NewPushConsumer(cfg config.MQConfig) (rocketmq.PushConsumer, error) {
+	opts, err := consumerOptions(cfg, cfg.GroupName)
+	if err != nil {
+		return nil, err
 	}
 
-	if accessKey != "" && secretKey != "" {
-		opts = append(opts, consumer.WithCredentials(primitive.Credentials{
-			AccessKey: accessKey,
-			SecretKey: secretKey,
-		}))
+	// Concurrency and prefetch controls
+	if cfg.ConsumeGoroutines > 0 {
+		opts = append(opts, consumer.WithConsumeGoroutineNums(cfg.ConsumeGoroutines))
+	}
+	if cfg.ConsumeBatchSize > 0 {
+		opts = append(opts, consumer.WithConsumeMessageBatchMaxSize(cfg.ConsumeBatchSize))
+	}
+	if cfg.MaxCachedMessages > 0 {
+		opts = append(opts, consumer.WithPullThresholdForQueue(int64(cfg.MaxCachedMessages)))
+	}
+	if cfg.ConsumeTimeout > 0 {
+		opts = append(opts, consumer.WithConsumeTimeout(cfg.ConsumeTimeout.Std()))
 	}
 
 	c, err := rocketmq.NewPushConsumer(opts...)
@@ -59,12 +83,172 @@ func NewPushConsumer(endpoint, accessKey, secretKey, groupName string) (rocketmq
 	return c, nil
 }
 
-// SendMessage sends a message to the specified topic.
-func SendMessage(ctx context.Context, p rocketmq.Producer, topic string, body []byte) error {
+// NewPullConsumer creates a RocketMQ pull consumer in group. The client's pull consumer
+// handles a single topic, so callers create one per topic.
+// Note: You must call Subscribe and then Start on the returned consumer.
+func NewPullConsumer(cfg config.MQConfig, group string) (rocketmq.PullConsumer, error) {
+	opts, err := consumerOptions(cfg, group)
+	if err != nil {
+		return nil, err
+	}
+	return rocketmq.NewPullConsumer(opts...)
+}
+
+// consumerOptions returns the options shared by push and pull consumers.
+func consumerOptions(cfg config.MQConfig, group string) ([]consumer.Option, error) {
+	opts := []consumer.Option{
+		consumer.WithNsResolver(primitive.NewPassthroughResolver([]string{cfg.NameServer})),
+		consumer.WithGroupName(group),
+	}
+	if cfg.Namespace != "" {
+		opts = append(opts, consumer.WithNamespace(cfg.Namespace))
+	}
+	if cfg.InstanceName != "" {
+		opts = append(opts, consumer.WithInstance(cfg.InstanceName))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, consumer.WithTls(true))
+	}
+
+	// Where a group without committed offsets starts consuming; the client parses the timestamp as UTC
+	switch cfg.ConsumeFrom {
+	case config.ConsumeFromFirst:
+		opts = append(opts, consumer.WithConsumeFromWhere(consumer.ConsumeFromFirstOffset))
+	case config.ConsumeFromTimestamp:
+		ts, err := time.Parse(time.RFC3339, cfg.ConsumeTimestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid consume_timestamp: %w", err)
+		}
+		opts = append(opts,
+			consumer.WithConsumeFromWhere(consumer.ConsumeFromTimestamp),
+			consumer.WithConsumeTimestamp(ts.UTC().Format("20060102150405")),
+		)
+	default:
+		opts = append(opts, consumer.WithConsumeFromWhere(consumer.ConsumeFromLastOffset))
+	}
+
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		opts = append(opts, consumer.WithCredentials(primitive.Credentials{
+			AccessKey: cfg.AccessKey,
+			SecretKey: cfg.SecretKey,
+		}))
+	}
+	if cfg.PullBatchSize > 0 {
+		opts = append(opts, consumer.WithPullBatchSize(int32(cfg.PullBatchSize)))
+	}
+	return opts, nil
+}
+
+// SendOption customizes a message before it is sent.
+type SendOption func(msg *primitive.Message) error
+
+// WithCompression compresses bodies of at least threshold bytes with algorithm
+// ("gzip" or "zstd"; empty disables compression). Encrypted bodies are left as they are.
+func WithCompression(algorithm string, threshold int) SendOption {
+	return func(msg *primitive.Message) error {
+		if algorithm == "" || len(msg.Body) < threshold || msg.GetProperty(EncryptionProperty) != "" {
+			return nil
+		}
+		return compress(msg, algorithm)
+	}
+}
+
+// WithKeys sets the message keys, by which messages can be looked up in the RocketMQ console.
+func WithKeys(keys ...string) SendOption {
+	return func(msg *primitive.Message) error {
+		if len(keys) > 0 {
+			msg.WithKeys(keys)
+		}
+		return nil
+	}
+}
+
+// WithShardingKey sends all messages with the same key to the same queue, where they are
+// stored in the order they were sent. An empty key leaves the queue choice random.
+func WithShardingKey(key string) SendOption {
+	return func(msg *primitive.Message) error {
+		if key != "" {
+			msg.WithShardingKey(key)
+		}
+		return nil
+	}
+}
+
+// Compression returns the compression option configured in cfg.
+func Compression(cfg config.MQConfig) SendOption {
+	return WithCompression(cfg.Compression, cfg.CompressThreshold)
+}
+
+func newMessage(topic string, body []byte, opts []SendOption) (*primitive.Message, error) {
 	msg := &primitive.Message{
 		Topic: topic,
 		Body:  body,
 	}
-	_, err := p.SendSync(ctx, msg)
+	for _, opt := range opts {
+		if err := opt(msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Producer is the part of rocketmq.Producer used to send messages, so tests can
+// substitute a fake (see package workertest).
+type Producer interface {
+	SendSync(ctx context.Context, msgs ...*primitive.Message) (*primitive.SendResult, error)
+	Shutdown() error
+}
+
+// SendMessage sends a message to the specified topic.
+func SendMessage(ctx context.Context, p Producer, topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	_, err = p.SendSync(ctx, msg)
 	return err
 }
+
+// maxBatchBytes bounds the bodies sent in one batch request, well below the broker's
+// default 4 MB message limit.
+const maxBatchBytes = 1 << 20
+
+// Message is a body to send together with its own options.
+type Message struct {
+	Body    []byte
+	Options []SendOption
+}
+
+// SendBatch sends msgs to topic in as few batch requests as the size limit allows. A batch
+// is stored in a single queue, so a new batch starts whenever the sharding key changes.
+// Batches are sent in order; on error, sent reports how many leading messages were accepted.
+func SendBatch(ctx context.Context, p Producer, topic string, msgs []Message) (sent int, err error) {
+	var batch []*primitive.Message
+	size := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := p.SendSync(ctx, batch...); err != nil {
+			return err
+		}
+		sent += len(batch)
+		batch, size = nil, 0
+		return nil
+	}
+
+	for _, m := range msgs {
+		msg, err := newMessage(topic, m.Body, m.Options)
+		if err != nil {
+			return sent, err
+		}
+		if size+len(msg.Body) > maxBatchBytes || len(batch) > 0 && batch[0].GetShardingKey() != msg.GetShardingKey() {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+		batch = append(batch, msg)
+		size += len(msg.Body)
+	}
+	return sent, flush()
+}
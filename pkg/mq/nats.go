@@ -0,0 +1,330 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"notification-system/pkg/config"
+)
+
+// natsProgressInterval is how often a held message is reported in progress, well within
+// the default ack wait of 30s.
+const natsProgressInterval = 10 * time.Second
+
+// natsMaxDeliveriesPrefix prefixes the subject of the advisories JetStream publishes for
+// messages a consumer delivered max deliver times without an acknowledgement.
+const natsMaxDeliveriesPrefix = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES."
+
+// natsBroker publishes to and consumes from NATS JetStream. Each topic is a subject; a
+// stream is created for it unless an existing stream already captures the subject.
+// Consumers of a group share a durable queue consumer, and failed messages are negatively
+// acknowledged so the server redelivers them after an increasing delay.
+//
+// Durable consumers deliver a message at most mq.max_retries+1 times, the last delivery
+// being the one the worker moves to the DLQ. Messages that run out of deliveries anyway,
+// e.g. because they crash the worker or their move to the DLQ failed, are published to
+// DLQ_<topic> on the max deliveries advisory, by one consumer of the group.
+type natsBroker struct {
+	cfg  config.MQConfig
+	conn *nats.Conn
+	js   nats.JetStreamContext
+
+	streams sync.Map // subject -> name of the stream capturing it
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	started  bool
+	slots    chan struct{} // bounds the handlers running at once
+}
+
+func newNATSBroker(cfg config.MQConfig) (*natsBroker, error) {
+	opts := []nats.Option{nats.MaxReconnects(-1)}
+	if cfg.InstanceName != "" {
+		opts = append(opts, nats.Name(cfg.InstanceName))
+	}
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		opts = append(opts, nats.UserInfo(cfg.AccessKey, cfg.SecretKey))
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	conn, err := nats.Connect(cfg.NATS.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	goroutines := cfg.ConsumeGoroutines
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	return &natsBroker{
+		cfg:      cfg,
+		conn:     conn,
+		js:       js,
+		handlers: make(map[string]Handler),
+		slots:    make(chan struct{}, goroutines),
+	}, nil
+}
+
+// ensureStream creates a stream for subject unless one captures it already, and returns
+// the name of the stream.
+func (b *natsBroker) ensureStream(subject string) (string, error) {
+	if name, ok := b.streams.Load(subject); ok {
+		return name.(string), nil
+	}
+	name, err := b.js.StreamNameBySubject(subject)
+	if errors.Is(err, nats.ErrNoMatchingStream) {
+		name = streamName(subject)
+		_, err = b.js.AddStream(&nats.StreamConfig{Name: name, Subjects: []string{subject}})
+	}
+	if err != nil {
+		return "", fmt.Errorf("stream for %s: %w", subject, err)
+	}
+	b.streams.Store(subject, name)
+	return name, nil
+}
+
+// durableName names the durable consumer of cfg.GroupName on topic. Durable names are
+// scoped to a stream, which may capture several topics.
+func durableName(cfg config.MQConfig, topic string) string {
+	return cfg.GroupName + "_" + streamName(topic)
+}
+
+// streamName derives a valid stream name from a subject.
+func streamName(subject string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(subject)
+}
+
+func (b *natsBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	if _, err := b.ensureStream(topic); err != nil {
+		return err
+	}
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+
+	out := nats.NewMsg(topic)
+	out.Data = msg.Body
+	for k, v := range msg.GetProperties() {
+		out.Header.Set(k, v)
+	}
+	_, err = b.js.PublishMsg(out, nats.Context(ctx))
+	return err
+}
+
+func (b *natsBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.started {
+		return b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *natsBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, h := range b.handlers {
+		if err := b.consume(topic, h); err != nil {
+			return fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+	b.started = true
+	return nil
+}
+
+// consume joins the group's durable queue consumer of topic. Callers hold b.mu.
+func (b *natsBroker) consume(topic string, h Handler) error {
+	stream, err := b.ensureStream(topic)
+	if err != nil {
+		return err
+	}
+
+	durable := durableName(b.cfg, topic)
+	opts := []nats.SubOpt{nats.Durable(durable), nats.ManualAck(), nats.MaxAckPending(cap(b.slots))}
+	// Dead-lettered messages are not dead-lettered again, e.g. by a redrive consumer
+	deadLetter := !strings.HasPrefix(topic, "DLQ_")
+	if deadLetter {
+		maxDeliver := b.cfg.MaxRetries + 1
+		if err := b.setMaxDeliver(stream, durable, maxDeliver); err != nil {
+			return fmt.Errorf("consumer %s: %w", durable, err)
+		}
+		opts = append(opts, nats.MaxDeliver(maxDeliver))
+	}
+	if b.cfg.ConsumeFrom == config.ConsumeFromFirst {
+		opts = append(opts, nats.DeliverAll())
+	} else {
+		opts = append(opts, nats.DeliverNew())
+	}
+	if b.cfg.ConsumeTimeout > 0 {
+		opts = append(opts, nats.AckWait(b.cfg.ConsumeTimeout.Std()))
+	}
+
+	_, err = b.js.QueueSubscribe(topic, durable, func(m *nats.Msg) {
+		// The client calls back sequentially, so messages are handled on their own goroutines
+		b.slots <- struct{}{}
+		go func() {
+			defer func() { <-b.slots }()
+			b.handle(m, h)
+		}()
+	}, opts...)
+	if err != nil || !deadLetter {
+		return err
+	}
+	// Advisories go to every subscriber, the queue group has one consumer handle each
+	_, err = b.conn.QueueSubscribe(natsMaxDeliveriesPrefix+stream+"."+durable, durable, b.deadLetter)
+	return err
+}
+
+// setMaxDeliver sets the max deliver of the durable consumer of stream if it exists with
+// another one, e.g. after mq.max_retries changed; the client refuses to join it otherwise.
+func (b *natsBroker) setMaxDeliver(stream, durable string, maxDeliver int) error {
+	info, err := b.js.ConsumerInfo(stream, durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Config.MaxDeliver == maxDeliver {
+		return nil
+	}
+	c := info.Config
+	c.MaxDeliver = maxDeliver
+	_, err = b.js.UpdateConsumer(stream, &c)
+	return err
+}
+
+// natsMaxDeliveries is the advisory JetStream publishes for a message that ran out of
+// deliveries.
+type natsMaxDeliveries struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// deadLetter publishes the message of a max deliveries advisory to DLQ_<subject>. The
+// message is lost from the consumer when this fails, as it is never delivered again.
+func (b *natsBroker) deadLetter(m *nats.Msg) {
+	var adv natsMaxDeliveries
+	if err := json.Unmarshal(m.Data, &adv); err != nil {
+		log.Printf("[MQ] Ignoring invalid max deliveries advisory on %s: %v", m.Subject, err)
+		return
+	}
+	id := fmt.Sprintf("%s-%d", adv.Stream, adv.StreamSeq)
+	msg, err := b.js.GetMsg(adv.Stream, adv.StreamSeq)
+	if err != nil {
+		log.Printf("[MQ] Failed to read message %s that exceeded %d deliveries: %v", id, adv.Deliveries, err)
+		return
+	}
+	dlq := "DLQ_" + msg.Subject
+	if _, err := b.ensureStream(dlq); err != nil {
+		log.Printf("[MQ] Failed to dead-letter message %s: %v", id, err)
+		return
+	}
+
+	out := nats.NewMsg(dlq)
+	out.Data = msg.Data
+	for k, v := range msg.Header {
+		out.Header[k] = v
+	}
+	if _, err := b.js.PublishMsg(out); err != nil {
+		log.Printf("[MQ] Failed to dead-letter message %s: %v", id, err)
+		return
+	}
+	log.Printf("[MQ] Dead-lettered message %s to %s after %d deliveries", id, dlq, adv.Deliveries)
+}
+
+func (b *natsBroker) handle(m *nats.Msg, h Handler) {
+	meta, err := m.Metadata()
+	if err != nil {
+		log.Printf("[MQ] Dropping message without JetStream metadata on %s: %v", m.Subject, err)
+		return
+	}
+	props := make(map[string]string, len(m.Header))
+	for k := range m.Header {
+		props[k] = m.Header.Get(k)
+	}
+	id := fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream)
+	attempts := int(meta.NumDelivered) - 1
+
+	d := newDelivery(m.Subject, id, m.Data, props, attempts, meta.Timestamp)
+	if err := h(context.Background(), d); err != nil {
+		if dropped(d, err) {
+			m.Term()
+			return
+		}
+		if delay, ok := held(err); ok {
+			b.hold(m, d.Attempts, delay)
+			return
+		}
+		// Back off like RocketMQ's consumer retries: 10s, 30s, 1m, 2m, ... up to 2h
+		m.NakWithDelay(redeliveryDelay(err, attempts))
+		return
+	}
+	m.Ack()
+}
+
+// hold re-publishes m after delay, carrying its attempts over in RetryTimesProperty since
+// a redelivery would count as one more. JetStream can't delay a message, so the handler
+// slot waits, keeping m in progress meanwhile.
+func (b *natsBroker) hold(m *nats.Msg, attempts int, delay time.Duration) {
+	progress := time.NewTicker(natsProgressInterval)
+	defer progress.Stop()
+	deadline := time.After(delay)
+	for waiting := true; waiting; {
+		select {
+		case <-progress.C:
+			m.InProgress()
+		case <-deadline:
+			waiting = false
+		}
+	}
+
+	out := nats.NewMsg(m.Subject)
+	out.Data = m.Data
+	for k, v := range m.Header {
+		out.Header[k] = v
+	}
+	out.Header.Set(RetryTimesProperty, strconv.Itoa(attempts))
+	if _, err := b.js.PublishMsg(out); err != nil {
+		log.Printf("[MQ] Failed to re-publish held message on %s: %v", m.Subject, err)
+		m.Nak()
+		return
+	}
+	m.Ack()
+}
+
+// Close closes the connection. Subscriptions are not unsubscribed, which would delete the
+// durable consumers and their acknowledged positions; unacknowledged messages are redelivered.
+func (b *natsBroker) Close() error {
+	b.conn.Close()
+	return nil
+}
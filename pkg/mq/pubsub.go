@@ -0,0 +1,223 @@
+//go:build pubsub
+
+package mq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+
+	"notification-system/pkg/config"
+)
+
+// The Pub/Sub client is linked in with -tags pubsub only, as it pulls in the Google API
+// client libraries. go.mod pins its version:
+//
+//	go build -tags pubsub ./...
+
+// pubSubBroker publishes to Google Cloud Pub/Sub topics and consumes them through the
+// subscriptions of mq.group_name (see config.PubSubConfig). The client extends the ack
+// deadline of messages while they are handled.
+//
+// Pub/Sub only counts delivery attempts on subscriptions with a dead-letter policy. On
+// those, a failed message is nacked and redelivered after the backoff of the
+// subscription's retry policy. Elsewhere, like with Kafka, it is published again with an
+// incremented RetryTimesProperty and acknowledged.
+type pubSubBroker struct {
+	cfg    config.MQConfig
+	client *pubsub.Client
+
+	mu       sync.Mutex
+	topics   map[string]*pubsub.Topic
+	handlers map[string]Handler
+	started  bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	polling  sync.WaitGroup
+}
+
+func newPubSubBroker(cfg config.MQConfig) (Broker, error) {
+	var opts []option.ClientOption
+	if f := cfg.PubSub.CredentialsFile; f != "" {
+		opts = append(opts, option.WithCredentialsFile(f))
+	}
+	client, err := pubsub.NewClient(context.Background(), cfg.PubSub.ProjectID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &pubSubBroker{
+		cfg:      cfg,
+		client:   client,
+		topics:   make(map[string]*pubsub.Topic),
+		handlers: make(map[string]Handler),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// topic returns the publisher of a topic. Publishers are kept, as they batch the messages
+// of a topic.
+func (b *pubSubBroker) topic(name string) *pubsub.Topic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[name]
+	if !ok {
+		t = b.client.Topic(name)
+		t.EnableMessageOrdering = b.cfg.PubSub.Ordering
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *pubSubBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	out := &pubsub.Message{Data: msg.Body, Attributes: msg.GetProperties()}
+	if b.cfg.PubSub.Ordering {
+		out.OrderingKey = msg.GetShardingKey()
+	}
+	return b.send(ctx, topic, out)
+}
+
+func (b *pubSubBroker) send(ctx context.Context, topic string, m *pubsub.Message) error {
+	t := b.topic(topic)
+	if _, err := t.Publish(ctx, m).Get(ctx); err != nil {
+		if m.OrderingKey != "" {
+			// A failed publish pauses its ordering key until resumed
+			t.ResumePublish(m.OrderingKey)
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *pubSubBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.started {
+		b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *pubSubBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, h := range b.handlers {
+		b.consume(topic, h)
+	}
+	b.started = true
+	return nil
+}
+
+// subscriptionID returns the subscription consumer group cfg.GroupName consumes topic
+// through.
+func subscriptionID(cfg config.MQConfig, topic string) string {
+	return cfg.GroupName + "_" + topic
+}
+
+// consume receives the messages of topic until Close. Callers hold b.mu.
+func (b *pubSubBroker) consume(topic string, h Handler) {
+	goroutines := b.cfg.ConsumeGoroutines
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	sub := b.client.Subscription(subscriptionID(b.cfg, topic))
+	sub.ReceiveSettings.MaxOutstandingMessages = goroutines
+	sub.ReceiveSettings.MaxExtension = b.cfg.PubSub.MaxExtension.Std()
+	sub.ReceiveSettings.NumGoroutines = 1
+
+	b.polling.Add(1)
+	go func() {
+		defer b.polling.Done()
+		for b.ctx.Err() == nil {
+			err := sub.Receive(b.ctx, func(ctx context.Context, m *pubsub.Message) {
+				b.handle(ctx, topic, m, h)
+			})
+			if b.ctx.Err() != nil {
+				return
+			}
+			// Receive retries transient errors itself, so these last, e.g. a missing
+			// subscription or permission
+			log.Printf("[MQ] Failed to receive messages of %s: %v", topic, err)
+			select {
+			case <-b.ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}()
+}
+
+func (b *pubSubBroker) handle(ctx context.Context, topic string, m *pubsub.Message, h Handler) {
+	attempts, counted := 0, m.DeliveryAttempt != nil
+	if counted {
+		attempts = max(*m.DeliveryAttempt-1, 0)
+	}
+	d := newDelivery(topic, m.ID, m.Data, m.Attributes, attempts, m.PublishTime)
+	err := h(ctx, d)
+	if err == nil || dropped(d, err) {
+		m.Ack()
+		return
+	}
+	retries := d.Attempts + 1
+	if delay, ok := held(err); ok {
+		// Pub/Sub can't delay a message: wait here, the client extending its ack deadline
+		// meanwhile, then publish it again without counting the attempt
+		select {
+		case <-ctx.Done():
+			m.Nack()
+			return
+		case <-time.After(delay):
+		}
+		retries = d.Attempts
+	} else if counted {
+		m.Nack()
+		return
+	}
+	if err := b.retry(topic, m, retries); err != nil {
+		log.Printf("[MQ] Failed to re-publish message %s for retry: %v", m.ID, err)
+		m.Nack()
+		return
+	}
+	m.Ack()
+}
+
+// retry publishes m to its topic again, flagged with its retry count.
+func (b *pubSubBroker) retry(topic string, m *pubsub.Message, retries int) error {
+	attrs := make(map[string]string, len(m.Attributes)+1)
+	for k, v := range m.Attributes {
+		attrs[k] = v
+	}
+	attrs[RetryTimesProperty] = strconv.Itoa(retries)
+	return b.send(context.Background(), topic, &pubsub.Message{Data: m.Data, Attributes: attrs, OrderingKey: m.OrderingKey})
+}
+
+// Close stops receiving, then flushes the publishers. Messages not acknowledged yet are
+// redelivered once their ack deadline ends.
+func (b *pubSubBroker) Close() error {
+	b.cancel()
+	b.polling.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range b.topics {
+		t.Stop()
+	}
+	return b.client.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !pubsub
+
+package mq
+
+import (
+	"fmt"
+
+	"notification-system/pkg/config"
+)
+
+// newPubSubBroker fails in builds without the Pub/Sub client, which is only linked in
+// with -tags pubsub (see pubsub.go).
+func newPubSubBroker(cfg config.MQConfig) (Broker, error) {
+	return nil, fmt.Errorf("mq.broker %s requires a build with -tags pubsub", config.BrokerPubSub)
+}
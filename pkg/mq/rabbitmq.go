@@ -0,0 +1,438 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"notification-system/pkg/config"
+)
+
+// rabbitMQBroker publishes to RabbitMQ exchanges and consumes from the queues of
+// mq.group_name bound to them (see config.RabbitMQConfig). Publishes wait for the
+// publisher confirm, so an error means the message may not have been stored, like with
+// RocketMQ's SendMessage. Publishes are mandatory: a message no queue is bound to fails
+// rather than being dropped.
+//
+// Failed messages are re-published with an incremented RetryTimesProperty to a delay
+// queue, whose message TTL is the delay level rounded up from the retry delay; expired
+// messages are dead-lettered back to the consumer queue. Consumer queues dead-letter to
+// the exchange of DLQ_<topic>, which takes messages over the delivery limit of quorum
+// queues and undecodable ones.
+type rabbitMQBroker struct {
+	cfg config.MQConfig
+
+	connMu sync.Mutex
+	conn   *amqp.Connection
+
+	pubMu   sync.Mutex // one publish at a time, so returns can be told apart
+	pub     *amqp.Channel
+	returns chan amqp.Return
+
+	declared sync.Map // topics whose exchange and queue are declared
+
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	started   bool
+	slots     chan struct{} // bounds the handlers running at once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	consuming sync.WaitGroup
+}
+
+func newRabbitMQBroker(cfg config.MQConfig) (*rabbitMQBroker, error) {
+	goroutines := cfg.ConsumeGoroutines
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &rabbitMQBroker{
+		cfg:      cfg,
+		handlers: make(map[string]Handler),
+		slots:    make(chan struct{}, goroutines),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if _, err := b.connection(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return b, nil
+}
+
+// connection returns the connection to the server, reconnecting if it was closed.
+func (b *rabbitMQBroker) connection() (*amqp.Connection, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if b.conn != nil && !b.conn.IsClosed() {
+		return b.conn, nil
+	}
+	c := amqp.Config{Properties: amqp.NewConnectionProperties()}
+	if b.cfg.InstanceName != "" {
+		c.Properties.SetClientConnectionName(b.cfg.InstanceName)
+	}
+	if b.cfg.AccessKey != "" && b.cfg.SecretKey != "" {
+		c.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: b.cfg.AccessKey, Password: b.cfg.SecretKey}}
+	}
+	if b.cfg.TLS != nil {
+		var err error
+		if c.TLSClientConfig, err = b.cfg.TLS.Load(); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := amqp.DialConfig(b.cfg.RabbitMQ.URL, c)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	return conn, nil
+}
+
+// queueName returns the queue consumer group cfg.GroupName consumes topic from.
+func queueName(cfg config.MQConfig, topic string) string {
+	return cfg.GroupName + "." + topic
+}
+
+// delayQueueName returns the queue holding messages of queue for the delay of level.
+func delayQueueName(queue string, level int) string {
+	return queue + ".delay." + strconv.Itoa(level)
+}
+
+// declare declares the exchange of topic and the queue of the group bound to it, and
+// the exchange and queue of DLQ_<topic> its queue dead-letters to.
+func (b *rabbitMQBroker) declare(ch *amqp.Channel, topic string) error {
+	if _, ok := b.declared.Load(topic); ok {
+		return nil
+	}
+	args := amqp.Table{"x-queue-type": b.cfg.RabbitMQ.QueueType}
+	if !strings.HasPrefix(topic, "DLQ_") {
+		if err := b.declare(ch, "DLQ_"+topic); err != nil {
+			return err
+		}
+		args["x-dead-letter-exchange"] = "DLQ_" + topic
+		if b.cfg.RabbitMQ.QueueType == config.QueueTypeQuorum {
+			args["x-delivery-limit"] = b.cfg.RabbitMQ.DeliveryLimit
+		}
+	}
+	queue := queueName(b.cfg, topic)
+	if err := ch.ExchangeDeclare(topic, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("exchange %s: %w", topic, err)
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, args); err != nil {
+		return fmt.Errorf("queue %s: %w", queue, err)
+	}
+	if err := ch.QueueBind(queue, "", topic, false, nil); err != nil {
+		return fmt.Errorf("queue %s: %w", queue, err)
+	}
+	b.declared.Store(topic, true)
+	return nil
+}
+
+// declareDelay declares the delay queue of queue for level.
+func (b *rabbitMQBroker) declareDelay(ch *amqp.Channel, queue string, level int) (string, error) {
+	name := delayQueueName(queue, level)
+	if _, ok := b.declared.Load(name); ok {
+		return name, nil
+	}
+	_, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-queue-type":              b.cfg.RabbitMQ.QueueType,
+		"x-message-ttl":             delayLevels[level-1].Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue,
+	})
+	if err != nil {
+		return "", fmt.Errorf("queue %s: %w", name, err)
+	}
+	b.declared.Store(name, true)
+	return name, nil
+}
+
+// publisher returns the channel in confirm mode messages are published on, opening it
+// anew if it was closed. Callers hold b.pubMu.
+func (b *rabbitMQBroker) publisher() (*amqp.Channel, error) {
+	if b.pub != nil && !b.pub.IsClosed() {
+		return b.pub, nil
+	}
+	conn, err := b.connection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	b.pub = ch
+	b.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	return ch, nil
+}
+
+func (b *rabbitMQBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	headers := make(amqp.Table, len(msg.GetProperties()))
+	for k, v := range msg.GetProperties() {
+		headers[k] = v
+	}
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	ch, err := b.publisher()
+	if err != nil {
+		return err
+	}
+	if err := b.declare(ch, topic); err != nil {
+		return err
+	}
+	return b.publish(ctx, ch, topic, "", amqp.Publishing{
+		Headers:      headers,
+		Body:         msg.Body,
+		MessageId:    randomID(),
+		Timestamp:    time.Now(),
+		DeliveryMode: amqp.Persistent,
+	})
+}
+
+// publish publishes p and waits for its confirm. Callers hold b.pubMu.
+func (b *rabbitMQBroker) publish(ctx context.Context, ch *amqp.Channel, exchange, key string, p amqp.Publishing) error {
+	dest := exchange
+	if dest == "" {
+		dest = key
+	}
+	// Drop the return of a publish given up on before its confirm
+	for len(b.returns) > 0 {
+		<-b.returns
+	}
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, p)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("message to %s rejected by the broker", dest)
+	}
+	// The server returns an unroutable message before confirming it
+	select {
+	case r := <-b.returns:
+		return fmt.Errorf("message to %s not routed to any queue: %s", dest, r.ReplyText)
+	default:
+	}
+	return nil
+}
+
+func (b *rabbitMQBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.started {
+		b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *rabbitMQBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, h := range b.handlers {
+		b.consume(topic, h)
+	}
+	b.started = true
+	return nil
+}
+
+// consume consumes the queue of topic until Close, reconnecting after connection or
+// channel failures. Callers hold b.mu.
+func (b *rabbitMQBroker) consume(topic string, h Handler) {
+	b.consuming.Add(1)
+	go func() {
+		defer b.consuming.Done()
+		var failed int
+		for b.ctx.Err() == nil {
+			established, err := b.consumeChannel(topic, h)
+			if b.ctx.Err() != nil {
+				return
+			}
+			if established {
+				failed = 0
+			}
+			// Logged once per failure streak, an outage would flood the log otherwise
+			if failed == 0 {
+				log.Printf("[MQ] Stopped consuming %s: %v", topic, err)
+			}
+			failed++
+			select {
+			case <-b.ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+}
+
+// consumeChannel consumes the queue of topic on a channel of its own until the channel
+// closes or b.ctx ends. established reports whether consuming started.
+func (b *rabbitMQBroker) consumeChannel(topic string, h Handler) (established bool, err error) {
+	conn, err := b.connection()
+	if err != nil {
+		return false, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+	if err := ch.Qos(cap(b.slots), 0, false); err != nil {
+		return false, err
+	}
+	if err := b.declare(ch, topic); err != nil {
+		return false, err
+	}
+	queue := queueName(b.cfg, topic)
+	deliveries, err := ch.ConsumeWithContext(b.ctx, queue, b.cfg.InstanceName, false, false, false, false, nil)
+	if err != nil {
+		return false, err
+	}
+	for m := range deliveries {
+		b.slots <- struct{}{}
+		go func() {
+			defer func() { <-b.slots }()
+			b.handle(topic, queue, m, h)
+		}()
+	}
+	return true, errors.New("channel closed")
+}
+
+func (b *rabbitMQBroker) handle(topic, queue string, m amqp.Delivery, h Handler) {
+	props := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		if s, ok := v.(string); ok {
+			props[k] = s
+		}
+	}
+	id := m.MessageId
+	if id == "" {
+		id = strconv.FormatUint(m.DeliveryTag, 10)
+	}
+
+	// newDelivery counts the attempts carried by RetryTimesProperty
+	d := newDelivery(topic, id, m.Body, props, 0, m.Timestamp)
+	if err := h(context.Background(), d); err != nil {
+		if d.Err != nil && errors.Is(err, d.Err) {
+			log.Printf("[MQ] Dead-lettering undecodable message %s: %v", id, d.Err)
+			m.Reject(false)
+			return
+		}
+		retries, delay := d.Attempts+1, redeliveryDelay(err, d.Attempts)
+		if held, ok := held(err); ok {
+			retries, delay = d.Attempts, held
+		}
+		if err := b.retry(queue, m, retries, delay); err != nil {
+			log.Printf("[MQ] Failed to re-publish message %s for retry: %v", id, err)
+			m.Nack(false, true)
+			return
+		}
+	}
+	if err := m.Ack(false); err != nil {
+		// The message is redelivered once its channel closes
+		log.Printf("[MQ] Failed to acknowledge message %s: %v", id, err)
+	}
+}
+
+// retry publishes m to the delay queue of queue for delay, flagged with its retry count.
+func (b *rabbitMQBroker) retry(queue string, m amqp.Delivery, retries int, delay time.Duration) error {
+	headers := make(amqp.Table, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		// Headers set by the server, e.g. the delivery count, are dropped
+		if !strings.HasPrefix(k, "x-") {
+			headers[k] = v
+		}
+	}
+	headers[RetryTimesProperty] = strconv.Itoa(retries)
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	ch, err := b.publisher()
+	if err != nil {
+		return err
+	}
+	delayQueue, err := b.declareDelay(ch, queue, DelayLevel(delay))
+	if err != nil {
+		return err
+	}
+	return b.publish(context.Background(), ch, "", delayQueue, amqp.Publishing{
+		Headers:      headers,
+		Body:         m.Body,
+		MessageId:    m.MessageId,
+		Timestamp:    m.Timestamp,
+		DeliveryMode: amqp.Persistent,
+	})
+}
+
+// Close stops consuming and closes the connection. Messages not acknowledged yet are
+// redelivered.
+func (b *rabbitMQBroker) Close() error {
+	b.cancel()
+	b.consuming.Wait()
+
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	if b.conn == nil || b.conn.IsClosed() {
+		return nil
+	}
+	return b.conn.Close()
+}
+
+// rabbitMQQueueDepth returns the messages ready in the queue consumer group cfg.GroupName
+// consumes topic from. Queues that don't exist have a depth of 0.
+func rabbitMQQueueDepth(cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newRabbitMQBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	q, err := ch.QueueDeclarePassive(queueName(cfg, topic), true, false, false, false, nil)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(q.Messages), nil
+}
+
+// rabbitMQAddr returns host:port of a RabbitMQ URL.
+func rabbitMQAddr(rawURL string) string {
+	u, err := amqp.ParseURI(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
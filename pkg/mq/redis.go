@@ -0,0 +1,460 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"notification-system/pkg/config"
+)
+
+const (
+	// redisBlock is how long a read waits for new entries.
+	redisBlock = 5 * time.Second
+	// redisClaimInterval is how often pending entries are checked for claiming.
+	redisClaimInterval = time.Second
+	// redisClaimPages bounds the pages of 100 pending entries checked per claim round.
+	redisClaimPages = 10
+)
+
+// redisBroker publishes to and consumes from Redis Streams, for deployments that run
+// Redis but no message broker. Each topic is a stream, capped at mq.redis.max_len
+// entries, and each consumer group a consumer group of the stream.
+//
+// A failed entry stays pending: its retry delay is recorded in a hash of the group, and
+// the entry is claimed again, by any consumer of the group, once it has been idle that
+// long. Entries of consumers that died are claimed after mq.redis.claim_idle; consumers
+// reset the idle time of the entries they are handling meanwhile. The deliveries of an
+// entry, held ones excepted, make up its attempts.
+type redisBroker struct {
+	cfg      config.MQConfig
+	client   *redis.Client
+	consumer string
+
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	started   bool
+	slots     chan struct{} // bounds the handlers running at once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	consuming sync.WaitGroup
+}
+
+func newRedisBroker(cfg config.MQConfig) (*redisBroker, error) {
+	opts := &redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Username: cfg.AccessKey,
+		Password: cfg.SecretKey,
+		DB:       cfg.Redis.DB,
+	}
+	if cfg.TLS != nil {
+		var err error
+		if opts.TLSConfig, err = cfg.TLS.Load(); err != nil {
+			return nil, err
+		}
+	}
+	consumer := cfg.InstanceName
+	if consumer == "" {
+		host, _ := os.Hostname()
+		consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	goroutines := cfg.ConsumeGoroutines
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &redisBroker{
+		cfg:      cfg,
+		client:   redis.NewClient(opts),
+		consumer: consumer,
+		handlers: make(map[string]Handler),
+		slots:    make(chan struct{}, goroutines),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// streamKey returns the key of the stream of topic. The hash tag keeps the keys of a
+// topic in one slot of a Redis Cluster.
+func streamKey(cfg config.MQConfig, topic string) string {
+	return cfg.Redis.KeyPrefix + "{" + topic + "}"
+}
+
+// retryKey returns the key of the hash holding the retries of the pending entries of
+// consumer group cfg.GroupName on topic.
+func retryKey(cfg config.MQConfig, topic string) string {
+	return streamKey(cfg, topic) + ":" + cfg.GroupName + ":retry"
+}
+
+// redisRetry is the retry of a failed or held entry recorded in the retry hash.
+type redisRetry struct {
+	delay time.Duration
+	held  int64 // deliveries held back rather than failed
+}
+
+func (r redisRetry) String() string {
+	return fmt.Sprintf("%d %d", r.delay.Milliseconds(), r.held)
+}
+
+func parseRedisRetry(s string) (redisRetry, bool) {
+	delay, held, ok := strings.Cut(s, " ")
+	ms, err1 := strconv.ParseInt(delay, 10, 64)
+	n, err2 := strconv.ParseInt(held, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return redisRetry{}, false
+	}
+	return redisRetry{delay: time.Duration(ms) * time.Millisecond, held: n}, true
+}
+
+func (b *redisBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	props, err := json.Marshal(msg.GetProperties())
+	if err != nil {
+		return err
+	}
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(b.cfg, topic),
+		MaxLen: b.cfg.Redis.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"body": msg.Body, "props": props, "born": time.Now().UnixMilli()},
+	}).Err()
+}
+
+func (b *redisBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.started {
+		return b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *redisBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, h := range b.handlers {
+		if err := b.consume(topic, h); err != nil {
+			return fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+	b.started = true
+	return nil
+}
+
+// consume creates the consumer group of topic unless it exists, then reads and claims its
+// entries until Close. Callers hold b.mu.
+func (b *redisBroker) consume(topic string, h Handler) error {
+	start := "$"
+	switch b.cfg.ConsumeFrom {
+	case config.ConsumeFromFirst:
+		start = "0"
+	case config.ConsumeFromTimestamp:
+		ts, err := time.Parse(time.RFC3339, b.cfg.ConsumeTimestamp)
+		if err != nil {
+			return fmt.Errorf("invalid consume_timestamp: %w", err)
+		}
+		start = strconv.FormatInt(ts.UnixMilli(), 10)
+	}
+	err := b.client.XGroupCreateMkStream(b.ctx, streamKey(b.cfg, topic), b.cfg.GroupName, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	b.consuming.Add(2)
+	go func() {
+		defer b.consuming.Done()
+		b.read(topic, h)
+	}()
+	go func() {
+		defer b.consuming.Done()
+		b.claim(topic, h)
+	}()
+	return nil
+}
+
+// read handles new entries of topic, each on its own goroutine while a handler slot is free.
+func (b *redisBroker) read(topic string, h Handler) {
+	key := streamKey(b.cfg, topic)
+	var failed int
+	for b.ctx.Err() == nil {
+		streams, err := b.client.XReadGroup(b.ctx, &redis.XReadGroupArgs{
+			Group:    b.cfg.GroupName,
+			Consumer: b.consumer,
+			Streams:  []string{key, ">"},
+			Count:    int64(max(cap(b.slots)-len(b.slots), 1)),
+			Block:    redisBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			// Logged once per failure streak, an outage would flood the log otherwise
+			if failed == 0 {
+				log.Printf("[MQ] Failed to read stream %s: %v", key, err)
+			}
+			failed++
+			time.Sleep(time.Second)
+			continue
+		}
+		if failed > 0 {
+			log.Printf("[MQ] Reading stream %s again after %d failures", key, failed)
+			failed = 0
+		}
+		for _, s := range streams {
+			for _, m := range s.Messages {
+				b.slots <- struct{}{}
+				go func() {
+					defer func() { <-b.slots }()
+					b.handle(topic, m, 0, 0, h)
+				}()
+			}
+		}
+	}
+}
+
+// claim claims the pending entries of topic whose retry is due, or whose consumer has
+// left them idle for mq.redis.claim_idle, while handler slots are free.
+func (b *redisBroker) claim(topic string, h Handler) {
+	key := streamKey(b.cfg, topic)
+	ticker := time.NewTicker(redisClaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := "-"
+		for page := 0; page < redisClaimPages; page++ {
+			pending, err := b.client.XPendingExt(b.ctx, &redis.XPendingExtArgs{
+				Stream: key,
+				Group:  b.cfg.GroupName,
+				Start:  start,
+				End:    "+",
+				Count:  100,
+			}).Result()
+			if err != nil {
+				if b.ctx.Err() == nil {
+					log.Printf("[MQ] Failed to list the pending entries of %s: %v", key, err)
+				}
+				break
+			}
+			if len(pending) == 0 || !b.claimPage(topic, pending, h) || len(pending) < 100 {
+				break
+			}
+			start = "(" + pending[len(pending)-1].ID
+		}
+	}
+}
+
+// claimPage claims the due entries of pending. It reports false when the handler slots
+// ran out.
+func (b *redisBroker) claimPage(topic string, pending []redis.XPendingExt, h Handler) bool {
+	key := streamKey(b.cfg, topic)
+	ids := make([]string, len(pending))
+	for i, p := range pending {
+		ids[i] = p.ID
+	}
+	retries, err := b.client.HMGet(b.ctx, retryKey(b.cfg, topic), ids...).Result()
+	if err != nil {
+		log.Printf("[MQ] Failed to read the retries of %s: %v", key, err)
+		return false
+	}
+
+	for i, p := range pending {
+		r := redisRetry{delay: b.cfg.Redis.ClaimIdle.Std()}
+		if s, ok := retries[i].(string); ok {
+			if parsed, ok := parseRedisRetry(s); ok {
+				r = parsed
+			}
+		}
+		if p.Idle < r.delay {
+			continue
+		}
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			return false
+		}
+		// The minimum idle time lets only one consumer claim the entry
+		msgs, err := b.client.XClaim(b.ctx, &redis.XClaimArgs{
+			Stream:   key,
+			Group:    b.cfg.GroupName,
+			Consumer: b.consumer,
+			MinIdle:  r.delay,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil || len(msgs) == 0 {
+			<-b.slots
+			continue
+		}
+		go func() {
+			defer func() { <-b.slots }()
+			// Claiming delivered the entry once more
+			b.handle(topic, msgs[0], p.RetryCount-r.held, r.held, h)
+		}()
+	}
+	return true
+}
+
+// handle handles an entry delivered after attempts earlier failures and holds earlier
+// held deliveries.
+func (b *redisBroker) handle(topic string, m redis.XMessage, attempts, holds int64, h Handler) {
+	if len(m.Values) == 0 {
+		// Trimmed off the stream while pending
+		b.ack(topic, m.ID)
+		return
+	}
+	props := make(map[string]string)
+	if s, ok := m.Values["props"].(string); ok {
+		if err := json.Unmarshal([]byte(s), &props); err != nil {
+			log.Printf("[MQ] Dropping entry %s with invalid properties: %v", m.ID, err)
+			b.ack(topic, m.ID)
+			return
+		}
+	}
+	body, _ := m.Values["body"].(string)
+	born := time.Now()
+	if s, ok := m.Values["born"].(string); ok {
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			born = time.UnixMilli(ms)
+		}
+	}
+
+	d := newDelivery(topic, m.ID, []byte(body), props, int(attempts), born)
+	stop := b.keepPending(topic, m.ID)
+	err := h(context.Background(), d)
+	stop()
+	if err == nil || dropped(d, err) {
+		b.ack(topic, m.ID)
+		return
+	}
+
+	r := redisRetry{delay: redeliveryDelay(err, int(attempts)), held: holds}
+	if delay, ok := held(err); ok {
+		r = redisRetry{delay: delay, held: holds + 1}
+	}
+	// Resetting the idle time starts the retry delay now
+	_, err = b.client.TxPipelined(context.Background(), func(p redis.Pipeliner) error {
+		p.HSet(context.Background(), retryKey(b.cfg, topic), m.ID, r.String())
+		p.XClaimJustID(context.Background(), &redis.XClaimArgs{
+			Stream:   streamKey(b.cfg, topic),
+			Group:    b.cfg.GroupName,
+			Consumer: b.consumer,
+			Messages: []string{m.ID},
+		})
+		return nil
+	})
+	if err != nil {
+		// The entry is claimed again after mq.redis.claim_idle
+		log.Printf("[MQ] Failed to record the retry of entry %s: %v", m.ID, err)
+	}
+}
+
+// keepPending resets the idle time of an entry being handled, so no other consumer
+// claims it, until stop is called.
+func (b *redisBroker) keepPending(topic, id string) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(b.cfg.Redis.ClaimIdle.Std() / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := b.client.XClaimJustID(context.Background(), &redis.XClaimArgs{
+					Stream:   streamKey(b.cfg, topic),
+					Group:    b.cfg.GroupName,
+					Consumer: b.consumer,
+					Messages: []string{id},
+				}).Err()
+				if err != nil {
+					log.Printf("[MQ] Failed to keep entry %s pending: %v", id, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (b *redisBroker) ack(topic, id string) {
+	_, err := b.client.TxPipelined(context.Background(), func(p redis.Pipeliner) error {
+		p.XAck(context.Background(), streamKey(b.cfg, topic), b.cfg.GroupName, id)
+		p.HDel(context.Background(), retryKey(b.cfg, topic), id)
+		return nil
+	})
+	if err != nil {
+		// The entry is claimed again after mq.redis.claim_idle
+		log.Printf("[MQ] Failed to acknowledge entry %s: %v", id, err)
+	}
+}
+
+// Close stops reading and claiming, then closes the client. Entries not acknowledged yet
+// are claimed by other consumers after mq.redis.claim_idle.
+func (b *redisBroker) Close() error {
+	b.cancel()
+	b.consuming.Wait()
+	return b.client.Close()
+}
+
+// redisTopicDepth returns the number of entries in the stream of topic.
+func redisTopicDepth(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newRedisBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+	return b.client.XLen(ctx, streamKey(cfg, topic)).Result()
+}
+
+// redisGroupLag returns the number of entries in the stream of topic that consumer group
+// cfg.GroupName has not read yet or not acknowledged.
+func redisGroupLag(ctx context.Context, cfg config.MQConfig, topic string) (int64, error) {
+	b, err := newRedisBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	groups, err := b.client.XInfoGroups(ctx, streamKey(cfg, topic)).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return 0, nil
+		}
+		return 0, err
+	}
+	for _, g := range groups {
+		if g.Name == cfg.GroupName {
+			return max(g.Lag, 0) + g.Pending, nil
+		}
+	}
+	return 0, nil
+}
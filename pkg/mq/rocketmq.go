@@ -0,0 +1,135 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2"
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+)
+
+// rocketMQBroker is the default Broker. Failed messages go back to the broker, which
+// redelivers them with its increasing retry delays.
+type rocketMQBroker struct {
+	cfg      config.MQConfig
+	producer rocketmq.Producer
+
+	mu       sync.Mutex
+	consumer rocketmq.PushConsumer // created by the first Subscribe
+	started  bool
+}
+
+func newRocketMQBroker(cfg config.MQConfig) (*rocketMQBroker, error) {
+	p, err := NewProducer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &rocketMQBroker{cfg: cfg, producer: p}, nil
+}
+
+func (b *rocketMQBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	return SendMessage(ctx, b.producer, topic, body, opts...)
+}
+
+func (b *rocketMQBroker) PublishBatch(ctx context.Context, topic string, msgs []Message) (int, error) {
+	return SendBatch(ctx, b.producer, topic, msgs)
+}
+
+func (b *rocketMQBroker) PublishAsync(topic string, body []byte, opts []SendOption, done func(error)) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+
+	// The client may report a transport error twice, so done is called once
+	var once sync.Once
+	return b.producer.SendAsync(context.Background(), func(_ context.Context, result *primitive.SendResult, err error) {
+		if err == nil && result.Status != primitive.SendOK {
+			err = fmt.Errorf("send status %d", result.Status)
+		}
+		once.Do(func() { done(err) })
+	}, msg)
+}
+
+func (b *rocketMQBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.consumer == nil {
+		c, err := NewPushConsumer(b.cfg)
+		if err != nil {
+			return err
+		}
+		b.consumer = c
+	}
+	return b.consumer.Subscribe(topic, consumer.MessageSelector{}, func(ctx context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+		for _, msg := range msgs {
+			d := newDelivery(msg.Topic, msg.MsgId, msg.Body, msg.GetProperties(), int(msg.ReconsumeTimes), time.UnixMilli(msg.BornTimestamp))
+			if err := h(ctx, d); err != nil && !dropped(d, err) {
+				if delay, ok := held(err); ok {
+					if err := b.hold(ctx, msg, d.Attempts, delay); err == nil {
+						continue
+					}
+					log.Printf("[MQ] Failed to re-publish held message %s: %v", msg.MsgId, err)
+				}
+				var retry *RetryError
+				if cc, ok := primitive.GetConcurrentlyCtx(ctx); ok && errors.As(err, &retry) {
+					// Level 0 lets the broker pick 3 + reconsume times; never retry earlier than that
+					cc.DelayLevelWhenNextConsume = max(DelayLevel(retry.Delay), min(3+int(msg.ReconsumeTimes), len(delayLevels)))
+				}
+				return consumer.ConsumeRetryLater, nil
+			}
+		}
+		return consumer.ConsumeSuccess, nil
+	})
+}
+
+// hold re-publishes msg to its topic with a delay, carrying its attempts over in
+// RetryTimesProperty since a redelivery by the broker would count as one more.
+func (b *rocketMQBroker) hold(ctx context.Context, msg *primitive.MessageExt, attempts int, delay time.Duration) error {
+	out := &primitive.Message{Topic: msg.Topic, Body: msg.Body}
+	out.WithProperties(msg.GetProperties())
+	out.WithProperty(RetryTimesProperty, strconv.Itoa(attempts))
+	out.WithDelayTimeLevel(DelayLevel(delay))
+	_, err := b.producer.SendSync(ctx, out)
+	return err
+}
+
+// Start starts the consumer. Topics subscribed later are picked up by the running consumer.
+func (b *rocketMQBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.consumer == nil {
+		c, err := NewPushConsumer(b.cfg)
+		if err != nil {
+			return err
+		}
+		b.consumer = c
+	}
+	if err := b.consumer.Start(); err != nil {
+		return err
+	}
+	b.started = true
+	return nil
+}
+
+func (b *rocketMQBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		if err := b.consumer.Shutdown(); err != nil {
+			log.Printf("[MQ] Failed to shutdown consumer: %v", err)
+		}
+	}
+	return b.producer.Shutdown()
+}
@@ -0,0 +1,289 @@
+//go:build rocketmq5
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	rmq "github.com/apache/rocketmq-clients/golang/v5"
+	"github.com/apache/rocketmq-clients/golang/v5/credentials"
+
+	"notification-system/pkg/config"
+)
+
+// RocketMQ 5 clients talk gRPC to a proxy, which the remoting client of rocketMQBroker
+// can't reach. The gRPC client is linked in with -tags rocketmq5 only, as it pulls in
+// its own dependency tree. go.mod pins its version:
+//
+//	go build -tags rocketmq5 ./...
+
+const (
+	// rmqReceiveBatch is the number of messages asked for per receive request.
+	rmqReceiveBatch = 16
+	// rmqAwait is how long a receive request waits for messages (long polling).
+	rmqAwait = 20 * time.Second
+	// rmqCodeMessageNotFound is the status of a receive request that found no messages.
+	rmqCodeMessageNotFound = 40401
+)
+
+// rocketMQ5Broker publishes and consumes through a RocketMQ 5.x proxy. The topics are
+// consumed by a simple consumer of mq.group_name: received messages stay invisible to
+// the group for rocketmq5.invisible_duration, and are redelivered unless acknowledged.
+// A failed message is made visible again after its retry delay, which counts an attempt
+// like RocketMQ's consumer retries do.
+type rocketMQ5Broker struct {
+	cfg      config.MQConfig
+	producer rmq.Producer
+	consumer rmq.SimpleConsumer // created by Start
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	started  bool
+	slots    chan struct{} // bounds the handlers running at once
+	ctx      context.Context
+	cancel   context.CancelFunc
+	polling  sync.WaitGroup
+}
+
+func newRocketMQ5Broker(cfg config.MQConfig) (Broker, error) {
+	p, err := rmq.NewProducer(rmqConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start producer: %w", err)
+	}
+
+	goroutines := cfg.ConsumeGoroutines
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rocketMQ5Broker{
+		cfg:      cfg,
+		producer: p,
+		handlers: make(map[string]Handler),
+		slots:    make(chan struct{}, goroutines),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+func rmqConfig(cfg config.MQConfig) *rmq.Config {
+	c := &rmq.Config{
+		Endpoint:      cfg.RocketMQ5.Endpoint,
+		NameSpace:     cfg.Namespace,
+		ConsumerGroup: cfg.GroupName,
+		Credentials:   &credentials.SessionCredentials{},
+	}
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		c.Credentials = &credentials.SessionCredentials{AccessKey: cfg.AccessKey, AccessSecret: cfg.SecretKey}
+	}
+	return c
+}
+
+// rmqMessage converts a message built by the send options. Keys, tags and delay levels
+// map onto their 5.x counterparts; the sharding key stays a property, as message groups
+// are only accepted by FIFO topics.
+func rmqMessage(topic string, body []byte, opts []SendOption) (*rmq.Message, error) {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := &rmq.Message{Topic: topic, Body: msg.Body}
+	for k, v := range msg.GetProperties() {
+		switch k {
+		case primitive.PropertyKeys:
+			out.SetKeys(strings.Fields(v)...)
+		case primitive.PropertyTags:
+			out.SetTag(v)
+		case primitive.PropertyDelayTimeLevel:
+			if level, err := strconv.Atoi(v); err == nil && level > 0 {
+				out.SetDelayTimestamp(time.Now().Add(delayLevels[min(level, len(delayLevels))-1]))
+			}
+		default:
+			out.AddProperty(k, v)
+		}
+	}
+	return out, nil
+}
+
+func (b *rocketMQ5Broker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := rmqMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	_, err = b.producer.Send(ctx, msg)
+	return err
+}
+
+func (b *rocketMQ5Broker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	switch {
+	case !b.started:
+		return nil
+	case b.consumer == nil:
+		return b.consume()
+	}
+	return b.consumer.Subscribe(topic, rmq.SUB_ALL)
+}
+
+// Start starts consuming the subscribed topics. Topics subscribed later are added to the
+// running consumer.
+func (b *rocketMQ5Broker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.started = true
+	if len(b.handlers) == 0 {
+		return nil
+	}
+	return b.consume()
+}
+
+// consume creates the simple consumer of the subscribed topics and polls it. Callers
+// hold b.mu.
+func (b *rocketMQ5Broker) consume() error {
+	subscriptions := make(map[string]*rmq.FilterExpression, len(b.handlers))
+	for topic := range b.handlers {
+		subscriptions[topic] = rmq.SUB_ALL
+	}
+	c, err := rmq.NewSimpleConsumer(rmqConfig(b.cfg),
+		rmq.WithAwaitDuration(rmqAwait),
+		rmq.WithSubscriptionExpressions(subscriptions),
+	)
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("failed to start consumer: %w", err)
+	}
+	b.consumer = c
+	b.polling.Add(1)
+	go b.poll()
+	return nil
+}
+
+// poll receives messages until Close, handling each on its own goroutine while a handler
+// slot is free.
+func (b *rocketMQ5Broker) poll() {
+	defer b.polling.Done()
+	invisible := b.cfg.RocketMQ5.InvisibleDuration.Std()
+
+	var failed int
+	for b.ctx.Err() == nil {
+		msgs, err := b.consumer.Receive(b.ctx, rmqReceiveBatch, invisible)
+		if status, ok := rmq.AsErrRpcStatus(err); ok && status.Code == rmqCodeMessageNotFound {
+			continue
+		}
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			// Logged once per failure streak, a proxy outage would flood the log otherwise
+			if failed == 0 {
+				log.Printf("[MQ] Failed to receive messages from %s: %v", b.cfg.RocketMQ5.Endpoint, err)
+			}
+			failed++
+			time.Sleep(time.Second)
+			continue
+		}
+		if failed > 0 {
+			log.Printf("[MQ] Receiving messages from %s again after %d failures", b.cfg.RocketMQ5.Endpoint, failed)
+			failed = 0
+		}
+		for _, m := range msgs {
+			b.slots <- struct{}{}
+			go func() {
+				defer func() { <-b.slots }()
+				b.handle(m)
+			}()
+		}
+	}
+}
+
+func (b *rocketMQ5Broker) handle(m *rmq.MessageView) {
+	b.mu.Lock()
+	h := b.handlers[m.GetTopic()]
+	b.mu.Unlock()
+	if h == nil {
+		return
+	}
+
+	born := time.Now()
+	if t := m.GetBornTimestamp(); t != nil {
+		born = *t
+	}
+	attempts := max(int(m.GetDeliveryAttempt())-1, 0)
+	d := newDelivery(m.GetTopic(), m.GetMessageId(), m.GetBody(), m.GetProperties(), attempts, born)
+	if err := h(context.Background(), d); err != nil && !dropped(d, err) {
+		if delay, ok := held(err); ok {
+			if err := b.hold(m, d.Attempts, delay); err == nil {
+				b.ack(m)
+				return
+			}
+			log.Printf("[MQ] Failed to re-publish held message %s: %v", m.GetMessageId(), err)
+		}
+		if err := b.consumer.ChangeInvisibleDuration(m, redeliveryDelay(err, attempts)); err != nil {
+			log.Printf("[MQ] Failed to delay redelivery of message %s: %v", m.GetMessageId(), err)
+		}
+		return
+	}
+	b.ack(m)
+}
+
+func (b *rocketMQ5Broker) ack(m *rmq.MessageView) {
+	if err := b.consumer.Ack(context.Background(), m); err != nil {
+		// The message becomes visible again and is redelivered
+		log.Printf("[MQ] Failed to acknowledge message %s: %v", m.GetMessageId(), err)
+	}
+}
+
+// hold re-publishes m to its topic with a delay, carrying its attempts over in
+// RetryTimesProperty since a redelivery would count as one more.
+func (b *rocketMQ5Broker) hold(m *rmq.MessageView, attempts int, delay time.Duration) error {
+	out := &rmq.Message{Topic: m.GetTopic(), Body: m.GetBody()}
+	for k, v := range m.GetProperties() {
+		out.AddProperty(k, v)
+	}
+	if tag := m.GetTag(); tag != nil {
+		out.SetTag(*tag)
+	}
+	if keys := m.GetKeys(); len(keys) > 0 {
+		out.SetKeys(keys...)
+	}
+	out.AddProperty(RetryTimesProperty, strconv.Itoa(attempts))
+	out.SetDelayTimestamp(time.Now().Add(delay))
+	_, err := b.producer.Send(context.Background(), out)
+	return err
+}
+
+// Close stops polling and then the consumer and the producer. Messages not acknowledged
+// yet are redelivered once their invisible duration ends.
+func (b *rocketMQ5Broker) Close() error {
+	b.cancel()
+	b.polling.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var errs []error
+	if b.consumer != nil {
+		errs = append(errs, b.consumer.GracefulStop())
+	}
+	errs = append(errs, b.producer.GracefulStop())
+	return errors.Join(errs...)
+}
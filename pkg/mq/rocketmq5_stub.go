@@ -0,0 +1,15 @@
+//go:build !rocketmq5
+
+package mq
+
+import (
+	"fmt"
+
+	"notification-system/pkg/config"
+)
+
+// newRocketMQ5Broker fails in builds without the RocketMQ 5 gRPC client, which is only
+// linked in with -tags rocketmq5 (see rocketmq5.go).
+func newRocketMQ5Broker(cfg config.MQConfig) (Broker, error) {
+	return nil, fmt.Errorf("mq.broker %s requires a build with -tags rocketmq5", config.BrokerRocketMQ5)
+}
@@ -0,0 +1,299 @@
+package mq
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// spoolSegmentBytes bounds a spool segment before writes move on to a new one.
+const spoolSegmentBytes = 4 << 20
+
+// ErrSpoolFull is returned by Spool.Put when the spool holds its maximum size.
+var ErrSpoolFull = errors.New("spool is full")
+
+// spooled is a message kept in the spool, as JSON lines. The body is stored as it would
+// have been sent, compressed or encrypted, so replaying it applies no options again.
+type spooled struct {
+	Topic      string            `json:"topic"`
+	Body       []byte            `json:"body"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Time       time.Time         `json:"time"`
+}
+
+// Spool keeps messages the broker did not accept in append-only segment files
+// (00000001.spool, ...), to send them once it does. Messages are replayed oldest first;
+// those older than maxAge are dropped, and Put fails once the segments hold maxBytes.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	replaying sync.Mutex // one Replay at a time
+
+	mu      sync.Mutex
+	size    int64 // bytes in all segments
+	current *os.File
+	curSize int64
+	next    int // number of the next segment
+	dropped int64
+}
+
+// OpenSpool opens the spool in dir, creating it if needed. Segments left by an earlier
+// process are replayed like new ones.
+func OpenSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes, maxAge: maxAge, next: 1}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segments {
+		info, err := os.Stat(seg)
+		if err != nil {
+			return nil, err
+		}
+		s.size += info.Size()
+		s.next = max(s.next, segmentNumber(seg)+1)
+	}
+	return s, nil
+}
+
+// segmentNumber returns the number of a segment file, or 0 if it isn't numbered.
+func segmentNumber(seg string) int {
+	var n int
+	fmt.Sscanf(filepath.Base(seg), "%08d.spool", &n)
+	return n
+}
+
+// segments lists the segment files, oldest first.
+func (s *Spool) segments() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.spool"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Put appends a message for topic to the spool and syncs it to disk. opts are applied
+// as Publish would.
+func (s *Spool) Put(topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(spooled{Topic: topic, Body: msg.Body, Properties: msg.GetProperties(), Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(line)) > s.maxBytes {
+		return ErrSpoolFull
+	}
+	if s.current == nil || s.curSize >= spoolSegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.current.Write(line); err != nil {
+		return err
+	}
+	if err := s.current.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(line))
+	s.curSize += int64(len(line))
+	return nil
+}
+
+// rotate closes the current segment and starts a new one. Callers hold s.mu.
+func (s *Spool) rotate() error {
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("%08d.spool", s.next)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	s.next++
+	s.current, s.curSize = f, 0
+	return nil
+}
+
+// Size returns the bytes held by the spool.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Dropped returns the number of messages dropped for being older than the maximum age.
+func (s *Spool) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Replay publishes the spooled messages to b, oldest first, and removes them from the
+// spool. It stops at the first failure, keeping the messages not sent yet.
+func (s *Spool) Replay(ctx context.Context, b Broker) (sent int, err error) {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	s.mu.Lock()
+	if s.size == 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	// Writes move on to a new segment, so the existing ones can be replayed and rewritten
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+	limit := s.next
+	s.mu.Unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		return 0, err
+	}
+	for _, seg := range segments {
+		if segmentNumber(seg) >= limit {
+			break
+		}
+		n, err := s.replaySegment(ctx, b, seg)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// replaySegment publishes the messages of seg and removes it; on failure, the messages
+// not sent yet are written back to seg.
+func (s *Spool) replaySegment(ctx context.Context, b Broker, seg string) (sent int, err error) {
+	msgs, err := readSegment(seg)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(seg)
+	if err != nil {
+		return 0, err
+	}
+
+	var dropped int64
+	for i, m := range msgs {
+		if s.maxAge > 0 && time.Since(m.Time) > s.maxAge {
+			dropped++
+			continue
+		}
+		if err = b.Publish(ctx, m.Topic, m.Body, WithProperties(m.Properties)); err != nil {
+			s.mu.Lock()
+			s.dropped += dropped
+			s.mu.Unlock()
+			if werr := s.rewrite(seg, info.Size(), msgs[i:]); werr != nil {
+				return sent, fmt.Errorf("%w; failed to keep the remaining messages of %s: %v", err, seg, werr)
+			}
+			return sent, err
+		}
+		sent++
+	}
+
+	if err := os.Remove(seg); err != nil {
+		return sent, err
+	}
+	s.mu.Lock()
+	s.dropped += dropped
+	s.size -= info.Size()
+	s.mu.Unlock()
+	return sent, nil
+}
+
+// rewrite replaces seg, of size bytes, with msgs.
+func (s *Spool) rewrite(seg string, size int64, msgs []spooled) error {
+	tmp := seg + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, seg); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.size += info.Size() - size
+	s.mu.Unlock()
+	return nil
+}
+
+func readSegment(seg string) ([]spooled, error) {
+	f, err := os.Open(seg)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// A decoder reads messages of any size, where a line scanner would get stuck on one
+	// larger than its buffer
+	var msgs []spooled
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var m spooled
+		if err := dec.Decode(&m); err != nil {
+			// Reading ends at the end of the segment, or at a write torn by a crash
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				return msgs, err
+			}
+			return msgs, nil
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// Close closes the current segment. Spooled messages stay on disk for the next process.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
@@ -0,0 +1,98 @@
+package mq_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"notification-system/pkg/mq"
+)
+
+// recordingBroker records the messages published to it, failing from the failAt-th on.
+type recordingBroker struct {
+	failAt int
+	bodies [][]byte
+}
+
+func (b *recordingBroker) Publish(ctx context.Context, topic string, body []byte, opts ...mq.SendOption) error {
+	if b.failAt > 0 && len(b.bodies)+1 >= b.failAt {
+		return errors.New("broker unavailable")
+	}
+	b.bodies = append(b.bodies, body)
+	return nil
+}
+
+func (b *recordingBroker) Subscribe(topic string, h mq.Handler) error { return nil }
+func (b *recordingBroker) Start() error                               { return nil }
+func (b *recordingBroker) Close() error                               { return nil }
+
+func TestSpoolReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	spool, err := mq.OpenSpool(dir, 64<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	// The second message is larger than a line scanner would read
+	bodies := [][]byte{[]byte("first"), bytes.Repeat([]byte("x"), 17<<20), []byte("third")}
+	for _, body := range bodies {
+		if err := spool.Put("order_queue", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failing := &recordingBroker{failAt: 2}
+	if sent, err := spool.Replay(ctx, failing); sent != 1 || err == nil {
+		t.Fatalf("Replay = %d, %v, want 1 message sent and an error", sent, err)
+	}
+
+	b := &recordingBroker{}
+	if sent, err := spool.Replay(ctx, b); sent != 2 || err != nil {
+		t.Fatalf("Replay = %d, %v, want the 2 remaining messages sent", sent, err)
+	}
+	if len(b.bodies) != 2 || !bytes.Equal(b.bodies[0], bodies[1]) || !bytes.Equal(b.bodies[1], bodies[2]) {
+		t.Errorf("replayed %d messages, want the large and the third message", len(b.bodies))
+	}
+	if size := spool.Size(); size != 0 {
+		t.Errorf("Size = %d after the replay, want 0", size)
+	}
+}
+
+func TestSpoolReplayTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := mq.OpenSpool(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Put("order_queue", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	spool.Close()
+
+	// A crash in the middle of the next write leaves part of its line
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.spool"))
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(segments))
+	}
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"topic":"order_queue","bo`)
+	f.Close()
+
+	reopened, err := mq.OpenSpool(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &recordingBroker{}
+	if sent, err := reopened.Replay(context.Background(), b); sent != 1 || err != nil {
+		t.Fatalf("Replay = %d, %v, want the complete message sent", sent, err)
+	}
+}
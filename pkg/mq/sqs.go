@@ -0,0 +1,484 @@
+package mq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"notification-system/pkg/config"
+)
+
+// Message attributes of SQS messages and SNS notifications.
+const (
+	// sqsPropertiesAttribute carries the message properties as a JSON object, as SQS
+	// allows no more than 10 attributes per message.
+	sqsPropertiesAttribute = "NotifyProperties"
+	// sqsBase64Attribute marks base64-encoded bodies. SQS only accepts XML characters in
+	// bodies, so binary ones, such as compressed or encrypted bodies, are encoded.
+	sqsBase64Attribute = "NotifyBase64"
+)
+
+const (
+	// sqsMaxDelay is the longest delay of a message sent to SQS.
+	sqsMaxDelay = 15 * time.Minute
+	// sqsMaxVisibility is the longest visibility timeout of a received message.
+	sqsMaxVisibility = 12 * time.Hour
+	// sqsWaitSeconds is how long a receive request waits for messages (long polling).
+	sqsWaitSeconds = 20
+	// sqsReceiveBatch is the most messages SQS returns per receive request.
+	sqsReceiveBatch = 10
+)
+
+// sqsBroker publishes to and consumes from AWS SQS queues, or publishes to SNS topics
+// fanning out to a queue per consumer group (see config.SQSConfig). A failed message is
+// left in its queue and becomes visible again after its retry delay, by setting its
+// visibility timeout; SQS counts the receives, which make up the attempts.
+type sqsBroker struct {
+	cfg config.MQConfig
+	sqs *sqs.Client
+	sns *sns.Client // nil without fan-out
+
+	queueURLs sync.Map // queue name -> URL
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	started  bool
+	slots    chan struct{} // bounds the handlers running at once
+	ctx      context.Context
+	cancel   context.CancelFunc
+	polling  sync.WaitGroup
+}
+
+func newSQSBroker(cfg config.MQConfig) (*sqsBroker, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.SQS.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.SQS.Region))
+	}
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	var endpoint *string
+	if cfg.SQS.Endpoint != "" {
+		endpoint = aws.String(cfg.SQS.Endpoint)
+	}
+	goroutines := cfg.ConsumeGoroutines
+	if goroutines == 0 {
+		goroutines = 20
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &sqsBroker{
+		cfg:      cfg,
+		sqs:      sqs.NewFromConfig(awsCfg, func(o *sqs.Options) { o.BaseEndpoint = endpoint }),
+		handlers: make(map[string]Handler),
+		slots:    make(chan struct{}, goroutines),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if cfg.SQS.SNSTopicARNPrefix != "" {
+		b.sns = sns.NewFromConfig(awsCfg, func(o *sns.Options) { o.BaseEndpoint = endpoint })
+	}
+	return b, nil
+}
+
+// queueName returns the queue consumer group cfg.GroupName consumes topic from.
+func (b *sqsBroker) queueName(topic string) string {
+	if b.sns != nil {
+		return b.name(b.cfg.GroupName + "_" + topic)
+	}
+	return b.name(topic)
+}
+
+// name prefixes and, for FIFO, suffixes the name of a queue or SNS topic.
+func (b *sqsBroker) name(s string) string {
+	s = b.cfg.SQS.QueuePrefix + s
+	if b.cfg.SQS.FIFO {
+		s += ".fifo"
+	}
+	return s
+}
+
+func (b *sqsBroker) queueURL(ctx context.Context, name string) (string, error) {
+	if u, ok := b.queueURLs.Load(name); ok {
+		return u.(string), nil
+	}
+	out, err := b.sqs.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err != nil {
+		return "", fmt.Errorf("queue %s: %w", name, err)
+	}
+	b.queueURLs.Store(name, *out.QueueUrl)
+	return *out.QueueUrl, nil
+}
+
+// sqsBody returns body as an SQS message body, base64-encoded unless it consists of
+// characters SQS accepts.
+func sqsBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		valid := true
+		for _, r := range string(body) {
+			if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF || r >= 0xD800 && r < 0xE000 {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return string(body), false
+		}
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+// randomID returns a message deduplication ID for FIFO queues and topics, which makes
+// every send a new message.
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (b *sqsBroker) Publish(ctx context.Context, topic string, body []byte, opts ...SendOption) error {
+	msg, err := newMessage(topic, body, opts)
+	if err != nil {
+		return err
+	}
+	props, err := json.Marshal(msg.GetProperties())
+	if err != nil {
+		return err
+	}
+	text, encoded := sqsBody(msg.Body)
+	var group, dedup *string
+	if b.cfg.SQS.FIFO {
+		// Messages without a sharding key have no order to keep, so they spread over groups
+		key := msg.GetShardingKey()
+		if key == "" {
+			key = randomID()
+		}
+		group, dedup = aws.String(key), aws.String(randomID())
+	}
+
+	if b.sns != nil {
+		attrs := map[string]snstypes.MessageAttributeValue{
+			sqsPropertiesAttribute: {DataType: aws.String("String"), StringValue: aws.String(string(props))},
+		}
+		if encoded {
+			attrs[sqsBase64Attribute] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("true")}
+		}
+		_, err = b.sns.Publish(ctx, &sns.PublishInput{
+			TopicArn:               aws.String(b.cfg.SQS.SNSTopicARNPrefix + b.name(topic)),
+			Message:                aws.String(text),
+			MessageAttributes:      attrs,
+			MessageGroupId:         group,
+			MessageDeduplicationId: dedup,
+		})
+		return err
+	}
+	return b.send(ctx, b.queueName(topic), text, encoded, string(props), group, dedup, 0)
+}
+
+func (b *sqsBroker) send(ctx context.Context, queue, text string, encoded bool, props string, group, dedup *string, delay time.Duration) error {
+	u, err := b.queueURL(ctx, queue)
+	if err != nil {
+		return err
+	}
+	attrs := map[string]types.MessageAttributeValue{
+		sqsPropertiesAttribute: {DataType: aws.String("String"), StringValue: aws.String(props)},
+	}
+	if encoded {
+		attrs[sqsBase64Attribute] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("true")}
+	}
+	_, err = b.sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(u),
+		MessageBody:            aws.String(text),
+		MessageAttributes:      attrs,
+		MessageGroupId:         group,
+		MessageDeduplicationId: dedup,
+		DelaySeconds:           int32(min(delay, sqsMaxDelay) / time.Second),
+	})
+	return err
+}
+
+func (b *sqsBroker) Subscribe(topic string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[topic]; ok {
+		return fmt.Errorf("topic %s is already subscribed", topic)
+	}
+	b.handlers[topic] = h
+	if b.started {
+		return b.consume(topic, h)
+	}
+	return nil
+}
+
+func (b *sqsBroker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, h := range b.handlers {
+		if err := b.consume(topic, h); err != nil {
+			return fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+	b.started = true
+	return nil
+}
+
+// consume polls the queue of topic until Close. Callers hold b.mu.
+func (b *sqsBroker) consume(topic string, h Handler) error {
+	u, err := b.queueURL(b.ctx, b.queueName(topic))
+	if err != nil {
+		return err
+	}
+	b.polling.Add(1)
+	go b.poll(topic, u, h)
+	return nil
+}
+
+// poll receives the messages of a queue, handling each on its own goroutine while a
+// handler slot is free.
+func (b *sqsBroker) poll(topic, queueURL string, h Handler) {
+	defer b.polling.Done()
+
+	var failed int
+	for b.ctx.Err() == nil {
+		out, err := b.sqs.ReceiveMessage(b.ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: sqsReceiveBatch,
+			WaitTimeSeconds:     sqsWaitSeconds,
+			VisibilityTimeout:   int32(b.cfg.SQS.VisibilityTimeout.Std() / time.Second),
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+				types.MessageSystemAttributeNameSentTimestamp,
+				types.MessageSystemAttributeNameMessageGroupId,
+			},
+			MessageAttributeNames: []string{sqsPropertiesAttribute, sqsBase64Attribute},
+		})
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			// Logged once per failure streak, an outage would flood the log otherwise
+			if failed == 0 {
+				log.Printf("[MQ] Failed to receive messages of %s: %v", topic, err)
+			}
+			failed++
+			time.Sleep(time.Second)
+			continue
+		}
+		if failed > 0 {
+			log.Printf("[MQ] Receiving messages of %s again after %d failures", topic, failed)
+			failed = 0
+		}
+		for _, m := range out.Messages {
+			b.slots <- struct{}{}
+			go func() {
+				defer func() { <-b.slots }()
+				b.handle(topic, queueURL, m, h)
+			}()
+		}
+	}
+}
+
+func (b *sqsBroker) handle(topic, queueURL string, m types.Message, h Handler) {
+	id := aws.ToString(m.MessageId)
+	props := make(map[string]string)
+	if v := m.MessageAttributes[sqsPropertiesAttribute].StringValue; v != nil {
+		if err := json.Unmarshal([]byte(*v), &props); err != nil {
+			log.Printf("[MQ] Dropping message %s with invalid properties: %v", id, err)
+			b.delete(queueURL, m)
+			return
+		}
+	}
+	body := []byte(aws.ToString(m.Body))
+	if m.MessageAttributes[sqsBase64Attribute].StringValue != nil {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			log.Printf("[MQ] Dropping message %s with an invalid body: %v", id, err)
+			b.delete(queueURL, m)
+			return
+		}
+		body = decoded
+	}
+	attempts := 0
+	if n, err := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]); err == nil {
+		attempts = max(n-1, 0)
+	}
+	born := time.Now()
+	if ms, err := strconv.ParseInt(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		born = time.UnixMilli(ms)
+	}
+
+	d := newDelivery(topic, id, body, props, attempts, born)
+	stop := b.keepInvisible(queueURL, m)
+	err := h(context.Background(), d)
+	stop()
+	if err != nil && !dropped(d, err) {
+		if delay, ok := held(err); ok {
+			if err := b.hold(queueURL, m, d.Attempts, delay); err == nil {
+				b.delete(queueURL, m)
+				return
+			}
+			log.Printf("[MQ] Failed to re-publish held message %s: %v", id, err)
+		}
+		// Back off like RocketMQ's consumer retries: 10s, 30s, 1m, 2m, ... up to 2h
+		b.setVisibility(queueURL, m, redeliveryDelay(err, attempts))
+		return
+	}
+	b.delete(queueURL, m)
+}
+
+// keepInvisible extends the visibility timeout of m while it is being handled, until
+// stop is called.
+func (b *sqsBroker) keepInvisible(queueURL string, m types.Message) (stop func()) {
+	timeout := b.cfg.SQS.VisibilityTimeout.Std()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				b.setVisibility(queueURL, m, timeout)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (b *sqsBroker) setVisibility(queueURL string, m types.Message, d time.Duration) {
+	_, err := b.sqs.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     m.ReceiptHandle,
+		VisibilityTimeout: int32(min(d, sqsMaxVisibility) / time.Second),
+	})
+	if err != nil {
+		log.Printf("[MQ] Failed to change the visibility of message %s: %v", aws.ToString(m.MessageId), err)
+	}
+}
+
+func (b *sqsBroker) delete(queueURL string, m types.Message) {
+	_, err := b.sqs.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	if err != nil {
+		// The message becomes visible again and is redelivered
+		log.Printf("[MQ] Failed to delete message %s: %v", aws.ToString(m.MessageId), err)
+	}
+}
+
+// hold sends m to its queue again after delay, carrying its attempts over in
+// RetryTimesProperty since a redelivery would count as one more. Standard queues delay
+// the copy, by up to 15 minutes; FIFO queues can't, so the handler slot waits, keeping m
+// invisible meanwhile. With SNS fan-out, the copy only goes to the group's own queue.
+func (b *sqsBroker) hold(queueURL string, m types.Message, attempts int, delay time.Duration) error {
+	props := make(map[string]string)
+	if v := m.MessageAttributes[sqsPropertiesAttribute].StringValue; v != nil {
+		json.Unmarshal([]byte(*v), &props)
+	}
+	props[RetryTimesProperty] = strconv.Itoa(attempts)
+	raw, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	attrs := map[string]types.MessageAttributeValue{
+		sqsPropertiesAttribute: {DataType: aws.String("String"), StringValue: aws.String(string(raw))},
+	}
+	if v, ok := m.MessageAttributes[sqsBase64Attribute]; ok {
+		attrs[sqsBase64Attribute] = v
+	}
+	var group, dedup *string
+	if b.cfg.SQS.FIFO {
+		stop := b.keepInvisible(queueURL, m)
+		time.Sleep(delay)
+		stop()
+		group, dedup = aws.String(m.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]), aws.String(randomID())
+		delay = 0
+	}
+	_, err = b.sqs.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl:               aws.String(queueURL),
+		MessageBody:            m.Body,
+		MessageAttributes:      attrs,
+		MessageGroupId:         group,
+		MessageDeduplicationId: dedup,
+		DelaySeconds:           int32(min(delay, sqsMaxDelay) / time.Second),
+	})
+	return err
+}
+
+// Close stops polling. Messages being handled and not deleted yet become visible again
+// once their visibility timeout ends.
+func (b *sqsBroker) Close() error {
+	b.cancel()
+	b.polling.Wait()
+	return nil
+}
+
+// sqsQueueDepth returns the messages of the queue consumer group cfg.GroupName consumes
+// topic from: those waiting, delayed and, with inFlight, those received but not deleted.
+// Queues that don't exist have a depth of 0.
+func sqsQueueDepth(ctx context.Context, cfg config.MQConfig, topic string, inFlight bool) (int64, error) {
+	b, err := newSQSBroker(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	u, err := b.queueURL(ctx, b.queueName(topic))
+	var missing *types.QueueDoesNotExist
+	if errors.As(err, &missing) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	names := []types.QueueAttributeName{
+		types.QueueAttributeNameApproximateNumberOfMessages,
+		types.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+	}
+	if inFlight {
+		names = append(names, types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)
+	}
+	out, err := b.sqs.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: aws.String(u), AttributeNames: names})
+	if err != nil {
+		return 0, err
+	}
+	var depth int64
+	for _, name := range names {
+		n, err := strconv.ParseInt(out.Attributes[string(name)], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s of queue %s", name, b.queueName(topic))
+		}
+		depth += n
+	}
+	return depth, nil
+}
@@ -0,0 +1,213 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+
+	"notification-system/pkg/config"
+)
+
+// RocketMQ remoting request codes for creating topics.
+const (
+	reqUpdateAndCreateTopic = 17
+	reqGetBrokerClusterInfo = 106
+)
+
+// EnsureTopics checks that topics exist as configured by cfg.TopicCheck (nil skips the
+// check): missing topics are created, or else the error lists them. Only RocketMQ and
+// Kafka topics are checked.
+func EnsureTopics(ctx context.Context, cfg config.MQConfig, topics []string) error {
+	check := cfg.TopicCheck
+	if check == nil {
+		return nil
+	}
+	var missing []string
+	var err error
+	switch cfg.Broker {
+	case config.BrokerRocketMQ:
+		missing, err = rocketMQMissingTopics(ctx, cfg, topics)
+	case config.BrokerKafka:
+		missing, err = kafkaMissingTopics(ctx, cfg, topics)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check topics: %w", err)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !check.Create {
+		return fmt.Errorf("topics do not exist: %s (create them or set mq.topic_check.create)", strings.Join(missing, ", "))
+	}
+
+	unit := "queues per broker"
+	if cfg.Broker == config.BrokerKafka {
+		err = createKafkaTopics(ctx, cfg, missing)
+		unit = "partitions"
+	} else {
+		err = createRocketMQTopics(ctx, cfg, missing)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create topics: %w", err)
+	}
+	log.Printf("Created topics %s with %d %s", strings.Join(missing, ", "), check.Queues, unit)
+	return nil
+}
+
+func rocketMQMissingTopics(ctx context.Context, cfg config.MQConfig, topics []string) ([]string, error) {
+	var missing []string
+	for _, t := range topics {
+		_, err := topicRoute(ctx, cfg, withNamespace(cfg, t))
+		if errors.Is(err, errTopicNotExist) {
+			missing = append(missing, t)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// createRocketMQTopics creates topics on every master broker of mq.topic_check.cluster,
+// like mqadmin updateTopic -c does.
+func createRocketMQTopics(ctx context.Context, cfg config.MQConfig, topics []string) error {
+	addrs, err := clusterBrokers(ctx, cfg, cfg.TopicCheck.Cluster)
+	if err != nil {
+		return err
+	}
+	queues := strconv.Itoa(cfg.TopicCheck.Queues)
+	for _, t := range topics {
+		for _, addr := range addrs {
+			resp, err := invoke(ctx, cfg, addr, reqUpdateAndCreateTopic, map[string]string{
+				"topic":           withNamespace(cfg, t),
+				"defaultTopic":    "TBW102",
+				"readQueueNums":   queues,
+				"writeQueueNums":  queues,
+				"perm":            "6", // read and write
+				"topicFilterType": "SINGLE_TAG",
+				"topicSysFlag":    "0",
+				"order":           "false",
+			}, nil)
+			if err != nil {
+				return fmt.Errorf("topic %s on broker %s: %w", t, addr, err)
+			}
+			if resp.Code != remotingResponseSuccess {
+				return fmt.Errorf("topic %s on broker %s: create failed (code %d): %s", t, addr, resp.Code, resp.Remark)
+			}
+		}
+	}
+	return nil
+}
+
+// clusterBrokers asks the name server for the master brokers of cluster, or of every
+// cluster when it is empty.
+func clusterBrokers(ctx context.Context, cfg config.MQConfig, cluster string) ([]string, error) {
+	resp, err := invoke(ctx, cfg, cfg.NameServer, reqGetBrokerClusterInfo, map[string]string{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("name server: %w", err)
+	}
+	if resp.Code != remotingResponseSuccess {
+		return nil, fmt.Errorf("cluster info not found (code %d): %s", resp.Code, resp.Remark)
+	}
+
+	var info struct {
+		BrokerAddrTable map[string]struct {
+			Cluster     string            `json:"cluster"`
+			BrokerAddrs map[string]string `json:"brokerAddrs"`
+		} `json:"brokerAddrTable"`
+	}
+	body := unquotedKeyPattern.ReplaceAll(resp.Body, []byte(`$1"$2":`))
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("invalid cluster info: %w", err)
+	}
+	var addrs []string
+	for _, b := range info.BrokerAddrTable {
+		if addr := b.BrokerAddrs["0"]; addr != "" && (cluster == "" || b.Cluster == cluster) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		if cluster != "" {
+			return nil, fmt.Errorf("no master broker found in cluster %s", cluster)
+		}
+		return nil, fmt.Errorf("no master broker found")
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func kafkaMissingTopics(ctx context.Context, cfg config.MQConfig, topics []string) ([]string, error) {
+	b, err := newKafkaBroker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	conn, err := b.dialer.DialContext(ctx, "tcp", cfg.Kafka.Brokers[0])
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// Asking for all topics, as brokers may create the topics asked for by name
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for _, p := range partitions {
+		existing[p.Topic] = true
+	}
+	var missing []string
+	for _, t := range topics {
+		if !existing[t] {
+			missing = append(missing, t)
+		}
+	}
+	return missing, nil
+}
+
+// createKafkaTopics creates topics through the controller. Topics created meanwhile, e.g.
+// by another replica starting up, are left as they are.
+func createKafkaTopics(ctx context.Context, cfg config.MQConfig, topics []string) error {
+	b, err := newKafkaBroker(cfg)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	conn, err := b.dialer.DialContext(ctx, "tcp", cfg.Kafka.Brokers[0])
+	if err != nil {
+		return err
+	}
+	controller, err := conn.Controller()
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	conn, err = b.dialer.DialContext(ctx, "tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return fmt.Errorf("controller: %w", err)
+	}
+	defer conn.Close()
+
+	for _, t := range topics {
+		err := conn.CreateTopics(kafka.TopicConfig{
+			Topic:             t,
+			NumPartitions:     cfg.TopicCheck.Queues,
+			ReplicationFactor: cfg.TopicCheck.ReplicationFactor,
+		})
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("topic %s: %w", t, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Package openapi builds OpenAPI 3 documents for the HTTP APIs of the notification
+// system. Operations are described by the handlers that serve them; request and response
+// schemas are derived from the Go types they encode, following the rules of encoding/json.
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version of the documents.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+	types      map[reflect.Type]string
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem maps the lower-case HTTP methods of a path to their operations.
+type PathItem map[string]*Operation
+
+// Operation describes one method of a path.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// Security lists the alternative credentials the operation accepts; nil means none.
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a query, path or header parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request.
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes a response by its status code.
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]*Header   `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header.
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType holds the schema of a body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the named schemas and the security schemes.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes a kind of credentials.
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+}
+
+// Schema is a subset of the OpenAPI schema object. An empty Schema allows any value.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is the schema of the values of a map.
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+	Items                *Schema `json:"items,omitempty"`
+	Nullable             bool    `json:"nullable,omitempty"`
+	ReadOnly             bool    `json:"readOnly,omitempty"`
+}
+
+// New creates a document without operations.
+func New(title, version, description string) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       Info{Title: title, Description: description, Version: version},
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: make(map[string]*Schema), SecuritySchemes: make(map[string]*SecurityScheme)},
+		types:      make(map[reflect.Type]string),
+	}
+}
+
+// Add describes the method of a path. Its operation ID defaults to the method and the
+// path, e.g. "post_admin_replay".
+func (d *Document) Add(method, path string, op *Operation) {
+	if op.OperationID == "" {
+		op.OperationID = operationID(method, path)
+	}
+	if op.Responses == nil {
+		op.Responses = make(map[string]*Response)
+	}
+	item := d.Paths[path]
+	if item == nil {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// SecurityScheme registers credentials operations can require by name.
+func (d *Document) SecurityScheme(name string, s *SecurityScheme) {
+	d.Components.SecuritySchemes[name] = s
+}
+
+// Schema returns the schema of the type of v. Named struct types are added to the
+// components and referenced, so they are described once.
+func (d *Document) Schema(v interface{}) *Schema {
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+// Component returns the registered schema of a named struct type, e.g. to mark required
+// or read-only properties after Schema referenced it. It returns nil for other types.
+func (d *Document) Component(v interface{}) *Schema {
+	name, ok := d.types[reflect.TypeOf(v)]
+	if !ok {
+		return nil
+	}
+	return d.Components.Schemas[name]
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t.Kind() == reflect.Pointer {
+		return d.schemaOf(t.Elem())
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Implements(jsonMarshalerType), t.Implements(textMarshalerType):
+		// Custom encodings in this module, such as config.Duration, are strings
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return d.ref(t)
+	}
+	return &Schema{}
+}
+
+// ref registers a named struct type and returns a reference to it.
+func (d *Document) ref(t reflect.Type) *Schema {
+	name, ok := d.types[t]
+	if !ok {
+		name = componentName(t, d.Components.Schemas)
+		d.types[t] = name
+		// Registered before its fields so recursive types end in a reference
+		d.Components.Schemas[name] = &Schema{}
+		*d.Components.Schemas[name] = *d.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// structSchema describes the exported fields of t by their JSON names, with the fields
+// of embedded structs promoted.
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for k, v := range d.structSchema(ft).Properties {
+				if _, ok := s.Properties[k]; !ok {
+					s.Properties[k] = v
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = d.schemaOf(f.Type)
+	}
+	return s
+}
+
+// componentName names the schema of t after the type, capitalized, and prefixes the
+// package when another type took the name.
+func componentName(t reflect.Type, taken map[string]*Schema) string {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if _, ok := taken[name]; ok {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
+
+func operationID(method, p string) string {
+	parts := strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '{' || r == '}' || r == '.' })
+	return strings.ToLower(method) + "_" + strings.Join(parts, "_")
+}
+
+// JSON is the content of a JSON body with the given schema.
+func JSON(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// Text is the content of a plain text body, such as the messages of http.Error.
+func Text() map[string]MediaType {
+	return map[string]MediaType{"text/plain": {Schema: &Schema{Type: "string"}}}
+}
+
+// Body is a required JSON request body with the given schema.
+func Body(description string, s *Schema) *RequestBody {
+	return &RequestBody{Description: description, Required: true, Content: JSON(s)}
+}
+
+// Query is an optional query parameter.
+func Query(name, typ, description string) *Parameter {
+	return &Parameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: typ}}
+}
+
+// PathParam is a parameter in the path, e.g. {event_type}.
+func PathParam(name, description string) *Parameter {
+	return &Parameter{Name: name, In: "path", Description: description, Required: true, Schema: &Schema{Type: "string"}}
+}
+
+// Responses maps status codes to responses. Responses without content are given a text
+// body, which is what the handlers of this module write for errors.
+func Responses(responses map[int]*Response) map[string]*Response {
+	out := make(map[string]*Response, len(responses))
+	for code, r := range responses {
+		if r.Description == "" {
+			r.Description = http.StatusText(code)
+		}
+		if r.Content == nil && code != http.StatusNoContent {
+			r.Content = Text()
+		}
+		out[strconv.Itoa(code)] = r
+	}
+	return out
+}
+
+// Error is a text response for an error status.
+func Error(description string) *Response {
+	return &Response{Description: description}
+}
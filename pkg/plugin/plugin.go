@@ -0,0 +1,292 @@
+// Package plugin runs notifier plugins: executables that deliver notifications over
+// channels the worker has no built-in support for.
+//
+// A plugin is started on first use and kept running. The worker writes one JSON Request
+// per line to its stdin and reads one JSON Response per line from its stdout, matched by
+// ID; a plugin may handle requests concurrently and answer them in any order. What it
+// writes to stderr is logged. A plugin that exits is restarted on the next delivery.
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+const (
+	// restartDelay is the minimum time between two starts of a plugin, so a plugin that
+	// crashes on start doesn't run once per delivery.
+	restartDelay = time.Second
+	// stopTimeout is how long a plugin may take to exit after its stdin is closed before
+	// it is killed.
+	stopTimeout = 5 * time.Second
+	// maxResponse bounds one response line.
+	maxResponse = 1 << 20
+)
+
+// Request asks a plugin to deliver a notification.
+type Request struct {
+	ID        uint64 `json:"id"`
+	Channel   string `json:"channel"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	Tenant    string `json:"tenant,omitempty"`
+	// Method, URL and Headers are rendered from http_method, http_url and headers.
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is the rendered body, encoded as configured by body_format.
+	Body string `json:"body"`
+}
+
+// Response answers the Request with the same ID.
+type Response struct {
+	ID uint64 `json:"id"`
+	// Error is empty when the notification was delivered.
+	Error string `json:"error,omitempty"`
+	// Permanent marks an error retrying cannot fix; the message goes to the DLQ.
+	Permanent bool `json:"permanent,omitempty"`
+	// RetryAfterMs asks for the next attempt to wait at least this long.
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+// Error is a delivery the plugin reported as failed.
+type Error struct {
+	Channel    string
+	Message    string
+	Permanent  bool
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("channel %s: %s", e.Channel, e.Message)
+}
+
+// Plugin is the process of a channel. It is safe for concurrent use.
+type Plugin struct {
+	cfg config.ChannelConfig
+
+	mu      sync.Mutex
+	proc    *process
+	started time.Time
+	closed  bool
+}
+
+func newPlugin(cfg config.ChannelConfig) *Plugin {
+	return &Plugin{cfg: cfg}
+}
+
+// Deliver sends req to the plugin, starting it if needed, and waits up to the channel
+// timeout for the response. A failure reported by the plugin is returned as *Error; other
+// errors mean the plugin could not be asked or did not answer.
+func (p *Plugin) Deliver(ctx context.Context, req Request) error {
+	proc, err := p.process()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", p.cfg.Name, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout.Std())
+	defer cancel()
+
+	req.Channel = p.cfg.Name
+	resp, err := proc.call(ctx, req)
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", p.cfg.Name, err)
+	}
+	if resp.Error != "" {
+		return &Error{
+			Channel:    p.cfg.Name,
+			Message:    resp.Error,
+			Permanent:  resp.Permanent,
+			RetryAfter: time.Duration(resp.RetryAfterMs) * time.Millisecond,
+		}
+	}
+	return nil
+}
+
+// process returns the running process, starting it unless it exited moments ago.
+func (p *Plugin) process() (*process, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, errors.New("plugin is stopped")
+	}
+	if p.proc != nil {
+		select {
+		case <-p.proc.done:
+			if time.Since(p.started) < restartDelay {
+				return nil, fmt.Errorf("process exited: %v", p.proc.err)
+			}
+		default:
+			return p.proc, nil
+		}
+	}
+	proc, err := start(p.cfg)
+	p.started = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	p.proc = proc
+	return proc, nil
+}
+
+// Close stops the plugin: its stdin is closed and it is killed unless it exits within
+// stopTimeout. Pending deliveries fail.
+func (p *Plugin) Close() {
+	p.mu.Lock()
+	p.closed = true
+	proc := p.proc
+	p.mu.Unlock()
+	if proc != nil {
+		proc.stop()
+	}
+}
+
+// process is one run of a plugin executable.
+type process struct {
+	name string
+	cmd  *exec.Cmd
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan Response
+
+	done chan struct{} // closed once the process exited
+	err  error         // exit error, set before done is closed
+}
+
+func start(cfg config.ChannelConfig) (*process, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = stderrLogger(cfg.Name)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cfg.Command, err)
+	}
+	log.Printf("Plugin %s started (pid %d)", cfg.Name, cmd.Process.Pid)
+
+	proc := &process{
+		name:    cfg.Name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan Response),
+		done:    make(chan struct{}),
+	}
+	go proc.read(stdout)
+	return proc, nil
+}
+
+// read dispatches the responses on stdout until the process exits.
+func (pr *process) read(stdout io.Reader) {
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 0, 64<<10), maxResponse)
+	for sc.Scan() {
+		var resp Response
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			log.Printf("Plugin %s: ignoring invalid response: %v", pr.name, err)
+			continue
+		}
+		pr.mu.Lock()
+		ch, ok := pr.pending[resp.ID]
+		delete(pr.pending, resp.ID)
+		pr.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Printf("Plugin %s: failed to read responses: %v", pr.name, err)
+		pr.cmd.Process.Kill()
+		io.Copy(io.Discard, stdout)
+	}
+	pr.err = pr.cmd.Wait()
+	if pr.err == nil {
+		pr.err = errors.New("exit status 0")
+	}
+	log.Printf("Plugin %s exited: %v", pr.name, pr.err)
+	close(pr.done)
+}
+
+func (pr *process) call(ctx context.Context, req Request) (Response, error) {
+	ch := make(chan Response, 1)
+	pr.mu.Lock()
+	pr.nextID++
+	req.ID = pr.nextID
+	pr.pending[req.ID] = ch
+	pr.mu.Unlock()
+	defer func() {
+		pr.mu.Lock()
+		delete(pr.pending, req.ID)
+		pr.mu.Unlock()
+	}()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	pr.writeMu.Lock()
+	_, err = pr.stdin.Write(append(line, '\n'))
+	pr.writeMu.Unlock()
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-pr.done:
+		// The response may have been read just before the exit
+		select {
+		case resp := <-ch:
+			return resp, nil
+		default:
+			return Response{}, fmt.Errorf("process exited: %v", pr.err)
+		}
+	case <-ctx.Done():
+		return Response{}, fmt.Errorf("no response: %w", ctx.Err())
+	}
+}
+
+func (pr *process) stop() {
+	pr.stdin.Close()
+	select {
+	case <-pr.done:
+	case <-time.After(stopTimeout):
+		pr.cmd.Process.Kill()
+		<-pr.done
+	}
+}
+
+// stderrLogger logs what a plugin writes to stderr, line by line.
+type stderrLogger string
+
+func (name stderrLogger) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		log.Printf("Plugin %s: %s", string(name), line)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"reflect"
+	"sync"
+
+	"notification-system/pkg/config"
+)
+
+// Registry holds the plugins of the configured channels.
+type Registry struct {
+	mu      sync.Mutex
+	plugins map[string]*Plugin
+}
+
+func NewRegistry() *Registry {
+	return &Registry{plugins: make(map[string]*Plugin)}
+}
+
+// Update applies a new set of channels. Plugins of removed channels are stopped, those
+// of changed channels are stopped and start again with the new settings on next use.
+func (r *Registry) Update(channels []config.ChannelConfig) {
+	r.mu.Lock()
+	var stopped []*Plugin
+	plugins := make(map[string]*Plugin, len(channels))
+	for _, c := range channels {
+		if p, ok := r.plugins[c.Name]; ok && reflect.DeepEqual(p.cfg, c) {
+			plugins[c.Name] = p
+			continue
+		}
+		plugins[c.Name] = newPlugin(c)
+	}
+	for name, p := range r.plugins {
+		if plugins[name] != p {
+			stopped = append(stopped, p)
+		}
+	}
+	r.plugins = plugins
+	r.mu.Unlock()
+
+	for _, p := range stopped {
+		go p.Close()
+	}
+}
+
+// Get returns the plugin of a channel.
+func (r *Registry) Get(name string) (*Plugin, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.plugins[name]
+	return p, ok
+}
+
+// Close stops all plugins.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	plugins := r.plugins
+	r.plugins = make(map[string]*Plugin)
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range plugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,145 @@
+// Package ratelimit enforces quotas: a sustained event rate with bursts and a daily event
+// volume, tracked per key (a tenant, an API key or a notification target).
+//
+// A MemoryLimiter keeps its counters in process memory, so each API instance or worker
+// enforces its quotas independently. A RedisLimiter shares them between processes.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Quota limits the events accepted for one key. Zero fields are unlimited.
+type Quota struct {
+	// Rate is the sustained number of events per second.
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is the number of events accepted at once above the rate (default: rate rounded up).
+	Burst int `json:"burst,omitempty"`
+	// Daily is the number of events accepted per UTC day.
+	Daily int64 `json:"daily,omitempty"`
+}
+
+// Validate reports invalid quota settings.
+func (q Quota) Validate() error {
+	if q.Rate < 0 || q.Burst < 0 || q.Daily < 0 {
+		return fmt.Errorf("rate, burst and daily cannot be negative")
+	}
+	if q.Burst > 0 && q.Rate == 0 {
+		return fmt.Errorf("burst requires rate")
+	}
+	return nil
+}
+
+func (q Quota) burst() int {
+	if q.Burst > 0 {
+		return q.Burst
+	}
+	return max(1, int(math.Ceil(q.Rate)))
+}
+
+// ExceededError is returned by Allow when an event is over quota.
+type ExceededError struct {
+	Key    string
+	Reason string
+	// RetryAfter is when the next event will be accepted.
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s: %s quota exceeded", e.Key, e.Reason)
+}
+
+// Stats counts the quota decisions for one key.
+type Stats struct {
+	Allowed  int64 `json:"allowed"`
+	Rejected int64 `json:"rejected"`
+	// Today is the number of events accepted during the current UTC day.
+	Today int64 `json:"today"`
+}
+
+// Limiter tracks quota usage per key.
+type Limiter interface {
+	// Allow records one event for key if it fits q, and returns an *ExceededError otherwise.
+	Allow(key string, q Quota) error
+	// Stats returns the counters of every key seen so far by this process.
+	Stats() map[string]Stats
+}
+
+// MemoryLimiter is a Limiter counting in process memory.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	quota   Quota
+	limiter *rate.Limiter
+	day     time.Time
+	stats   Stats
+}
+
+// New creates an empty MemoryLimiter.
+func New() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow records one event for key if it fits q, and returns an *ExceededError otherwise.
+// A changed quota (e.g. after a config reload) takes effect immediately; the daily count is kept.
+func (l *MemoryLimiter) Allow(key string, q Quota) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{}
+		l.buckets[key] = b
+	}
+	if !ok || b.quota != q {
+		b.quota = q
+		b.limiter = nil
+		if q.Rate > 0 {
+			b.limiter = rate.NewLimiter(rate.Limit(q.Rate), q.burst())
+		}
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	if !b.day.Equal(today) {
+		b.day, b.stats.Today = today, 0
+	}
+
+	if q.Daily > 0 && b.stats.Today >= q.Daily {
+		b.stats.Rejected++
+		return &ExceededError{Key: key, Reason: "daily volume", RetryAfter: today.Add(24 * time.Hour).Sub(now)}
+	}
+	if b.limiter != nil {
+		r := b.limiter.ReserveN(now, 1)
+		if delay := r.DelayFrom(now); delay > 0 {
+			r.CancelAt(now)
+			b.stats.Rejected++
+			return &ExceededError{Key: key, Reason: "rate", RetryAfter: delay}
+		}
+	}
+
+	b.stats.Allowed++
+	b.stats.Today++
+	return nil
+}
+
+// Stats returns the counters of every key seen so far.
+func (l *MemoryLimiter) Stats() map[string]Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make(map[string]Stats, len(l.buckets))
+	for key, b := range l.buckets {
+		out[key] = b.stats
+	}
+	return out
+}
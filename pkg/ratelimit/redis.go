@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisTimeout bounds one quota check; Redis is expected to answer within milliseconds.
+	redisTimeout = 500 * time.Millisecond
+	// redisErrorLogInterval rate-limits the logging of Redis failures.
+	redisErrorLogInterval = time.Minute
+)
+
+// RedisConfig connects a RedisLimiter.
+type RedisConfig struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	// TLS connects over TLS, verifying the server against the system roots.
+	TLS bool `json:"tls,omitempty"`
+	// KeyPrefix is prepended to every Redis key (default "notify:ratelimit:" for rate
+	// limits; other users of Redis have their own).
+	KeyPrefix string `json:"key_prefix,omitempty"`
+}
+
+// allowScript checks and records one event atomically. The rate is enforced with GCRA:
+// KEYS[1] holds the theoretical arrival time of the next event in milliseconds. KEYS[2]
+// counts the events of the current UTC day and expires at midnight. Times come from the
+// Redis clock, so processes with skewed clocks share one schedule.
+//
+// ARGV: rate (events/s, 0 unlimited), burst, daily volume (0 unlimited).
+// Returns {allowed, reason (1 rate, 2 daily volume), retry after ms, events today}.
+var allowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local daily = tonumber(ARGV[3])
+local dayEnd = (math.floor(now / 86400000) + 1) * 86400000
+
+local today = tonumber(redis.call('GET', KEYS[2]) or '0')
+if daily > 0 and today >= daily then
+  return {0, 2, dayEnd - now, today}
+end
+if rate > 0 then
+  local interval = 1000 / rate
+  local tat = tonumber(redis.call('GET', KEYS[1]) or '0')
+  if tat < now then
+    tat = now
+  end
+  local allowAt = tat + interval - interval * burst
+  if now < allowAt then
+    return {0, 1, math.ceil(allowAt - now), today}
+  end
+  local next = tat + interval
+  redis.call('SET', KEYS[1], string.format('%.3f', next), 'PX', math.ceil(next - now) + 1000)
+end
+today = redis.call('INCR', KEYS[2])
+redis.call('PEXPIREAT', KEYS[2], dayEnd)
+return {1, 0, 0, today}
+`)
+
+// RedisLimiter is a Limiter whose counters are shared through Redis, so every process
+// using the same Redis enforces one aggregate quota per key. When Redis is unavailable
+// events are allowed, so an outage of the limiter does not stop ingestion or delivery.
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+
+	mu        sync.Mutex
+	stats     map[string]Stats
+	lastError time.Time
+}
+
+// NewRedisLimiter creates a RedisLimiter. It connects lazily.
+func NewRedisLimiter(cfg RedisConfig) (*RedisLimiter, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("redis addr is required")
+	}
+	client, prefix := cfg.Client(redisTimeout, "notify:ratelimit:")
+	return &RedisLimiter{client: client, prefix: prefix, stats: make(map[string]Stats)}, nil
+}
+
+// Client connects to the Redis of cfg, bounding each read and write by timeout, and
+// returns the prefix of its keys: KeyPrefix, or defaultPrefix if that is unset. It
+// connects lazily.
+func (cfg RedisConfig) Client(timeout time.Duration, defaultPrefix string) (*redis.Client, string) {
+	opts := &redis.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return redis.NewClient(opts), prefix
+}
+
+// Open returns a RedisLimiter when cfg is set and a MemoryLimiter otherwise.
+func Open(cfg *RedisConfig) (Limiter, error) {
+	if cfg == nil {
+		return New(), nil
+	}
+	return NewRedisLimiter(*cfg)
+}
+
+func (l *RedisLimiter) Allow(key string, q Quota) error {
+	if q.Rate == 0 && q.Daily == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	// The hash tag keeps both keys in one slot of a Redis Cluster
+	base := l.prefix + "{" + key + "}"
+	res, err := allowScript.Run(ctx, l.client, []string{base + ":rate", base + ":daily"}, q.Rate, q.burst(), q.Daily).Int64Slice()
+	if err == nil && len(res) != 4 {
+		err = fmt.Errorf("unexpected reply %v", res)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.stats[key]
+	defer func() { l.stats[key] = s }()
+
+	if err != nil {
+		if time.Since(l.lastError) > redisErrorLogInterval {
+			log.Printf("Rate limiter: Redis unavailable, allowing events: %v", err)
+			l.lastError = time.Now()
+		}
+		s.Allowed++
+		return nil
+	}
+	s.Today = res[3]
+	if res[0] == 1 {
+		s.Allowed++
+		return nil
+	}
+	s.Rejected++
+	reason := "rate"
+	if res[1] == 2 {
+		reason = "daily volume"
+	}
+	return &ExceededError{Key: key, Reason: reason, RetryAfter: time.Duration(res[2]) * time.Millisecond}
+}
+
+func (l *RedisLimiter) Stats() map[string]Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make(map[string]Stats, len(l.stats))
+	for key, s := range l.stats {
+		out[key] = s
+	}
+	return out
+}
+
+// Close closes the connections to Redis.
+func (l *RedisLimiter) Close() error {
+	return l.client.Close()
+}
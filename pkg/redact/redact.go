@@ -0,0 +1,83 @@
+// Package redact masks sensitive event fields (email, phone, ...) so they don't end up
+// in logs, delivery records or, optionally, delivered payloads.
+package redact
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"notification-system/pkg/transform"
+)
+
+// minScrubLength is the shortest value Scrub replaces; shorter values would mask unrelated text.
+const minScrubLength = 4
+
+// Redactor masks a fixed set of fields.
+type Redactor struct {
+	fields []string
+	keep   int
+	mask   transform.Pipeline
+}
+
+// New creates a Redactor for dot-separated field paths. Masked values reveal at most
+// their last keep characters.
+func New(fields []string, keep int) (*Redactor, error) {
+	mask, err := transform.Compile([]transform.Spec{{Type: "mask", Fields: fields, Keep: keep}})
+	if err != nil {
+		return nil, err
+	}
+	return &Redactor{fields: fields, keep: keep, mask: mask}, nil
+}
+
+// Data returns a copy of data with the fields masked.
+func (r *Redactor) Data(data map[string]interface{}) map[string]interface{} {
+	out, err := r.mask.Apply(data)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// Scrub replaces every occurrence in s of a sensitive value taken from data, e.g. a target
+// echoing the email address back in an error response.
+func (r *Redactor) Scrub(data map[string]interface{}, s string) string {
+	values := r.values(data)
+	if len(values) == 0 || s == "" {
+		return s
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, transform.Mask(v, r.keep))
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// values collects the string form of the sensitive fields present in data, longest first
+// so that overlapping values are replaced as a whole.
+func (r *Redactor) values(data map[string]interface{}) []string {
+	var values []string
+	for _, f := range r.fields {
+		if v, ok := lookup(data, f); ok && v != nil {
+			if s := fmt.Sprint(v); len(s) >= minScrubLength {
+				values = append(values, s)
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+func lookup(data map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
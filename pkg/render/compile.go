@@ -0,0 +1,279 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"notification-system/pkg/event"
+)
+
+// Template is a template compiled once, with its placeholders parsed, to be rendered
+// for many events.
+type Template struct {
+	root node
+}
+
+// Compile parses the placeholders of the template v, like Value would on every call.
+func Compile(v interface{}) (*Template, error) {
+	root, err := compile(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{root: root}, nil
+}
+
+// Render returns a copy of the template with all placeholders resolved against evt.
+func (t *Template) Render(evt event.Event) (interface{}, error) {
+	return t.root.render(evt)
+}
+
+// JSON renders the template and encodes the result as JSON.
+func (t *Template) JSON(evt event.Event) ([]byte, error) {
+	rendered, err := t.Render(evt)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rendered)
+}
+
+// Missing returns the placeholders of the template that have no value for evt and no
+// default (see the function Missing).
+func (t *Template) Missing(evt event.Event) []string {
+	return t.root.missing(evt, nil)
+}
+
+// node is a compiled part of a template.
+type node interface {
+	render(evt event.Event) (interface{}, error)
+	missing(evt event.Event, out []string) []string
+}
+
+func compile(v interface{}) (node, error) {
+	switch val := v.(type) {
+	case string:
+		if IsPlaceholder(val) {
+			e, err := parse(val)
+			if err != nil {
+				return nil, err
+			}
+			return &exprNode{src: val, e: e}, nil
+		}
+		t, err := CompileText(val)
+		if err != nil {
+			return nil, err
+		}
+		if t.static() {
+			return constNode{val}, nil
+		}
+		return t, nil
+	case map[string]interface{}:
+		n := &objectNode{keys: make([]string, 0, len(val))}
+		for k := range val {
+			n.keys = append(n.keys, k)
+		}
+		sort.Strings(n.keys)
+		n.values = make([]node, len(n.keys))
+		for i, k := range n.keys {
+			item, err := compile(val[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			n.values[i] = item
+		}
+		return n, nil
+	case []interface{}:
+		n := make(arrayNode, len(val))
+		for i, item := range val {
+			c, err := compile(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			n[i] = c
+		}
+		return n, nil
+	default:
+		return constNode{val}, nil
+	}
+}
+
+// constNode is a value without placeholders.
+type constNode struct {
+	v interface{}
+}
+
+func (n constNode) render(event.Event) (interface{}, error) { return n.v, nil }
+
+func (n constNode) missing(_ event.Event, out []string) []string { return out }
+
+// exprNode is a string made of one placeholder, which keeps the type of its value.
+type exprNode struct {
+	src string
+	e   *expr
+}
+
+func (n *exprNode) render(evt event.Event) (interface{}, error) { return n.e.eval(evt) }
+
+func (n *exprNode) missing(evt event.Event, out []string) []string {
+	if lookup(n.e.path, evt) == nil && !n.e.hasDefault() {
+		out = append(out, n.src)
+	}
+	return out
+}
+
+type objectNode struct {
+	keys   []string // sorted
+	values []node
+}
+
+func (n *objectNode) render(evt event.Event) (interface{}, error) {
+	out := make(map[string]interface{}, len(n.keys))
+	for i, k := range n.keys {
+		v, err := n.values[i].render(evt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (n *objectNode) missing(evt event.Event, out []string) []string {
+	for _, v := range n.values {
+		out = v.missing(evt, out)
+	}
+	return out
+}
+
+type arrayNode []node
+
+func (n arrayNode) render(evt event.Event) (interface{}, error) {
+	out := make([]interface{}, len(n))
+	for i, item := range n {
+		v, err := item.render(evt)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (n arrayNode) missing(evt event.Event, out []string) []string {
+	for _, item := range n {
+		out = item.missing(evt, out)
+	}
+	return out
+}
+
+// Text is a string with embedded placeholders, compiled once to be rendered for many
+// events (see String).
+type Text struct {
+	parts []textPart
+}
+
+// textPart is literal text or, when e is set, the placeholder src.
+type textPart struct {
+	text string
+	src  string
+	e    *expr
+}
+
+// CompileText parses the placeholders embedded in s.
+func CompileText(s string) (*Text, error) {
+	t := &Text{}
+	err := scan(s, func(text string) {
+		if text != "" {
+			t.parts = append(t.parts, textPart{text: text})
+		}
+	}, func(placeholder string) error {
+		e, err := parse(placeholder)
+		if err != nil {
+			return err
+		}
+		t.parts = append(t.parts, textPart{src: placeholder, e: e})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// static reports whether t has no placeholders.
+func (t *Text) static() bool {
+	for _, p := range t.parts {
+		if p.e != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Render replaces the placeholders of t with the string form of their values for evt.
+func (t *Text) Render(evt event.Event) (string, error) {
+	if len(t.parts) == 1 && t.parts[0].e == nil {
+		return t.parts[0].text, nil
+	}
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.e == nil {
+			b.WriteString(p.text)
+			continue
+		}
+		v, err := p.e.eval(evt)
+		if err != nil {
+			return "", err
+		}
+		if v != nil {
+			b.WriteString(toString(v))
+		}
+	}
+	return b.String(), nil
+}
+
+// render lets a Text be a node of a Template.
+func (t *Text) render(evt event.Event) (interface{}, error) { return t.Render(evt) }
+
+// Missing returns the placeholders of t that have no value for evt and no default.
+func (t *Text) Missing(evt event.Event) []string {
+	return t.missing(evt, nil)
+}
+
+func (t *Text) missing(evt event.Event, out []string) []string {
+	for _, p := range t.parts {
+		if p.e != nil && lookup(p.e.path, evt) == nil && !p.e.hasDefault() {
+			out = append(out, p.src)
+		}
+	}
+	return out
+}
+
+// maxCachedTexts bounds the strings compileCached keeps. Templates come from the
+// configuration, so the cache stays far smaller; beyond it, strings are compiled on use.
+const maxCachedTexts = 4096
+
+var (
+	texts       sync.Map // source string -> *Text
+	cachedTexts atomic.Int64
+)
+
+// compileCached returns the compiled form of s, compiling it on first use.
+func compileCached(s string) (*Text, error) {
+	if t, ok := texts.Load(s); ok {
+		return t.(*Text), nil
+	}
+	t, err := CompileText(s)
+	if err != nil {
+		return nil, err
+	}
+	if cachedTexts.Load() < maxCachedTexts {
+		if _, loaded := texts.LoadOrStore(s, t); !loaded {
+			cachedTexts.Add(1)
+		}
+	}
+	return t, nil
+}
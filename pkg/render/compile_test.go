@@ -0,0 +1,65 @@
+package render_test
+
+import (
+	"reflect"
+	"testing"
+
+	"notification-system/pkg/event"
+	"notification-system/pkg/render"
+)
+
+func TestCompile(t *testing.T) {
+	evt := event.Event{ID: "evt-1", Type: "order.created", Data: map[string]interface{}{
+		"id":    float64(42),
+		"name":  "alice",
+		"items": []interface{}{"a", "b"},
+	}}
+	tests := []struct {
+		name string
+		tmpl interface{}
+		want interface{}
+	}{
+		{"constant", map[string]interface{}{"n": 1.5, "s": "plain"}, map[string]interface{}{"n": 1.5, "s": "plain"}},
+		{"placeholder keeps its type", "{$.event.id}", float64(42)},
+		{"embedded placeholder", "/orders/{$.event.id}?by={$.event.name | upper}", "/orders/42?by=ALICE"},
+		{"missing field", "{$.event.missing}", nil},
+		{"default", "{$.event.missing | default 'guest'}", "guest"},
+		{"nested", map[string]interface{}{
+			"event": "{$.type}",
+			"list":  []interface{}{"{$.id}", map[string]interface{}{"items": "{$.event.items}"}},
+		}, map[string]interface{}{
+			"event": "order.created",
+			"list":  []interface{}{"evt-1", map[string]interface{}{"items": []interface{}{"a", "b"}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := render.Compile(tt.tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A compiled template renders the same for every event
+			for range 2 {
+				got, err := tmpl.Render(evt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("Render = %#v, want %#v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileMalformed(t *testing.T) {
+	for _, tmpl := range []interface{}{
+		"{$.event.name | shout}",
+		"{$.unknown}",
+		map[string]interface{}{"url": "/orders/{$.event.id | default 'x}"},
+	} {
+		if _, err := render.Compile(tmpl); err == nil {
+			t.Errorf("Compile(%v) succeeded, want an error", tmpl)
+		}
+	}
+}
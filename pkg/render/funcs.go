@@ -0,0 +1,151 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Func is a template helper. It receives the current pipeline value and the
+// arguments written after the function name.
+type Func func(v interface{}, args []string) (interface{}, error)
+
+var funcs = map[string]Func{
+	"upper":     upper,
+	"lower":     lower,
+	"default":   defaultValue,
+	"urlencode": urlencode,
+	"date":      date,
+	"number":    number,
+}
+
+// upper converts the value to upper case: {$.event.code | upper}
+func upper(v interface{}, args []string) (interface{}, error) {
+	return strings.ToUpper(toString(v)), nil
+}
+
+// lower converts the value to lower case: {$.event.email | lower}
+func lower(v interface{}, args []string) (interface{}, error) {
+	return strings.ToLower(toString(v)), nil
+}
+
+// defaultValue replaces a missing or empty value: {$.event.name | default 'guest'}
+func defaultValue(v interface{}, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expects 1 argument")
+	}
+	if v == nil || v == "" {
+		return args[0], nil
+	}
+	return v, nil
+}
+
+// urlencode escapes the value for use in a URL query: {$.event.query | urlencode}
+func urlencode(v interface{}, args []string) (interface{}, error) {
+	return url.QueryEscape(toString(v)), nil
+}
+
+// date formats a time with a Go layout (default RFC 3339) or one of the names
+// rfc3339, unix and unixms: {$.timestamp | date '2006-01-02 15:04'}.
+// Strings are parsed as RFC 3339 and numbers as Unix seconds. An optional second
+// argument converts to a time zone: {$.timestamp | date rfc3339 'Asia/Shanghai'}
+func date(v interface{}, args []string) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("expects at most 2 arguments")
+	}
+	t, err := toTime(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 2 {
+		loc, err := time.LoadLocation(args[1])
+		if err != nil {
+			return nil, err
+		}
+		t = t.In(loc)
+	}
+
+	layout := time.RFC3339
+	if len(args) > 0 {
+		layout = args[0]
+	}
+	switch layout {
+	case "rfc3339":
+		return t.Format(time.RFC3339), nil
+	case "unix":
+		return t.Unix(), nil
+	case "unixms":
+		return t.UnixMilli(), nil
+	}
+	return t.Format(layout), nil
+}
+
+// number formats a numeric value with a fixed number of decimals (default 0): {$.event.amount | number 2}
+func number(v interface{}, args []string) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("expects at most 1 argument")
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return nil, err
+	}
+	prec := 0
+	if len(args) == 1 {
+		if prec, err = strconv.Atoi(args[0]); err != nil || prec < 0 {
+			return nil, fmt.Errorf("invalid precision '%s'", args[0])
+		}
+	}
+	return strconv.FormatFloat(f, 'f', prec, 64), nil
+}
+
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(val)
+		return string(b)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, nil
+	case string:
+		return time.Parse(time.RFC3339, val)
+	case float64:
+		sec := int64(val)
+		return time.Unix(sec, int64((val-float64(sec))*1e9)).UTC(), nil
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return toTime(f)
+	}
+	return time.Time{}, fmt.Errorf("cannot use %T as a time", v)
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case int:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	case json.Number:
+		return val.Float64()
+	case string:
+		return strconv.ParseFloat(val, 64)
+	}
+	return 0, fmt.Errorf("cannot use %T as a number", v)
+}
@@ -0,0 +1,385 @@
+// Package render resolves placeholders in notification templates against an event.
+//
+// A placeholder is a string of the form "{<path> | fn arg ... | fn ...}" where path is one of
+//
+//	$.id, $.type, $.timestamp,  event metadata
+//	$.tenant_id
+//	$.event.field[.nested...]   a field of the event data
+//	$.meta.key                  source, correlation_id, trace_id or a key of the
+//	                            event's metadata
+//
+// and the optional pipeline applies helper functions in order, e.g.
+// "{$.timestamp | date '2006-01-02'}" or "{$.event.name | default 'guest' | upper}".
+// A placeholder that makes up the whole string keeps the type of its value; a missing
+// field renders as null unless a default is given.
+//
+// Placeholders embedded in a longer string, such as a URL or header value, are replaced
+// by the string form of their value (see String); missing values render as "".
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/tidwall/gjson"
+
+	"notification-system/pkg/event"
+)
+
+// Value returns a copy of the template v with all placeholders resolved against evt.
+// Maps and slices are traversed recursively; other values are returned unchanged.
+// Templates rendered for many events are better compiled once with Compile.
+func Value(v interface{}, evt event.Event) (interface{}, error) {
+	t, err := Compile(v)
+	if err != nil {
+		return nil, err
+	}
+	return t.Render(evt)
+}
+
+// JSON renders the template and encodes the result as JSON.
+func JSON(tmpl map[string]interface{}, evt event.Event) ([]byte, error) {
+	t, err := Compile(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return t.JSON(evt)
+}
+
+// Check reports the first malformed placeholder (bad path, unknown function) in the template,
+// so configuration errors surface at load time instead of on delivery.
+func Check(v interface{}) error {
+	_, err := Compile(v)
+	return err
+}
+
+// Missing returns the placeholders of the template that have no value for evt and no
+// default, in the order they appear; keys of maps are taken in sorted order. Such
+// placeholders render as null or "". Malformed templates have none.
+func Missing(v interface{}, evt event.Event) []string {
+	if s, ok := v.(string); ok && !IsPlaceholder(s) {
+		t, err := compileCached(s)
+		if err != nil {
+			return nil
+		}
+		return t.Missing(evt)
+	}
+	t, err := Compile(v)
+	if err != nil {
+		return nil
+	}
+	return t.Missing(evt)
+}
+
+// String replaces every placeholder embedded in s with the string form of its value,
+// e.g. "https://api.example.com/users/{$.event.user_id}/notify". Strings are compiled
+// once and cached.
+func String(s string, evt event.Event) (string, error) {
+	t, err := compileCached(s)
+	if err != nil {
+		return "", err
+	}
+	return t.Render(evt)
+}
+
+// CheckString reports the first malformed placeholder embedded in s.
+func CheckString(s string) error {
+	_, err := CompileText(s)
+	return err
+}
+
+// Sample returns s with every embedded placeholder replaced by "x", so the
+// surrounding text can be validated (e.g. parsed as a URL) without an event.
+func Sample(s string) string {
+	var b strings.Builder
+	if err := scan(s, func(text string) {
+		b.WriteString(text)
+	}, func(string) error {
+		b.WriteString("x")
+		return nil
+	}); err != nil {
+		return s
+	}
+	return b.String()
+}
+
+// scan walks s, passing literal text and complete "{$...}" placeholders to the callbacks.
+func scan(s string, text func(string), placeholder func(string) error) error {
+	for {
+		start := strings.Index(s, "{$")
+		if start < 0 {
+			text(s)
+			return nil
+		}
+		text(s[:start])
+
+		end := -1
+		var quote rune
+		for i, r := range s[start:] {
+			if quote != 0 {
+				if r == quote {
+					quote = 0
+				}
+				continue
+			}
+			if r == '\'' || r == '"' {
+				quote = r
+			} else if r == '}' {
+				end = start + i
+				break
+			}
+		}
+		if end < 0 {
+			return fmt.Errorf("unterminated placeholder in '%s'", s)
+		}
+		if err := placeholder(s[start : end+1]); err != nil {
+			return err
+		}
+		s = s[end+1:]
+	}
+}
+
+// IsPlaceholder reports whether s consists of exactly one placeholder expression.
+func IsPlaceholder(s string) bool {
+	if !strings.HasPrefix(s, "{$") || !strings.HasSuffix(s, "}") {
+		return false
+	}
+	n := 0
+	err := scan(s, func(text string) {
+		if text != "" {
+			n++
+		}
+	}, func(string) error {
+		n++
+		return nil
+	})
+	return err == nil && n == 1
+}
+
+type call struct {
+	name string
+	fn   Func
+	args []string
+}
+
+type expr struct {
+	path  []string
+	calls []call
+}
+
+// parse compiles "{$.path | fn arg | ...}".
+func parse(s string) (*expr, error) {
+	src := s[1 : len(s)-1]
+	stages, err := splitPipeline(src)
+	if err != nil {
+		return nil, fmt.Errorf("placeholder %s: %v", s, err)
+	}
+
+	path := strings.Split(strings.TrimSpace(stages[0]), ".")
+	if err := checkPath(path); err != nil {
+		return nil, fmt.Errorf("placeholder %s: %v", s, err)
+	}
+
+	e := &expr{path: path}
+	for _, stage := range stages[1:] {
+		words, err := splitWords(stage)
+		if err != nil {
+			return nil, fmt.Errorf("placeholder %s: %v", s, err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("placeholder %s: empty function", s)
+		}
+		fn, ok := funcs[words[0]]
+		if !ok {
+			return nil, fmt.Errorf("placeholder %s: unknown function '%s'", s, words[0])
+		}
+		e.calls = append(e.calls, call{name: words[0], fn: fn, args: words[1:]})
+	}
+	return e, nil
+}
+
+func checkPath(path []string) error {
+	if path[0] != "$" || len(path) < 2 {
+		return fmt.Errorf("path must start with $.")
+	}
+	switch path[1] {
+	case "id", "type", "timestamp", "tenant_id":
+		if len(path) != 2 {
+			return fmt.Errorf("$.%s has no fields", path[1])
+		}
+	case "event":
+		if len(path) < 3 {
+			return fmt.Errorf("$.event requires a field")
+		}
+	case "meta":
+		if len(path) != 3 {
+			return fmt.Errorf("$.meta requires a single key")
+		}
+	default:
+		return fmt.Errorf("unknown path $.%s", path[1])
+	}
+	for _, p := range path[1:] {
+		if p == "" {
+			return fmt.Errorf("empty path segment")
+		}
+	}
+	return nil
+}
+
+func (e *expr) eval(evt event.Event) (interface{}, error) {
+	v := lookup(e.path, evt)
+	for _, c := range e.calls {
+		// A missing value passes through untouched until a default is applied.
+		if v == nil && c.name != "default" {
+			continue
+		}
+		var err error
+		if v, err = c.fn(v, c.args); err != nil {
+			return nil, fmt.Errorf("%s: %v", c.name, err)
+		}
+	}
+	return v, nil
+}
+
+func (e *expr) hasDefault() bool {
+	for _, c := range e.calls {
+		if c.name == "default" {
+			return true
+		}
+	}
+	return false
+}
+
+func lookup(path []string, evt event.Event) interface{} {
+	switch path[1] {
+	case "id":
+		return evt.ID
+	case "type":
+		return evt.Type
+	case "tenant_id":
+		return evt.TenantID
+	case "timestamp":
+		if evt.Timestamp.IsZero() {
+			return nil
+		}
+		return evt.Timestamp
+	case "meta":
+		if v, ok := evt.Meta(path[2]); ok {
+			return v
+		}
+		return nil
+	}
+
+	if evt.Data == nil && evt.RawData != nil {
+		return rawLookup(evt.RawData, path[2:])
+	}
+	var cur interface{} = evt.Data
+	for _, key := range path[2:] {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[key]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+// rawLookup is lookup of a data field for an event whose data is still undecoded: the
+// field is found by scanning raw, and only its value is decoded, with the types
+// json.Unmarshal would give it.
+func rawLookup(raw []byte, keys []string) interface{} {
+	// raw is never modified, so the scan can share its memory
+	r := gjson.Parse(unsafe.String(unsafe.SliceData(raw), len(raw)))
+	for _, key := range keys {
+		if !r.IsObject() {
+			return nil
+		}
+		if r = r.Get(escapeKey(key)); !r.Exists() {
+			return nil
+		}
+	}
+	return r.Value()
+}
+
+// pathChars are the characters gjson paths give a meaning, which keys have to escape.
+const pathChars = `\.*?|#@!=<>%~,()[]{}`
+
+// escapeKey quotes the characters of a data key that gjson paths give a meaning.
+func escapeKey(key string) string {
+	if !strings.ContainsAny(key, pathChars) {
+		return key
+	}
+	var b strings.Builder
+	for _, r := range key {
+		if strings.ContainsRune(pathChars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// splitPipeline splits on "|" outside of quotes.
+func splitPipeline(s string) ([]string, error) {
+	var stages []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '|':
+			stages = append(stages, s[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	return append(stages, s[start:]), nil
+}
+
+// splitWords splits a pipeline stage into the function name and its arguments.
+// Arguments may be quoted with ' or " to include spaces.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
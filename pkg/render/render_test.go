@@ -0,0 +1,56 @@
+package render_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"notification-system/pkg/event"
+	"notification-system/pkg/render"
+)
+
+// TestRawLookup renders placeholders against undecoded event data and expects the values
+// decoded data gives, also for keys with characters that gjson paths give a meaning.
+func TestRawLookup(t *testing.T) {
+	raw := json.RawMessage(`{
+		"id": 42,
+		"user": {"name": "alice", "tags": ["a", "b"], "active": true},
+		"#": "hash",
+		"a*": "star",
+		"why?": "question",
+		"none": null
+	}`)
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tmpl := range []string{
+		"{$.event.id}",
+		"{$.event.user}",
+		"{$.event.user.name}",
+		"{$.event.user.tags}",
+		"{$.event.user.active}",
+		"{$.event.#}",
+		"{$.event.a*}",
+		"{$.event.why?}",
+		"{$.event.user.tags.#}",
+		"{$.event.none}",
+		"{$.event.missing}",
+		"{$.event.id.nested}",
+		"{$.event.user.missing | default 'guest'}",
+		"/users/{$.event.user.name}/{$.event.id}",
+	} {
+		decoded, err := render.Value(tmpl, event.Event{Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		undecoded, err := render.Value(tmpl, event.Event{RawData: raw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(undecoded, decoded) {
+			t.Errorf("%s = %#v from raw data, want %#v", tmpl, undecoded, decoded)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package schema
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/mq"
+)
+
+// Message properties of events whose data is Avro-encoded with a registry schema. The
+// body holds the data in the Confluent wire format (a zero byte, the 4-byte schema ID
+// and the Avro binary encoding), so other consumers of the topic can decode it with
+// standard deserializers; the rest of the event is JSON in EventProperty.
+const (
+	ContentTypeAvro = "avro/binary"
+	// EventProperty holds the event without its data.
+	EventProperty = "NOTIFY_EVENT"
+	// SchemaIDProperty is the ID of the registry schema the data was checked against.
+	SchemaIDProperty = "NOTIFY_SCHEMA_ID"
+)
+
+// Schema types of the registry. Subjects registered without a type are Avro.
+const (
+	TypeAvro = "AVRO"
+	TypeJSON = "JSON"
+)
+
+// RegistryError reports a failed lookup in the registry, which may succeed when retried.
+type RegistryError struct {
+	Err error
+}
+
+func (e *RegistryError) Error() string { return e.Err.Error() }
+
+func (e *RegistryError) Unwrap() error { return e.Err }
+
+// RegistrySchema is a schema of the registry.
+type RegistrySchema struct {
+	ID   int
+	Type string
+
+	avro avro.Schema
+	json *jsonschema.Schema
+}
+
+// Registry looks up schemas in a Confluent compatible schema registry and caches them.
+// It is safe for concurrent use.
+type Registry struct {
+	mu     sync.Mutex
+	latest map[string]latestSchema
+	byID   map[string]*RegistrySchema
+}
+
+type latestSchema struct {
+	schema  *RegistrySchema
+	fetched time.Time
+}
+
+// NewRegistry creates a Registry with empty caches.
+func NewRegistry() *Registry {
+	return &Registry{latest: make(map[string]latestSchema), byID: make(map[string]*RegistrySchema)}
+}
+
+// Latest returns the latest schema of subject, cached for the configured cache_ttl. When
+// the registry can't be reached, an expired schema is used until it can.
+func (r *Registry) Latest(ctx context.Context, cfg *config.SchemaRegistryConfig, subject string) (*RegistrySchema, error) {
+	key := cfg.URL + "\x00" + subject
+	r.mu.Lock()
+	cached, ok := r.latest[key]
+	r.mu.Unlock()
+	if ok && time.Since(cached.fetched) < cfg.CacheTTL.Std() {
+		return cached.schema, nil
+	}
+
+	s, err := r.fetch(ctx, cfg, "/subjects/"+url.PathEscape(subject)+"/versions/latest")
+	if err != nil {
+		if ok {
+			log.Printf("Using cached schema %d of subject %s: %v", cached.schema.ID, subject, err)
+			return cached.schema, nil
+		}
+		return nil, &RegistryError{fmt.Errorf("failed to look up schema of subject %s: %w", subject, err)}
+	}
+	r.mu.Lock()
+	r.latest[key] = latestSchema{schema: s, fetched: time.Now()}
+	r.byID[cfg.URL+"\x00"+strconv.Itoa(s.ID)] = s
+	r.mu.Unlock()
+	return s, nil
+}
+
+// ByID returns the schema with the given ID, e.g. the writer schema of a message.
+func (r *Registry) ByID(ctx context.Context, cfg *config.SchemaRegistryConfig, id int) (*RegistrySchema, error) {
+	key := cfg.URL + "\x00" + strconv.Itoa(id)
+	r.mu.Lock()
+	s, ok := r.byID[key]
+	r.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := r.fetch(ctx, cfg, "/schemas/ids/"+strconv.Itoa(id))
+	if err != nil {
+		return nil, &RegistryError{fmt.Errorf("failed to look up schema %d: %w", id, err)}
+	}
+	s.ID = id
+	r.mu.Lock()
+	r.byID[key] = s
+	r.mu.Unlock()
+	return s, nil
+}
+
+// fetch gets and compiles the schema at path of the registry API.
+func (r *Registry) fetch(ctx context.Context, cfg *config.SchemaRegistryConfig, path string) (*RegistrySchema, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(cfg.URL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if cfg.Username != "" {
+		req.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+	client := &http.Client{Timeout: cfg.Timeout.Std()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("registry returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var doc struct {
+		ID         int    `json:"id"`
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid registry response: %w", err)
+	}
+	s := &RegistrySchema{ID: doc.ID, Type: doc.SchemaType}
+	switch s.Type {
+	case "", TypeAvro:
+		s.Type = TypeAvro
+		if s.avro, err = avro.Parse(doc.Schema); err != nil {
+			return nil, fmt.Errorf("invalid Avro schema %d: %w", doc.ID, err)
+		}
+	case TypeJSON:
+		if s.json, err = jsonschema.CompileString(fmt.Sprintf("registry:%d", doc.ID), doc.Schema); err != nil {
+			return nil, fmt.Errorf("invalid JSON Schema %d: %w", doc.ID, err)
+		}
+	default:
+		return nil, fmt.Errorf("schema type %s is not supported", s.Type)
+	}
+	return s, nil
+}
+
+// Validate checks data against the schema. It returns a *ValidationError when data is
+// invalid.
+func (s *RegistrySchema) Validate(data map[string]interface{}) error {
+	name := fmt.Sprintf("registry:%d", s.ID)
+	if s.Type == TypeJSON {
+		return check(s.json, name, data)
+	}
+	if _, err := s.encode(data); err != nil {
+		return &ValidationError{Schema: name, Causes: []string{err.Error()}}
+	}
+	return nil
+}
+
+// EncodeEvent validates the data of evt and returns the message body and properties of
+// evt. The data of Avro schemas is encoded as described at ContentTypeAvro; events of
+// JSON Schemas are encoded with mq.EncodeEvent and only flagged with the schema ID. It
+// returns a *ValidationError when the data does not match the schema.
+func (s *RegistrySchema) EncodeEvent(evt event.Event, encoding string) ([]byte, map[string]string, error) {
+	props := map[string]string{SchemaIDProperty: strconv.Itoa(s.ID)}
+	if s.Type != TypeAvro {
+		if err := s.Validate(evt.Data); err != nil {
+			return nil, nil, err
+		}
+		body, err := mq.EncodeEvent(evt, encoding)
+		return body, props, err
+	}
+
+	data, err := s.encode(evt.Data)
+	if err != nil {
+		return nil, nil, &ValidationError{Schema: fmt.Sprintf("registry:%d", s.ID), Causes: []string{err.Error()}}
+	}
+	body := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(body[1:], uint32(s.ID))
+	body = append(body, data...)
+
+	evt.Data = nil
+	envelope, err := json.Marshal(evt)
+	if err != nil {
+		return nil, nil, err
+	}
+	props[mq.ContentTypeProperty] = ContentTypeAvro
+	props[EventProperty] = string(envelope)
+	return body, props, nil
+}
+
+// DecodeEvent decodes the body of a message with the given properties. Avro-encoded data
+// is decoded with its writer schema, looked up by the ID in the body; other bodies are
+// decoded with mq.DecodeEvent.
+func (r *Registry) DecodeEvent(ctx context.Context, cfg *config.SchemaRegistryConfig, body []byte, props map[string]string) (event.Event, error) {
+	if props[mq.ContentTypeProperty] != ContentTypeAvro {
+		return mq.DecodeEvent(body, props[mq.ContentTypeProperty])
+	}
+
+	var evt event.Event
+	if err := json.Unmarshal([]byte(props[EventProperty]), &evt); err != nil {
+		return evt, fmt.Errorf("invalid %s property: %w", EventProperty, err)
+	}
+	if len(body) < 5 || body[0] != 0 {
+		return evt, fmt.Errorf("body is not in the Confluent wire format")
+	}
+	if cfg == nil {
+		return evt, fmt.Errorf("schema_registry is required to decode Avro events")
+	}
+	s, err := r.ByID(ctx, cfg, int(binary.BigEndian.Uint32(body[1:5])))
+	if err != nil {
+		return evt, err
+	}
+	if s.Type != TypeAvro {
+		return evt, fmt.Errorf("schema %d is not an Avro schema", s.ID)
+	}
+	var data interface{}
+	if err := avro.Unmarshal(s.avro, body[5:], &data); err != nil {
+		return evt, fmt.Errorf("failed to decode data with schema %d: %w", s.ID, err)
+	}
+	// Templates see the same types as for JSON events, e.g. float64 numbers
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return evt, err
+	}
+	if err := json.Unmarshal(raw, &evt.Data); err != nil {
+		return evt, fmt.Errorf("data of schema %d is not an object", s.ID)
+	}
+	return evt, nil
+}
+
+// encode encodes data with the Avro schema.
+func (s *RegistrySchema) encode(data map[string]interface{}) ([]byte, error) {
+	var v interface{} = data
+	if data == nil {
+		v = map[string]interface{}{}
+	}
+	native, err := avroNative(s.avro, v)
+	if err != nil {
+		return nil, err
+	}
+	return avro.Marshal(s.avro, native)
+}
+
+// avroNative converts a value decoded from JSON to the Go types the Avro encoder expects
+// for s: integral numbers to int32 or int64, union values wrapped in their branch name.
+func avroNative(s avro.Schema, v interface{}) (interface{}, error) {
+	switch s := s.(type) {
+	case *avro.RefSchema:
+		return avroNative(s.Schema(), v)
+	case *avro.RecordSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: want an object, got %T", s.FullName(), v)
+		}
+		out := make(map[string]interface{}, len(m))
+		for _, f := range s.Fields() {
+			fv, ok := m[f.Name()]
+			if !ok {
+				if !f.HasDefault() {
+					return nil, fmt.Errorf("%s: field %s is required", s.FullName(), f.Name())
+				}
+				continue
+			}
+			nv, err := avroNative(f.Type(), fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			out[f.Name()] = nv
+		}
+		return out, nil
+	case *avro.ArraySchema:
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("want an array, got %T", v)
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			nv, err := avroNative(s.Items(), item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			out[i] = nv
+		}
+		return out, nil
+	case *avro.MapSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("want an object, got %T", v)
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, item := range m {
+			nv, err := avroNative(s.Values(), item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = nv
+		}
+		return out, nil
+	case *avro.UnionSchema:
+		if v == nil {
+			if s.Nullable() {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("null is not allowed")
+		}
+		for _, branch := range s.Types() {
+			if branch.Type() == avro.Null {
+				continue
+			}
+			if nv, err := avroNative(branch, v); err == nil {
+				return map[string]interface{}{avroTypeName(branch): nv}, nil
+			}
+		}
+		return nil, fmt.Errorf("%v matches no type of the union", v)
+	case *avro.EnumSchema:
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("want a symbol of %s, got %T", s.FullName(), v)
+		}
+		return str, nil
+	case *avro.FixedSchema:
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("want a string, got %T", v)
+		}
+		return []byte(str), nil
+	case *avro.PrimitiveSchema:
+		return avroPrimitive(s.Type(), v)
+	}
+	return nil, fmt.Errorf("schema type %s is not supported", s.Type())
+}
+
+func avroPrimitive(typ avro.Type, v interface{}) (interface{}, error) {
+	ok := true
+	switch typ {
+	case avro.Null:
+		ok = v == nil
+	case avro.Boolean:
+		_, ok = v.(bool)
+	case avro.String:
+		_, ok = v.(string)
+	case avro.Bytes:
+		var str string
+		if str, ok = v.(string); ok {
+			return []byte(str), nil
+		}
+	case avro.Int, avro.Long:
+		f, isNumber := v.(float64)
+		if !isNumber || f != math.Trunc(f) || (typ == avro.Int && (f < math.MinInt32 || f > math.MaxInt32)) {
+			ok = false
+			break
+		}
+		if typ == avro.Int {
+			return int32(f), nil
+		}
+		return int64(f), nil
+	case avro.Float:
+		var f float64
+		if f, ok = v.(float64); ok {
+			return float32(f), nil
+		}
+	case avro.Double:
+		_, ok = v.(float64)
+	}
+	if !ok {
+		return nil, fmt.Errorf("want %s, got %T", typ, v)
+	}
+	return v, nil
+}
+
+// avroTypeName is the name of a union branch.
+func avroTypeName(s avro.Schema) string {
+	if n, ok := s.(avro.NamedSchema); ok {
+		return n.FullName()
+	}
+	return string(s.Type())
+}
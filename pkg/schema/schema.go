@@ -0,0 +1,99 @@
+// Package schema validates event data against JSON Schemas referenced from the config.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ValidationError reports why event data does not conform to its schema.
+type ValidationError struct {
+	Schema string
+	Causes []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("event data does not match schema %s: %s", e.Schema, strings.Join(e.Causes, "; "))
+}
+
+// Validator compiles schemas on first use and caches them by reference.
+// A reference is a file path or an http(s):// or file:// URL.
+type Validator struct {
+	mu      sync.Mutex
+	schemas map[string]*jsonschema.Schema
+}
+
+// NewValidator creates an empty Validator.
+func NewValidator() *Validator {
+	return &Validator{schemas: make(map[string]*jsonschema.Schema)}
+}
+
+// Validate checks data against the schema at ref. It returns a *ValidationError when data
+// is invalid and a plain error when the schema itself cannot be loaded.
+func (v *Validator) Validate(ref string, data map[string]interface{}) error {
+	s, err := v.compile(ref)
+	if err != nil {
+		return err
+	}
+	return check(s, ref, data)
+}
+
+// check validates data against s, reporting name as the schema of a *ValidationError.
+func check(s *jsonschema.Schema, name string, data map[string]interface{}) error {
+	var doc interface{} = data
+	if data == nil {
+		// A missing data object is validated as an empty object, so "required" is enforced
+		doc = map[string]interface{}{}
+	}
+
+	err := s.Validate(doc)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+
+	result := &ValidationError{Schema: name}
+	for _, unit := range ve.BasicOutput().Errors {
+		if unit.Error == "" || strings.HasPrefix(unit.Error, "doesn't validate with") {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		result.Causes = append(result.Causes, location+": "+unit.Error)
+	}
+	if len(result.Causes) == 0 {
+		result.Causes = []string{ve.Message}
+	}
+	return result
+}
+
+// Reset drops all compiled schemas, e.g. after a config change.
+func (v *Validator) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.schemas = make(map[string]*jsonschema.Schema)
+}
+
+func (v *Validator) compile(ref string) (*jsonschema.Schema, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if s, ok := v.schemas[ref]; ok {
+		return s, nil
+	}
+	s, err := jsonschema.Compile(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema %s: %w", ref, err)
+	}
+	v.schemas[ref] = s
+	return s, nil
+}
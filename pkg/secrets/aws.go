@@ -0,0 +1,60 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// AWSSecretsManagerBackend reads secrets from AWS Secrets Manager.
+// References look like aws-sm://prod/partner-api#api_key. Without a fragment the whole
+// SecretString is returned; with one, the SecretString is parsed as JSON and the field selected.
+type AWSSecretsManagerBackend struct {
+	once   sync.Once
+	client *secretsmanager.Client
+	err    error
+}
+
+// Fetch reads the referenced secret, creating the AWS client on first use.
+func (b *AWSSecretsManagerBackend) Fetch(ctx context.Context, ref *url.URL) (string, error) {
+	b.once.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			b.err = fmt.Errorf("failed to load AWS config: %w", err)
+			return
+		}
+		b.client = secretsmanager.NewFromConfig(cfg)
+	})
+	if b.err != nil {
+		return "", b.err
+	}
+
+	name := strings.TrimPrefix(ref.Host+ref.Path, "/")
+	out, err := b.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	secret := aws.ToString(out.SecretString)
+
+	if ref.Fragment == "" {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	value, ok := fields[ref.Fragment]
+	if !ok {
+		return "", fmt.Errorf("field '%s' not found", ref.Fragment)
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+
+	"notification-system/pkg/config"
+)
+
+// Message properties identifying the key of an encrypted message.
+const (
+	// KeyIDProperty is the KMS key or the name of the static key.
+	KeyIDProperty = "NOTIFY_KEY_ID"
+	// DataKeyProperty is the base64-encoded data key, encrypted by KMS.
+	DataKeyProperty = "NOTIFY_DATA_KEY"
+)
+
+// dataKeyRetention is how long a data key decrypted by KMS stays cached after its last use.
+const dataKeyRetention = time.Hour
+
+// Keyring supplies the message encryption keys of mq.encryption (see mq.Keyring).
+//
+// With aws-kms, a data key generated by KMS encrypts the messages of data_key_ttl and
+// travels with them encrypted by the KMS key, so consumers need kms:Decrypt on the KMS key.
+// Data keys decrypted by KMS are cached, which keeps KMS requests to a few per
+// data_key_ttl. Static keys are resolved with the Resolver, so they may be secret
+// references. It is safe for concurrent use.
+type Keyring struct {
+	resolver *Resolver
+
+	kmsOnce sync.Once
+	kms     *kms.Client
+	kmsErr  error
+
+	mu sync.Mutex
+	// current holds the encryption key per KMS key or static key reference
+	current map[string]cachedKey
+	// decrypted holds data keys decrypted by KMS per encrypted data key
+	decrypted map[string]cachedKey
+}
+
+type cachedKey struct {
+	key   []byte
+	props map[string]string
+	until time.Time
+}
+
+// NewKeyring creates a Keyring that resolves static keys with resolver.
+func NewKeyring(resolver *Resolver) *Keyring {
+	return &Keyring{resolver: resolver, current: make(map[string]cachedKey), decrypted: make(map[string]cachedKey)}
+}
+
+// EncryptionKey returns the key to encrypt a message with and the properties that
+// identify it.
+func (k *Keyring) EncryptionKey(ctx context.Context, cfg *config.EncryptionConfig) ([]byte, map[string]string, error) {
+	cacheKey := cfg.Provider + "\x00" + cfg.KeyID
+	if cfg.Provider == config.EncryptionStatic {
+		cacheKey += "\x00" + cfg.Keys[cfg.KeyID]
+	}
+	k.mu.Lock()
+	cached, ok := k.current[cacheKey]
+	k.mu.Unlock()
+	if ok && time.Now().Before(cached.until) {
+		return cached.key, cached.props, nil
+	}
+
+	var key []byte
+	var props map[string]string
+	var err error
+	switch cfg.Provider {
+	case config.EncryptionAWSKMS:
+		key, props, err = k.generateDataKey(ctx, cfg.KeyID)
+	case config.EncryptionStatic:
+		key, err = k.staticKey(ctx, cfg, cfg.KeyID)
+		props = map[string]string{KeyIDProperty: cfg.KeyID}
+	default:
+		err = fmt.Errorf("unknown provider '%s'", cfg.Provider)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	k.mu.Lock()
+	k.current[cacheKey] = cachedKey{key: key, props: props, until: time.Now().Add(cfg.DataKeyTTL.Std())}
+	k.mu.Unlock()
+	return key, props, nil
+}
+
+// DecryptionKey returns the key of a message with the given properties: the data key of
+// the message decrypted by KMS, or else the static key it names.
+func (k *Keyring) DecryptionKey(ctx context.Context, cfg *config.EncryptionConfig, props map[string]string) ([]byte, error) {
+	if encrypted := props[DataKeyProperty]; encrypted != "" {
+		return k.decryptDataKey(ctx, encrypted)
+	}
+	name := props[KeyIDProperty]
+	if cfg == nil || cfg.Keys[name] == "" {
+		return nil, fmt.Errorf("no key '%s' in mq.encryption.keys", name)
+	}
+	return k.staticKey(ctx, cfg, name)
+}
+
+// staticKey resolves and decodes the key name of cfg.Keys.
+func (k *Keyring) staticKey(ctx context.Context, cfg *config.EncryptionConfig, name string) ([]byte, error) {
+	value, err := k.resolver.Resolve(ctx, cfg.Keys[name])
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("key '%s' is not base64-encoded", name)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key '%s' must be 256 bits, got %d", name, len(key)*8)
+	}
+	return key, nil
+}
+
+// generateDataKey has KMS generate a data key under keyID.
+func (k *Keyring) generateDataKey(ctx context.Context, keyID string) ([]byte, map[string]string, error) {
+	client, err := k.client(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(keyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted := base64.StdEncoding.EncodeToString(out.CiphertextBlob)
+	k.mu.Lock()
+	k.decrypted[encrypted] = cachedKey{key: out.Plaintext, until: time.Now().Add(dataKeyRetention)}
+	k.mu.Unlock()
+	return out.Plaintext, map[string]string{KeyIDProperty: keyID, DataKeyProperty: encrypted}, nil
+}
+
+// decryptDataKey has KMS decrypt a data key, unless it is cached.
+func (k *Keyring) decryptDataKey(ctx context.Context, encrypted string) ([]byte, error) {
+	now := time.Now()
+	k.mu.Lock()
+	cached, ok := k.decrypted[encrypted]
+	if ok {
+		k.decrypted[encrypted] = cachedKey{key: cached.key, until: now.Add(dataKeyRetention)}
+	}
+	k.mu.Unlock()
+	if ok {
+		return cached.key, nil
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s property", DataKeyProperty)
+	}
+	client, err := k.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	for key, c := range k.decrypted {
+		if now.After(c.until) {
+			delete(k.decrypted, key)
+		}
+	}
+	k.decrypted[encrypted] = cachedKey{key: out.Plaintext, until: now.Add(dataKeyRetention)}
+	k.mu.Unlock()
+	return out.Plaintext, nil
+}
+
+// client creates the KMS client on first use with the default AWS credential chain.
+func (k *Keyring) client(ctx context.Context) (*kms.Client, error) {
+	k.kmsOnce.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			k.kmsErr = fmt.Errorf("failed to load AWS config: %w", err)
+			return
+		}
+		k.kms = kms.NewFromConfig(cfg)
+	})
+	return k.kms, k.kmsErr
+}
@@ -0,0 +1,229 @@
+// Package secrets resolves secret references such as vault://secret/data/app#token
+// or aws-sm://prod/partner#api_key that may appear in place of plaintext values in the config.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// Backend fetches the value of a secret reference for a single URI scheme.
+type Backend interface {
+	Fetch(ctx context.Context, ref *url.URL) (string, error)
+}
+
+// Resolver dispatches secret references to the backend registered for their scheme.
+type Resolver struct {
+	backends map[string]Backend
+}
+
+// NewResolver creates a Resolver with the given backends keyed by URI scheme.
+func NewResolver(backends map[string]Backend) *Resolver {
+	return &Resolver{backends: backends}
+}
+
+// NewDefaultResolver creates a Resolver for vault:// (VAULT_ADDR, VAULT_TOKEN) and
+// aws-sm:// (default AWS credential chain) references.
+func NewDefaultResolver() *Resolver {
+	return NewResolver(map[string]Backend{
+		"vault":  NewVaultBackendFromEnv(),
+		"aws-sm": &AWSSecretsManagerBackend{},
+	})
+}
+
+// IsReference reports whether value is a reference to a registered backend.
+func (r *Resolver) IsReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return false
+	}
+	_, known := r.backends[scheme]
+	return known
+}
+
+// Resolve returns the secret value for a reference, or value unchanged if it is not a reference.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !r.IsReference(value) {
+		return value, nil
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret reference: %w", err)
+	}
+	secret, err := r.backends[ref.Scheme].Fetch(ctx, ref)
+	if err != nil {
+		// Do not include the reference itself in logs beyond its scheme and path
+		return "", fmt.Errorf("failed to resolve %s://%s%s: %w", ref.Scheme, ref.Host, ref.Path, err)
+	}
+	return secret, nil
+}
+
+// ResolveConfig returns a copy of cfg where every secret reference in MQ credentials,
+// notification and enrichment headers, signing secrets, OAuth2 client secrets, the
+// environment of notifier plugins and the Slack URLs of the lag monitor and quarantine is
+// replaced by its value.
+// cfg is not modified.
+func (r *Resolver) ResolveConfig(ctx context.Context, cfg *config.Config) (*config.Config, error) {
+	cache := make(map[string]string)
+	resolve := func(value string) (string, error) {
+		if v, ok := cache[value]; ok {
+			return v, nil
+		}
+		v, err := r.Resolve(ctx, value)
+		if err != nil {
+			return "", err
+		}
+		cache[value] = v
+		return v, nil
+	}
+
+	out := *cfg
+	var err error
+	if out.MQ.AccessKey, err = resolve(cfg.MQ.AccessKey); err != nil {
+		return nil, fmt.Errorf("mq.access_key: %w", err)
+	}
+	if out.MQ.SecretKey, err = resolve(cfg.MQ.SecretKey); err != nil {
+		return nil, fmt.Errorf("mq.secret_key: %w", err)
+	}
+
+	if cfg.RateLimit.Redis != nil {
+		redis := *cfg.RateLimit.Redis
+		if redis.Password, err = resolve(redis.Password); err != nil {
+			return nil, fmt.Errorf("rate_limit.redis.password: %w", err)
+		}
+		out.RateLimit.Redis = &redis
+	}
+	if a := cfg.Worker.Assignment; a != nil && a.Redis != nil {
+		assignment, redis := *a, *a.Redis
+		if redis.Password, err = resolve(redis.Password); err != nil {
+			return nil, fmt.Errorf("worker.assignment.redis.password: %w", err)
+		}
+		assignment.Redis = &redis
+		out.Worker.Assignment = &assignment
+	}
+	if l := cfg.Worker.LagMonitor; l != nil {
+		monitor := *l
+		if monitor.SlackURL, err = resolve(l.SlackURL); err != nil {
+			return nil, fmt.Errorf("worker.lag_monitor.slack_url: %w", err)
+		}
+		out.Worker.LagMonitor = &monitor
+	}
+	if q := cfg.Worker.Quarantine; q != nil {
+		quarantine := *q
+		if quarantine.SlackURL, err = resolve(q.SlackURL); err != nil {
+			return nil, fmt.Errorf("worker.quarantine.slack_url: %w", err)
+		}
+		out.Worker.Quarantine = &quarantine
+	}
+	if b := cfg.API.DLQBrowser; b != nil && b.Redis != nil {
+		browser, redis := *b, *b.Redis
+		if redis.Password, err = resolve(redis.Password); err != nil {
+			return nil, fmt.Errorf("api.dlq_browser.redis.password: %w", err)
+		}
+		browser.Redis = &redis
+		out.API.DLQBrowser = &browser
+	}
+
+	if out.Defaults.SigningSecret, err = resolve(cfg.Defaults.SigningSecret); err != nil {
+		return nil, fmt.Errorf("defaults.signing_secret: %w", err)
+	}
+	if cfg.Defaults.Headers != nil {
+		headers := make(map[string]string, len(cfg.Defaults.Headers))
+		for k, v := range cfg.Defaults.Headers {
+			if headers[k], err = resolve(v); err != nil {
+				return nil, fmt.Errorf("defaults.headers.%s: %w", k, err)
+			}
+		}
+		out.Defaults.Headers = headers
+	}
+
+	out.Channels = make([]config.ChannelConfig, len(cfg.Channels))
+	for i, c := range cfg.Channels {
+		if c.Env != nil {
+			env := make(map[string]string, len(c.Env))
+			for k, v := range c.Env {
+				if env[k], err = resolve(v); err != nil {
+					return nil, fmt.Errorf("channels[%d].env.%s: %w", i, k, err)
+				}
+			}
+			c.Env = env
+		}
+		out.Channels[i] = c
+	}
+
+	out.Notifications = make([]config.NotificationConfig, len(cfg.Notifications))
+	for i, n := range cfg.Notifications {
+		if n.SigningSecret, err = resolve(n.SigningSecret); err != nil {
+			return nil, fmt.Errorf("notifications[%d].signing_secret: %w", i, err)
+		}
+		if n.Auth != nil {
+			auth := *n.Auth
+			if auth.ClientSecret, err = resolve(auth.ClientSecret); err != nil {
+				return nil, fmt.Errorf("notifications[%d].auth.client_secret: %w", i, err)
+			}
+			n.Auth = &auth
+		}
+		if n.Headers != nil {
+			headers := make(map[string]string, len(n.Headers))
+			for k, v := range n.Headers {
+				if headers[k], err = resolve(v); err != nil {
+					return nil, fmt.Errorf("notifications[%d].headers.%s: %w", i, k, err)
+				}
+			}
+			n.Headers = headers
+		}
+		if n.Enrich != nil {
+			enrich := make([]config.EnrichConfig, len(n.Enrich))
+			for j, e := range n.Enrich {
+				if e.Headers != nil {
+					headers := make(map[string]string, len(e.Headers))
+					for k, v := range e.Headers {
+						if headers[k], err = resolve(v); err != nil {
+							return nil, fmt.Errorf("notifications[%d].enrich[%d].headers.%s: %w", i, j, k, err)
+						}
+					}
+					e.Headers = headers
+				}
+				enrich[j] = e
+			}
+			n.Enrich = enrich
+		}
+		out.Notifications[i] = n
+	}
+	out.Reindex()
+	return &out, nil
+}
+
+// Track keeps resolving the store's configuration whenever the store changes and every
+// secrets.refresh_interval, passing each result to apply. Failed refreshes are logged and
+// the previously applied configuration stays in effect.
+func (r *Resolver) Track(ctx context.Context, store *config.Store, apply func(*config.Config)) {
+	refresh := func(cfg *config.Config) {
+		resolved, err := r.ResolveConfig(ctx, cfg)
+		if err != nil {
+			log.Printf("[Secrets] Failed to refresh secrets: %v", err)
+			return
+		}
+		apply(resolved)
+	}
+	store.OnChange(refresh)
+
+	go func() {
+		ticker := time.NewTicker(store.Config().Secrets.RefreshInterval.Std())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refresh(store.Config())
+			}
+		}
+	}()
+}
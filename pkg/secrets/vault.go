@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultBackend reads secrets from HashiCorp Vault over its HTTP API.
+// References look like vault://secret/data/notify/partner#api_key, where the
+// path is the Vault API path and the fragment selects a field. KV v1 and v2 are supported.
+type VaultBackend struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+// NewVaultBackendFromEnv configures a VaultBackend from VAULT_ADDR and VAULT_TOKEN.
+func NewVaultBackendFromEnv() *VaultBackend {
+	return &VaultBackend{
+		Address: os.Getenv("VAULT_ADDR"),
+		Token:   os.Getenv("VAULT_TOKEN"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Fetch reads the referenced field from Vault.
+func (b *VaultBackend) Fetch(ctx context.Context, ref *url.URL) (string, error) {
+	if b.Address == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	if ref.Fragment == "" {
+		return "", fmt.Errorf("vault reference must select a field with #field")
+	}
+
+	path := strings.TrimPrefix(ref.Host+ref.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(b.Address, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", b.Token)
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	data := payload.Data
+	// KV v2 nests the secret under data.data next to data.metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMeta := data["metadata"]; hasMeta {
+			data = inner
+		}
+	}
+
+	value, ok := data[ref.Fragment]
+	if !ok {
+		return "", fmt.Errorf("field '%s' not found", ref.Fragment)
+	}
+	return fmt.Sprint(value), nil
+}
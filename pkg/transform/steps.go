@@ -0,0 +1,139 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// rename moves the value at From to To. Missing fields are ignored.
+type rename struct{ from, to string }
+
+func newRename(spec Spec) (Step, error) {
+	if err := checkPath(spec.From); err != nil {
+		return nil, fmt.Errorf("from: %v", err)
+	}
+	if err := checkPath(spec.To); err != nil {
+		return nil, fmt.Errorf("to: %v", err)
+	}
+	return rename{from: spec.From, to: spec.To}, nil
+}
+
+func (r rename) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	if v, ok := get(data, r.from); ok {
+		del(data, r.from)
+		set(data, r.to, v)
+	}
+	return data, nil
+}
+
+// drop removes the listed fields.
+type drop struct{ fields []string }
+
+func newDrop(spec Spec) (Step, error) {
+	if err := checkFields(spec.Fields); err != nil {
+		return nil, err
+	}
+	return drop{fields: spec.Fields}, nil
+}
+
+func (d drop) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	for _, f := range d.fields {
+		del(data, f)
+	}
+	return data, nil
+}
+
+// mask replaces the listed fields with "****", keeping the last Keep characters.
+type mask struct {
+	fields []string
+	keep   int
+}
+
+func newMask(spec Spec) (Step, error) {
+	if err := checkFields(spec.Fields); err != nil {
+		return nil, err
+	}
+	if spec.Keep < 0 {
+		return nil, fmt.Errorf("keep cannot be negative")
+	}
+	return mask{fields: spec.Fields, keep: spec.Keep}, nil
+}
+
+func (m mask) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	for _, f := range m.fields {
+		if v, ok := get(data, f); ok && v != nil {
+			set(data, f, Mask(fmt.Sprint(v), m.keep))
+		}
+	}
+	return data, nil
+}
+
+// Mask hides s behind "****", revealing at most its last keep characters.
+func Mask(s string, keep int) string {
+	r := []rune(s)
+	if keep <= 0 || keep >= len(r) {
+		return "****"
+	}
+	return "****" + string(r[len(r)-keep:])
+}
+
+// add sets static fields, overwriting existing values.
+type add struct{ values map[string]interface{} }
+
+func newAdd(spec Spec) (Step, error) {
+	if len(spec.Values) == 0 {
+		return nil, fmt.Errorf("values is required")
+	}
+	for k := range spec.Values {
+		if err := checkPath(k); err != nil {
+			return nil, err
+		}
+	}
+	return add{values: spec.Values}, nil
+}
+
+func (a add) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	for k, v := range a.values {
+		set(data, k, deepCopy(v))
+	}
+	return data, nil
+}
+
+// jq replaces the data with the first output of a jq expression, which must be an object.
+type jq struct {
+	expr string
+	code *gojq.Code
+}
+
+func newJQ(spec Spec) (Step, error) {
+	if strings.TrimSpace(spec.Expr) == "" {
+		return nil, fmt.Errorf("expr is required")
+	}
+	query, err := gojq.Parse(spec.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("expr is invalid: %v", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("expr is invalid: %v", err)
+	}
+	return jq{expr: spec.Expr, code: code}, nil
+}
+
+func (j jq) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	iter := j.code.Run(data)
+	v, ok := iter.Next()
+	if !ok {
+		return nil, fmt.Errorf("jq '%s' produced no output", j.expr)
+	}
+	if err, ok := v.(error); ok {
+		return nil, fmt.Errorf("jq '%s': %v", j.expr, err)
+	}
+	out, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("jq '%s' must produce an object, got %T", j.expr, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,199 @@
+// Package transform reshapes event data before it is rendered into a notification.
+//
+// A pipeline is a list of steps applied in order to a copy of the event data. Built-in
+// steps are rename, drop, mask, add and jq; further step types can be added with Register.
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Spec is the configuration of a single pipeline step. Which fields apply depends on Type:
+//
+//	{"type": "rename", "from": "user.mail", "to": "email"}
+//	{"type": "drop", "fields": ["password", "internal"]}
+//	{"type": "mask", "fields": ["phone"], "keep": 4}
+//	{"type": "add", "values": {"source": "crm"}}
+//	{"type": "jq", "expr": "{id: .user.id, total: (.price * .qty)}"}
+//
+// Field names are dot-separated paths into nested objects.
+type Spec struct {
+	Type   string                 `json:"type"`
+	From   string                 `json:"from,omitempty"`
+	To     string                 `json:"to,omitempty"`
+	Fields []string               `json:"fields,omitempty"`
+	Keep   int                    `json:"keep,omitempty"`
+	Values map[string]interface{} `json:"values,omitempty"`
+	Expr   string                 `json:"expr,omitempty"`
+}
+
+// Step transforms event data. Apply may modify data in place and returns the result.
+type Step interface {
+	Apply(data map[string]interface{}) (map[string]interface{}, error)
+}
+
+// Factory builds a Step from its configuration, reporting configuration errors.
+type Factory func(spec Spec) (Step, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		"rename": newRename,
+		"drop":   newDrop,
+		"mask":   newMask,
+		"add":    newAdd,
+		"jq":     newJQ,
+	}
+)
+
+// Register makes a step type available to pipelines. It panics if the type is already registered.
+func Register(typ string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[typ]; ok {
+		panic("transform: step type registered twice: " + typ)
+	}
+	registry[typ] = factory
+}
+
+// Types returns the registered step types in sorted order.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Pipeline is a compiled list of steps.
+type Pipeline []Step
+
+// Compile builds a pipeline from its configuration.
+func Compile(specs []Spec) (Pipeline, error) {
+	p := make(Pipeline, 0, len(specs))
+	for i, spec := range specs {
+		registryMu.RLock()
+		factory, ok := registry[spec.Type]
+		registryMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("[%d]: unknown type '%s' (expected one of %s)", i, spec.Type, strings.Join(Types(), ", "))
+		}
+		step, err := factory(spec)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %s: %v", i, spec.Type, err)
+		}
+		p = append(p, step)
+	}
+	return p, nil
+}
+
+// Apply runs all steps on a deep copy of data; the input is never modified.
+func (p Pipeline) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	if len(p) == 0 {
+		return data, nil
+	}
+	out, _ := deepCopy(data).(map[string]interface{})
+	if out == nil {
+		out = make(map[string]interface{})
+	}
+	for _, step := range p {
+		var err error
+		if out, err = step.Apply(out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = deepCopy(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = deepCopy(item)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// get returns the value at a dot-separated path.
+func get(data map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	cur := data
+	for _, key := range parts[:len(parts)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur = next
+	}
+	v, ok := cur[parts[len(parts)-1]]
+	return v, ok
+}
+
+// set stores v at a dot-separated path, creating intermediate objects as needed.
+func set(data map[string]interface{}, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	cur := data
+	for _, key := range parts[:len(parts)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[key] = next
+		}
+		cur = next
+	}
+	cur[parts[len(parts)-1]] = v
+}
+
+// del removes the value at a dot-separated path.
+func del(data map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	cur := data
+	for _, key := range parts[:len(parts)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, parts[len(parts)-1])
+}
+
+func checkPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("field is empty")
+	}
+	for _, p := range strings.Split(path, ".") {
+		if p == "" {
+			return fmt.Errorf("field '%s' has an empty segment", path)
+		}
+	}
+	return nil
+}
+
+func checkFields(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("fields is required")
+	}
+	for _, f := range fields {
+		if err := checkPath(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
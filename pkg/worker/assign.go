@@ -0,0 +1,313 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"notification-system/pkg/config"
+)
+
+// ReplicaEnv names the environment variable naming a worker replica for worker.assignment;
+// replicas without it are named by their hostname.
+const ReplicaEnv = "NOTIFY_WORKER_REPLICA"
+
+const (
+	// assignmentKeyPrefix prefixes the Redis keys of worker.assignment unless its redis
+	// sets a key_prefix.
+	assignmentKeyPrefix = "notify:assignment:"
+	// assignmentTimeout bounds one Redis request of the assignment.
+	assignmentTimeout = 2 * time.Second
+)
+
+// claimScript takes or renews the lease KEYS[1] for the replica ARGV[1] for ARGV[2]
+// milliseconds, unless another replica holds it. Returns 1 when the replica holds it.
+var claimScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder and holder ~= ARGV[1] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// releaseScript deletes the lease KEYS[1] if the replica ARGV[1] holds it.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ReplicaAssignment is the assignment of a worker replica, as recorded in Redis for
+// GET /admin/assignments.
+type ReplicaAssignment struct {
+	Replica string `json:"replica"`
+	// Topics are the topics the replica consumes.
+	Topics []string `json:"topics"`
+	// Leases are the topics pinned by count the replica holds a lease of.
+	Leases    []string  `json:"leases,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// assignment decides which topics this replica consumes. With worker.assignment.redis it
+// also holds the leases of topics pinned by count and records the assignment.
+type assignment struct {
+	replica string
+	client  *redis.Client // nil without worker.assignment.redis
+	prefix  string
+
+	mu     sync.Mutex
+	leases map[string]string // pinned topic -> key of the lease held
+	stop   context.CancelFunc
+	done   chan struct{}
+}
+
+func newAssignment(cfg *config.AssignmentConfig) (*assignment, error) {
+	replica := os.Getenv(ReplicaEnv)
+	if replica == "" {
+		var err error
+		if replica, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to name the replica, set %s: %w", ReplicaEnv, err)
+		}
+	}
+	a := &assignment{replica: replica, leases: make(map[string]string)}
+	if cfg != nil && cfg.Redis != nil {
+		a.client, a.prefix = cfg.Redis.Client(assignmentTimeout, assignmentKeyPrefix)
+	}
+	return a, nil
+}
+
+// assigned reports whether this replica consumes topic under cfg.
+func (a *assignment) assigned(cfg *config.AssignmentConfig, topic string) bool {
+	if cfg == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pin, ok := cfg.Pins[topic]
+	if !ok {
+		// Unpinned topics are consumed by every replica but dedicated ones
+		return !cfg.Dedicated || !a.pinnedLocked(cfg)
+	}
+	if pin.Count > 0 {
+		_, held := a.leases[topic]
+		return held
+	}
+	return slices.Contains(pin.Replicas, a.replica)
+}
+
+// pinnedLocked reports whether some topic is pinned to this replica. Callers hold a.mu.
+func (a *assignment) pinnedLocked(cfg *config.AssignmentConfig) bool {
+	if len(a.leases) > 0 {
+		return true
+	}
+	for _, pin := range cfg.Pins {
+		if slices.Contains(pin.Replicas, a.replica) {
+			return true
+		}
+	}
+	return false
+}
+
+// coordinate takes the leases of topics pinned by count and records the assignment, then
+// renews them every third of worker.assignment.lease_ttl until ctx ends or the worker
+// shuts down, subscribing to the topics of leases taken later.
+func (a *assignment) coordinate(ctx context.Context, w *Worker) {
+	if a.client == nil {
+		return
+	}
+	// The first round runs before the worker subscribes, later ones subscribe themselves
+	a.round(ctx, w)
+
+	ctx, a.stop = context.WithCancel(ctx)
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		for {
+			interval := 10 * time.Second
+			if ac := w.Config().Worker.Assignment; ac != nil {
+				interval = ac.LeaseTTL.Std() / 3
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			if a.round(ctx, w) {
+				if err := w.subscribe(w.Config()); err != nil {
+					log.Printf("Failed to subscribe to the topics leased by replica %s: %v", a.replica, err)
+				}
+			}
+		}
+	}()
+}
+
+// round renews the leases held, takes free leases of topics pinned by count and records
+// the assignment. It reports whether a lease was taken.
+func (a *assignment) round(ctx context.Context, w *Worker) bool {
+	ac := w.Config().Worker.Assignment
+	if ac == nil {
+		return false
+	}
+	ttl := ac.LeaseTTL.Std()
+
+	a.mu.Lock()
+	held := make(map[string]string, len(a.leases))
+	for topic, key := range a.leases {
+		held[topic] = key
+	}
+	a.mu.Unlock()
+
+	for topic, key := range held {
+		ok, err := a.claim(ctx, key, ttl)
+		if err != nil {
+			log.Printf("Failed to renew the lease of topic %s: %v", topic, err)
+			continue
+		}
+		if !ok {
+			// Topics can't be unsubscribed, the replica keeps consuming it until restarted
+			log.Printf("WARNING: replica %s lost the lease of topic %s to another replica", a.replica, topic)
+			delete(held, topic)
+		}
+	}
+
+	taken := false
+	// Dedicated replicas take no leases once they consume unpinned topics
+	if !ac.Dedicated || !slices.ContainsFunc(w.Subscriptions(), func(t string) bool { return !ac.Pinned(t) }) {
+		for topic, pin := range ac.Pins {
+			if _, ok := held[topic]; ok || pin.Count == 0 {
+				continue
+			}
+			for i := 0; i < pin.Count; i++ {
+				key := fmt.Sprintf("%slease:%s:%d", a.prefix, topic, i)
+				ok, err := a.claim(ctx, key, ttl)
+				if err != nil {
+					log.Printf("Failed to take a lease of topic %s: %v", topic, err)
+					break
+				}
+				if ok {
+					log.Printf("Replica %s took lease %d of topic %s", a.replica, i, topic)
+					held[topic] = key
+					taken = true
+					break
+				}
+			}
+		}
+	}
+
+	a.mu.Lock()
+	a.leases = held
+	a.mu.Unlock()
+
+	if err := a.record(ctx, w.Assignment(), ttl); err != nil {
+		log.Printf("Failed to record the assignment of replica %s: %v", a.replica, err)
+	}
+	return taken
+}
+
+func (a *assignment) claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, assignmentTimeout)
+	defer cancel()
+	n, err := claimScript.Run(ctx, a.client, []string{key}, a.replica, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// record stores the assignment of the replica in Redis for ttl.
+func (a *assignment) record(ctx context.Context, r ReplicaAssignment, ttl time.Duration) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, assignmentTimeout)
+	defer cancel()
+	return a.client.Set(ctx, a.prefix+"replica:"+a.replica, body, ttl).Err()
+}
+
+// release stops renewing, hands the leases held to other replicas and removes the
+// recorded assignment.
+func (a *assignment) release() {
+	if a.client == nil {
+		return
+	}
+	if a.stop != nil {
+		a.stop()
+		<-a.done
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), assignmentTimeout)
+	defer cancel()
+
+	a.mu.Lock()
+	for topic, key := range a.leases {
+		if err := releaseScript.Run(ctx, a.client, []string{key}, a.replica).Err(); err != nil {
+			log.Printf("Failed to release the lease of topic %s: %v", topic, err)
+		}
+	}
+	a.leases = make(map[string]string)
+	a.mu.Unlock()
+
+	if err := a.client.Del(ctx, a.prefix+"replica:"+a.replica).Err(); err != nil {
+		log.Printf("Failed to remove the assignment of replica %s: %v", a.replica, err)
+	}
+	a.client.Close()
+}
+
+// Assignment returns the assignment of this replica: its name, the topics it consumes and
+// the leases it holds.
+func (w *Worker) Assignment() ReplicaAssignment {
+	r := ReplicaAssignment{Replica: w.assignment.replica, Topics: w.Subscriptions(), UpdatedAt: time.Now()}
+	sort.Strings(r.Topics)
+	w.assignment.mu.Lock()
+	for topic := range w.assignment.leases {
+		r.Leases = append(r.Leases, topic)
+	}
+	w.assignment.mu.Unlock()
+	sort.Strings(r.Leases)
+	return r
+}
+
+// ListAssignments returns the assignments the running replicas recorded in the Redis of
+// cfg, sorted by replica.
+func ListAssignments(ctx context.Context, cfg *config.AssignmentConfig) ([]ReplicaAssignment, error) {
+	if cfg == nil || cfg.Redis == nil {
+		return nil, fmt.Errorf("worker.assignment.redis is not configured")
+	}
+	client, prefix := cfg.Redis.Client(assignmentTimeout, assignmentKeyPrefix)
+	defer client.Close()
+
+	var keys []string
+	iter := client.Scan(ctx, 0, prefix+"replica:*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	replicas := []ReplicaAssignment{}
+	for _, key := range keys {
+		body, err := client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			// Expired since the scan
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r ReplicaAssignment
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, fmt.Errorf("invalid assignment %s: %w", key, err)
+		}
+		replicas = append(replicas, r)
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].Replica < replicas[j].Replica })
+	return replicas, nil
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+const (
+	defaultBackoffBase = 200 * time.Millisecond
+	defaultBackoffMax  = 10 * time.Second
+)
+
+// backoff returns the delay before local retry n (1-based) with full jitter: a random
+// duration between 0 and min(max, base * 2^(n-1)). Jitter spreads the retries of many
+// messages that failed at once instead of sending them in synchronized waves.
+func backoff(cfg *config.BackoffConfig, n int) time.Duration {
+	base, ceiling := defaultBackoffBase, defaultBackoffMax
+	if cfg != nil {
+		if cfg.Base > 0 {
+			base = cfg.Base.Std()
+		}
+		if cfg.Max > 0 {
+			ceiling = cfg.Max.Std()
+		}
+	}
+	ceiling = max(ceiling, base)
+
+	d := ceiling
+	if shift := n - 1; shift < 32 && base<<shift > 0 && base<<shift < ceiling {
+		d = base << shift
+	}
+	return rand.N(d + 1)
+}
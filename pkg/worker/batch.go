@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+)
+
+// consumeOutcome is the result of consuming one message of a batch.
+type consumeOutcome struct {
+	retry bool
+	// delayLevel is the delay level the retry has to wait at least, or 0 for RocketMQ's own.
+	delayLevel int
+}
+
+// consumeBatch consumes msgs, up to mq.consume_parallelism at once, and returns their
+// outcomes in the order of msgs.
+func (w *Worker) consumeBatch(ctx context.Context, cfg *config.Config, msgs []*primitive.MessageExt) []consumeOutcome {
+	outcomes := make([]consumeOutcome, len(msgs))
+	if len(msgs) == 1 || cfg.MQ.ConsumeParallelism <= 1 {
+		for i, msg := range msgs {
+			outcomes[i] = w.consumeMessage(ctx, cfg, msg)
+		}
+		return outcomes
+	}
+
+	slots := make(chan struct{}, cfg.MQ.ConsumeParallelism)
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			outcomes[i] = w.consumeMessage(ctx, cfg, msg)
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// requeueRetry is how long requeueAll waits before it re-publishes the messages that failed
+// to be re-published again, like the RocketMQ client waits to send back a batch.
+const requeueRetry = 5 * time.Second
+
+// batchResult aggregates the outcomes of a batch. RocketMQ acknowledges or retries a
+// batch as a whole, so when only some of its messages need a retry, those are re-published
+// with their delay like pull mode retries (see requeue) and the batch is acknowledged;
+// healthy messages aren't retried because of a failing target. A batch that can't be
+// split that way is retried as a whole, after the longest delay any message asked for.
+func (w *Worker) batchResult(ctx context.Context, msgs []*primitive.MessageExt, outcomes []consumeOutcome) consumer.ConsumeResult {
+	var retry []int
+	delayLevel := 0
+	for i, o := range outcomes {
+		if o.retry {
+			retry = append(retry, i)
+			delayLevel = max(delayLevel, o.delayLevel)
+		}
+	}
+	if len(retry) == 0 {
+		return consumer.ConsumeSuccess
+	}
+	if len(retry) < len(msgs) && w.requeueAll(msgs, outcomes, retry) {
+		return consumer.ConsumeSuccess
+	}
+	if delayLevel > 0 {
+		if cc, ok := primitive.GetConcurrentlyCtx(ctx); ok {
+			cc.DelayLevelWhenNextConsume = delayLevel
+		}
+	}
+	return consumer.ConsumeRetryLater
+}
+
+// requeueAll re-publishes the messages of a batch at the indices retry. A message that
+// fails to be re-published doesn't stop the others; the failed ones are re-published again
+// every requeueRetry until they all are, since retrying the batch would deliver the
+// re-published ones twice. It only returns false if the worker drains before, and the
+// batch is then retried as a whole.
+func (w *Worker) requeueAll(msgs []*primitive.MessageExt, outcomes []consumeOutcome, retry []int) bool {
+	requeued := 0
+	for {
+		var failed []int
+		for _, i := range retry {
+			if err := w.requeue(msgs[i], outcomes[i].delayLevel); err != nil {
+				fmt.Printf("[Worker] Failed to re-publish message %s for retry: %v\n", msgs[i].MsgId, err)
+				failed = append(failed, i)
+			}
+		}
+		requeued += len(retry) - len(failed)
+		if len(failed) == 0 {
+			fmt.Printf("[Worker] Re-published %d of %d messages of the batch for retry\n", requeued, len(msgs))
+			return true
+		}
+		if w.isDraining() {
+			fmt.Printf("[Worker] Retrying the batch of %d messages: %d could not be re-published before shutdown\n", len(msgs), len(failed))
+			return false
+		}
+		time.Sleep(requeueRetry)
+		retry = failed
+	}
+}
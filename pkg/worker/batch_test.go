@@ -0,0 +1,93 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/event"
+	"notification-system/pkg/worker/workertest"
+)
+
+const batchConfig = `{
+  "mq": {"name_server": "127.0.0.1:9876", "group_name": "notification_group", "max_retries": 3, "consume_batch_size": 3},
+  "notifications": [
+    {"event_type": "order.created", "queue_name": "order_queue", "http_method": "POST", "http_url": "http://healthy.example/orders"},
+    {"event_type": "order.paid", "queue_name": "order_queue", "http_method": "POST", "http_url": "http://failing.example/payments"}
+  ]
+}`
+
+// failingTarget answers 503 for failing.example and 200 for other hosts.
+var failingTarget = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.Host == "failing.example" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+})
+
+func batch(t *testing.T, types ...string) []*primitive.MessageExt {
+	t.Helper()
+	var msgs []*primitive.MessageExt
+	for i, typ := range types {
+		id := "evt-" + string(rune('1'+i))
+		body, err := json.Marshal(event.Event{ID: id, Type: typ, Data: map[string]interface{}{"id": id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, &primitive.MessageExt{
+			Message:       primitive.Message{Topic: "order_queue", Body: body},
+			MsgId:         "msg-" + id,
+			BornTimestamp: time.Now().UnixMilli(),
+		})
+	}
+	return msgs
+}
+
+func TestBatchResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		types      []string
+		want       consumer.ConsumeResult
+		requeued   int
+		deliveries int
+	}{
+		{"all delivered", []string{"order.created", "order.created"}, consumer.ConsumeSuccess, 0, 2},
+		// The batch is acknowledged, and only the failing message retried
+		{"failing message re-published", []string{"order.created", "order.paid", "order.created"}, consumer.ConsumeSuccess, 1, 2},
+		{"all failing", []string{"order.paid", "order.paid"}, consumer.ConsumeRetryLater, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, client := workertest.NewMQ(), &workertest.Client{Handler: failingTarget}
+			w := startWorker(t, batchConfig, queue, client)
+
+			result, err := w.HandleMessage(context.Background(), batch(t, tt.types...)...)
+			if err != nil || result != tt.want {
+				t.Fatalf("HandleMessage = %v, %v, want %v", result, err, tt.want)
+			}
+			requeued := queue.Sent("order_queue")
+			if len(requeued) != tt.requeued {
+				t.Errorf("re-published %d messages, want %d", len(requeued), tt.requeued)
+			}
+			for _, msg := range requeued {
+				var evt event.Event
+				if err := json.Unmarshal(msg.Body, &evt); err != nil || evt.Type != "order.paid" {
+					t.Errorf("re-published %s, want only the failing event", msg.Body)
+				}
+			}
+			delivered := 0
+			for _, r := range client.Requests() {
+				if r.URL == "http://healthy.example/orders" {
+					delivered++
+				}
+			}
+			if delivered != tt.deliveries {
+				t.Errorf("delivered %d notifications, want %d", delivered, tt.deliveries)
+			}
+		})
+	}
+}
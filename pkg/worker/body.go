@@ -0,0 +1,168 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/render"
+	"notification-system/pkg/transform"
+)
+
+// encodeBody renders the body template for evt and encodes it according to cfg.BodyFormat.
+// It returns the encoded body and its default Content-Type; a Content-Type header in the
+// notification config takes precedence.
+func encodeBody(cfg *config.NotificationConfig, evt event.Event) ([]byte, string, error) {
+	if cfg.BodyFormat == config.BodyFormatText {
+		text, err := render.String(cfg.BodyText, evt)
+		return []byte(text), "text/plain; charset=utf-8", err
+	}
+
+	tmpl, err := cfg.BodyTemplate()
+	if err != nil {
+		return nil, "", err
+	}
+	rendered, err := tmpl.Render(evt)
+	if err != nil {
+		return nil, "", err
+	}
+	fields, _ := rendered.(map[string]interface{})
+
+	switch cfg.BodyFormat {
+	case config.BodyFormatForm:
+		return []byte(encodeForm(fields)), "application/x-www-form-urlencoded", nil
+	case config.BodyFormatXML:
+		body, err := encodeXML(fields)
+		return body, "application/xml; charset=utf-8", err
+	default:
+		body, err := json.Marshal(rendered)
+		return body, "application/json", err
+	}
+}
+
+// encodeForm encodes top-level fields as form values. Arrays become repeated keys;
+// nested objects are sent as JSON strings.
+func encodeForm(fields map[string]interface{}) string {
+	values := url.Values{}
+	for k, v := range fields {
+		if list, ok := v.([]interface{}); ok {
+			for _, item := range list {
+				values.Add(k, formValue(item))
+			}
+			continue
+		}
+		values.Set(k, formValue(v))
+	}
+	return values.Encode()
+}
+
+// formValue returns strings unchanged and JSON-encodes everything else.
+func formValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(b)
+	}
+}
+
+// encodeXML encodes a body with a single top-level key as an XML document rooted at
+// that key. Objects become child elements (in key order), arrays repeat the element and
+// scalars become text content.
+func encodeXML(fields map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	for _, k := range sortedKeys(fields) {
+		if err := writeXML(enc, k, fields[k]); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXML(enc *xml.Encoder, name string, v interface{}) error {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if err := writeXML(enc, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(val) {
+			if err := writeXML(enc, k, val[k]); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(formValue(val))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// transformData runs the notification's transform pipeline on data.
+func (w *Worker) transformData(cfg *config.NotificationConfig, data map[string]interface{}) (map[string]interface{}, error) {
+	if len(cfg.Transform) == 0 {
+		return data, nil
+	}
+	p, err := w.pipeline(cfg.Transform)
+	if err != nil {
+		return nil, err
+	}
+	return p.Apply(data)
+}
+
+// pipeline compiles specs into a transform pipeline. Compiled pipelines are cached by
+// their configuration, like HTTP clients.
+func (w *Worker) pipeline(specs []transform.Spec) (transform.Pipeline, error) {
+	keyBytes, err := json.Marshal(specs)
+	if err != nil {
+		return nil, err
+	}
+	key := string(keyBytes)
+
+	w.pipelinesMu.Lock()
+	defer w.pipelinesMu.Unlock()
+	p, ok := w.pipelines[key]
+	if !ok {
+		if p, err = transform.Compile(specs); err != nil {
+			return nil, err
+		}
+		w.pipelines[key] = p
+	}
+	return p, nil
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// errDraining hands a message back to the broker while the worker shuts down.
+var errDraining = errors.New("worker is shutting down")
+
+// startBroker subscribes to the configured topics and starts consuming them when
+// mq.broker is not RocketMQ.
+func (w *Worker) startBroker() error {
+	if err := w.subscribe(w.Config()); err != nil {
+		return err
+	}
+	if err := w.Broker.Start(); err != nil {
+		return fmt.Errorf("failed to start consumer: %w", err)
+	}
+	w.started.Store(true)
+	return nil
+}
+
+// handleDelivery is the mq.Handler of brokers other than RocketMQ. Like HandleMessage it
+// moves messages that failed permanently or mq.max_retries times to the DLQ_<topic> topic,
+// parked ones to the parking topic of their target and undecodable ones to
+// QUARANTINE_<topic>; returning an error has the broker redeliver the message.
+func (w *Worker) handleDelivery(ctx context.Context, d *mq.Delivery) error {
+	if !w.beginMessage() {
+		return errDraining
+	}
+	defer w.endMessage()
+
+	cfg := w.Config()
+	w.observeLag(d.Topic, d.BornTime)
+	fmt.Printf("[Worker] Received message from topic: %s, msgId: %s, reconsumeTimes: %d\n", d.Topic, d.ID, d.Attempts)
+
+	if d.Err != nil {
+		// Bodies that can't be decompressed are quarantined however often they were tried
+		err := w.poison(ctx, cfg, d.Topic, d.ID, d.Body, d.Properties, d.Err)
+		var poisoned *quarantineError
+		if errors.As(err, &poisoned) {
+			return w.publishQuarantine(ctx, cfg, d, poisoned)
+		}
+		return d.Err
+	}
+	if d.Attempts >= cfg.MQ.MaxRetries {
+		fmt.Printf("[Worker] Message %s exceeded max retries (%d). Sending to DLQ.\n", d.ID, cfg.MQ.MaxRetries)
+		if err := w.publishDLQ(ctx, cfg, d, ReasonMaxRetries, ""); err != nil {
+			return err
+		}
+		w.deadLetterReceipt(ctx, cfg, d.Body, d.Properties, d.Attempts+1)
+		return nil
+	}
+	err := w.deliverEvent(ctx, cfg, d.Topic, d.ID, d.Body, d.Properties, d.Attempts+1)
+	var parked *parkError
+	if errors.As(err, &parked) {
+		return w.publishPark(ctx, cfg, d, parked)
+	}
+	var poisoned *quarantineError
+	if errors.As(err, &poisoned) {
+		return w.publishQuarantine(ctx, cfg, d, poisoned)
+	}
+	if delay, ok := heldBack(err); ok {
+		return &mq.RetryError{Err: err, Delay: delay, Hold: true}
+	}
+	var pErr *PermanentError
+	if errors.As(err, &pErr) {
+		if err := w.publishDLQ(ctx, cfg, d, pErr.Reason, pErr.Message); err != nil {
+			return err
+		}
+		pErr.sendReceipt()
+		return nil
+	}
+	if delay := retryAfterOf(err); delay > 0 {
+		return &mq.RetryError{Err: err, Delay: delay}
+	}
+	return err
+}
+
+func (w *Worker) publishDLQ(ctx context.Context, cfg *config.Config, d *mq.Delivery, reason, message string) error {
+	props := dlqProperties(d.Properties, reason, message)
+	err := w.Broker.Publish(ctx, "DLQ_"+d.Topic, d.Body, mq.WithProperties(props), mq.Compression(cfg.MQ))
+	if err != nil {
+		fmt.Printf("[Worker] Failed to send message %s to DLQ: %v\n", d.ID, err)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+)
+
+// deliveryContext bounds a delivery, its local retries included, by the delivery_timeout
+// of cfg, so a single message can't hold a consume goroutine for minutes, and by the
+// time evt expires (see expiresAt). The cause of a context ended by the latter is an
+// *expiredError.
+func (w *Worker) deliveryContext(ctx context.Context, cfg *config.NotificationConfig, evt event.Event) (context.Context, context.CancelFunc) {
+	cancelTimeout := context.CancelFunc(func() {})
+	if cfg.DeliveryTimeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, cfg.DeliveryTimeout.Std())
+	}
+	deadline, ok := expiresAt(cfg, evt)
+	if !ok {
+		return ctx, cancelTimeout
+	}
+	// Relative to the worker's clock, which expiry is checked against before delivery
+	ctx, cancelExpiry := context.WithTimeoutCause(ctx, deadline.Sub(w.clock.Now()), &expiredError{deadline: deadline})
+	return ctx, func() {
+		cancelExpiry()
+		cancelTimeout()
+	}
+}
+
+// sleep waits for d, or returns the error of ctx once it is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// budgetSpent returns the error of a delivery whose time ran out after attempts, wrapping
+// the error of its last attempt if it made one. It is retried like that error, unless the
+// event expired.
+func budgetSpent(ctx context.Context, cfg *config.NotificationConfig, attempts int, lastErr error) error {
+	if lastErr == nil || errors.Is(lastErr, ctx.Err()) {
+		lastErr = ctx.Err()
+	}
+	var expired *expiredError
+	switch {
+	case errors.As(context.Cause(ctx), &expired):
+		return fmt.Errorf("delivery stopped after %d attempts: %w", attempts, lastErr)
+	case cfg.DeliveryTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("delivery_timeout of %v spent after %d attempts: %w", cfg.DeliveryTimeout.Std(), attempts, lastErr)
+	}
+	return fmt.Errorf("delivery cancelled after %d attempts: %w", attempts, lastErr)
+}
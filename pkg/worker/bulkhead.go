@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// bulkheadError holds back a delivery whose bulkhead (see config.BulkheadConfig) stayed
+// full for worker.bulkhead.max_wait. Like a *PausedError, the message is redelivered
+// after Delay without counting as a failed attempt.
+type bulkheadError struct {
+	Bulkhead string
+	Delay    time.Duration
+}
+
+func (e *bulkheadError) Error() string {
+	return fmt.Sprintf("bulkhead %s is full", e.Bulkhead)
+}
+
+// bulkheads counts the deliveries in progress per bulkhead.
+type bulkheads struct {
+	mu     sync.Mutex
+	active map[string]int
+	// wake is closed and replaced whenever a slot is released.
+	wake chan struct{}
+}
+
+func newBulkheads() *bulkheads {
+	return &bulkheads{active: make(map[string]int), wake: make(chan struct{})}
+}
+
+// acquire takes one of the limit slots of key, waiting at most maxWait for one to free up.
+func (b *bulkheads) acquire(key string, limit int, maxWait time.Duration) bool {
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+	for {
+		b.mu.Lock()
+		if b.active[key] < limit {
+			b.active[key]++
+			b.mu.Unlock()
+			return true
+		}
+		wake := b.wake
+		b.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+func (b *bulkheads) release(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.active[key]--; b.active[key] == 0 {
+		delete(b.active, key)
+	}
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
+
+// status returns the deliveries in progress per busy bulkhead.
+func (b *bulkheads) status() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make(map[string]int, len(b.active))
+	for k, n := range b.active {
+		out[k] = n
+	}
+	return out
+}
+
+// bulkheadKey names the bulkhead of deliveries of cfg to the rendered url, e.g.
+// "acme/order.created https://hooks.example.com".
+func bulkheadKey(cfg *config.NotificationConfig, url string) string {
+	key := cfg.EventType + " " + parkTarget(cfg, url)
+	if cfg.Tenant != "" {
+		key = cfg.Tenant + "/" + key
+	}
+	return key
+}
+
+// enterBulkhead takes a slot of the bulkhead of cfg and the rendered url, returning the
+// function that frees it, or a *bulkheadError when none freed up in time.
+func (w *Worker) enterBulkhead(b *config.BulkheadConfig, cfg *config.NotificationConfig, url string) (func(), error) {
+	key := bulkheadKey(cfg, url)
+	if !w.bulkheads.acquire(key, b.Limit(cfg.EventType), b.MaxWait.Std()) {
+		return nil, &bulkheadError{Bulkhead: key, Delay: b.Delay.Std()}
+	}
+	return func() { w.bulkheads.release(key) }, nil
+}
+
+// BulkheadStatus returns the deliveries in progress per bulkhead with any, or nil when
+// worker.bulkhead is not set.
+func (w *Worker) BulkheadStatus() map[string]int {
+	if w.Config().Worker.Bulkhead == nil {
+		return nil
+	}
+	return w.bulkheads.status()
+}
+
+// heldBack returns how long the delivery that failed with err is held back, if it is:
+// for a *PausedError, a *bulkheadError or a *digestHold.
+func heldBack(err error) (time.Duration, bool) {
+	var paused *PausedError
+	if errors.As(err, &paused) {
+		return paused.Delay, true
+	}
+	var full *bulkheadError
+	if errors.As(err, &full) {
+		return full.Delay, true
+	}
+	var digested *digestHold
+	if errors.As(err, &digested) {
+		return digested.Delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/plugin"
+)
+
+// notifyChannel delivers a rendered notification through the plugin of cfg.Channel, with
+// the local retries, backoff and rate limit of HTTP deliveries. It returns the number of
+// attempts made.
+func (w *Worker) notifyChannel(ctx context.Context, cfg *config.NotificationConfig, evt event.Event, rendered *Request) (int, error) {
+	p, ok := w.plugins.Get(cfg.Channel)
+	if !ok {
+		return 0, fmt.Errorf("channel %s is not configured", cfg.Channel)
+	}
+	req := plugin.Request{
+		EventID:   evt.ID,
+		EventType: evt.Type,
+		Tenant:    evt.TenantID,
+		Method:    rendered.Method,
+		URL:       rendered.URL,
+		Headers:   make(map[string]string, len(rendered.Header)),
+		Body:      string(rendered.Body),
+	}
+	for k := range rendered.Header {
+		req.Headers[k] = rendered.Header.Get(k)
+	}
+
+	maxLocalRetries := 3
+	if cfg.Retries > 0 {
+		maxLocalRetries = cfg.Retries
+	}
+	attempts := 0
+	var lastErr error
+	for i := 0; i < maxLocalRetries; i++ {
+		if i > 0 {
+			delay := backoff(cfg.Backoff, i)
+			if d := retryAfterOf(lastErr); d > 0 {
+				delay = d
+			}
+			fmt.Printf("[Worker] Local retry %d/%d for event %s in %v\n", i+1, maxLocalRetries, evt.ID, delay)
+			if sleep(ctx, delay) != nil {
+				return attempts, budgetSpent(ctx, cfg, attempts, lastErr)
+			}
+		}
+		if cfg.RateLimit != nil {
+			if err := w.waitForRateLimit(ctx, cfg); err != nil {
+				return attempts, err
+			}
+		}
+		attempts++
+
+		err := p.Deliver(ctx, req)
+		if err == nil {
+			fmt.Printf("[Worker] Notification sent successfully for event %s over channel %s\n", evt.ID, cfg.Channel)
+			return attempts, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			return attempts, budgetSpent(ctx, cfg, attempts, lastErr)
+		}
+		var pErr *plugin.Error
+		if errors.As(err, &pErr) && (pErr.Permanent || pErr.RetryAfter > maxLocalRetryAfter) {
+			return attempts, err
+		}
+	}
+	return attempts, lastErr
+}
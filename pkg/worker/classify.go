@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notification-system/pkg/plugin"
+)
+
+// Message properties set on dead-lettered messages.
+const (
+	// DLQReasonProperty says why a message was dead-lettered (one of the Reason constants).
+	DLQReasonProperty = "NOTIFY_DLQ_REASON"
+	// DLQErrorProperty carries the error of a permanent failure, with sensitive fields masked.
+	DLQErrorProperty = "NOTIFY_DLQ_ERROR"
+	// DLQTimeProperty is when the message was dead-lettered, in Unix milliseconds.
+	DLQTimeProperty = "NOTIFY_DLQ_TIME"
+	// RedrivesProperty counts how often the message was redriven from the DLQ, see
+	// worker.redrive. It is kept when the message is dead-lettered again.
+	RedrivesProperty = "NOTIFY_REDRIVES"
+	// DLQIDProperty is the ID of the DLQ message a message waiting in a redrive topic was
+	// moved from, by which its tombstone is found.
+	DLQIDProperty = "NOTIFY_DLQ_ID"
+)
+
+// Reasons for dead-lettering a message.
+const (
+	ReasonMaxRetries  = "max_retries"
+	ReasonClientError = "client_error"
+	ReasonTLS         = "tls_error"
+	ReasonTemplate    = "template_error"
+	// ReasonChannel is a permanent failure reported by the plugin of a channel.
+	ReasonChannel = "channel_error"
+)
+
+// maxDLQError bounds the error message kept on a dead-lettered message.
+const maxDLQError = 1024
+
+// PermanentError is a delivery failure that retrying cannot fix. The message skips the
+// remaining MQ retries and goes straight to the DLQ. Middleware may return one to
+// dead-letter a message with a reason of its own.
+type PermanentError struct {
+	Reason string
+	// Message is the error text with sensitive fields masked.
+	Message string
+	Err     error
+	// receipt sends the failure receipt once the message is in the DLQ.
+	receipt func()
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// sendReceipt sends the failure receipt of a delivery that reached the target.
+func (e *PermanentError) sendReceipt() {
+	if e.receipt != nil {
+		e.receipt()
+	}
+}
+
+// templateError wraps failures to render or transform a request.
+type templateError struct {
+	err error
+}
+
+func (e *templateError) Error() string { return e.err.Error() }
+
+func (e *templateError) Unwrap() error { return e.err }
+
+// failureReason classifies a delivery error. It returns "" for errors worth retrying and
+// the dead-letter reason for permanent ones: client errors (4xx except 408 and 429),
+// certificate errors of the target, templates that cannot be rendered and failures a
+// channel plugin marked as permanent.
+func failureReason(err error) string {
+	var dErr *DeliveryError
+	if errors.As(err, &dErr) && !retryableStatus(dErr.StatusCode) {
+		return ReasonClientError
+	}
+	if isCertificateError(err) {
+		return ReasonTLS
+	}
+	var tErr *templateError
+	if errors.As(err, &tErr) {
+		return ReasonTemplate
+	}
+	var pErr *plugin.Error
+	if errors.As(err, &pErr) && pErr.Permanent {
+		return ReasonChannel
+	}
+	return ""
+}
+
+// retryableStatus reports whether a failed response may succeed when retried:
+// everything but client errors, except timeouts (408) and throttling (429).
+func retryableStatus(status int) bool {
+	return status < 400 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+func isCertificateError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		hostErr      x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.As(err, &verifyErr) || errors.As(err, &hostErr) || errors.As(err, &authorityErr) || errors.As(err, &invalidErr)
+}
+
+// dlqProperties returns the properties of a dead-lettered message: those of the original
+// message plus the reason, the time and, for permanent failures, the error.
+func dlqProperties(props map[string]string, reason, message string) map[string]string {
+	out := make(map[string]string, len(props)+3)
+	for k, v := range props {
+		out[k] = v
+	}
+	out[DLQReasonProperty] = reason
+	out[DLQTimeProperty] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if message != "" {
+		out[DLQErrorProperty] = truncate(message, maxDLQError)
+	}
+	return out
+}
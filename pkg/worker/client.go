@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// DefaultHTTPTimeout is the request timeout for targets that don't configure one.
+const DefaultHTTPTimeout = 10 * time.Second
+
+// clientFor returns the HTTP client used to deliver a notification.
+// Notifications without target-specific settings share w.Client; others get a dedicated
+// client built once and cached by their settings, so config changes produce a fresh one.
+func (w *Worker) clientFor(cfg *config.NotificationConfig) (HTTPDoer, error) {
+	if cfg.TLS == nil && cfg.HTTPClient == nil {
+		return w.Client, nil
+	}
+
+	keyBytes, err := json.Marshal([]interface{}{cfg.TLS, cfg.HTTPClient})
+	if err != nil {
+		return nil, err
+	}
+	key := string(keyBytes)
+
+	w.clientsMu.Lock()
+	defer w.clientsMu.Unlock()
+
+	if c, ok := w.clients[key]; ok {
+		return c, nil
+	}
+
+	c, err := buildClient(cfg, newTransport(w.Config().Worker.Transport))
+	if err != nil {
+		return nil, err
+	}
+	c.Transport = &countingTransport{base: c.Transport, stats: w.conns}
+	w.clients[key] = c
+	return c, nil
+}
+
+// buildClient creates a client with its own transport, a clone of base, from the
+// notification's TLS and HTTP settings.
+func buildClient(cfg *config.NotificationConfig, base *http.Transport) (*http.Client, error) {
+	transport := base.Clone()
+	timeout := DefaultHTTPTimeout
+
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		if base.TLSClientConfig != nil {
+			tlsConfig.ClientSessionCache = base.TLSClientConfig.ClientSessionCache
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if h := cfg.HTTPClient; h != nil {
+		if h.Timeout > 0 {
+			timeout = h.Timeout.Std()
+		}
+		if h.ProxyURL != "" {
+			proxyURL, err := url.Parse(h.ProxyURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy_url: %w", err)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		if h.MaxIdleConns > 0 {
+			// The transport only talks to this target, so the per-host limit is the total limit
+			transport.MaxIdleConns = h.MaxIdleConns
+			transport.MaxIdleConnsPerHost = h.MaxIdleConns
+		}
+		transport.DisableKeepAlives = h.DisableKeepAlives
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
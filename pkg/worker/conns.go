@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+
+	"notification-system/pkg/config"
+)
+
+// ConnStats counts how the requests to one host got their connection.
+type ConnStats struct {
+	Requests int64 `json:"requests"`
+	// Reused counts requests sent over a pooled connection.
+	Reused int64 `json:"reused"`
+	// NewConns counts requests that had to open a connection.
+	NewConns      int64 `json:"new_conns"`
+	TLSHandshakes int64 `json:"tls_handshakes"`
+}
+
+// connStats counts connection reuse per host.
+type connStats struct {
+	mu    sync.Mutex
+	hosts map[string]*ConnStats
+}
+
+func newConnStats() *connStats {
+	return &connStats{hosts: make(map[string]*ConnStats)}
+}
+
+func (s *connStats) add(host string, f func(*ConnStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.hosts[host]
+	if !ok {
+		c = &ConnStats{}
+		s.hosts[host] = c
+	}
+	f(c)
+}
+
+func (s *connStats) snapshot() map[string]ConnStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]ConnStats, len(s.hosts))
+	for host, c := range s.hosts {
+		out[host] = *c
+	}
+	return out
+}
+
+// countingTransport counts the connections the requests of base use.
+type countingTransport struct {
+	base  http.RoundTripper
+	stats *connStats
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.stats.add(host, func(c *ConnStats) {
+				c.Requests++
+				if info.Reused {
+					c.Reused++
+				} else {
+					c.NewConns++
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			t.stats.add(host, func(c *ConnStats) { c.TLSHandshakes++ })
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// newTransport returns a transport with the pool settings of cfg.
+func newTransport(cfg config.TransportConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout.Std()
+	transport.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout.Std()
+	transport.ExpectContinueTimeout = cfg.ExpectContinueTimeout.Std()
+	// Keep TLS sessions, so connections the pool couldn't keep resume them
+	transport.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	return transport
+}
+
+// ConnectionStats returns the connection reuse of deliveries per target host since the
+// worker started. Requests of a Client passed to NewWorkerWith aren't counted.
+func (w *Worker) ConnectionStats() map[string]ConnStats {
+	return w.conns.snapshot()
+}
@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+)
+
+// sensitiveHeaders are masked in the delivery log.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// attemptLog is one line of the delivery log.
+type attemptLog struct {
+	Time            time.Time         `json:"time"`
+	EventID         string            `json:"event_id"`
+	EventType       string            `json:"event_type"`
+	Tenant          string            `json:"tenant,omitempty"`
+	Attempt         int               `json:"attempt"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	RequestBody     string            `json:"request_body"`
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Timings         attemptTimings    `json:"timings"`
+}
+
+// attemptTimings are the phases of an attempt in milliseconds. Phases that didn't happen,
+// like DNS and connect on a reused connection, are omitted.
+type attemptTimings struct {
+	DNSMs       *int64 `json:"dns_ms,omitempty"`
+	ConnectMs   *int64 `json:"connect_ms,omitempty"`
+	TLSMs       *int64 `json:"tls_ms,omitempty"`
+	FirstByteMs *int64 `json:"first_byte_ms,omitempty"`
+	TotalMs     int64  `json:"total_ms"`
+	Reused      bool   `json:"reused_connection"`
+}
+
+// attemptTrace records the timings of one request.
+type attemptTrace struct {
+	start time.Time
+
+	mu                        sync.Mutex
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+	reused                    bool
+}
+
+// traceRequest returns req with a trace collecting its timings.
+func traceRequest(req *http.Request) (*http.Request, *attemptTrace) {
+	t := &attemptTrace{start: time.Now()}
+	set := func(field *time.Time) {
+		t.mu.Lock()
+		*field = time.Now()
+		t.mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { set(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { set(&t.dnsDone) },
+		ConnectStart:      func(string, string) { set(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { set(&t.connectDone) },
+		TLSHandshakeStart: func() { set(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { set(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() { set(&t.firstByte) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+func (t *attemptTrace) timings() attemptTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	phase := func(from, to time.Time) *int64 {
+		if from.IsZero() || to.IsZero() {
+			return nil
+		}
+		ms := to.Sub(from).Milliseconds()
+		return &ms
+	}
+	return attemptTimings{
+		DNSMs:       phase(t.dnsStart, t.dnsDone),
+		ConnectMs:   phase(t.connectStart, t.connectDone),
+		TLSMs:       phase(t.tlsStart, t.tlsDone),
+		FirstByteMs: phase(t.start, t.firstByte),
+		TotalMs:     time.Since(t.start).Milliseconds(),
+		Reused:      t.reused,
+	}
+}
+
+// sampled reports whether the attempts of the event with the given ID are logged
+// regardless of their outcome.
+func sampled(cfg *config.DeliveryLogConfig, eventID string) bool {
+	if cfg.SampleRate >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(eventID))
+	return float64(h.Sum32()) < cfg.SampleRate*(1<<32)
+}
+
+// deliveryLog writes the delivery log to worker.delivery_log.path, reopening it when the
+// path changes.
+type deliveryLog struct {
+	mu   sync.Mutex
+	path string
+	out  io.Writer
+	file *os.File
+}
+
+func (l *deliveryLog) write(path string, entry *attemptLog) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.out == nil || path != l.path {
+		if l.file != nil {
+			l.file.Close()
+			l.file = nil
+		}
+		l.out, l.path = os.Stdout, path
+		if path != "" {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				log.Printf("Failed to open delivery log %s: %v", path, err)
+				l.out = nil
+				return
+			}
+			l.out, l.file = f, f
+		}
+	}
+	l.out.Write(append(line, '\n'))
+}
+
+func (l *deliveryLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	l.out = nil
+}
+
+// logAttempt writes an attempt to the delivery log if its event is sampled or it failed
+// and failures are logged. Bodies are truncated to max_body and scrubbed of the
+// notification's sensitive values. Requests held back by adaptive backoff aren't logged.
+func (w *Worker) logAttempt(lc *config.DeliveryLogConfig, cfg *config.NotificationConfig, evt event.Event, attempt int, req *http.Request, reqBody []byte, trace *attemptTrace, resp *http.Response, respBody []byte, err error) {
+	var tErr *throttledError
+	if errors.As(err, &tErr) {
+		return
+	}
+	failed := err != nil || !isSuccessStatus(cfg.Success, resp.StatusCode) || checkResponseBody(cfg.Success, respBody) != nil
+	if !sampled(lc, evt.ID) && !(failed && lc.Failures) {
+		return
+	}
+
+	entry := &attemptLog{
+		Time:           trace.start,
+		EventID:        evt.ID,
+		EventType:      evt.Type,
+		Tenant:         evt.TenantID,
+		Attempt:        attempt,
+		Method:         req.Method,
+		URL:            scrub(cfg, evt.Data, req.URL.String()),
+		RequestHeaders: logHeaders(req.Header),
+		RequestBody:    truncate(scrub(cfg, evt.Data, string(reqBody)), lc.MaxBody),
+		Timings:        trace.timings(),
+	}
+	if resp != nil {
+		entry.Status = resp.StatusCode
+		entry.ResponseHeaders = logHeaders(resp.Header)
+		entry.ResponseBody = truncate(scrub(cfg, evt.Data, string(respBody)), lc.MaxBody)
+	}
+	if err != nil {
+		entry.Error = scrub(cfg, evt.Data, err.Error())
+	}
+	w.deliveryLog.write(lc.Path, entry)
+}
+
+func logHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if sensitiveHeaders[k] {
+			out[k] = "***"
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
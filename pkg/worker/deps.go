@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// Consumer is the part of rocketmq.PushConsumer the worker uses.
+type Consumer interface {
+	Subscribe(topic string, selector consumer.MessageSelector, f func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)) error
+	Start() error
+	Suspend()
+	Shutdown() error
+}
+
+// HTTPDoer sends HTTP requests; *http.Client implements it.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Clock tells the time used for expiry, pauses and delivery records.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Dependencies replace the connections and clock a worker would otherwise create, so
+// handlers and middleware can be tested without a live RocketMQ; package workertest has
+// fakes for all of them. Nil fields get the defaults of NewWorker.
+type Dependencies struct {
+	// NewConsumer creates the RocketMQ push consumers, one per priority (default mq.NewPushConsumer).
+	NewConsumer func(cfg config.MQConfig) (Consumer, error)
+	// Producer sends DLQ, retry, parked and status messages (default mq.NewProducer).
+	Producer mq.Producer
+	// Broker is used instead of RocketMQ when mq.broker is set (default mq.NewBroker).
+	Broker mq.Broker
+	// Client sends the notifications without tls or http_client settings, receipts and
+	// OAuth2 token requests.
+	Client HTTPDoer
+	Clock  Clock
+}
+
+func newPushConsumer(cfg config.MQConfig) (Consumer, error) {
+	return mq.NewPushConsumer(cfg)
+}
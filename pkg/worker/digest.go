@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/render"
+)
+
+// digestRetry is how long a digest that could not be published waits before the next try.
+// Held messages come back this long after their digest is due.
+const digestRetry = 10 * time.Second
+
+// digestPublishedTTL is how long the events of a published digest are remembered, so
+// their held messages are acknowledged when they come back; the longest hold is the
+// longest delay level, two hours.
+const digestPublishedTTL = 3 * time.Hour
+
+// digests holds the events buffered for digest notifications, by topic, tenant,
+// notification and rendered key.
+type digests struct {
+	mu      sync.Mutex
+	pending map[string]*digest
+	// published are the events of recently published digests, by digest and event ID,
+	// with the time they were published
+	published map[string]time.Time
+}
+
+type digest struct {
+	topic  string
+	key    string
+	events []event.Event
+	// members are the digest and event IDs of events
+	members map[string]bool
+	// due is when the digest is published next
+	due   time.Time
+	timer *time.Timer
+}
+
+// digestHold holds the message of an event buffered for a digest until the digest is
+// published, so the event is buffered again if the worker stops before.
+type digestHold struct {
+	Delay time.Duration
+}
+
+func (e *digestHold) Error() string { return "waiting for the digest to be published" }
+
+// digestEvent is an event of a digest as it appears in the digest's data.
+type digestEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// addToDigest buffers evt for the digest of its key. The digest is published to topic as
+// one event once the window since its first event ends or it holds max_events; it is then
+// delivered like any other event. Until then the message of evt is held: it returns
+// after the digest is due and is acknowledged if the digest was published, or buffered
+// again, e.g. after a restart. It returns a *digestHold, or nil once the digest holding
+// evt was published.
+func (w *Worker) addToDigest(cfg *config.NotificationConfig, topic string, evt event.Event) error {
+	key, err := render.String(cfg.Digest.Key, evt)
+	if err != nil {
+		fmt.Printf("[Worker] Failed to render digest key for event %s: %v. Using an empty key.\n", evt.ID, err)
+	}
+	id := strings.Join([]string{topic, evt.TenantID, cfg.EventType, key}, "\x00")
+	member := id + "\x00" + evt.ID
+
+	w.digests.mu.Lock()
+	if w.digests.takePublished(member) {
+		w.digests.mu.Unlock()
+		return nil
+	}
+	d, ok := w.digests.pending[id]
+	if !ok {
+		window := cfg.Digest.Window.Std()
+		d = &digest{topic: topic, key: key, members: make(map[string]bool), due: time.Now().Add(window)}
+		d.timer = time.AfterFunc(window, func() { w.flushDigest(id) })
+		w.digests.pending[id] = d
+	}
+	if !d.members[member] {
+		d.members[member] = true
+		d.events = append(d.events, evt)
+	}
+	full := len(d.events) >= cfg.Digest.MaxEvents
+	delay := time.Until(d.due) + digestRetry
+	w.digests.mu.Unlock()
+
+	if full {
+		w.flushDigest(id)
+		w.digests.mu.Lock()
+		published := w.digests.takePublished(member)
+		w.digests.mu.Unlock()
+		if published {
+			return nil
+		}
+	}
+	return &digestHold{Delay: delay}
+}
+
+// takePublished tells whether the digest of member was published, and forgets member.
+// The caller holds mu.
+func (ds *digests) takePublished(member string) bool {
+	if _, ok := ds.published[member]; !ok {
+		return false
+	}
+	delete(ds.published, member)
+	return true
+}
+
+// flushDigest publishes the digest id. If that fails, its events go back to the buffer
+// and are tried again after digestRetry.
+func (w *Worker) flushDigest(id string) {
+	w.digests.mu.Lock()
+	d, ok := w.digests.pending[id]
+	delete(w.digests.pending, id)
+	w.digests.mu.Unlock()
+	if !ok {
+		return
+	}
+	d.timer.Stop()
+
+	body, err := json.Marshal(d.event(w.clock.Now()))
+	if err == nil {
+		cfg := w.Config()
+		ctx := context.Background()
+		if err = w.publish(ctx, d.topic, body, mq.Compression(cfg.MQ), mq.WithEncryption(ctx, w.keyring, cfg.MQ.Encryption)); err == nil {
+			w.digests.mu.Lock()
+			defer w.digests.mu.Unlock()
+			now := time.Now()
+			for member, at := range w.digests.published {
+				if now.Sub(at) > digestPublishedTTL {
+					delete(w.digests.published, member)
+				}
+			}
+			for member := range d.members {
+				w.digests.published[member] = now
+			}
+			return
+		}
+	}
+	fmt.Printf("[Worker] Failed to publish digest of %d events to %s: %v. Retrying in %v.\n", len(d.events), d.topic, err, digestRetry)
+
+	w.digests.mu.Lock()
+	defer w.digests.mu.Unlock()
+	if next, ok := w.digests.pending[id]; ok {
+		// Events whose held message came back meanwhile are in both
+		events := d.events
+		for _, e := range next.events {
+			if !d.members[id+"\x00"+e.ID] {
+				events = append(events, e)
+			}
+		}
+		for member := range d.members {
+			next.members[member] = true
+		}
+		next.events = events
+		return
+	}
+	d.due = time.Now().Add(digestRetry)
+	d.timer = time.AfterFunc(digestRetry, func() { w.flushDigest(id) })
+	w.digests.pending[id] = d
+}
+
+// dropDigests drops every buffered digest on shutdown. The held messages of their events
+// bring them back to the next worker.
+func (w *Worker) dropDigests() {
+	w.digests.mu.Lock()
+	defer w.digests.mu.Unlock()
+	n := 0
+	for id, d := range w.digests.pending {
+		d.timer.Stop()
+		n += len(d.events)
+		delete(w.digests.pending, id)
+	}
+	if n > 0 {
+		fmt.Printf("[Worker] Dropped %d events buffered for digests; their held messages will be consumed again.\n", n)
+	}
+}
+
+// event returns the digest as an event of the type of its first event, created at now.
+// Its data holds the key, the number of events and the events in the order they were
+// received.
+func (d *digest) event(now time.Time) event.Event {
+	first := d.events[0]
+	events := make([]interface{}, len(d.events))
+	for i, e := range d.events {
+		events[i] = digestEvent{ID: e.ID, Type: e.Type, Timestamp: e.Timestamp, Data: e.Data}
+	}
+	return event.Event{
+		ID:        "digest-" + first.ID,
+		Type:      first.Type,
+		TenantID:  first.TenantID,
+		Timestamp: now,
+		Digest:    true,
+		Data: map[string]interface{}{
+			"key":    d.key,
+			"count":  len(d.events),
+			"events": events,
+		},
+	}
+}
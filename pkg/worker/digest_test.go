@@ -0,0 +1,84 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/event"
+	"notification-system/pkg/worker/workertest"
+)
+
+const digestConfig = `{
+  "mq": {"name_server": "127.0.0.1:9876", "group_name": "notification_group", "max_retries": 3},
+  "notifications": [{
+    "event_type": "comment.created", "queue_name": "comment_queue",
+    "http_method": "POST", "http_url": "http://target.example/digest",
+    "digest": {"key": "{$.event.user_id}", "window": "1h", "max_events": 2}
+  }]
+}`
+
+func commentCreated(t *testing.T, id string) []byte {
+	t.Helper()
+	body, err := json.Marshal(event.Event{ID: id, Type: "comment.created", Data: map[string]interface{}{"user_id": "u1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// consume has the started worker consume msg, which was sent to the queue.
+func consume(t *testing.T, queue *workertest.MQ, msg *primitive.Message) {
+	t.Helper()
+	ext := &primitive.MessageExt{Message: primitive.Message{Topic: msg.Topic, Body: msg.Body}, MsgId: "held"}
+	ext.WithProperties(msg.GetProperties())
+	result, err := queue.DeliverMessage(context.Background(), ext)
+	if err != nil || result != consumer.ConsumeSuccess {
+		t.Fatalf("DeliverMessage = %v, %v, want success", result, err)
+	}
+}
+
+func TestDigestSurvivesWorkerLoss(t *testing.T) {
+	ctx := context.Background()
+	queue := workertest.NewMQ()
+	first, second := commentCreated(t, "evt-1"), commentCreated(t, "evt-2")
+
+	// The first worker buffers the first event and is lost within the window, without
+	// shutting down
+	startWorker(t, digestConfig, queue, &workertest.Client{})
+	if result, err := queue.Deliver(ctx, "comment_queue", first); err != nil || result != consumer.ConsumeSuccess {
+		t.Fatalf("Deliver = %v, %v, want success", result, err)
+	}
+	held := queue.Sent("comment_queue")
+	if len(held) != 1 || !bytes.Equal(held[0].Body, first) {
+		t.Fatalf("got %d messages sent, want the first event held", len(held))
+	}
+
+	// The next worker gets the held message and completes the digest
+	startWorker(t, digestConfig, queue, &workertest.Client{})
+	consume(t, queue, held[0])
+	if result, err := queue.Deliver(ctx, "comment_queue", second); err != nil || result != consumer.ConsumeSuccess {
+		t.Fatalf("Deliver = %v, %v, want success", result, err)
+	}
+	sent := queue.Sent("comment_queue")
+	if len(sent) != 3 {
+		t.Fatalf("got %d messages sent, want the first event held twice and the digest", len(sent))
+	}
+	var digest event.Event
+	if err := json.Unmarshal(sent[2].Body, &digest); err != nil {
+		t.Fatal(err)
+	}
+	if !digest.Digest || digest.ID != "digest-evt-1" || digest.Data["count"] != float64(2) {
+		t.Errorf("digest = %s, want evt-1 and evt-2", sent[2].Body)
+	}
+
+	// The first event's message is acknowledged once it comes back
+	consume(t, queue, sent[1])
+	if n := len(queue.Sent("comment_queue")); n != 3 {
+		t.Errorf("got %d messages sent, want no more after the digest", n)
+	}
+}
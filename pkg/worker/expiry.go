@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/event"
+)
+
+// expiresAt returns when a notification of evt stops being worth delivering: the earlier
+// of the event's expires_at and its timestamp plus the notification's ttl. It returns
+// false when neither is set.
+func expiresAt(cfg *config.NotificationConfig, evt event.Event) (time.Time, bool) {
+	var deadline time.Time
+	if evt.ExpiresAt != nil {
+		deadline = *evt.ExpiresAt
+	}
+	if cfg.TTL > 0 && !evt.Timestamp.IsZero() {
+		if ttl := evt.Timestamp.Add(cfg.TTL.Std()); deadline.IsZero() || ttl.Before(deadline) {
+			deadline = ttl
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+// expiredError ends a delivery whose event expired while it was under way. It is final:
+// the notification is dropped like one that expired before delivery, not retried.
+type expiredError struct {
+	deadline time.Time
+	err      error // of the delivery when it was stopped
+}
+
+func (e *expiredError) Error() string {
+	msg := fmt.Sprintf("event expired at %s", e.deadline.Format(time.RFC3339))
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *expiredError) Unwrap() error { return e.err }
+
+// dropExpired drops the notification of an expired event: it is counted per tenant and
+// reported to the callback URL, but not delivered.
+func (w *Worker) dropExpired(cfg *config.NotificationConfig, evt event.Event, deliveries int, deadline time.Time) {
+	now := w.clock.Now()
+	late := now.Sub(deadline).Round(time.Second)
+	fmt.Printf("[Worker] Event %s expired %v ago. Dropping notification.\n", evt.ID, late)
+	w.countExpired(evt.TenantID, false)
+	w.sendReceipt(cfg, evt, expiredReceipt(cfg, evt, deliveries, deadline, now))
+}
+
+// dropExpiredInFlight drops the notification of an event that expired during its
+// delivery, after the attempts of record. It is counted apart from notifications that
+// expired before delivery.
+func (w *Worker) dropExpiredInFlight(cfg *config.NotificationConfig, evt event.Event, deliveries int, record delivery.Record, err *expiredError) {
+	fmt.Printf("[Worker] Event %s expired during delivery after %d attempts. Dropping notification.\n", evt.ID, record.Attempts)
+	w.countExpired(evt.TenantID, true)
+	r := expiredReceipt(cfg, evt, deliveries, err.deadline, w.clock.Now())
+	r.Attempts, r.StatusCode = record.Attempts, record.StatusCode
+	w.sendReceipt(cfg, evt, r)
+}
+
+func expiredReceipt(cfg *config.NotificationConfig, evt event.Event, deliveries int, deadline, now time.Time) *Receipt {
+	return &Receipt{
+		EventID:    evt.ID,
+		EventType:  evt.Type,
+		TenantID:   evt.TenantID,
+		DeliveryID: DeliveryID(cfg, evt),
+		Status:     ReceiptExpired,
+		Deliveries: deliveries,
+		Error:      fmt.Sprintf("event expired at %s", deadline.Format(time.RFC3339)),
+		Time:       now,
+	}
+}
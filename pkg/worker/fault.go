@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// chance reports true with probability rate.
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// dropFault reports whether worker.faults drops the message of evt.
+func dropFault(f *config.FaultConfig) bool {
+	return f != nil && chance(f.DropRate)
+}
+
+// attemptFault applies worker.faults to an HTTP attempt: it adds latency and returns the
+// failure the attempt ends with instead of sending the request, if any.
+func attemptFault(f *config.FaultConfig) error {
+	if f == nil {
+		return nil
+	}
+	if f.Latency > 0 && chance(f.LatencyRate) {
+		time.Sleep(f.Latency.Std())
+	}
+	if chance(f.FailureRate) {
+		return newDeliveryError("injected fault", http.StatusServiceUnavailable, nil)
+	}
+	return nil
+}
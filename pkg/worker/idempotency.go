@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/ratelimit"
+)
+
+// IdempotencyHeader carries the delivery ID, which stays the same across retries and
+// redeliveries of an event to a target, so targets can drop duplicates.
+const IdempotencyHeader = "Idempotency-Key"
+
+const (
+	// deliveredCapacity is the number of successful delivery IDs a worker remembers.
+	deliveredCapacity = 10000
+	// deliveredTTL is how long Redis keeps the ID of a successful delivery, longer than the
+	// MQ keeps redelivering a message.
+	deliveredTTL = 24 * time.Hour
+	// deliveredTimeout bounds one Redis request of a deliveredSet.
+	deliveredTimeout = 500 * time.Millisecond
+	// deliveredKeyPrefix prefixes the Redis keys of delivery IDs unless rate_limit.redis sets
+	// a key_prefix.
+	deliveredKeyPrefix = "notify:delivered:"
+)
+
+// DeliveryID returns the deterministic ID of delivering evt to the target of cfg: its
+// tenant, event type and ID, and the channel or (unrendered) URL it is sent to. Weighted
+// targets share the ID, so a target taking over an event gets the same key.
+func DeliveryID(cfg *config.NotificationConfig, evt event.Event) string {
+	target := cfg.URL
+	if cfg.Channel != "" {
+		target = "channel://" + cfg.Channel
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{evt.TenantID, cfg.EventType, evt.ID, target}, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// deliveredSet remembers the IDs of the latest successful deliveries, so a message the
+// MQ redelivers after it was delivered (e.g. because the ack was lost) isn't sent again.
+// With rate_limit.redis the IDs are shared through Redis by all workers and outlive
+// restarts; otherwise each worker only knows its own. Either way it is best-effort: when
+// Redis is unavailable, or a worker fails after delivering but before recording, the
+// event is sent again, and targets should deduplicate by the Idempotency-Key.
+type deliveredSet struct {
+	mu   sync.Mutex
+	ids  map[string]bool
+	ring []string
+	next int
+
+	client    *redis.Client
+	prefix    string
+	lastError time.Time
+}
+
+// newDeliveredSet remembers up to capacity IDs in memory, and shares them through the
+// Redis of cfg if it is set.
+func newDeliveredSet(capacity int, cfg *ratelimit.RedisConfig) *deliveredSet {
+	s := &deliveredSet{ids: make(map[string]bool, capacity), ring: make([]string, capacity)}
+	if cfg != nil {
+		s.client, s.prefix = cfg.Client(deliveredTimeout, deliveredKeyPrefix)
+	}
+	return s
+}
+
+func (s *deliveredSet) contains(ctx context.Context, id string) bool {
+	s.mu.Lock()
+	known := s.ids[id]
+	s.mu.Unlock()
+	if known || s.client == nil {
+		return known
+	}
+	n, err := s.client.Exists(ctx, s.prefix+id).Result()
+	if err != nil {
+		s.logError("look up", id, err)
+		return false
+	}
+	return n > 0
+}
+
+// add remembers id, forgetting the oldest ID in memory when full.
+func (s *deliveredSet) add(ctx context.Context, id string) {
+	s.remember(id)
+	if s.client == nil {
+		return
+	}
+	if err := s.client.Set(ctx, s.prefix+id, 1, deliveredTTL).Err(); err != nil {
+		s.logError("record", id, err)
+	}
+}
+
+func (s *deliveredSet) remember(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids[id] {
+		return
+	}
+	delete(s.ids, s.ring[s.next])
+	s.ring[s.next] = id
+	s.ids[id] = true
+	s.next = (s.next + 1) % len(s.ring)
+}
+
+// logError logs a failed Redis request, at most once a minute.
+func (s *deliveredSet) logError(action, id string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastError) < time.Minute {
+		return
+	}
+	s.lastError = time.Now()
+	fmt.Printf("[Worker] Failed to %s delivery %s in Redis: %v\n", action, id, err)
+}
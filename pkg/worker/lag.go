@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// lagCheckTimeout bounds the broker requests of one topic's lag check.
+const lagCheckTimeout = 10 * time.Second
+
+// GroupLag is the lag of a consumer group on a topic, see worker.lag_monitor.
+type GroupLag struct {
+	Group string `json:"group"`
+	// Lag is the number of messages the group has not consumed yet.
+	Lag       int64     `json:"lag"`
+	Threshold int64     `json:"threshold,omitempty"`
+	Alerting  bool      `json:"alerting,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Values of LagAlert.Status.
+const (
+	LagFiring   = "firing"
+	LagResolved = "resolved"
+)
+
+// LagAlert is posted to worker.lag_monitor.webhook_url when the lag of a topic exceeds
+// its threshold (firing) and when it is back under it (resolved).
+type LagAlert struct {
+	Status    string    `json:"status"`
+	Topic     string    `json:"topic"`
+	Group     string    `json:"group"`
+	Lag       int64     `json:"lag"`
+	Threshold int64     `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// monitorLag checks the lag of the subscribed topics every worker.lag_monitor.interval
+// until ctx ends. The config is read anew on every check, so the monitor may be enabled
+// at runtime.
+func (w *Worker) monitorLag(ctx context.Context) {
+	interval := time.Minute
+	for {
+		if l := w.Config().Worker.LagMonitor; l != nil {
+			interval = l.Interval.Std()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		cfg := w.Config()
+		if cfg.Worker.LagMonitor == nil {
+			continue
+		}
+		for _, topic := range w.Subscriptions() {
+			w.checkLag(ctx, cfg, topic)
+		}
+	}
+}
+
+// checkLag records the lag of the consumer group of topic and raises or resolves its alert.
+func (w *Worker) checkLag(ctx context.Context, cfg *config.Config, topic string) {
+	l := cfg.Worker.LagMonitor
+	mqCfg := cfg.MQ
+	if mqCfg.Broker == config.BrokerRocketMQ {
+		mqCfg.GroupName = cfg.ConsumerGroup(topic)
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, lagCheckTimeout)
+	lag, err := mq.GroupLag(checkCtx, mqCfg, topic)
+	cancel()
+
+	now := w.clock.Now()
+	w.groupLagMu.Lock()
+	prev := w.groupLag[topic]
+	cur := &GroupLag{Group: mqCfg.GroupName, Lag: lag, Threshold: l.TopicThreshold(topic), CheckedAt: now}
+	if prev != nil {
+		cur.Alerting = prev.Alerting
+	}
+	if err != nil {
+		// Keep the last known lag; an unreachable broker doesn't resolve an alert
+		if prev != nil {
+			cur.Lag = prev.Lag
+		}
+		cur.Error = err.Error()
+		w.groupLag[topic] = cur
+		w.groupLagMu.Unlock()
+		log.Printf("Failed to check the lag of group %s on %s: %v", mqCfg.GroupName, topic, err)
+		return
+	}
+	status := ""
+	switch {
+	case !cur.Alerting && cur.Threshold > 0 && lag > cur.Threshold:
+		status, cur.Alerting = LagFiring, true
+	case cur.Alerting && (cur.Threshold == 0 || lag <= cur.Threshold):
+		status, cur.Alerting = LagResolved, false
+	}
+	w.groupLag[topic] = cur
+	w.groupLagMu.Unlock()
+
+	if status != "" {
+		w.alertLag(ctx, l, LagAlert{Status: status, Topic: topic, Group: cur.Group, Lag: lag, Threshold: cur.Threshold, Time: now})
+	}
+}
+
+// alertLag logs a and posts it to the webhook and Slack URLs of l.
+func (w *Worker) alertLag(ctx context.Context, l *config.LagMonitorConfig, a LagAlert) {
+	text := fmt.Sprintf("Consumer group %s is %d messages behind on %s (threshold %d)", a.Group, a.Lag, a.Topic, a.Threshold)
+	if a.Status == LagResolved {
+		text = fmt.Sprintf("Consumer group %s is back to %d messages behind on %s (threshold %d)", a.Group, a.Lag, a.Topic, a.Threshold)
+	}
+	log.Printf("Lag alert %s: %s", a.Status, text)
+
+	if l.WebhookURL != "" {
+		if err := w.postAlert(ctx, l.WebhookURL, a); err != nil {
+			log.Printf("Failed to post lag alert to %s: %v", l.WebhookURL, err)
+		}
+	}
+	if l.SlackURL != "" {
+		// The Slack URL is a secret, so it is left out of the log
+		if err := w.postAlert(ctx, l.SlackURL, map[string]string{"text": text}); err != nil {
+			log.Printf("Failed to post lag alert to Slack: %v", err)
+		}
+	}
+}
+
+func (w *Worker) postAlert(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// GroupLag returns the lag of the consumer group of every topic checked by
+// worker.lag_monitor.
+func (w *Worker) GroupLag() map[string]GroupLag {
+	w.groupLagMu.Lock()
+	defer w.groupLagMu.Unlock()
+
+	out := make(map[string]GroupLag, len(w.groupLag))
+	for topic, l := range w.groupLag {
+		out[topic] = *l
+	}
+	return out
+}
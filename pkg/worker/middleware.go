@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/event"
+)
+
+// Delivery is an event on its way to the notification configured for it.
+type Delivery struct {
+	Notification *config.NotificationConfig
+	Event        event.Event
+	// Topic and MessageID identify the message carrying the event.
+	Topic     string
+	MessageID string
+	// Attempt counts how often the message has been consumed, starting at 1.
+	Attempt int
+	// Record is the outcome of the delivery once the worker has sent it; it stays zero
+	// when a middleware returns without calling next.
+	Record delivery.Record
+}
+
+// Handler delivers an event. An error has the MQ redeliver the message, unless it is a
+// *PermanentError, which moves the message to the DLQ right away.
+type Handler func(ctx context.Context, d *Delivery) error
+
+// Middleware wraps a Handler with a cross-cutting concern such as metrics, tracing or
+// deduplication. It may inspect or change d before calling next, look at d.Record and
+// the error afterwards, or return without calling next to skip the delivery.
+type Middleware func(next Handler) Handler
+
+// Chain returns h wrapped in middleware, the first of which runs outermost.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Use appends middleware to the chain every event passes through on its way to the
+// target. Events that cannot be decoded, have no notification or exceeded mq.max_retries
+// don't reach the chain. Use must be called before Start.
+func (w *Worker) Use(middleware ...Middleware) {
+	w.middleware = append(w.middleware, middleware...)
+	w.handler = Chain(w.deliver, w.middleware...)
+}
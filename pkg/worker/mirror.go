@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/event"
+)
+
+// mirror sends a copy of the delivery of r to its mirror URL in the background, once per
+// delivery rather than per attempt. The copy has the headers and body of r, but not the
+// OAuth2 access token of the target. Failures are only logged, and so are responses whose
+// outcome differs from the target's, as given by record.
+func (w *Worker) mirror(cfg *config.NotificationConfig, evt event.Event, r *Request, record delivery.Record) {
+	w.mirrors.Add(1)
+	go func() {
+		defer w.mirrors.Done()
+		status, err := w.sendMirror(cfg, r)
+		if err != nil {
+			fmt.Printf("[Worker] Mirror %s of event %s failed: %s\n", r.MirrorURL, evt.ID, scrub(cfg, evt.Data, err.Error()))
+			return
+		}
+		if isSuccessStatus(cfg.Success, status) != record.Success {
+			fmt.Printf("[Worker] Mirror %s answered %d for event %s, the target %s\n", r.MirrorURL, status, evt.ID, targetOutcome(record))
+		}
+	}()
+}
+
+func (w *Worker) sendMirror(cfg *config.NotificationConfig, r *Request) (int, error) {
+	client, err := w.clientFor(cfg)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(r.Method, r.MirrorURL, bytes.NewReader(r.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = r.Header.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, nil
+}
+
+// targetOutcome describes the outcome of a delivery for comparison with its mirror.
+func targetOutcome(record delivery.Record) string {
+	if record.StatusCode != 0 {
+		return fmt.Sprintf("answered %d", record.StatusCode)
+	}
+	return "failed: " + record.Error
+}
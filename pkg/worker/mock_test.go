@@ -0,0 +1,77 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"go.uber.org/mock/gomock"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/worker"
+	"notification-system/pkg/worker/workertest"
+)
+
+type consumeFunc = func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)
+
+func TestHandleMessageMaxRetriesMocks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// The worker subscribes to order_queue and hands its messages to the captured handler
+	var handle consumeFunc
+	push := workertest.NewMockConsumer(ctrl)
+	push.EXPECT().Subscribe("order_queue", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ string, _ consumer.MessageSelector, f consumeFunc) error {
+			handle = f
+			return nil
+		})
+	push.EXPECT().Subscribe(gomock.Not("order_queue"), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	push.EXPECT().Start().Return(nil).AnyTimes()
+	push.EXPECT().Suspend().AnyTimes()
+	push.EXPECT().Shutdown().Return(nil).AnyTimes()
+
+	// After the last retry the message goes to the DLQ without a request to the target
+	producer := workertest.NewMockProducer(ctrl)
+	producer.EXPECT().SendSync(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, msgs ...*primitive.Message) (*primitive.SendResult, error) {
+			if len(msgs) != 1 || msgs[0].Topic != "DLQ_order_queue" {
+				t.Errorf("sent %d messages to %v, want one to DLQ_order_queue", len(msgs), msgs)
+			}
+			return &primitive.SendResult{Status: primitive.SendOK}, nil
+		})
+	producer.EXPECT().Shutdown().Return(nil).AnyTimes()
+	client := workertest.NewMockHTTPDoer(ctrl)
+	clock := workertest.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(now).AnyTimes()
+
+	cfg, err := config.ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := worker.NewWorkerWith(cfg, worker.Dependencies{
+		NewConsumer: func(config.MQConfig) (worker.Consumer, error) { return push, nil },
+		Producer:    producer,
+		Client:      client,
+		Clock:       clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Shutdown() })
+
+	msg := &primitive.MessageExt{
+		Message:        primitive.Message{Topic: "order_queue", Body: orderCreated(t)},
+		MsgId:          "msg-1",
+		ReconsumeTimes: 3,
+		BornTimestamp:  now.UnixMilli(),
+	}
+	if result, err := handle(context.Background(), msg); err != nil || result != consumer.ConsumeSuccess {
+		t.Fatalf("handle = %v, %v, want success", result, err)
+	}
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+// tokenRefreshMargin is how long before expiry a cached token is considered stale.
+const tokenRefreshMargin = 30 * time.Second
+
+// oauthToken is a cached bearer token.
+type oauthToken struct {
+	accessToken string
+	tokenType   string
+	expiresAt   time.Time
+}
+
+func (t *oauthToken) valid() bool {
+	return t != nil && (t.expiresAt.IsZero() || time.Now().Add(tokenRefreshMargin).Before(t.expiresAt))
+}
+
+// tokenCache fetches and caches OAuth2 client credentials tokens per auth configuration.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]*oauthToken
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: make(map[string]*oauthToken)}
+}
+
+// authorization returns the Authorization header value for auth, fetching a new token when
+// the cached one is missing or about to expire.
+func (c *tokenCache) authorization(ctx context.Context, client HTTPDoer, auth *config.AuthConfig) (string, error) {
+	key := cacheKey(auth)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tok := c.tokens[key]
+	if !tok.valid() {
+		var err error
+		tok, err = fetchToken(ctx, client, auth)
+		if err != nil {
+			return "", err
+		}
+		c.tokens[key] = tok
+	}
+
+	tokenType := tok.tokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + tok.accessToken, nil
+}
+
+// invalidate drops the cached token, e.g. after the target rejected it with 401.
+func (c *tokenCache) invalidate(auth *config.AuthConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, cacheKey(auth))
+}
+
+// cacheKey includes the client secret so a rotated secret never reuses the old token.
+func cacheKey(auth *config.AuthConfig) string {
+	return strings.Join([]string{auth.TokenURL, auth.ClientID, auth.ClientSecret, strings.Join(auth.Scopes, " ")}, "\x00")
+}
+
+// fetchToken performs the client credentials grant (RFC 6749 section 4.4).
+func fetchToken(ctx context.Context, client HTTPDoer, auth *config.AuthConfig) (*oauthToken, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	tok := &oauthToken{accessToken: payload.AccessToken, tokenType: payload.TokenType}
+	if payload.ExpiresIn > 0 {
+		tok.expiresAt = time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/render"
+)
+
+// Properties added to parked messages. The other properties are those of the original message.
+const (
+	// ParkTopicProperty is the topic a parked message came from and is drained back to.
+	ParkTopicProperty = "NOTIFY_PARK_TOPIC"
+	// ParkReasonProperty records why the message was parked.
+	ParkReasonProperty = "NOTIFY_PARK_REASON"
+)
+
+// Reasons for parking a message.
+const (
+	ParkReasonPaused   = "paused"
+	ParkReasonDegraded = "degraded"
+)
+
+// ParkTopic returns the parking topic of a target URL: PARK_ followed by its scheme and
+// host, e.g. PARK_https_partner_example_com. Channel notifications are parked by channel,
+// with the target channel://<name>.
+func ParkTopic(target string) string {
+	key := strings.Replace(targetKey(target), "://", "_", 1)
+	return "PARK_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// parkError moves a message to the parking topic of its target instead of retrying it.
+type parkError struct {
+	Target string
+	Reason string
+	Err    error
+}
+
+func (e *parkError) Error() string {
+	return fmt.Sprintf("parking message for %s (%s): %v", e.Target, e.Reason, e.Err)
+}
+
+func (e *parkError) Unwrap() error { return e.Err }
+
+// parkTarget returns the target a delivery is parked by: the channel of channel
+// notifications, otherwise the scheme and host of url.
+func parkTarget(cfg *config.NotificationConfig, url string) string {
+	if cfg.Channel != "" {
+		return "channel://" + cfg.Channel
+	}
+	return targetKey(url)
+}
+
+// parkPaused turns the *PausedError err into a *parkError when worker.park.paused is set.
+// url is the rendered target URL, or empty when the pause was found before rendering;
+// the URL is then rendered from the event as received, and a URL that can't be rendered
+// leaves the message held.
+func (w *Worker) parkPaused(cfg *config.NotificationConfig, evt event.Event, url string, err error) error {
+	if p := w.Config().Worker.Park; p == nil || !p.Paused {
+		return err
+	}
+	if url == "" && cfg.Channel == "" {
+		var rErr error
+		urlTemplate, _ := targetFor(cfg, evt.ID)
+		if url, rErr = render.String(urlTemplate, evt); rErr != nil {
+			return err
+		}
+	}
+	return &parkError{Target: parkTarget(cfg, url), Reason: ParkReasonPaused, Err: err}
+}
+
+// parkProperties returns the properties of a message parked from topic.
+func parkProperties(props map[string]string, topic, reason string) map[string]string {
+	out := make(map[string]string, len(props)+2)
+	for k, v := range props {
+		out[k] = v
+	}
+	out[ParkTopicProperty] = topic
+	out[ParkReasonProperty] = reason
+	return out
+}
+
+// parkMessage moves msg to the parking topic of its target. Parked messages share their
+// target's queue, so they are drained in the order they were parked.
+func (w *Worker) parkMessage(ctx context.Context, msg *primitive.MessageExt, pErr *parkError) error {
+	parked := &primitive.Message{
+		Topic: ParkTopic(pErr.Target),
+		Body:  msg.Body,
+	}
+	parked.WithProperties(parkProperties(msg.GetProperties(), msg.Topic, pErr.Reason))
+	parked.WithShardingKey(pErr.Target)
+
+	_, err := w.DLQProducer.SendSync(ctx, parked)
+	return err
+}
+
+func (w *Worker) publishPark(ctx context.Context, cfg *config.Config, d *mq.Delivery, pErr *parkError) error {
+	props := parkProperties(d.Properties, d.Topic, pErr.Reason)
+	err := w.Broker.Publish(ctx, ParkTopic(pErr.Target), d.Body, mq.WithProperties(props), mq.WithShardingKey(pErr.Target), mq.Compression(cfg.MQ))
+	if err != nil {
+		fmt.Printf("[Worker] Failed to park message %s: %v\n", d.ID, err)
+	}
+	return err
+}
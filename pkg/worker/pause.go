@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/mq"
+)
+
+// pauseRecheck is how long a held message waits before its pause is checked again.
+const pauseRecheck = 30 * time.Second
+
+// PausedError holds back a delivery matched by one of the configured pauses. The message
+// is redelivered after Delay without counting as a failed attempt.
+type PausedError struct {
+	Pause config.PauseConfig
+	Delay time.Duration
+}
+
+func (e *PausedError) Error() string {
+	if e.Pause.Reason != "" {
+		return "delivery paused: " + e.Pause.Reason
+	}
+	return "delivery paused"
+}
+
+// checkPause returns a *PausedError if a pause holds back the delivery of evt to url,
+// which is empty before the target URL is rendered. Held messages are checked again every
+// pauseRecheck, or when the pause expires if that is sooner.
+func (w *Worker) checkPause(evt event.Event, url string) error {
+	now := w.clock.Now()
+	p := w.Config().Paused(evt.TenantID, evt.Type, url, now)
+	if p == nil {
+		return nil
+	}
+	delay := pauseRecheck
+	if p.Until != nil {
+		delay = min(delay, p.Until.Sub(now))
+	}
+	return &PausedError{Pause: *p, Delay: delay}
+}
+
+// reconsumeTimes returns the failed attempts of msg, including those carried over by
+// re-publishing it (see mq.RetryTimesProperty).
+func reconsumeTimes(msg *primitive.MessageExt) int {
+	n, _ := strconv.Atoi(msg.GetProperty(mq.RetryTimesProperty))
+	return int(msg.ReconsumeTimes) + n
+}
+
+// hold re-publishes msg to its topic to be consumed again after delay. Unlike a retry, it
+// keeps the attempts of msg so being held back doesn't use up its retries.
+func (w *Worker) hold(msg *primitive.MessageExt, delay time.Duration) error {
+	held := &primitive.Message{
+		Topic: msg.Topic,
+		Body:  msg.Body,
+	}
+	held.WithProperties(msg.GetProperties())
+	held.WithProperty(mq.RetryTimesProperty, strconv.Itoa(reconsumeTimes(msg)))
+	held.WithDelayTimeLevel(mq.DelayLevel(delay))
+
+	_, err := w.DLQProducer.SendSync(context.Background(), held)
+	if err != nil {
+		return fmt.Errorf("failed to re-publish held message %s: %w", msg.MsgId, err)
+	}
+	return nil
+}
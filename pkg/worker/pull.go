@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2"
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// pollTimeout bounds a single Poll so shutdown is noticed promptly.
+const pollTimeout = time.Second
+
+// startPulling starts the pull consumers created so far, then subscribes to the remaining topics.
+func (w *Worker) startPulling() error {
+	cfg := w.Config()
+
+	w.mu.Lock()
+	w.pollCtx, w.stopPolling = context.WithCancel(context.Background())
+	for topic, pc := range w.pullers {
+		if err := w.startPuller(topic, pc); err != nil {
+			w.mu.Unlock()
+			return fmt.Errorf("failed to start consumer for topic %s: %w", topic, err)
+		}
+	}
+	w.mu.Unlock()
+
+	if err := w.subscribe(cfg); err != nil {
+		return err
+	}
+	w.started.Store(true)
+	return nil
+}
+
+// addPuller creates the pull consumer for topic. Once polling has started it is also
+// started, so topics added by UpdateConfig are consumed right away. Callers hold w.mu.
+func (w *Worker) addPuller(cfg *config.Config, topic string) error {
+	pc, err := mq.NewPullConsumer(cfg.MQ, cfg.ConsumerGroup(topic))
+	if err != nil {
+		return err
+	}
+	if err := pc.Subscribe(topic, consumer.MessageSelector{}); err != nil {
+		return err
+	}
+	if w.pollCtx != nil {
+		if err := w.startPuller(topic, pc); err != nil {
+			return err
+		}
+	}
+	w.pullers[topic] = pc
+	return nil
+}
+
+func (w *Worker) startPuller(topic string, pc rocketmq.PullConsumer) error {
+	if err := pc.Start(); err != nil {
+		return err
+	}
+	w.polling.Add(1)
+	go w.poll(topic, pc, w.slotsFor(w.Config().TopicPriority(topic)))
+	return nil
+}
+
+// slotsFor returns the delivery slots of a priority: its weighted share of
+// worker.max_concurrency, shared by all topics of that priority. Callers hold w.mu.
+func (w *Worker) slotsFor(priority string) chan struct{} {
+	if slots, ok := w.slots[priority]; ok {
+		return slots
+	}
+	cfg := w.Config()
+	slots := make(chan struct{}, cfg.PriorityShare(cfg.Worker.MaxConcurrency, priority))
+	w.slots[priority] = slots
+	return slots
+}
+
+// poll fetches a batch only after acquiring a delivery slot, so at most max_concurrency
+// batches are processed at once and nothing more is taken from the broker meanwhile.
+func (w *Worker) poll(topic string, pc rocketmq.PullConsumer, slots chan struct{}) {
+	defer w.polling.Done()
+	ctx := w.pollCtx
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		cr, err := pc.Poll(ctx, pollTimeout)
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, consumer.ErrNoNewMsg) {
+				log.Printf("Failed to poll topic %s: %v", topic, err)
+			}
+			continue
+		}
+
+		w.polling.Add(1)
+		go func() {
+			defer w.polling.Done()
+			defer func() { <-slots }()
+			if result, ok := w.consumePulled(ctx, cr.GetMsgList()); ok {
+				pc.ACK(context.Background(), cr, result)
+			}
+		}()
+	}
+}
+
+// consumePulled delivers pulled messages one by one. Failed messages are re-published with a
+// delay instead of being sent back to the broker, mirroring push-mode retries. It returns
+// false when the batch was not processed because the worker is shutting down; such
+// messages stay unacknowledged and are redelivered.
+func (w *Worker) consumePulled(ctx context.Context, msgs []*primitive.MessageExt) (consumer.ConsumeResult, bool) {
+	for _, msg := range msgs {
+		if w.isDraining() {
+			return consumer.ConsumeRetryLater, false
+		}
+		// HandleMessage reports a longer delay requested by the target like it does in push mode
+		cc := primitive.NewConsumeConcurrentlyContext()
+		result, _ := w.HandleMessage(primitive.WithConcurrentlyCtx(ctx, cc), msg)
+
+		if result == consumer.ConsumeSuccess {
+			continue
+		}
+		if err := w.requeue(msg, cc.DelayLevelWhenNextConsume); err != nil {
+			log.Printf("Failed to re-publish message %s for retry: %v", msg.MsgId, err)
+			return consumer.ConsumeRetryLater, true
+		}
+	}
+	return consumer.ConsumeSuccess, true
+}
+
+// retryLevel is the delay level RocketMQ uses for the retry of a message consumed
+// reconsumeTimes times before.
+func retryLevel(reconsumeTimes int) int {
+	return min(3+reconsumeTimes, 18)
+}
+
+// requeue re-publishes msg to its topic with an incremented retry count, delayed like
+// RocketMQ's own consumer retries (10s, 30s, 1m, 2m, ...) or by level when it is longer.
+func (w *Worker) requeue(msg *primitive.MessageExt, level int) error {
+	retries := reconsumeTimes(msg) + 1
+	retry := &primitive.Message{
+		Topic: msg.Topic,
+		Body:  msg.Body,
+	}
+	retry.WithProperties(msg.GetProperties())
+	retry.WithProperty(mq.RetryTimesProperty, strconv.Itoa(retries))
+	retry.WithDelayTimeLevel(max(retryLevel(retries), level))
+
+	_, err := w.DLQProducer.SendSync(context.Background(), retry)
+	return err
+}
+
+func (w *Worker) isDraining() bool {
+	w.inflightMu.Lock()
+	defer w.inflightMu.Unlock()
+	return w.draining
+}
+
+// shutdownPullers stops every pull consumer, committing acknowledged offsets.
+func (w *Worker) shutdownPullers() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	for topic, pc := range w.pullers {
+		if err := pc.Shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("topic %s: %w", topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,263 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/schema"
+)
+
+// Properties added to quarantined messages. The other properties are those of the original message.
+const (
+	// QuarantineDiagnosisProperty is why the event of the message can't be decoded, one
+	// of the Diagnosis values.
+	QuarantineDiagnosisProperty = "NOTIFY_QUARANTINE_DIAGNOSIS"
+	// QuarantineErrorProperty carries the decoding error.
+	QuarantineErrorProperty = "NOTIFY_QUARANTINE_ERROR"
+)
+
+// Diagnoses of undecodable messages.
+const (
+	DiagnosisEmpty            = "empty_body"
+	DiagnosisInvalidUTF8      = "invalid_utf8"
+	DiagnosisTruncatedJSON    = "truncated_json"
+	DiagnosisInvalidJSON      = "invalid_json"
+	DiagnosisUnexpectedSchema = "unexpected_schema"
+	// DiagnosisInvalidEncoding is given to Protobuf and Avro bodies that don't decode.
+	DiagnosisInvalidEncoding = "invalid_encoding"
+	DiagnosisDecryptError    = "decrypt_error"
+	// DiagnosisInvalidCompression is given to bodies that can't be decompressed.
+	DiagnosisInvalidCompression = "invalid_compression"
+)
+
+// QuarantineTopic returns the topic the undecodable messages of topic are moved to.
+func QuarantineTopic(topic string) string {
+	return "QUARANTINE_" + topic
+}
+
+// QuarantineSample is a message moved to the quarantine topic, see worker.quarantine.
+type QuarantineSample struct {
+	MessageID string    `json:"message_id"`
+	Diagnosis string    `json:"diagnosis"`
+	Error     string    `json:"error"`
+	Size      int       `json:"size"`
+	Time      time.Time `json:"time"`
+}
+
+// QuarantineStatus sums up the messages of a topic moved to its quarantine topic.
+type QuarantineStatus struct {
+	Quarantined int64 `json:"quarantined"`
+	// Diagnoses counts the quarantined messages by diagnosis.
+	Diagnoses map[string]int64 `json:"diagnoses"`
+	// Samples are the latest quarantined messages, newest first.
+	Samples   []QuarantineSample `json:"samples"`
+	LastAlert time.Time          `json:"last_alert,omitempty"`
+
+	// recent are the messages quarantined within the window
+	recent []recentPoison
+}
+
+type recentPoison struct {
+	time      time.Time
+	diagnosis string
+}
+
+// PoisonAlert is posted to worker.quarantine.webhook_url when worker.quarantine.threshold
+// messages of a topic were quarantined within the window.
+type PoisonAlert struct {
+	Topic string `json:"topic"`
+	// Count is the number of messages quarantined within the window.
+	Count     int              `json:"count"`
+	Window    string           `json:"window"`
+	Diagnoses map[string]int64 `json:"diagnoses"`
+	Sample    QuarantineSample `json:"sample"`
+	Time      time.Time        `json:"time"`
+}
+
+// quarantineError moves a message whose event can't be decoded to the quarantine topic.
+type quarantineError struct {
+	Diagnosis string
+	Err       error
+}
+
+func (e *quarantineError) Error() string {
+	return fmt.Sprintf("quarantining message (%s): %v", e.Diagnosis, e.Err)
+}
+
+func (e *quarantineError) Unwrap() error { return e.Err }
+
+// poison handles a message whose event can't be decoded. Without worker.quarantine it is
+// skipped; otherwise it is diagnosed, counted, and returned as a *quarantineError.
+func (w *Worker) poison(ctx context.Context, cfg *config.Config, topic, msgID string, body []byte, props map[string]string, err error) error {
+	q := cfg.Worker.Quarantine
+	if q == nil {
+		fmt.Printf("[Worker] Error unmarshalling event data: %v. Skipping message.\n", err)
+		// Acknowledge the message to prevent infinite redelivery of bad data
+		return nil
+	}
+	diagnosis := DiagnosisDecryptError
+	var compressErr *mq.DecompressError
+	if errors.As(err, &compressErr) {
+		diagnosis = DiagnosisInvalidCompression
+	} else if plain, dErr := mq.Decrypt(ctx, w.keyring, cfg.MQ.Encryption, body, props); dErr == nil {
+		diagnosis = diagnose(plain, props[mq.ContentTypeProperty])
+	} else if errors.As(dErr, &compressErr) {
+		diagnosis = DiagnosisInvalidCompression
+	}
+	fmt.Printf("[Worker] Error unmarshalling event data of message %s (%s): %v. Quarantining message.\n", msgID, diagnosis, err)
+
+	now := w.clock.Now()
+	sample := QuarantineSample{MessageID: msgID, Diagnosis: diagnosis, Error: truncate(err.Error(), maxDLQError), Size: len(body), Time: now}
+	w.quarantineMu.Lock()
+	s := w.quarantine[topic]
+	if s == nil {
+		s = &QuarantineStatus{Diagnoses: make(map[string]int64)}
+		w.quarantine[topic] = s
+	}
+	s.Quarantined++
+	s.Diagnoses[diagnosis]++
+	s.Samples = append([]QuarantineSample{sample}, s.Samples...)
+	if len(s.Samples) > q.Samples {
+		s.Samples = s.Samples[:q.Samples]
+	}
+	window := q.Window.Std()
+	recent := s.recent[:0]
+	for _, r := range s.recent {
+		if now.Sub(r.time) < window {
+			recent = append(recent, r)
+		}
+	}
+	s.recent = append(recent, recentPoison{now, diagnosis})
+	var alert *PoisonAlert
+	if len(s.recent) >= q.Threshold && now.Sub(s.LastAlert) >= window {
+		s.LastAlert = now
+		alert = &PoisonAlert{Topic: topic, Count: len(s.recent), Window: window.String(), Diagnoses: make(map[string]int64), Sample: sample, Time: now}
+		for _, r := range s.recent {
+			alert.Diagnoses[r.diagnosis]++
+		}
+	}
+	w.quarantineMu.Unlock()
+
+	if alert != nil {
+		go w.alertPoison(context.WithoutCancel(ctx), q, *alert)
+	}
+	return &quarantineError{Diagnosis: diagnosis, Err: err}
+}
+
+// diagnose tells why a decrypted body doesn't decode to an event.
+func diagnose(body []byte, contentType string) string {
+	if contentType == mq.ContentTypeProtobuf || contentType == schema.ContentTypeAvro {
+		return DiagnosisInvalidEncoding
+	}
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) == 0:
+		return DiagnosisEmpty
+	case !utf8.Valid(body):
+		return DiagnosisInvalidUTF8
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	err := dec.Decode(&v)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return DiagnosisTruncatedJSON
+	case err != nil || dec.InputOffset() < int64(len(trimmed)):
+		return DiagnosisInvalidJSON
+	}
+	// Valid JSON that isn't an event: not an object, or fields of the wrong type
+	return DiagnosisUnexpectedSchema
+}
+
+// alertPoison logs a and posts it to the webhook and Slack URLs of q.
+func (w *Worker) alertPoison(ctx context.Context, q *config.QuarantineConfig, a PoisonAlert) {
+	diagnoses := make([]string, 0, len(a.Diagnoses))
+	for d, n := range a.Diagnoses {
+		diagnoses = append(diagnoses, fmt.Sprintf("%s: %d", d, n))
+	}
+	sort.Strings(diagnoses)
+	text := fmt.Sprintf("%d undecodable messages on %s within %s were quarantined to %s (%s)",
+		a.Count, a.Topic, a.Window, QuarantineTopic(a.Topic), strings.Join(diagnoses, ", "))
+	log.Printf("Poison message alert: %s", text)
+
+	if q.WebhookURL != "" {
+		if err := w.postAlert(ctx, q.WebhookURL, a); err != nil {
+			log.Printf("Failed to post poison message alert to %s: %v", q.WebhookURL, err)
+		}
+	}
+	if q.SlackURL != "" {
+		// The Slack URL is a secret, so it is left out of the log
+		if err := w.postAlert(ctx, q.SlackURL, map[string]string{"text": text}); err != nil {
+			log.Printf("Failed to post poison message alert to Slack: %v", err)
+		}
+	}
+}
+
+// quarantineProperties returns the properties of a quarantined message.
+func quarantineProperties(props map[string]string, qErr *quarantineError) map[string]string {
+	out := make(map[string]string, len(props)+2)
+	for k, v := range props {
+		out[k] = v
+	}
+	out[QuarantineDiagnosisProperty] = qErr.Diagnosis
+	out[QuarantineErrorProperty] = truncate(qErr.Err.Error(), maxDLQError)
+	return out
+}
+
+func (w *Worker) quarantineMessage(ctx context.Context, msg *primitive.MessageExt, qErr *quarantineError) error {
+	quarantined := &primitive.Message{
+		Topic: QuarantineTopic(msg.Topic),
+		Body:  msg.Body,
+	}
+	quarantined.WithProperties(quarantineProperties(msg.GetProperties(), qErr))
+
+	_, err := w.DLQProducer.SendSync(ctx, quarantined)
+	return err
+}
+
+func (w *Worker) publishQuarantine(ctx context.Context, cfg *config.Config, d *mq.Delivery, qErr *quarantineError) error {
+	props := quarantineProperties(d.Properties, qErr)
+	opts := []mq.SendOption{mq.WithProperties(props)}
+	if d.Err == nil {
+		// Bodies that can't be decompressed are kept as consumed, with their compression
+		opts = append(opts, mq.Compression(cfg.MQ))
+	}
+	err := w.Broker.Publish(ctx, QuarantineTopic(d.Topic), d.Body, opts...)
+	if err != nil {
+		fmt.Printf("[Worker] Failed to quarantine message %s: %v\n", d.ID, err)
+	}
+	return err
+}
+
+// Quarantine returns the messages moved to the quarantine topic of every topic, see
+// worker.quarantine.
+func (w *Worker) Quarantine() map[string]QuarantineStatus {
+	w.quarantineMu.Lock()
+	defer w.quarantineMu.Unlock()
+
+	out := make(map[string]QuarantineStatus, len(w.quarantine))
+	for topic, s := range w.quarantine {
+		status := *s
+		status.Diagnoses = make(map[string]int64, len(s.Diagnoses))
+		for d, n := range s.Diagnoses {
+			status.Diagnoses[d] = n
+		}
+		status.Samples = append([]QuarantineSample(nil), s.Samples...)
+		status.recent = nil
+		out[topic] = status
+	}
+	return out
+}
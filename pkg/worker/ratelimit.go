@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/ratelimit"
+)
+
+// waitForRateLimit takes one request from the rate limit of the notification, waiting up
+// to maxLocalRetryAfter for it, or until ctx is done. Longer waits are left to the MQ
+// redelivery: the returned error carries the delay (see retryAfterOf).
+func (w *Worker) waitForRateLimit(ctx context.Context, cfg *config.NotificationConfig) error {
+	key := rateLimitKey(cfg)
+	deadline := time.Now().Add(maxLocalRetryAfter)
+	for {
+		err := w.limiter.Allow(key, *cfg.RateLimit)
+		var qErr *ratelimit.ExceededError
+		if !errors.As(err, &qErr) {
+			return err
+		}
+		if time.Now().Add(qErr.RetryAfter).After(deadline) {
+			return fmt.Errorf("rate limit of notification %s reached: %w", key, err)
+		}
+		if err := sleep(ctx, qErr.RetryAfter); err != nil {
+			return fmt.Errorf("waiting for the rate limit of notification %s: %w", key, err)
+		}
+	}
+}
+
+// rateLimitKey identifies the rate limit of a notification among all quota keys.
+func rateLimitKey(cfg *config.NotificationConfig) string {
+	return "notification:" + cfg.Tenant + "/" + cfg.EventType
+}
+
+// RateLimitStats returns the rate limit counters of every notification seen so far.
+func (w *Worker) RateLimitStats() map[string]ratelimit.Stats {
+	return w.limiter.Stats()
+}
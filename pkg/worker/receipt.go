@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/event"
+)
+
+// Receipt statuses.
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptFailed    = "failed"
+	// ReceiptExpired reports a notification dropped because its event expired.
+	ReceiptExpired = "expired"
+)
+
+// receiptAttempts bounds how often posting a receipt is attempted.
+const receiptAttempts = 3
+
+// Receipt reports the final outcome of a delivery to the callback URL of the event or its
+// notification: the notification was delivered, it failed and the message was moved to
+// the DLQ, or it was dropped because the event expired. It is signed like the
+// notification when a signing secret is configured.
+type Receipt struct {
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	TenantID  string `json:"tenant_id,omitempty"`
+	// CorrelationID is the correlation ID of the event, so the producer can match the
+	// receipt to the request that caused it.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// DeliveryID is the Idempotency-Key sent with the notification.
+	DeliveryID string `json:"delivery_id"`
+	Status     string `json:"status"`
+	// Deliveries counts how often the message was consumed, including MQ redeliveries.
+	Deliveries int `json:"deliveries"`
+	// Attempts counts the HTTP requests of the last delivery.
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Reason is the dead-letter reason of a failed delivery (see DLQReasonProperty).
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// newReceipt builds the receipt of a delivery from its record. A non-empty reason marks
+// it failed.
+func newReceipt(rec delivery.Record, deliveries int, reason string) *Receipt {
+	r := &Receipt{
+		EventID:    rec.EventID,
+		EventType:  rec.EventType,
+		TenantID:   rec.Tenant,
+		DeliveryID: rec.DeliveryID,
+		Status:     ReceiptDelivered,
+		Deliveries: deliveries,
+		Attempts:   rec.Attempts,
+		StatusCode: rec.StatusCode,
+		Time:       time.Now(),
+	}
+	if reason != "" {
+		r.Status = ReceiptFailed
+		r.Error = rec.Error
+		r.Reason = reason
+	}
+	return r
+}
+
+// deadLetterReceipt sends the receipt of a message moved to the DLQ after mq.max_retries
+// deliveries. body is the decompressed, possibly encrypted message body and props its
+// properties.
+func (w *Worker) deadLetterReceipt(ctx context.Context, cfg *config.Config, body []byte, props map[string]string, deliveries int) {
+	evt, err := w.decodeEvent(ctx, cfg, body, props)
+	if err != nil {
+		return
+	}
+	n := cfg.FindNotificationConfig(evt.TenantID, evt.Type, cfg.UpcastVersion(evt.TenantID, evt.Type, evt.Version))
+	if n == nil {
+		return
+	}
+	w.sendReceipt(n, evt, &Receipt{
+		EventID:    evt.ID,
+		EventType:  evt.Type,
+		TenantID:   evt.TenantID,
+		DeliveryID: DeliveryID(n, evt),
+		Status:     ReceiptFailed,
+		Deliveries: deliveries,
+		Error:      fmt.Sprintf("exceeded mq.max_retries (%d)", cfg.MQ.MaxRetries),
+		Reason:     ReasonMaxRetries,
+		Time:       time.Now(),
+	})
+}
+
+// sendReceipt posts r to the callback URL of the event, or else of its notification, in
+// the background. Receipts are best effort: failures are logged after a few attempts.
+// Notifications in dry run send none.
+func (w *Worker) sendReceipt(n *config.NotificationConfig, evt event.Event, r *Receipt) {
+	if n.DryRun {
+		return
+	}
+	callbackURL := evt.CallbackURL
+	if callbackURL == "" {
+		callbackURL = n.CallbackURL
+	}
+	if callbackURL == "" {
+		return
+	}
+	r.CorrelationID = evt.CorrelationID
+	body, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to encode receipt for event %s: %v", r.EventID, err)
+		return
+	}
+
+	w.receipts.Add(1)
+	go func() {
+		defer w.receipts.Done()
+		var err error
+		for i := 0; i < receiptAttempts; i++ {
+			if i > 0 {
+				time.Sleep(backoff(nil, i))
+			}
+			if err = w.postReceipt(callbackURL, n.SigningSecret, body); err == nil {
+				return
+			}
+		}
+		log.Printf("Failed to send receipt for event %s to %s: %v", r.EventID, callbackURL, err)
+	}()
+}
+
+func (w *Worker) postReceipt(callbackURL, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(SignatureHeader, sign(secret, body))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
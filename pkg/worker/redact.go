@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"notification-system/pkg/config"
+	"notification-system/pkg/redact"
+)
+
+// redactorFor returns the redactor for a notification, or nil if it has no sensitive fields.
+func redactorFor(cfg *config.NotificationConfig) *redact.Redactor {
+	if cfg.Redact == nil {
+		return nil
+	}
+	r, err := redact.New(cfg.Redact.Fields, cfg.Redact.Keep)
+	if err != nil {
+		// Already rejected by config validation.
+		return nil
+	}
+	return r
+}
+
+// scrub masks the notification's sensitive event values wherever they appear in s,
+// before s is logged or stored in a delivery record.
+func scrub(cfg *config.NotificationConfig, data map[string]interface{}, s string) string {
+	if r := redactorFor(cfg); r != nil {
+		return r.Scrub(data, s)
+	}
+	return s
+}
@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+)
+
+// redriveGroupSuffix is appended to mq.group_name for the consumer group of the redrives,
+// which keeps its own offsets on the DLQ topics.
+const redriveGroupSuffix = "_redrive"
+
+// RedriveTopic returns the topic the dead-lettered messages of topic wait in until they are
+// redriven. Holding them back there rather than in the DLQ keeps copies of them out of
+// the DLQ, which other consumers read too.
+func RedriveTopic(topic string) string {
+	return "REDRIVE_" + topic
+}
+
+// redriver moves the messages of the DLQs of the topics the worker consumes back to their
+// topics, see worker.redrive.
+type redriver struct {
+	w *Worker
+
+	mu      sync.Mutex
+	broker  mq.Broker // connected on the first subscription
+	topics  map[string]bool
+	started bool
+	// tombstones are those of the DLQ browser, or nil without api.dlq_browser
+	tombstones *DLQTombstones
+}
+
+func newRedriver(w *Worker) *redriver {
+	return &redriver{w: w, topics: make(map[string]bool)}
+}
+
+// subscribe has the redriver consume the DLQ and the redrive topic of topic. Errors are
+// logged: the topic is consumed regardless.
+func (r *redriver) subscribe(cfg *config.Config, topic string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.topics[topic] {
+		return
+	}
+	if r.broker == nil {
+		mqCfg := cfg.MQ
+		mqCfg.GroupName += redriveGroupSuffix
+		mqCfg.ConsumeGoroutines = 1
+		b, err := mq.NewBroker(mqCfg)
+		if err != nil {
+			log.Printf("Failed to connect the DLQ redrive consumer: %v", err)
+			return
+		}
+		r.broker = b
+		r.tombstones = OpenDLQTombstones(cfg)
+	}
+	dlq := "DLQ_" + topic
+	if err := r.broker.Subscribe(dlq, r.handleDead); err != nil {
+		log.Printf("Failed to subscribe to %s for redrives: %v", dlq, err)
+		return
+	}
+	if err := r.broker.Subscribe(RedriveTopic(topic), r.handleHeld); err != nil {
+		log.Printf("Failed to subscribe to %s for redrives: %v", RedriveTopic(topic), err)
+		return
+	}
+	r.topics[topic] = true
+	if !r.started {
+		if err := r.broker.Start(); err != nil {
+			log.Printf("Failed to start the DLQ redrive consumer: %v", err)
+			return
+		}
+		r.started = true
+	}
+	log.Printf("Redriving the messages of %s", dlq)
+}
+
+// handleDead redrives a dead-lettered message if the interval of its policy has passed
+// since it was dead-lettered, or else moves it to the redrive topic to wait there.
+// Messages that are not to be redriven (anymore) are left in the DLQ, as are those whose
+// body can't be decompressed and those purged or redriven in the DLQ browser.
+func (r *redriver) handleDead(ctx context.Context, d *mq.Delivery) error {
+	if d.Err != nil {
+		return d.Err
+	}
+	cfg := r.w.Config()
+	topic := strings.TrimPrefix(d.Topic, "DLQ_")
+	wait, ok := r.due(cfg, topic, d)
+	if !ok {
+		return nil
+	}
+	if dropped, err := r.dropped(ctx, d.ID); err != nil || dropped {
+		return err
+	}
+	if wait <= 0 {
+		return r.redrive(ctx, cfg, topic, d.ID, d)
+	}
+
+	props := make(map[string]string, len(d.Properties)+2)
+	for k, v := range d.Properties {
+		props[k] = v
+	}
+	if props[DLQTimeProperty] == "" {
+		props[DLQTimeProperty] = strconv.FormatInt(d.BornTime.UnixMilli(), 10)
+	}
+	props[DLQIDProperty] = d.ID
+	held := RedriveTopic(topic)
+	if err := r.broker.Publish(ctx, held, d.Body, mq.WithProperties(props), mq.Compression(cfg.MQ)); err != nil {
+		fmt.Printf("[Worker] Failed to move message %s to %s: %v\n", d.ID, held, err)
+		return err
+	}
+	fmt.Printf("[Worker] Holding message %s of %s in %s; its redrive is due in %v.\n", d.ID, d.Topic, held, wait)
+	return nil
+}
+
+// handleHeld redrives a message of a redrive topic once it is due, holding it back until
+// then. Messages whose policy no longer redrives them are dropped: they are still in
+// the DLQ. So are those purged or redriven in the DLQ browser meanwhile.
+func (r *redriver) handleHeld(ctx context.Context, d *mq.Delivery) error {
+	if d.Err != nil {
+		return d.Err
+	}
+	cfg := r.w.Config()
+	topic := strings.TrimPrefix(d.Topic, "REDRIVE_")
+	wait, ok := r.due(cfg, topic, d)
+	if !ok {
+		return nil
+	}
+	id := d.Properties[DLQIDProperty]
+	if dropped, err := r.dropped(ctx, id); err != nil || dropped {
+		return err
+	}
+	if wait > 0 {
+		return &mq.RetryError{Err: fmt.Errorf("redrive of message %s is due in %v", d.ID, wait), Delay: wait, Hold: true}
+	}
+	return r.redrive(ctx, cfg, topic, id, d)
+}
+
+// dropped reports whether the DLQ message with the ID was purged or redriven in the DLQ
+// browser.
+func (r *redriver) dropped(ctx context.Context, id string) (bool, error) {
+	if r.tombstones == nil || id == "" {
+		return false, nil
+	}
+	dropped, err := r.tombstones.Marked(ctx, id)
+	if err != nil {
+		fmt.Printf("[Worker] Failed to look up the tombstone of DLQ message %s: %v\n", id, err)
+		return false, err
+	}
+	if dropped {
+		fmt.Printf("[Worker] DLQ message %s was purged or redriven in the DLQ browser. Not redriving it.\n", id)
+	}
+	return dropped, nil
+}
+
+// due reports whether the dead-lettered message d of topic is to be redriven, and in how
+// long.
+func (r *redriver) due(cfg *config.Config, topic string, d *mq.Delivery) (time.Duration, bool) {
+	rc := cfg.Worker.Redrive
+	if rc == nil {
+		return 0, false
+	}
+	p := rc.Policy(topic, cfg.TopicPriority(topic))
+	if p.Disabled || !slices.Contains(p.Reasons, d.Properties[DLQReasonProperty]) {
+		return 0, false
+	}
+	redrives, _ := strconv.Atoi(d.Properties[RedrivesProperty])
+	if redrives >= p.MaxRedrives {
+		fmt.Printf("[Worker] Message %s of %s was redriven %d times. Leaving it in the DLQ.\n", d.ID, d.Topic, redrives)
+		return 0, false
+	}
+
+	// Held back messages are re-published, so the time comes from the property
+	deadAt := d.BornTime
+	if ms, err := strconv.ParseInt(d.Properties[DLQTimeProperty], 10, 64); err == nil {
+		deadAt = time.UnixMilli(ms)
+	}
+	return deadAt.Add(p.Interval.Std()).Sub(r.w.clock.Now()), true
+}
+
+// redrive publishes the dead-lettered message d, whose DLQ message has the ID, back to
+// topic. With the DLQ browser, the message is tombstoned first, so it is redriven once
+// even if an operator redrives it too.
+func (r *redriver) redrive(ctx context.Context, cfg *config.Config, topic, id string, d *mq.Delivery) error {
+	if r.tombstones != nil && id != "" {
+		claimed, err := r.tombstones.Claim(ctx, id)
+		if err != nil {
+			fmt.Printf("[Worker] Failed to tombstone DLQ message %s: %v\n", id, err)
+			return err
+		}
+		if !claimed {
+			fmt.Printf("[Worker] DLQ message %s was purged or redriven in the DLQ browser. Not redriving it.\n", id)
+			return nil
+		}
+	}
+	props := RedriveProperties(d.Properties)
+	if err := r.broker.Publish(ctx, topic, d.Body, mq.WithProperties(props), mq.Compression(cfg.MQ)); err != nil {
+		fmt.Printf("[Worker] Failed to redrive message %s to %s: %v\n", d.ID, topic, err)
+		if r.tombstones != nil && id != "" {
+			if err := r.tombstones.Release(ctx, id); err != nil {
+				fmt.Printf("[Worker] Failed to release the tombstone of DLQ message %s: %v\n", id, err)
+			}
+		}
+		return err
+	}
+	fmt.Printf("[Worker] Redrove message %s from %s to %s (redrive %s).\n", d.ID, d.Topic, topic, props[RedrivesProperty])
+	return nil
+}
+
+// RedriveProperties returns the properties of a dead-lettered message moved back to its
+// topic: those of the message without the ones of the DLQ and the retries, so it starts
+// over with its retries, and with RedrivesProperty counting the redrive.
+func RedriveProperties(props map[string]string) map[string]string {
+	out := make(map[string]string, len(props))
+	for k, v := range props {
+		switch k {
+		case DLQReasonProperty, DLQErrorProperty, DLQTimeProperty, DLQIDProperty, mq.RetryTimesProperty, primitive.PropertyDelayTimeLevel:
+		default:
+			out[k] = v
+		}
+	}
+	redrives, _ := strconv.Atoi(props[RedrivesProperty])
+	out[RedrivesProperty] = strconv.Itoa(redrives + 1)
+	return out
+}
+
+// close stops consuming the DLQs.
+func (r *redriver) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.broker == nil {
+		return
+	}
+	if err := r.broker.Close(); err != nil {
+		log.Printf("Failed to close the DLQ redrive consumer: %v", err)
+	}
+	r.broker = nil
+	if r.tombstones != nil {
+		r.tombstones.Close()
+		r.tombstones = nil
+	}
+}
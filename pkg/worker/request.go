@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/render"
+	"notification-system/pkg/transform"
+)
+
+// Request is a notification request rendered for an event.
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Target is the name of the weighted target the request is sent to, if any.
+	Target string
+	// MirrorURL is the rendered mirror_url, if any.
+	MirrorURL string
+	// Missing lists the placeholders that had no value and no default.
+	Missing []string
+	// TemplateVersion is the version of the body template, when the notification has one.
+	TemplateVersion string
+}
+
+// RenderRequest renders the request a delivery of evt sends for cfg, without sending it:
+// the event data is redacted and transformed as configured, and the body is signed when
+// a signing secret is set. OAuth2 access tokens are not fetched.
+func RenderRequest(cfg *config.NotificationConfig, evt event.Event) (*Request, error) {
+	return renderRequest(cfg, evt, func(data map[string]interface{}) (map[string]interface{}, error) {
+		p, err := transform.Compile(cfg.Transform)
+		if err != nil {
+			return nil, err
+		}
+		return p.Apply(data)
+	})
+}
+
+func (w *Worker) renderRequest(cfg *config.NotificationConfig, evt event.Event) (*Request, error) {
+	return renderRequest(cfg, evt, func(data map[string]interface{}) (map[string]interface{}, error) {
+		return w.transformData(cfg, data)
+	})
+}
+
+func renderRequest(cfg *config.NotificationConfig, evt event.Event, transformData func(map[string]interface{}) (map[string]interface{}, error)) (*Request, error) {
+	cfg, version := templateFor(cfg, evt.ID)
+	if r := redactorFor(cfg); r != nil && cfg.Redact.Payload {
+		evt.Data = r.Data(evt.Data)
+	}
+
+	// Transform the event data, then render Request Body, URL and Headers using the templates from config
+	var err error
+	if len(cfg.Transform) > 0 {
+		if evt.Data, err = transformData(evt.Data); err != nil {
+			return nil, fmt.Errorf("failed to transform event data: %w", err)
+		}
+	}
+	body, contentType, err := encodeBody(cfg, evt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render body: %w", err)
+	}
+
+	urlTemplate, target := targetFor(cfg, evt.ID)
+	req := &Request{Method: cfg.Method, Header: make(http.Header), Body: body, Target: target, TemplateVersion: version}
+	if req.URL, err = render.String(urlTemplate, evt); err != nil {
+		return nil, fmt.Errorf("failed to render URL: %w", err)
+	}
+	if cfg.MirrorURL != "" {
+		if req.MirrorURL, err = render.String(cfg.MirrorURL, evt); err != nil {
+			return nil, fmt.Errorf("failed to render mirror URL: %w", err)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(IdempotencyHeader, DeliveryID(cfg, evt))
+	for k, v := range cfg.Headers {
+		value, err := render.String(v, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render header %s: %w", k, err)
+		}
+		req.Header.Set(k, value)
+	}
+	if cfg.SigningSecret != "" {
+		req.Header.Set(SignatureHeader, sign(cfg.SigningSecret, body))
+	}
+
+	req.Missing = render.Missing(urlTemplate, evt)
+	req.Missing = append(req.Missing, render.Missing(cfg.MirrorURL, evt)...)
+	for _, v := range cfg.Headers {
+		req.Missing = append(req.Missing, render.Missing(v, evt)...)
+	}
+	if cfg.BodyFormat == config.BodyFormatText {
+		req.Missing = append(req.Missing, render.Missing(cfg.BodyText, evt)...)
+	} else if tmpl, err := cfg.BodyTemplate(); err == nil {
+		req.Missing = append(req.Missing, tmpl.Missing(evt)...)
+	}
+	return req, nil
+}
+
+// Send performs a single attempt of a rendered request with the notification's client
+// settings and authentication, and applies its success criteria. The response status and
+// (bounded) body are returned even when the response is not a success.
+func Send(ctx context.Context, cfg *config.NotificationConfig, r *Request) (int, []byte, error) {
+	client, err := buildClient(cfg, http.DefaultTransport.(*http.Transport))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to configure HTTP client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = r.Header.Clone()
+	if cfg.Auth != nil {
+		authorization, err := newTokenCache().authorization(ctx, client, cfg.Auth)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to obtain access token: %w", err)
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("request network error: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+
+	if !isSuccessStatus(cfg.Success, resp.StatusCode) {
+		return resp.StatusCode, body, newDeliveryError("request failed", resp.StatusCode, body)
+	}
+	if err := checkResponseBody(cfg.Success, body); err != nil {
+		return resp.StatusCode, body, newDeliveryError(err.Error(), resp.StatusCode, body)
+	}
+	return resp.StatusCode, body, nil
+}
@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/plugin"
+	"notification-system/pkg/ratelimit"
+)
+
+const (
+	// maxResponseBody bounds how much of a response is read for success checks.
+	maxResponseBody = 1 << 20
+	// maxCapturedBody bounds how much of a failed response is kept in errors and delivery records.
+	maxCapturedBody = 4 << 10
+)
+
+// DeliveryError is returned when the target answered but the response was not a success.
+// It carries the (truncated) response body for debugging.
+type DeliveryError struct {
+	StatusCode int
+	Body       string
+	Reason     string
+	// RetryAfter is the delay the target asked for with a Retry-After header (429 and 503 only).
+	RetryAfter time.Duration
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("%s (status %d): %s", e.Reason, e.StatusCode, e.Body)
+}
+
+func newDeliveryError(reason string, statusCode int, body []byte) *DeliveryError {
+	return &DeliveryError{StatusCode: statusCode, Body: truncate(string(body), maxCapturedBody), Reason: reason}
+}
+
+// retryAfterOf returns the Retry-After delay carried by a delivery error, the wait of a
+// reached rate limit or the delay a channel plugin asked for, or 0.
+func retryAfterOf(err error) time.Duration {
+	var dErr *DeliveryError
+	if errors.As(err, &dErr) {
+		return dErr.RetryAfter
+	}
+	var qErr *ratelimit.ExceededError
+	if errors.As(err, &qErr) {
+		return qErr.RetryAfter
+	}
+	var pErr *plugin.Error
+	if errors.As(err, &pErr) {
+		return pErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
+
+// isSuccessStatus reports whether status is accepted by the success criteria.
+func isSuccessStatus(sc *config.SuccessConfig, status int) bool {
+	if sc == nil || len(sc.StatusCodes) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range sc.StatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResponseBody applies the body-based success criteria.
+func checkResponseBody(sc *config.SuccessConfig, body []byte) error {
+	if sc == nil {
+		return nil
+	}
+
+	if sc.BodyRegex != "" {
+		re, err := regexp.Compile(sc.BodyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid body_regex: %w", err)
+		}
+		if !re.Match(body) {
+			return fmt.Errorf("response body does not match %q", sc.BodyRegex)
+		}
+	}
+
+	if sc.JSONField != "" {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return fmt.Errorf("response body is not JSON: %w", err)
+		}
+		actual, ok := lookupPath(doc, sc.JSONField)
+		if !ok {
+			return fmt.Errorf("response field %q is missing", sc.JSONField)
+		}
+		if !jsonEqual(actual, sc.JSONValue) {
+			return fmt.Errorf("response field %q is %v, expected %v", sc.JSONField, actual, sc.JSONValue)
+		}
+	}
+	return nil
+}
+
+// lookupPath resolves a dot-separated path in a decoded JSON document.
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	current := doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// jsonEqual compares two decoded JSON values. Both sides come from encoding/json,
+// so numbers are float64 on both sides.
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"hash/fnv"
+
+	"notification-system/pkg/config"
+)
+
+// targetFor returns the URL template evt is sent to and the name of its target, which is
+// empty without targets. Each event ID falls at a fixed point of the total weight and the
+// targets take consecutive ranges of it in the order they are listed, so retries and
+// redeliveries of an event go to the same target while the weights are unchanged.
+func targetFor(cfg *config.NotificationConfig, eventID string) (string, string) {
+	total := 0
+	for _, t := range cfg.Targets {
+		total += t.Weight
+	}
+	if total == 0 {
+		return cfg.URL, ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte("target:" + eventID))
+	point := uint64(h.Sum32()) * uint64(total) >> 32
+
+	end := uint64(0)
+	for _, t := range cfg.Targets {
+		end += uint64(t.Weight)
+		if point < end {
+			return t.URL, t.Name
+		}
+	}
+	return cfg.URL, ""
+}
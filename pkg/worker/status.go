@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"notification-system/pkg/mq"
+)
+
+// statusBuffer bounds the delivery records waiting to be published to mq.status_topic;
+// records beyond it are dropped rather than slowing down deliveries.
+const statusBuffer = 1000
+
+// forwardStatus publishes every recorded delivery to mq.status_topic until ctx ends.
+// Records are dropped while no topic is configured.
+func (w *Worker) forwardStatus(ctx context.Context) {
+	sub := w.Feed.Subscribe(statusBuffer)
+	defer sub.Close()
+
+	var failed int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rec := <-sub.C:
+			cfg := w.Config()
+			if cfg.MQ.StatusTopic == "" {
+				continue
+			}
+			body, err := json.Marshal(rec)
+			if err != nil {
+				continue
+			}
+			if err := w.publish(ctx, cfg.MQ.StatusTopic, body); err != nil {
+				// Logged once per failure streak, a broker outage would flood the log otherwise
+				if failed == 0 {
+					log.Printf("Failed to publish delivery status to %s: %v", cfg.MQ.StatusTopic, err)
+				}
+				failed++
+				continue
+			}
+			if failed > 0 {
+				log.Printf("Publishing delivery status to %s recovered after %d failures", cfg.MQ.StatusTopic, failed)
+				failed = 0
+			}
+		}
+	}
+}
+
+// publish sends a message with the broker, or the producer of RocketMQ consumers.
+func (w *Worker) publish(ctx context.Context, topic string, body []byte, opts ...mq.SendOption) error {
+	if w.Broker != nil {
+		return w.Broker.Publish(ctx, topic, body, opts...)
+	}
+	return mq.SendMessage(ctx, w.DLQProducer, topic, body, opts...)
+}
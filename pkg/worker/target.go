@@ -0,0 +1,285 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"notification-system/pkg/config"
+)
+
+const (
+	// windowBuckets is the number of buckets a rolling failure window is split into.
+	windowBuckets = 6
+	// maxBackoffStretch bounds how much a degraded target stretches the local backoff.
+	maxBackoffStretch = 10
+	// targetIdleWindows is how many windows a target stays tracked without requests.
+	targetIdleWindows = 10
+)
+
+// TargetStatus reports the adaptive backoff state of one target.
+type TargetStatus struct {
+	Requests    int     `json:"requests"`
+	Failures    int     `json:"failures"`
+	Retries     int     `json:"retries"`
+	FailureRate float64 `json:"failure_rate"`
+	Degraded    bool    `json:"degraded"`
+	Limit       int     `json:"concurrency_limit"`
+	Active      int     `json:"active"`
+}
+
+type targetBucket struct {
+	epoch                       int64 // index of the bucket interval since the Unix epoch
+	requests, failures, retries int
+}
+
+// target tracks the recent outcomes of requests to one host and limits their concurrency
+// with additive increase / multiplicative decrease.
+type target struct {
+	mu       sync.Mutex
+	buckets  [windowBuckets]targetBucket
+	limit    float64
+	active   int
+	lastUsed time.Time
+	// lastDecrease rate-limits decreases to one per bucket interval, so one burst of
+	// failures doesn't collapse the limit at once.
+	lastDecrease time.Time
+	// wake is closed and replaced whenever a slot is released.
+	wake chan struct{}
+}
+
+// targets holds the state of every target seen within the last targetIdleWindows windows.
+type targets struct {
+	mu        sync.Mutex
+	byHost    map[string]*target
+	lastPrune time.Time
+}
+
+func newTargets() *targets {
+	return &targets{byHost: make(map[string]*target)}
+}
+
+// targetKey identifies the target of a rendered URL by scheme and host.
+func targetKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (ts *targets) get(key string, a *config.AdaptiveConfig) *target {
+	now := time.Now()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if idle := targetIdleWindows * a.Window.Std(); now.Sub(ts.lastPrune) > idle {
+		for k, t := range ts.byHost {
+			t.mu.Lock()
+			if t.active == 0 && now.Sub(t.lastUsed) > idle {
+				delete(ts.byHost, k)
+			}
+			t.mu.Unlock()
+		}
+		ts.lastPrune = now
+	}
+
+	t, ok := ts.byHost[key]
+	if !ok {
+		t = &target{limit: float64(a.MaxConcurrency), wake: make(chan struct{})}
+		ts.byHost[key] = t
+	}
+	return t
+}
+
+// status returns the state of every tracked target.
+func (ts *targets) status(a *config.AdaptiveConfig) map[string]TargetStatus {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	out := make(map[string]TargetStatus, len(ts.byHost))
+	for k, t := range ts.byHost {
+		out[k] = t.status(a, time.Now())
+	}
+	return out
+}
+
+// acquire takes a concurrency slot, waiting at most maxWait for one to free up.
+func (t *target) acquire(a *config.AdaptiveConfig, maxWait time.Duration) bool {
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+	for {
+		t.mu.Lock()
+		t.lastUsed = time.Now()
+		if limit := min(int(t.limit), a.MaxConcurrency); t.active < max(limit, a.MinConcurrency) {
+			t.active++
+			t.mu.Unlock()
+			return true
+		}
+		wake := t.wake
+		t.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+func (t *target) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	close(t.wake)
+	t.wake = make(chan struct{})
+}
+
+// record adds the outcome of one request. Failures of a degraded target halve its
+// concurrency limit; successes raise it by about one per limit's worth of requests.
+func (t *target) record(a *config.AdaptiveConfig, failed, retry bool) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.bucket(a, now)
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if retry {
+		b.retries++
+	}
+
+	if !failed {
+		t.limit = min(t.limit+1/t.limit, float64(a.MaxConcurrency))
+		return
+	}
+	if requests, failures, _ := t.totals(a, now); degraded(a, requests, failures) && now.Sub(t.lastDecrease) >= bucketSize(a) {
+		t.limit = max(t.limit/2, float64(a.MinConcurrency))
+		t.lastDecrease = now
+	}
+}
+
+// allowRetry reports whether the retry budget of the window has room for another local retry.
+func (t *target) allowRetry(a *config.AdaptiveConfig) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests, _, retries := t.totals(a, time.Now())
+	if requests < a.MinRequests {
+		return true
+	}
+	return float64(retries+1) <= a.RetryBudget*float64(requests)
+}
+
+// backoffStretch returns the factor by which the local retry backoff is stretched:
+// 1 for a healthy target, 1/(1-failure rate) for a degraded one.
+func (t *target) backoffStretch(a *config.AdaptiveConfig) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests, failures, _ := t.totals(a, time.Now())
+	if !degraded(a, requests, failures) {
+		return 1
+	}
+	rate := float64(failures) / float64(requests)
+	if rate >= 1-1.0/maxBackoffStretch {
+		return maxBackoffStretch
+	}
+	return 1 / (1 - rate)
+}
+
+func (t *target) status(a *config.AdaptiveConfig, now time.Time) TargetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests, failures, retries := t.totals(a, now)
+	s := TargetStatus{
+		Requests: requests,
+		Failures: failures,
+		Retries:  retries,
+		Degraded: degraded(a, requests, failures),
+		Limit:    max(int(t.limit), a.MinConcurrency),
+		Active:   t.active,
+	}
+	if requests > 0 {
+		s.FailureRate = float64(failures) / float64(requests)
+	}
+	return s
+}
+
+// bucket returns the bucket of now, resetting it when it last held an older interval.
+func (t *target) bucket(a *config.AdaptiveConfig, now time.Time) *targetBucket {
+	epoch := now.UnixNano() / int64(bucketSize(a))
+	b := &t.buckets[epoch%windowBuckets]
+	if b.epoch != epoch {
+		*b = targetBucket{epoch: epoch}
+	}
+	return b
+}
+
+// totals sums the buckets within the window ending at now.
+func (t *target) totals(a *config.AdaptiveConfig, now time.Time) (requests, failures, retries int) {
+	epoch := now.UnixNano() / int64(bucketSize(a))
+	for _, b := range t.buckets {
+		if epoch-b.epoch < windowBuckets {
+			requests += b.requests
+			failures += b.failures
+			retries += b.retries
+		}
+	}
+	return requests, failures, retries
+}
+
+func bucketSize(a *config.AdaptiveConfig) time.Duration {
+	return max(a.Window.Std()/windowBuckets, time.Millisecond)
+}
+
+func degraded(a *config.AdaptiveConfig, requests, failures int) bool {
+	return requests >= a.MinRequests && float64(failures) > a.FailureThreshold*float64(requests)
+}
+
+// targetFailed reports whether a response counts against the target's health: the
+// target failed (5xx) or throttled us (429). Network errors always count.
+func targetFailed(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// throttledError is returned without sending a request when a degraded target has no
+// free concurrency slot; the message is left to the MQ redelivery.
+type throttledError struct {
+	Target string
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("target %s is at its concurrency limit", e.Target)
+}
+
+// do executes req and reads the response body. With adaptive backoff it holds one of the
+// target's concurrency slots, waiting at most maxLocalRetryAfter for one, and records the outcome.
+func do(t *target, a *config.AdaptiveConfig, client HTTPDoer, req *http.Request, retry bool) (*http.Response, []byte, error) {
+	if t != nil {
+		if !t.acquire(a, maxLocalRetryAfter) {
+			return nil, nil, &throttledError{Target: targetKey(req.URL.String())}
+		}
+		defer t.release()
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if t != nil {
+			t.record(a, true, retry)
+		}
+		return nil, nil, err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	resp.Body.Close()
+	if t != nil {
+		t.record(a, targetFailed(resp.StatusCode), retry)
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"hash/fnv"
+
+	"notification-system/pkg/config"
+)
+
+// templateFor returns cfg with the body of the template version evt is rolled out to,
+// and that version. Each event ID falls into one of 100 buckets; the versions take
+// consecutive ranges of buckets in the order they are listed, and the remaining
+// buckets get the notification's own body.
+func templateFor(cfg *config.NotificationConfig, eventID string) (*config.NotificationConfig, string) {
+	if len(cfg.Templates) == 0 {
+		return cfg, cfg.Version
+	}
+	h := fnv.New32a()
+	h.Write([]byte("template:" + eventID))
+	bucket := int(h.Sum32() % 100)
+
+	end := 0
+	for _, t := range cfg.Templates {
+		end += t.Percent
+		if bucket < end {
+			return cfg.WithTemplate(t), t.Version
+		}
+	}
+	return cfg, cfg.Version
+}
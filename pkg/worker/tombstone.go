@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"notification-system/pkg/config"
+)
+
+const (
+	// DLQKeyPrefix prefixes the Redis keys of the DLQ browser unless its redis sets a
+	// key_prefix.
+	DLQKeyPrefix = "notify:dlq:"
+	// dlqRedisTimeout bounds one Redis request on the DLQ browser's Redis.
+	dlqRedisTimeout = 2 * time.Second
+	// dlqTombstoneTTL is how long a purged or redriven message is remembered, longer than
+	// a message waits for its redrive.
+	dlqTombstoneTTL = 7 * 24 * time.Hour
+)
+
+// DLQTombstones records the dead-lettered messages that were purged or redriven, by the
+// ID of their DLQ message, in the Redis of api.dlq_browser. The DLQ browser and the
+// redriver of worker.redrive consume the DLQ topics in groups of their own; the
+// tombstones keep either from redriving a message the other dropped or redrove.
+type DLQTombstones struct {
+	client *redis.Client
+	prefix string
+}
+
+// OpenDLQTombstones connects to the Redis of cfg.API.DLQBrowser, or returns nil without
+// it. It connects lazily.
+func OpenDLQTombstones(cfg *config.Config) *DLQTombstones {
+	b := cfg.API.DLQBrowser
+	if b == nil || b.Redis == nil {
+		return nil
+	}
+	return NewDLQTombstones(b.Redis.Client(dlqRedisTimeout, DLQKeyPrefix))
+}
+
+// NewDLQTombstones records the tombstones with client, under the keys of the DLQ browser
+// with prefix.
+func NewDLQTombstones(client *redis.Client, prefix string) *DLQTombstones {
+	return &DLQTombstones{client: client, prefix: prefix}
+}
+
+func (t *DLQTombstones) key(id string) string { return t.prefix + "tombstone:" + id }
+
+// Claim tombstones the message with the ID before it is redriven. It returns false if the
+// message was purged or redriven before, and must not be redriven.
+func (t *DLQTombstones) Claim(ctx context.Context, id string) (bool, error) {
+	return t.client.SetNX(ctx, t.key(id), 1, dlqTombstoneTTL).Result()
+}
+
+// Release removes the tombstone of a claimed message that could not be redriven.
+func (t *DLQTombstones) Release(ctx context.Context, id string) error {
+	return t.client.Del(ctx, t.key(id)).Err()
+}
+
+// Mark tombstones the messages with the IDs, which were purged.
+func (t *DLQTombstones) Mark(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	pipe := t.client.Pipeline()
+	for _, id := range ids {
+		pipe.Set(ctx, t.key(id), 1, dlqTombstoneTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Marked reports whether the message with the ID was purged or redriven.
+func (t *DLQTombstones) Marked(ctx context.Context, id string) (bool, error) {
+	n, err := t.client.Exists(ctx, t.key(id)).Result()
+	return n > 0, err
+}
+
+// Close closes the connections to Redis.
+func (t *DLQTombstones) Close() error {
+	return t.client.Close()
+}
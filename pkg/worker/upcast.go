@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"fmt"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/transform"
+)
+
+// Upcast upgrades evt to the latest version through the configured upcasters, as the
+// worker does before looking up its notification.
+func Upcast(cfg *config.Config, evt event.Event) (event.Event, error) {
+	return upcast(cfg, evt, transform.Compile)
+}
+
+func (w *Worker) upcast(cfg *config.Config, evt event.Event) (event.Event, error) {
+	return upcast(cfg, evt, w.pipeline)
+}
+
+func upcast(cfg *config.Config, evt event.Event, compile func([]transform.Spec) (transform.Pipeline, error)) (event.Event, error) {
+	chain := cfg.UpcastChain(evt.TenantID, evt.Type, evt.Version)
+	if len(chain) > 0 {
+		// Upcasters transform the decoded data
+		if err := evt.DecodeData(); err != nil {
+			return evt, fmt.Errorf("failed to upcast event: %w", err)
+		}
+	}
+	for _, u := range chain {
+		if len(u.Transform) > 0 {
+			p, err := compile(u.Transform)
+			if err != nil {
+				return evt, fmt.Errorf("failed to upcast event from version %d to %d: %w", u.From, u.To, err)
+			}
+			if evt.Data, err = p.Apply(evt.Data); err != nil {
+				return evt, fmt.Errorf("failed to upcast event from version %d to %d: %w", u.From, u.To, err)
+			}
+		}
+		evt.Version = u.To
+	}
+	return evt, nil
+}
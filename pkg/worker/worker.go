@@ -3,247 +3,1131 @@ package worker
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/rocketmq-client-go/v2"
 	"github.com/apache/rocketmq-client-go/v2/consumer"
 	"github.com/apache/rocketmq-client-go/v2/primitive"
 
+	"notification-system/pkg/audit"
 	"notification-system/pkg/config"
+	"notification-system/pkg/delivery"
+	"notification-system/pkg/enrich"
 	"notification-system/pkg/event"
 	"notification-system/pkg/mq"
+	"notification-system/pkg/plugin"
+	"notification-system/pkg/ratelimit"
+	"notification-system/pkg/schema"
+	"notification-system/pkg/secrets"
+	"notification-system/pkg/transform"
 )
 
-// Worker handles the processing of events received from RocketMQ.
+// Worker handles the processing of events received from the message queue.
 type Worker struct {
-	Config      *config.Config
-	Client      *http.Client
-	Consumer    rocketmq.PushConsumer
-	DLQProducer rocketmq.Producer
+	Client HTTPDoer
+	// Consumer is the push consumer for normal priority topics; it is nil in pull mode.
+	Consumer    Consumer
+	DLQProducer mq.Producer
+	// Broker consumes topics when mq.broker is not RocketMQ; Consumer and DLQProducer are nil then.
+	Broker mq.Broker
+	// Deliveries records the outcome of every delivery, including failed response bodies.
+	Deliveries delivery.Store
+	// Feed broadcasts the outcome of every delivery as it is recorded.
+	Feed *delivery.Feed
+
+	cfg   atomic.Pointer[config.Config]
+	clock Clock
+	// newConsumer creates the push consumers of the priorities and pinned topics
+	newConsumer func(cfg config.MQConfig) (Consumer, error)
+
+	mu      sync.Mutex
+	topics  map[string]bool
+	started atomic.Bool
+
+	// Push mode: high and low priority topics are consumed by their own consumers, so
+	// each priority has its own share of consume goroutines, as are the topics pinned by
+	// worker.assignment. Keyed by consumer group.
+	groupConsumers map[string]Consumer
+	consuming      bool
+	// Consumer is started once it has a topic: a member of its group without the group's
+	// topics would be given queues it doesn't consume
+	consumerTopics  bool
+	consumerStarted bool
+
+	// assignment decides which topics this replica consumes, see worker.assignment
+	assignment *assignment
+	// redrive moves the messages of the DLQs back to their topics when worker.redrive
+	// is set at start
+	redrive *redriver
+
+	clientsMu sync.Mutex
+	clients   map[string]*http.Client
+	// conns counts the connection reuse of the clients the worker built
+	conns *connStats
+
+	pipelinesMu sync.Mutex
+	pipelines   map[string]transform.Pipeline
+
+	// registry decodes events encoded with schemas of the schema registry
+	registry *schema.Registry
+	// keyring supplies the keys of encrypted messages
+	keyring *secrets.Keyring
+
+	tokens *tokenCache
+
+	// Adaptive backoff state per target host
+	targets *targets
+	// Deliveries in progress per event type and target, see worker.bulkhead
+	bulkheads *bulkheads
+	// limiter enforces the rate limits of notifications
+	limiter ratelimit.Limiter
+	// plugins deliver the notifications of channels
+	plugins *plugin.Registry
+	// enricher runs the lookups of notifications
+	enricher *enrich.Enricher
+	// deliveryLog writes the detailed delivery log when worker.delivery_log is set
+	deliveryLog deliveryLog
+	// digests buffers the events of digest notifications
+	digests digests
+	// delivered holds the IDs of recent successful deliveries, see DeliveryID
+	delivered *deliveredSet
+
+	// handler delivers decoded events through the middleware registered with Use
+	handler    Handler
+	middleware []Middleware
+
+	// Receipts being posted in the background
+	receipts sync.WaitGroup
+	// Copies of deliveries being sent to mirror URLs
+	mirrors sync.WaitGroup
+
+	// audit exports delivery records when audit.url is set
+	audit *audit.Exporter
+
+	// Pull mode: one pull consumer per topic, polled while a delivery slot is free
+	pullers     map[string]rocketmq.PullConsumer
+	slots       map[string]chan struct{} // per priority
+	pollCtx     context.Context
+	stopPolling context.CancelFunc
+	polling     sync.WaitGroup
+
+	// Delivery outcomes per tenant
+	statsMu sync.Mutex
+	stats   map[string]*TenantStats
+
+	// Consumer lag estimate per topic: time between message production and consumption
+	lagMu sync.Mutex
+	lag   map[string]time.Duration
+
+	// Messages not consumed yet per topic, see worker.lag_monitor
+	groupLagMu sync.Mutex
+	groupLag   map[string]*GroupLag
+
+	// Messages moved to the quarantine topics, see worker.quarantine
+	quarantineMu sync.Mutex
+	quarantine   map[string]*QuarantineStatus
+
+	// In-flight tracking for graceful shutdown
+	inflightMu sync.Mutex
+	inflight   sync.WaitGroup
+	inflightN  int64
+	draining   bool
 }
 
-// NewWorker creates a new Worker instance and initializes the RocketMQ consumer.
+// NewWorker creates a new Worker instance and initializes the message queue consumer.
 func NewWorker(cfg *config.Config) (*Worker, error) {
-	c, err := mq.NewPushConsumer(cfg.MQ.NameServer, cfg.MQ.AccessKey, cfg.MQ.SecretKey, cfg.MQ.GroupName)
+	return NewWorkerWith(cfg, Dependencies{})
+}
+
+// NewWorkerWith creates a Worker like NewWorker, using the non-nil deps instead of
+// connecting to the message queue or the system clock.
+func NewWorkerWith(cfg *config.Config, deps Dependencies) (*Worker, error) {
+	w := &Worker{
+		Client:     deps.Client,
+		clock:      deps.Clock,
+		Deliveries: delivery.NewMemoryStore(1000),
+		Feed:       delivery.NewFeed(),
+		topics:     make(map[string]bool),
+		lag:        make(map[string]time.Duration),
+		groupLag:   make(map[string]*GroupLag),
+		quarantine: make(map[string]*QuarantineStatus),
+		stats:      make(map[string]*TenantStats),
+		clients:    make(map[string]*http.Client),
+		pipelines:  make(map[string]transform.Pipeline),
+		registry:   schema.NewRegistry(),
+		keyring:    secrets.NewKeyring(secrets.NewDefaultResolver()),
+		tokens:     newTokenCache(),
+		targets:    newTargets(),
+		bulkheads:  newBulkheads(),
+		plugins:    plugin.NewRegistry(),
+		enricher:   enrich.New(),
+		pullers:    make(map[string]rocketmq.PullConsumer),
+		slots:      make(map[string]chan struct{}),
+		digests:    digests{pending: make(map[string]*digest), published: make(map[string]time.Time)},
+		delivered:  newDeliveredSet(deliveredCapacity, cfg.RateLimit.Redis),
+		conns:      newConnStats(),
+
+		groupConsumers: make(map[string]Consumer),
+		newConsumer:    deps.NewConsumer,
+	}
+	if w.Client == nil {
+		w.Client = &http.Client{
+			Timeout:   DefaultHTTPTimeout,
+			Transport: &countingTransport{base: newTransport(cfg.Worker.Transport), stats: w.conns},
+		}
+	}
+	if w.clock == nil {
+		w.clock = systemClock{}
+	}
+	if w.newConsumer == nil {
+		w.newConsumer = newPushConsumer
+	}
+	w.cfg.Store(cfg)
+	w.handler = w.deliver
+	w.plugins.Update(cfg.Channels)
+
+	assignment, err := newAssignment(cfg.Worker.Assignment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer: %w", err)
+		return nil, fmt.Errorf("failed to set up worker.assignment: %w", err)
 	}
+	w.assignment = assignment
 
-	// Initialize Producer for DLQ
-	p, err := mq.NewProducer(cfg.MQ.NameServer, cfg.MQ.AccessKey, cfg.MQ.SecretKey)
+	limiter, err := ratelimit.Open(cfg.RateLimit.Redis)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create DLQ producer: %w", err)
+		return nil, fmt.Errorf("failed to create rate limiter: %w", err)
+	}
+	w.limiter = limiter
+
+	if cfg.MQ.Broker != config.BrokerRocketMQ {
+		w.Broker = deps.Broker
+		if w.Broker == nil {
+			b, err := mq.NewBroker(cfg.MQ)
+			if err != nil {
+				return nil, fmt.Errorf("failed to connect to %s: %w", cfg.MQ.Broker, err)
+			}
+			w.Broker = b
+		}
+		return w, nil
 	}
 
-	return &Worker{
-		Config:      cfg,
-		Client:      &http.Client{Timeout: 10 * time.Second},
-		Consumer:    c,
-		DLQProducer: p,
-	}, nil
+	if cfg.Worker.Mode != config.WorkerModePull {
+		c, err := w.newConsumer(pushConsumerConfig(cfg, cfg.MQ.GroupName, config.PriorityNormal))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consumer: %w", err)
+		}
+		w.Consumer = c
+	}
+
+	// Initialize Producer for DLQ
+	w.DLQProducer = deps.Producer
+	if w.DLQProducer == nil {
+		p, err := mq.NewProducer(cfg.MQ)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create DLQ producer: %w", err)
+		}
+		w.DLQProducer = p
+	}
+	return w, nil
+}
+
+// Config returns the configuration currently used by the worker.
+func (w *Worker) Config() *config.Config {
+	return w.cfg.Load()
 }
 
 // Start subscribes to topics and starts the consumer.
 func (w *Worker) Start(ctx context.Context) error {
-	topics := make(map[string]bool)
-	for _, n := range w.Config.Notifications {
+	if cfg := w.Config(); cfg.Worker.Faults != nil || cfg.MQ.Faults != nil {
+		log.Printf("WARNING: fault injection is enabled (worker.faults / mq.faults); don't run this configuration in production")
+	}
+	if cfg := w.Config(); cfg.Audit.URL != "" {
+		e, err := audit.New(ctx, cfg.Audit)
+		if err != nil {
+			return fmt.Errorf("failed to open audit export: %w", err)
+		}
+		w.audit = e
+		go e.Run()
+	}
+	if cfg := w.Config(); cfg.Worker.Redrive != nil {
+		w.redrive = newRedriver(w)
+	}
+	go w.forwardStatus(ctx)
+	go w.monitorLag(ctx)
+	// Take the leases of pinned topics before subscribing, so dedicated replicas don't
+	// subscribe to other topics first
+	w.assignment.coordinate(ctx, w)
+
+	if w.Broker != nil {
+		return w.startBroker()
+	}
+	if w.Consumer == nil {
+		return w.startPulling()
+	}
+
+	if err := w.subscribe(w.Config()); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.consumerTopics {
+		if err := w.Consumer.Start(); err != nil {
+			return fmt.Errorf("failed to start consumer: %w", err)
+		}
+		w.consumerStarted = true
+	}
+	for group, c := range w.groupConsumers {
+		if err := c.Start(); err != nil {
+			return fmt.Errorf("failed to start consumer of group %s: %w", group, err)
+		}
+	}
+	w.consuming = true
+	w.started.Store(true)
+
+	return nil
+}
+
+// Ready reports whether the consumer is started and subscribed to at least one topic.
+// Replicas of a worker.assignment may have no topic assigned and are ready once started.
+func (w *Worker) Ready() error {
+	if !w.started.Load() {
+		return fmt.Errorf("consumer not started")
+	}
+	if len(w.Subscriptions()) == 0 && w.Config().Worker.Assignment == nil {
+		return fmt.Errorf("no topics subscribed")
+	}
+	return nil
+}
+
+// Subscriptions returns the topics the worker is subscribed to.
+func (w *Worker) Subscriptions() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	topics := make([]string, 0, len(w.topics))
+	for t := range w.topics {
+		topics = append(topics, t)
+	}
+	return topics
+}
+
+// UpdateConfig swaps in a new configuration at runtime and subscribes to any topics it introduces.
+// Topics that are no longer referenced stay subscribed; their messages are skipped as unconfigured.
+func (w *Worker) UpdateConfig(cfg *config.Config) error {
+	w.cfg.Store(cfg)
+	w.plugins.Update(cfg.Channels)
+	return w.subscribe(cfg)
+}
+
+// subscribe subscribes to every topic referenced by cfg and assigned to this replica that
+// is not subscribed yet.
+func (w *Worker) subscribe(cfg *config.Config) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, n := range cfg.Notifications {
 		// Avoid duplicate subscriptions
-		if _, exists := topics[n.QueueName]; exists {
+		if w.topics[n.QueueName] || !w.assignment.assigned(cfg.Worker.Assignment, n.QueueName) {
 			continue
 		}
 
 		// Subscribe to topic
-		if err := w.Consumer.Subscribe(n.QueueName, consumer.MessageSelector{}, w.HandleMessage); err != nil {
+		if w.Broker != nil {
+			if err := w.Broker.Subscribe(n.QueueName, w.handleDelivery); err != nil {
+				return fmt.Errorf("failed to subscribe to topic %s: %w", n.QueueName, err)
+			}
+		} else if w.Consumer == nil {
+			if err := w.addPuller(cfg, n.QueueName); err != nil {
+				return fmt.Errorf("failed to subscribe to topic %s: %w", n.QueueName, err)
+			}
+		} else if err := w.subscribePush(cfg, n.QueueName); err != nil {
 			return fmt.Errorf("failed to subscribe to topic %s: %w", n.QueueName, err)
 		}
-		topics[n.QueueName] = true
+		w.topics[n.QueueName] = true
+		if w.redrive != nil {
+			w.redrive.subscribe(cfg, n.QueueName)
+		}
 		log.Printf("Subscribed to topic: %s for event type: %s", n.QueueName, n.EventType)
 	}
+	return nil
+}
 
-	if err := w.Consumer.Start(); err != nil {
-		return fmt.Errorf("failed to start consumer: %w", err)
+// subscribePush subscribes topic on the push consumer of its group, creating that
+// consumer on first use. Callers hold w.mu.
+func (w *Worker) subscribePush(cfg *config.Config, topic string) error {
+	group := cfg.ConsumerGroup(topic)
+	if group == cfg.MQ.GroupName {
+		if err := w.Consumer.Subscribe(topic, consumer.MessageSelector{}, w.HandleMessage); err != nil {
+			return err
+		}
+		w.consumerTopics = true
+		if w.consuming && !w.consumerStarted {
+			if err := w.Consumer.Start(); err != nil {
+				return err
+			}
+			w.consumerStarted = true
+		}
+		return nil
+	}
+	if c, ok := w.groupConsumers[group]; ok {
+		return c.Subscribe(topic, consumer.MessageSelector{}, w.HandleMessage)
 	}
 
+	c, err := w.newConsumer(pushConsumerConfig(cfg, group, cfg.TopicPriority(topic)))
+	if err != nil {
+		return err
+	}
+	if err := c.Subscribe(topic, consumer.MessageSelector{}, w.HandleMessage); err != nil {
+		return err
+	}
+	if w.consuming {
+		if err := c.Start(); err != nil {
+			return err
+		}
+	}
+	w.groupConsumers[group] = c
+	log.Printf("Created consumer of group %s", group)
 	return nil
 }
 
-// Shutdown stops the consumer.
+// pushConsumerConfig returns the settings of the consumer of group, consuming topics of
+// priority: its weighted share of mq.consume_goroutines (client default 20).
+func pushConsumerConfig(cfg *config.Config, group, priority string) config.MQConfig {
+	mqCfg := cfg.MQ
+	total := mqCfg.ConsumeGoroutines
+	if total == 0 {
+		total = 20
+	}
+	mqCfg.ConsumeGoroutines = cfg.PriorityShare(total, priority)
+	mqCfg.GroupName = group
+	return mqCfg
+}
+
+// Shutdown stops pulling new messages, waits up to worker.shutdown_timeout for in-flight
+// deliveries to finish, and then stops the consumer and the DLQ producer.
 func (w *Worker) Shutdown() error {
-	if err := w.DLQProducer.Shutdown(); err != nil {
-		log.Printf("Failed to shutdown DLQ producer: %v", err)
+	w.started.Store(false)
+	// Hand the leases of pinned topics to other replicas once the consumers have stopped
+	defer w.assignment.release()
+
+	w.inflightMu.Lock()
+	w.draining = true
+	w.inflightMu.Unlock()
+
+	// Stop fetching new messages while in-flight ones complete. Other brokers keep
+	// fetching, but their handlers hand messages back while draining.
+	if w.Consumer != nil {
+		w.mu.Lock()
+		if w.consumerStarted {
+			w.Consumer.Suspend()
+		}
+		for _, c := range w.groupConsumers {
+			c.Suspend()
+		}
+		w.mu.Unlock()
+	} else if w.stopPolling != nil {
+		w.stopPolling()
 	}
-	return w.Consumer.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		w.inflight.Wait()
+		w.polling.Wait()
+		w.receipts.Wait()
+		w.mirrors.Wait()
+		close(done)
+	}()
+
+	timeout := w.Config().Worker.ShutdownTimeout.Std()
+	select {
+	case <-done:
+		log.Println("All in-flight deliveries completed.")
+	case <-time.After(timeout):
+		log.Printf("Shutdown deadline (%v) exceeded with %d deliveries still in flight; they will be redelivered.", timeout, w.InFlight())
+	}
+	w.dropDigests()
+	if w.audit != nil {
+		if err := w.audit.Close(); err != nil {
+			log.Printf("Audit export failed: %v", err)
+		}
+	}
+	if w.redrive != nil {
+		w.redrive.close()
+	}
+	w.plugins.Close()
+	w.enricher.Close()
+	w.deliveryLog.close()
+
+	if w.Broker != nil {
+		return w.Broker.Close()
+	}
+	var err error
+	if w.Consumer != nil {
+		err = w.shutdownConsumers()
+	} else {
+		err = w.shutdownPullers()
+	}
+	if perr := w.DLQProducer.Shutdown(); perr != nil {
+		log.Printf("Failed to shutdown DLQ producer: %v", perr)
+	}
+	return err
+}
+
+// shutdownConsumers stops the push consumers of every group, committing offsets.
+func (w *Worker) shutdownConsumers() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	if w.consumerStarted {
+		errs = append(errs, w.Consumer.Shutdown())
+	}
+	for group, c := range w.groupConsumers {
+		if err := c.Shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("consumer of group %s: %w", group, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InFlight returns the number of HandleMessage invocations currently running.
+func (w *Worker) InFlight() int64 {
+	return atomic.LoadInt64(&w.inflightN)
+}
+
+// DefaultTenant labels the stats of events that belong to no tenant.
+const DefaultTenant = "default"
+
+// TenantStats counts the delivery outcomes of one tenant.
+type TenantStats struct {
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`
+	// Expired counts notifications dropped because their event expired.
+	Expired int64 `json:"expired"`
+	// DeadlineExceeded counts notifications dropped because their event expired while
+	// they were being delivered; they count neither as delivered nor as failed.
+	DeadlineExceeded int64 `json:"deadline_exceeded"`
+}
+
+// TenantStats returns delivery counts per tenant since the worker started.
+func (w *Worker) TenantStats() map[string]TenantStats {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+
+	out := make(map[string]TenantStats, len(w.stats))
+	for tenant, s := range w.stats {
+		out[tenant] = *s
+	}
+	return out
+}
+
+func (w *Worker) countDelivery(tenant string, delivered bool) {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+
+	if s := w.tenantStats(tenant); delivered {
+		s.Delivered++
+	} else {
+		s.Failed++
+	}
+}
+
+func (w *Worker) countExpired(tenant string, inFlight bool) {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+
+	if s := w.tenantStats(tenant); inFlight {
+		s.DeadlineExceeded++
+	} else {
+		s.Expired++
+	}
+}
+
+// tenantStats returns the counters of tenant, creating them on first use. Callers hold w.statsMu.
+func (w *Worker) tenantStats(tenant string) *TenantStats {
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	s, ok := w.stats[tenant]
+	if !ok {
+		s = &TenantStats{}
+		w.stats[tenant] = s
+	}
+	return s
+}
+
+// TargetStatus returns the adaptive backoff state per target host, or nil when
+// worker.adaptive is not configured.
+func (w *Worker) TargetStatus() map[string]TargetStatus {
+	a := w.Config().Worker.Adaptive
+	if a == nil {
+		return nil
+	}
+	return w.targets.status(a)
+}
+
+// Status returns the state of the worker reported by /debug/status of cmd/worker, and of
+// cmd/api running the worker.
+func (w *Worker) Status() map[string]interface{} {
+	lag := make(map[string]int64)
+	for topic, d := range w.ConsumerLag() {
+		lag[topic] = d.Milliseconds()
+	}
+	return map[string]interface{}{
+		"inflight_deliveries": w.InFlight(),
+		"subscriptions":       w.Subscriptions(),
+		"consumer_lag_ms":     lag,
+		"group_lag":           w.GroupLag(),
+		"quarantine":          w.Quarantine(),
+		"tenant_deliveries":   w.TenantStats(),
+		"targets":             w.TargetStatus(),
+		"bulkheads":           w.BulkheadStatus(),
+		"rate_limits":         w.RateLimitStats(),
+		"connections":         w.ConnectionStats(),
+		"assignment":          w.Assignment(),
+	}
+}
+
+// ConsumerLag returns, per topic, how long the most recently received message waited
+// between being produced and being consumed. It is a cheap estimate of consumer lag.
+func (w *Worker) ConsumerLag() map[string]time.Duration {
+	w.lagMu.Lock()
+	defer w.lagMu.Unlock()
+
+	out := make(map[string]time.Duration, len(w.lag))
+	for k, v := range w.lag {
+		out[k] = v
+	}
+	return out
+}
+
+func (w *Worker) observeLag(topic string, born time.Time) {
+	if born.UnixMilli() <= 0 {
+		return
+	}
+	lag := time.Since(born)
+	w.lagMu.Lock()
+	w.lag[topic] = lag
+	w.lagMu.Unlock()
+}
+
+// beginMessage registers an in-flight invocation. It returns false once shutdown has started.
+func (w *Worker) beginMessage() bool {
+	w.inflightMu.Lock()
+	defer w.inflightMu.Unlock()
+
+	if w.draining {
+		return false
+	}
+	w.inflight.Add(1)
+	atomic.AddInt64(&w.inflightN, 1)
+	return true
+}
+
+func (w *Worker) endMessage() {
+	atomic.AddInt64(&w.inflightN, -1)
+	w.inflight.Done()
 }
 
 // HandleMessage is the callback function invoked by RocketMQ Consumer when a new message arrives.
 // It implements the consumer logic: Unmarshal -> Find Config -> Render Body -> Send Request.
+// The messages of a batch are delivered in parallel, up to mq.consume_parallelism at once,
+// and retried one by one (see batchResult).
 func (w *Worker) HandleMessage(ctx context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
-	for _, msg := range msgs {
-		fmt.Printf("[Worker] Received message from topic: %s, msgId: %s, reconsumeTimes: %d\n", msg.Topic, msg.MsgId, msg.ReconsumeTimes)
+	if !w.beginMessage() {
+		// Shutting down: leave the messages for the next consumer instead of starting new deliveries
+		return consumer.ConsumeRetryLater, nil
+	}
+	defer w.endMessage()
 
-		// Check for MaxRetries (DLQ Logic)
-		// RocketMQ uses int32 for ReconsumeTimes
-		if int(msg.ReconsumeTimes) >= w.Config.MQ.MaxRetries {
-			fmt.Printf("[Worker] Message %s exceeded max retries (%d). Sending to DLQ.\n", msg.MsgId, w.Config.MQ.MaxRetries)
-			if err := w.sendToDLQ(ctx, msg); err != nil {
-				fmt.Printf("[Worker] Failed to send message %s to DLQ: %v\n", msg.MsgId, err)
-				// If DLQ send fails, we might want to retry later, or just log error and consume success to avoid infinite loop
-				// Let's retry later to be safe, hoping DLQ issue is transient
-				return consumer.ConsumeRetryLater, nil
-			}
-			return consumer.ConsumeSuccess, nil
+	cfg := w.Config()
+	outcomes := w.consumeBatch(ctx, cfg, msgs)
+	return w.batchResult(ctx, msgs, outcomes), nil
+}
+
+// consumeMessage delivers one message of a batch and tells whether it has to be retried.
+func (w *Worker) consumeMessage(ctx context.Context, cfg *config.Config, msg *primitive.MessageExt) consumeOutcome {
+	w.observeLag(msg.Topic, time.UnixMilli(msg.BornTimestamp))
+	attempts := reconsumeTimes(msg)
+	fmt.Printf("[Worker] Received message from topic: %s, msgId: %s, reconsumeTimes: %d\n", msg.Topic, msg.MsgId, attempts)
+
+	// Check for MaxRetries (DLQ Logic)
+	if attempts >= cfg.MQ.MaxRetries {
+		fmt.Printf("[Worker] Message %s exceeded max retries (%d). Sending to DLQ.\n", msg.MsgId, cfg.MQ.MaxRetries)
+		if err := w.sendToDLQ(ctx, msg, ReasonMaxRetries, ""); err != nil {
+			fmt.Printf("[Worker] Failed to send message %s to DLQ: %v\n", msg.MsgId, err)
+			// If DLQ send fails, we might want to retry later, or just log error and consume success to avoid infinite loop
+			// Let's retry later to be safe, hoping DLQ issue is transient
+			return consumeOutcome{retry: true}
+		}
+		if body, err := mq.Body(msg); err == nil {
+			w.deadLetterReceipt(ctx, cfg, body, msg.GetProperties(), attempts+1)
 		}
+		return consumeOutcome{}
+	}
 
-		// 1. Unmarshal Event
-		var evt event.Event
-		if err := json.Unmarshal(msg.Body, &evt); err != nil {
-			fmt.Printf("[Worker] Error unmarshalling event data: %v. Skipping message.\n", err)
-			// Return ConsumeSuccess to acknowledge the message and prevent infinite redelivery of bad data
-			return consumer.ConsumeSuccess, nil
+	body, err := mq.Body(msg)
+	if err == nil {
+		err = w.deliverEvent(ctx, cfg, msg.Topic, msg.MsgId, body, msg.GetProperties(), attempts+1)
+	} else {
+		err = w.poison(ctx, cfg, msg.Topic, msg.MsgId, msg.Body, msg.GetProperties(), err)
+	}
+	if err == nil {
+		return consumeOutcome{}
+	}
+	var parked *parkError
+	if errors.As(err, &parked) {
+		if err := w.parkMessage(ctx, msg, parked); err != nil {
+			fmt.Printf("[Worker] Failed to park message %s: %v\n", msg.MsgId, err)
+			return consumeOutcome{retry: true}
+		}
+		return consumeOutcome{}
+	}
+	var poisoned *quarantineError
+	if errors.As(err, &poisoned) {
+		if err := w.quarantineMessage(ctx, msg, poisoned); err != nil {
+			fmt.Printf("[Worker] Failed to quarantine message %s: %v\n", msg.MsgId, err)
+			return consumeOutcome{retry: true}
+		}
+		return consumeOutcome{}
+	}
+	if delay, ok := heldBack(err); ok {
+		if err := w.hold(msg, delay); err != nil {
+			fmt.Printf("[Worker] %v\n", err)
+			return consumeOutcome{retry: true}
+		}
+		return consumeOutcome{}
+	}
+	var pErr *PermanentError
+	if errors.As(err, &pErr) {
+		if err := w.sendToDLQ(ctx, msg, pErr.Reason, pErr.Message); err != nil {
+			fmt.Printf("[Worker] Failed to send message %s to DLQ: %v\n", msg.MsgId, err)
+			return consumeOutcome{retry: true}
 		}
+		pErr.sendReceipt()
+		return consumeOutcome{}
+	}
+	// Let RocketMQ handle the retry (with backoff), waiting at least as long as the
+	// target asked for
+	out := consumeOutcome{retry: true}
+	if d := retryAfterOf(err); d > 0 {
+		out.delayLevel = max(mq.DelayLevel(d), retryLevel(attempts))
+	}
+	return out
+}
+
+// decodeEvent decodes the event of a message body with the given properties, decrypting
+// the body first if it is encrypted.
+func (w *Worker) decodeEvent(ctx context.Context, cfg *config.Config, body []byte, props map[string]string) (event.Event, error) {
+	plain, err := mq.Decrypt(ctx, w.keyring, cfg.MQ.Encryption, body, props)
+	if err != nil {
+		return event.Event{}, err
+	}
+	return w.registry.DecodeEvent(ctx, cfg.SchemaRegistry, plain, props)
+}
 
-		// 2. Find Notification Configuration
-		notifyConfig := w.Config.FindNotificationConfig(evt.Type)
-		if notifyConfig == nil {
-			fmt.Printf("[Worker] No configuration found for event type: %s. Skipping message.\n", evt.Type)
-			return consumer.ConsumeSuccess, nil
+// decodeEnvelope is decodeEvent for deliveries. With notifications reading raw data
+// configured, the data of JSON events stays undecoded until their notification is known.
+func (w *Worker) decodeEnvelope(ctx context.Context, cfg *config.Config, body []byte, props map[string]string) (event.Event, error) {
+	if !cfg.RawData() || props[mq.ContentTypeProperty] == schema.ContentTypeAvro {
+		return w.decodeEvent(ctx, cfg, body, props)
+	}
+	plain, err := mq.Decrypt(ctx, w.keyring, cfg.MQ.Encryption, body, props)
+	if err != nil {
+		return event.Event{}, err
+	}
+	return mq.DecodeEnvelope(plain, props[mq.ContentTypeProperty])
+}
+
+// deliverEvent decodes an event and delivers it to the notification configured for it,
+// through the middleware chain. It returns an error only for failed deliveries, which
+// should be retried unless it is a *PermanentError, and for undecodable events with
+// worker.quarantine, as *quarantineError; unconfigured events are skipped. props are the
+// properties of the message, which tell how the event is encoded, and deliveries counts
+// how often the message was consumed.
+func (w *Worker) deliverEvent(ctx context.Context, cfg *config.Config, topic, msgID string, body []byte, props map[string]string, deliveries int) error {
+	// 1. Decode Event
+	evt, err := w.decodeEnvelope(ctx, cfg, body, props)
+	var regErr *schema.RegistryError
+	var keyErr *mq.KeyError
+	if errors.As(err, &regErr) || errors.As(err, &keyErr) {
+		fmt.Printf("[Worker] Failed to decode event of message %s: %v. Will retry.\n", msgID, err)
+		return err
+	}
+	if err != nil {
+		return w.poison(ctx, cfg, topic, msgID, body, props, err)
+	}
+
+	// 2. Upgrade old versions and find Notification Configuration
+	evt, err = w.upcast(cfg, evt)
+	if err != nil {
+		fmt.Printf("[Worker] Failed to upcast event %s: %v. Sending to DLQ.\n", evt.ID, err)
+		return &PermanentError{Reason: ReasonTemplate, Message: err.Error(), Err: err}
+	}
+	notifyConfig := cfg.FindNotificationConfig(evt.TenantID, evt.Type, evt.Version)
+	if notifyConfig == nil {
+		fmt.Printf("[Worker] No configuration found for event type: %s version %d (tenant %q). Skipping message.\n", evt.Type, evt.Version, evt.TenantID)
+		return nil
+	}
+	if !notifyConfig.RawData {
+		if err := evt.DecodeData(); err != nil {
+			return w.poison(ctx, cfg, topic, msgID, body, props, err)
 		}
+	}
+	if dropFault(cfg.Worker.Faults) {
+		fmt.Printf("[Worker] Fault injection: dropping event %s.\n", evt.ID)
+		return nil
+	}
 
-		// 3. Process Notification
-		if err := w.processNotification(notifyConfig, evt); err != nil {
-			fmt.Printf("[Worker] Failed to send notification for event %s: %v. Will retry.\n", evt.ID, err)
-			// Return ConsumeRetryLater to let RocketMQ handle the retry (with backoff)
-			return consumer.ConsumeRetryLater, nil
+	// 3. Drop notifications that are no longer worth delivering
+	if deadline, ok := expiresAt(notifyConfig, evt); ok && !w.clock.Now().Before(deadline) {
+		w.dropExpired(notifyConfig, evt, deliveries, deadline)
+		return nil
+	}
+	if notifyConfig.Digest != nil && !evt.Digest {
+		return w.addToDigest(notifyConfig, topic, evt)
+	}
+
+	// 4. Process Notification
+	return w.handler(ctx, &Delivery{
+		Notification: notifyConfig,
+		Event:        evt,
+		Topic:        topic,
+		MessageID:    msgID,
+		Attempt:      deliveries,
+	})
+}
+
+// deliver is the innermost Handler: it sends the event to the target and its receipt to
+// the callback URL. Permanent failures are returned as *PermanentError, held deliveries
+// as *PausedError or *bulkheadError and parked ones as *parkError.
+func (w *Worker) deliver(ctx context.Context, d *Delivery) error {
+	notifyConfig, evt := d.Notification, d.Event
+	id := DeliveryID(notifyConfig, evt)
+	if w.delivered.contains(ctx, id) {
+		fmt.Printf("[Worker] Event %s was already delivered (delivery %s). Skipping duplicate.\n", evt.ID, id)
+		return nil
+	}
+	record, err := w.processNotification(ctx, notifyConfig, evt)
+	d.Record = record
+	var parked *parkError
+	if errors.As(err, &parked) {
+		fmt.Printf("[Worker] Parking event %s in %s: %v\n", evt.ID, ParkTopic(parked.Target), parked.Err)
+		return err
+	}
+	if delay, ok := heldBack(err); ok {
+		fmt.Printf("[Worker] Holding event %s for %v: %v\n", evt.ID, delay, err)
+		return err
+	}
+	var expired *expiredError
+	if errors.As(err, &expired) {
+		w.dropExpiredInFlight(notifyConfig, evt, d.Attempt, record, expired)
+		return nil
+	}
+	if err != nil {
+		msg := scrub(notifyConfig, evt.Data, err.Error())
+		if reason := failureReason(err); reason != "" {
+			fmt.Printf("[Worker] Permanent failure (%s) for event %s: %s. Sending to DLQ.\n", reason, evt.ID, msg)
+			return &PermanentError{Reason: reason, Message: msg, Err: err, receipt: func() {
+				w.sendReceipt(notifyConfig, evt, newReceipt(record, d.Attempt, reason))
+			}}
 		}
+		fmt.Printf("[Worker] Failed to send notification for event %s: %s. Will retry.\n", evt.ID, msg)
+		return err
+	}
+	if !record.DryRun {
+		w.delivered.add(ctx, id)
 	}
-	return consumer.ConsumeSuccess, nil
+	w.sendReceipt(notifyConfig, evt, newReceipt(record, d.Attempt, ""))
+	return nil
 }
 
-func (w *Worker) sendToDLQ(ctx context.Context, msg *primitive.MessageExt) error {
+// sendToDLQ moves msg to the DLQ_<topic> topic, recording why (see dlqProperties).
+func (w *Worker) sendToDLQ(ctx context.Context, msg *primitive.MessageExt, reason, message string) error {
 	dlqTopic := fmt.Sprintf("DLQ_%s", msg.Topic)
 	dlqMsg := &primitive.Message{
 		Topic: dlqTopic,
 		Body:  msg.Body,
 	}
-	// Copy properties if needed
-	dlqMsg.WithProperties(msg.GetProperties())
+	dlqMsg.WithProperties(dlqProperties(msg.GetProperties(), reason, message))
 
 	_, err := w.DLQProducer.SendSync(ctx, dlqMsg)
 	return err
 }
 
-func (w *Worker) processNotification(cfg *config.NotificationConfig, evt event.Event) error {
-	// 1. Render Request Body using the template from config
-	reqBody, err := w.renderBody(cfg.Body, evt)
+func (w *Worker) processNotification(ctx context.Context, cfg *config.NotificationConfig, evt event.Event) (record delivery.Record, err error) {
+	ctx, cancel := w.deliveryContext(ctx, cfg, evt)
+	defer cancel()
+	start := w.clock.Now()
+	attempts := 0
+	var lastStatus int
+	var rendered *Request
+	original := evt
+	defer func() {
+		// Whatever stopped a delivery whose event expired, retrying it is pointless
+		var expired *expiredError
+		if err != nil && errors.As(context.Cause(ctx), &expired) {
+			err = &expiredError{deadline: expired.deadline, err: err}
+		}
+		// Held and parked deliveries didn't happen yet
+		var parked *parkError
+		if _, held := heldBack(err); !held && !errors.As(err, &parked) {
+			record = w.recordDelivery(cfg, original, rendered, start, attempts, lastStatus, err)
+			if rendered != nil && rendered.MirrorURL != "" && !record.DryRun {
+				w.mirror(cfg, original, rendered, record)
+			}
+		}
+	}()
+	if err = w.checkPause(evt, ""); err != nil {
+		return record, w.parkPaused(cfg, evt, "", err)
+	}
+
+	// 1. Look up the data the event doesn't carry, then render Request Body, URL and
+	// Headers using the templates from config
+	if evt, err = w.enricher.Apply(ctx, cfg.Enrich, evt); err != nil {
+		return record, err
+	}
+	if rendered, err = w.renderRequest(cfg, evt); err != nil {
+		return record, &templateError{err}
+	}
+	if err = w.checkPause(evt, rendered.URL); err != nil {
+		return record, w.parkPaused(cfg, evt, rendered.URL, err)
+	}
+	if cfg.DryRun {
+		to := "as " + rendered.Method + " " + rendered.URL
+		if cfg.Channel != "" {
+			to = "to channel " + cfg.Channel
+		}
+		fmt.Printf("[Worker] Dry run: would send event %s %s: %s\n", evt.ID, to, scrub(cfg, original.Data, string(rendered.Body)))
+		return record, nil
+	}
+	if b := w.Config().Worker.Bulkhead; b != nil {
+		var leave func()
+		if leave, err = w.enterBulkhead(b, cfg, rendered.URL); err != nil {
+			return record, err
+		}
+		defer leave()
+	}
+	if cfg.Channel != "" {
+		attempts, err = w.notifyChannel(ctx, cfg, evt, rendered)
+		return record, err
+	}
+
+	client, err := w.clientFor(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to render body: %w", err)
+		return record, fmt.Errorf("failed to configure HTTP client: %w", err)
+	}
+
+	// With adaptive backoff, degraded targets get fewer concurrent requests and slower retries
+	var tgt *target
+	adaptive := w.Config().Worker.Adaptive
+	if adaptive != nil {
+		tgt = w.targets.get(targetKey(rendered.URL), adaptive)
+		if park := w.Config().Worker.Park; park != nil && park.Degraded && tgt.status(adaptive, w.clock.Now()).Degraded {
+			key := targetKey(rendered.URL)
+			return record, &parkError{Target: key, Reason: ParkReasonDegraded, Err: fmt.Errorf("target %s is degraded", key)}
+		}
 	}
 
 	// Local Retry Logic with Exponential Backoff
 	maxLocalRetries := 3
+	if cfg.Retries > 0 {
+		maxLocalRetries = cfg.Retries
+	}
 	var lastErr error
+	tokenRenewed := false
 
 	for i := 0; i < maxLocalRetries; i++ {
 		if i > 0 {
-			// Exponential backoff: 200ms, 400ms, 800ms...
-			backoff := time.Duration(math.Pow(2, float64(i))) * 100 * time.Millisecond
-			fmt.Printf("[Worker] Local retry %d/%d for event %s in %v\n", i+1, maxLocalRetries, evt.ID, backoff)
-			time.Sleep(backoff)
+			if tgt != nil && !tgt.allowRetry(adaptive) {
+				fmt.Printf("[Worker] Retry budget of %s spent, leaving event %s to MQ redelivery\n", targetKey(rendered.URL), evt.ID)
+				break
+			}
+			// Exponential backoff with full jitter: up to 200ms, 400ms, 800ms... by default
+			delay := backoff(cfg.Backoff, i)
+			if tgt != nil {
+				delay = time.Duration(float64(delay) * tgt.backoffStretch(adaptive))
+			}
+			// unless the throttled target said when to come back
+			if d := retryAfterOf(lastErr); d > 0 {
+				delay = d
+			}
+			fmt.Printf("[Worker] Local retry %d/%d for event %s in %v\n", i+1, maxLocalRetries, evt.ID, delay)
+			if sleep(ctx, delay) != nil {
+				return record, budgetSpent(ctx, cfg, attempts, lastErr)
+			}
+		}
+		if cfg.RateLimit != nil {
+			if err := w.waitForRateLimit(ctx, cfg); err != nil {
+				return record, err
+			}
+		}
+		attempts++
+		if err := attemptFault(w.Config().Worker.Faults); err != nil {
+			lastErr = err
+			lastStatus = http.StatusServiceUnavailable
+			continue
 		}
 
 		// 2. Create HTTP Request
-		req, err := http.NewRequest(cfg.Method, cfg.URL, bytes.NewBuffer(reqBody))
+		req, err := http.NewRequestWithContext(ctx, rendered.Method, rendered.URL, bytes.NewBuffer(rendered.Body))
 		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
+			// The rendered URL is invalid
+			return record, &templateError{fmt.Errorf("failed to create request: %w", err)}
 		}
 
 		// 3. Set Headers
-		for k, v := range cfg.Headers {
-			req.Header.Set(k, v)
+		req.Header = rendered.Header.Clone()
+		if cfg.Auth != nil {
+			authorization, err := w.tokens.authorization(ctx, client, cfg.Auth)
+			if err != nil {
+				lastErr = fmt.Errorf("failed to obtain access token: %w", err)
+				continue
+			}
+			req.Header.Set("Authorization", authorization)
 		}
 
 		// 4. Execute Request
-		resp, err := w.Client.Do(req)
+		logCfg := w.Config().Worker.DeliveryLog
+		var trace *attemptTrace
+		if logCfg != nil {
+			req, trace = traceRequest(req)
+		}
+		resp, body, err := do(tgt, adaptive, client, req, i > 0)
+		if logCfg != nil {
+			w.logAttempt(logCfg, cfg, evt, attempts, req, rendered.Body, trace, resp, body, err)
+		}
 		if err != nil {
+			var tErr *throttledError
+			if errors.As(err, &tErr) {
+				return record, err
+			}
 			lastErr = fmt.Errorf("request network error: %w", err)
+			if isCertificateError(err) {
+				return record, lastErr // The certificate won't change between attempts
+			}
+			if ctx.Err() != nil {
+				return record, budgetSpent(ctx, cfg, attempts, lastErr)
+			}
 			continue // Retry on network error
 		}
-		defer resp.Body.Close()
+		lastStatus = resp.StatusCode
 
-		// 5. Check Response Status
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			fmt.Printf("[Worker] Notification sent successfully for event %s to %s\n", evt.ID, cfg.URL)
-			return nil
+		// 5. Check Response Status and Body
+		if isSuccessStatus(cfg.Success, resp.StatusCode) {
+			// Some targets answer 200 with an error payload; treat a mismatch like a server error
+			if err := checkResponseBody(cfg.Success, body); err != nil {
+				lastErr = newDeliveryError(err.Error(), resp.StatusCode, body)
+				continue
+			}
+			to, _ := targetFor(cfg, evt.ID)
+			fmt.Printf("[Worker] Notification sent successfully for event %s to %s\n", evt.ID, to)
+			return record, nil
+		}
+
+		// A rejected token may have been revoked early; retry once with a new one
+		if resp.StatusCode == http.StatusUnauthorized && cfg.Auth != nil {
+			w.tokens.invalidate(cfg.Auth)
+			if !tokenRenewed && i+1 < maxLocalRetries {
+				tokenRenewed = true
+				lastErr = newDeliveryError("request failed with client error", resp.StatusCode, body)
+				continue
+			}
 		}
 
-		// If 5xx, retry. If 4xx (client error), maybe don't retry?
-		// For simplicity and robustness, let's retry 5xx and 429.
-		// Fail fast on 400, 401, 403, 404
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 429 {
-			body, _ := ioutil.ReadAll(resp.Body)
-			return fmt.Errorf("request failed with client error status %d: %s", resp.StatusCode, string(body))
+		// Retry 5xx, 408 and 429. Fail fast on other client errors (400, 401, 403, 404, ...),
+		// which also skip the MQ retries (see failureReason)
+		if !retryableStatus(resp.StatusCode) {
+			return record, newDeliveryError("request failed with client error", resp.StatusCode, body)
 		}
 
-		body, _ := ioutil.ReadAll(resp.Body)
-		lastErr = fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		dErr := newDeliveryError("request failed", resp.StatusCode, body)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), w.clock.Now()); ok {
+				dErr.RetryAfter = d
+				// Don't hold the message for long waits; the MQ redelivers it after the delay
+				if d > maxLocalRetryAfter {
+					return record, dErr
+				}
+			}
+		}
+		lastErr = dErr
 	}
 
-	return lastErr
+	return record, lastErr
 }
 
-// renderBody replaces placeholders in the template body with actual values from the event.
-func (w *Worker) renderBody(templateBody map[string]interface{}, evt event.Event) ([]byte, error) {
-	rendered := w.replacePlaceholders(templateBody, evt)
-	return json.Marshal(rendered)
-}
+// maxLocalRetryAfter is the longest Retry-After honoured by sleeping before a local retry.
+// Longer delays end the local retries and are passed on to the MQ redelivery.
+const maxLocalRetryAfter = 10 * time.Second
 
-// replacePlaceholders recursively traverses the template and replaces strings matching {$.event.field}.
-func (w *Worker) replacePlaceholders(v interface{}, evt event.Event) interface{} {
-	switch val := v.(type) {
-	case string:
-		return w.resolveValue(val, evt)
-	case map[string]interface{}:
-		newMap := make(map[string]interface{})
-		for k, v := range val {
-			newMap[k] = w.replacePlaceholders(v, evt)
-		}
-		return newMap
-	case []interface{}:
-		newSlice := make([]interface{}, len(val))
-		for i, v := range val {
-			newSlice[i] = w.replacePlaceholders(v, evt)
+// recordDelivery stores the outcome of a delivery, including the response body of failures
+// and the request body of dry runs.
+func (w *Worker) recordDelivery(cfg *config.NotificationConfig, evt event.Event, rendered *Request, start time.Time, attempts, status int, err error) delivery.Record {
+	dryRun := cfg.DryRun && rendered != nil && err == nil
+	var expired *expiredError
+	if !dryRun && !errors.As(err, &expired) {
+		w.countDelivery(evt.TenantID, err == nil)
+	}
+
+	_, version := templateFor(cfg, evt.ID)
+	url, target := targetFor(cfg, evt.ID)
+	record := delivery.Record{
+		Tenant:          evt.TenantID,
+		EventID:         evt.ID,
+		EventType:       evt.Type,
+		DeliveryID:      DeliveryID(cfg, evt),
+		TemplateVersion: version,
+		URL:             url,
+		Target:          target,
+		Success:         err == nil,
+		Attempts:        attempts,
+		StatusCode:      status,
+		DurationMs:      w.clock.Now().Sub(start).Milliseconds(),
+		Time:            start,
+	}
+	if dryRun {
+		record.DryRun = true
+		record.RequestBody = scrub(cfg, evt.Data, string(rendered.Body))
+	}
+	if err != nil {
+		record.Error = err.Error()
+		var dErr *DeliveryError
+		if errors.As(err, &dErr) {
+			record.Error = dErr.Reason
+			record.ResponseBody = scrub(cfg, evt.Data, dErr.Body)
 		}
-		return newSlice
-	default:
-		return val
+		record.Error = scrub(cfg, evt.Data, record.Error)
+	}
+	w.Deliveries.Add(record)
+	w.Feed.Publish(record)
+	if w.audit != nil {
+		w.audit.Add(record)
 	}
+	return record
 }
 
-// resolveValue checks if a string is a placeholder and resolves it.
-// Supported syntax: "{$.event.field}"
-func (w *Worker) resolveValue(val string, evt event.Event) interface{} {
-	if strings.HasPrefix(val, "{$.event.") && strings.HasSuffix(val, "}") {
-		path := val[2 : len(val)-1] // Remove { and } -> $.event.field
-		parts := strings.Split(path, ".")
+// SignatureHeader carries the HMAC-SHA256 signature of the request body when a signing secret is configured.
+const SignatureHeader = "X-Signature-256"
 
-		// Currently only supports $.event.field (depth of 3: $, event, field)
-		// Can be extended to support nested JSON path if needed
-		if len(parts) == 3 && parts[0] == "$" && parts[1] == "event" {
-			key := parts[2]
-			if v, ok := evt.Data[key]; ok {
-				return v
-			}
-			// If key not found, return original string or null?
-			// Returning original string helps debugging configuration errors
-			return val
-		}
-	}
-	return val
+// sign returns the body signature in the form "sha256=<hex>".
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature (the SignatureHeader value) matches body
+// signed with secret. Receivers can use it to authenticate notifications.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(sign(secret, body)), []byte(signature))
 }
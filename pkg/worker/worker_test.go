@@ -0,0 +1,112 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/event"
+	"notification-system/pkg/worker"
+	"notification-system/pkg/worker/workertest"
+)
+
+const testConfig = `{
+  "mq": {"name_server": "127.0.0.1:9876", "group_name": "notification_group", "max_retries": 3},
+  "notifications": [{
+    "event_type": "order.created", "queue_name": "order_queue",
+    "http_method": "POST", "http_url": "http://target.example/orders",
+    "body": {"id": "{$.event.id}"}
+  }]
+}`
+
+// startWorker starts a worker with settings on the fakes of workertest.
+func startWorker(t *testing.T, settings string, queue *workertest.MQ, client *workertest.Client) *worker.Worker {
+	t.Helper()
+	cfg, err := config.ParseConfig([]byte(settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := worker.NewWorkerWith(cfg, worker.Dependencies{
+		NewConsumer: queue.NewConsumer,
+		Producer:    queue,
+		Client:      client,
+		Clock:       workertest.NewClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Shutdown() })
+	return w
+}
+
+func orderCreated(t *testing.T) []byte {
+	t.Helper()
+	body, err := json.Marshal(event.Event{ID: "evt-1", Type: "order.created", Data: map[string]interface{}{"id": "42"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHandleMessage(t *testing.T) {
+	queue, client := workertest.NewMQ(), &workertest.Client{}
+	startWorker(t, testConfig, queue, client)
+
+	result, err := queue.Deliver(context.Background(), "order_queue", orderCreated(t))
+	if err != nil || result != consumer.ConsumeSuccess {
+		t.Fatalf("Deliver = %v, %v, want success", result, err)
+	}
+	requests := client.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if r := requests[0]; r.Method != http.MethodPost || r.URL != "http://target.example/orders" || string(r.Body) != `{"id":"42"}` {
+		t.Errorf("request = %s %s %s", r.Method, r.URL, r.Body)
+	}
+}
+
+func TestHandleMessageRetries(t *testing.T) {
+	queue := workertest.NewMQ()
+	startWorker(t, testConfig, queue, &workertest.Client{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})})
+
+	result, err := queue.Deliver(context.Background(), "order_queue", orderCreated(t))
+	if err != nil || result != consumer.ConsumeRetryLater {
+		t.Fatalf("Deliver = %v, %v, want a retry", result, err)
+	}
+	if dlq := queue.Sent("DLQ_order_queue"); len(dlq) != 0 {
+		t.Errorf("got %d DLQ messages before the last retry, want 0", len(dlq))
+	}
+}
+
+func TestHandleMessageMaxRetries(t *testing.T) {
+	queue, client := workertest.NewMQ(), &workertest.Client{}
+	startWorker(t, testConfig, queue, client)
+
+	msg := &primitive.MessageExt{
+		Message:        primitive.Message{Topic: "order_queue", Body: orderCreated(t)},
+		MsgId:          "msg-1",
+		ReconsumeTimes: 3,
+		BornTimestamp:  time.Now().UnixMilli(),
+	}
+	result, err := queue.DeliverMessage(context.Background(), msg)
+	if err != nil || result != consumer.ConsumeSuccess {
+		t.Fatalf("DeliverMessage = %v, %v, want success", result, err)
+	}
+	if dlq := queue.Sent("DLQ_order_queue"); len(dlq) != 1 {
+		t.Errorf("got %d DLQ messages, want 1", len(dlq))
+	}
+	if requests := client.Requests(); len(requests) != 0 {
+		t.Errorf("got %d requests after the last retry, want 0", len(requests))
+	}
+}
@@ -0,0 +1,255 @@
+// Package workertest provides fakes of the worker's dependencies, so handlers and
+// middleware can be unit tested without a live RocketMQ or HTTP target:
+//
+//	queue := workertest.NewMQ()
+//	target := &workertest.Client{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		w.WriteHeader(http.StatusServiceUnavailable)
+//	})}
+//	w, err := worker.NewWorkerWith(cfg, worker.Dependencies{
+//		NewConsumer: queue.NewConsumer,
+//		Producer:    queue,
+//		Client:      target,
+//		Clock:       workertest.NewClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)),
+//	})
+//	...
+//	w.Start(ctx)
+//	result, err := queue.Deliver(ctx, "order_queue", body)
+//	dlq := queue.Sent("DLQ_order_queue")
+//
+// The fakes stand in for RocketMQ (mq.broker unset) in push mode.
+//
+// Where a test needs to assert on the calls themselves, the package also has gomock mocks
+// generated with mockgen: MockConsumer, MockProducer, MockHTTPDoer and MockClock.
+package workertest
+
+//go:generate go tool mockgen -destination mock_worker.go -package workertest notification-system/pkg/worker Consumer,HTTPDoer,Clock
+//go:generate go tool mockgen -destination mock_mq.go -package workertest notification-system/pkg/mq Producer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+
+	"notification-system/pkg/config"
+	"notification-system/pkg/mq"
+	"notification-system/pkg/worker"
+)
+
+type handler func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)
+
+// MQ is an in-process RocketMQ: a producer recording the messages sent and a factory of
+// push consumers whose subscriptions receive the messages passed to Deliver.
+type MQ struct {
+	mu       sync.Mutex
+	handlers map[string]handler
+	sent     []*primitive.Message
+	nextID   int
+	// SendErr, when set, fails every send.
+	SendErr error
+}
+
+var (
+	_ mq.Producer     = (*MQ)(nil)
+	_ worker.Consumer = (*pushConsumer)(nil)
+)
+
+// NewMQ returns an MQ without subscriptions.
+func NewMQ() *MQ {
+	return &MQ{handlers: make(map[string]handler)}
+}
+
+// NewConsumer creates a push consumer; use it as worker.Dependencies.NewConsumer.
+func (m *MQ) NewConsumer(cfg config.MQConfig) (worker.Consumer, error) {
+	return &pushConsumer{mq: m, group: cfg.GroupName, subs: make(map[string]handler)}, nil
+}
+
+// SendSync records msgs.
+func (m *MQ) SendSync(ctx context.Context, msgs ...*primitive.Message) (*primitive.SendResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.SendErr != nil {
+		return nil, m.SendErr
+	}
+	m.sent = append(m.sent, msgs...)
+	m.nextID++
+	return &primitive.SendResult{Status: primitive.SendOK, MsgID: fmt.Sprintf("sent-%d", m.nextID)}, nil
+}
+
+// Shutdown does nothing.
+func (m *MQ) Shutdown() error { return nil }
+
+// Sent returns the messages sent to topic, e.g. "DLQ_order_queue", in order.
+func (m *MQ) Sent(topic string) []*primitive.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*primitive.Message
+	for _, msg := range m.sent {
+		if msg.Topic == topic {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+// Deliver has the started consumer subscribed to topic consume a new message with body
+// and returns its result.
+func (m *MQ) Deliver(ctx context.Context, topic string, body []byte) (consumer.ConsumeResult, error) {
+	m.mu.Lock()
+	m.nextID++
+	id := fmt.Sprintf("msg-%d", m.nextID)
+	m.mu.Unlock()
+
+	msg := &primitive.MessageExt{
+		Message:       primitive.Message{Topic: topic, Body: body},
+		MsgId:         id,
+		BornTimestamp: time.Now().UnixMilli(),
+	}
+	return m.DeliverMessage(ctx, msg)
+}
+
+// DeliverMessage has the started consumer subscribed to msg.Topic consume msg, e.g. a
+// redelivery with ReconsumeTimes set, and returns its result.
+func (m *MQ) DeliverMessage(ctx context.Context, msg *primitive.MessageExt) (consumer.ConsumeResult, error) {
+	m.mu.Lock()
+	h, ok := m.handlers[msg.Topic]
+	m.mu.Unlock()
+	if !ok {
+		return consumer.ConsumeRetryLater, fmt.Errorf("no started consumer subscribed to %s", msg.Topic)
+	}
+	return h(ctx, msg)
+}
+
+// pushConsumer routes the topics it subscribed to its MQ once started.
+type pushConsumer struct {
+	mq      *MQ
+	group   string
+	mu      sync.Mutex
+	subs    map[string]handler
+	started bool
+}
+
+func (c *pushConsumer) Subscribe(topic string, _ consumer.MessageSelector, f func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs[topic] = f
+	if c.started {
+		c.mq.mu.Lock()
+		c.mq.handlers[topic] = f
+		c.mq.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *pushConsumer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.started = true
+	c.mq.mu.Lock()
+	defer c.mq.mu.Unlock()
+	for topic, f := range c.subs {
+		c.mq.handlers[topic] = f
+	}
+	return nil
+}
+
+func (c *pushConsumer) Suspend() {}
+
+func (c *pushConsumer) Shutdown() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.started = false
+	c.mq.mu.Lock()
+	defer c.mq.mu.Unlock()
+	for topic := range c.subs {
+		delete(c.mq.handlers, topic)
+	}
+	return nil
+}
+
+// Request is a request received by Client.
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Client is a worker.HTTPDoer that serves requests in process with Handler, or answers
+// 200 OK without one, and records them.
+type Client struct {
+	Handler http.Handler
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// Do records req and returns the response of Handler.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, Request{Method: req.Method, URL: req.URL.String(), Header: req.Header.Clone(), Body: body})
+	c.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	if c.Handler != nil {
+		served := req.Clone(req.Context())
+		served.Body = io.NopCloser(bytes.NewReader(body))
+		c.Handler.ServeHTTP(rec, served)
+	}
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// Requests returns the requests received so far, in order.
+func (c *Client) Requests() []Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Request(nil), c.requests...)
+}
+
+// Clock is a worker.Clock that only moves when told to.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}